@@ -1,34 +1,58 @@
 package client
 
 import (
+	"crypto/tls"
 	"errors"
 	"github.com/huiming23344/kv-raft/cmd"
 	"github.com/huiming23344/kv-raft/network"
 	"net"
 	"strconv"
+	"time"
+)
+
+const (
+	// 建立连接的超时时间，避免对端不可达时长时间阻塞
+	dialTimeout = 3 * time.Second
+	// 请求的默认超时时间，从发送请求到读完响应，包含 member join 等待新节点追赶日志的时间
+	DefaultRequestTimeout = 3 * time.Minute
 )
 
 type Client struct {
 	connnection network.Connection
+	// 每个请求的超时时间，为 0 时不超时
+	timeout time.Duration
 }
 
 func NewClient(addr string) (*Client, error) {
+	return NewTLSClient(addr, nil)
+}
+
+// NewTLSClient 使用 TLS 连接 addr，tlsConfig 为 nil 时使用明文连接
+func NewTLSClient(addr string, tlsConfig *tls.Config) (*Client, error) {
 	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if tlsConfig != nil {
+		// 使用原始地址，未配置 ServerName 时按地址中的主机名校验证书
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", tcpAddr.String())
+	}
 	if err != nil {
 		return nil, err
 	}
 	client := &Client{
 		connnection: network.NewConnection(conn),
+		timeout:     DefaultRequestTimeout,
 	}
 	return client, nil
 }
 
-func (c *Client) Member(opt, serverID, address string) (string, error) {
-	frame := cmd.NewMember(opt, serverID, address).IntoFrame()
+func (c *Client) Member(opt, serverID, address string, options ...string) (string, error) {
+	frame := cmd.NewMember(opt, serverID, address, options...).IntoFrame()
 	rsp, err := c.Invoke(frame)
 	if err != nil {
 		return "", err
@@ -43,8 +67,40 @@ func (c *Client) Member(opt, serverID, address string) (string, error) {
 	}
 }
 
+// Range 查看或管理分片区间，opt 为 list、split 或 merge
+func (c *Client) Range(opt string, args ...string) (string, error) {
+	rsp, err := c.Invoke(cmd.NewRange(opt, args...).IntoFrame())
+	if err != nil {
+		return "", err
+	}
+	switch rsp.Ftype {
+	case network.Simple:
+		return rsp.Value.(string), nil
+	case network.Error:
+		return "", errors.New(rsp.Value.(string))
+	default:
+		return "", errors.New("protocol error; expected simple frame or error frame")
+	}
+}
+
+// Info 读取节点 INFO 命令的输出，section 为空时返回所有段
+func (c *Client) Info(section string) (string, error) {
+	rsp, err := c.Invoke(cmd.NewInfo(section).IntoFrame())
+	if err != nil {
+		return "", err
+	}
+	switch rsp.Ftype {
+	case network.Bulk:
+		return string(rsp.Value.([]byte)), nil
+	case network.Error:
+		return "", errors.New(rsp.Value.(string))
+	default:
+		return "", errors.New("protocol error; expected bulk frame or error frame")
+	}
+}
+
 func (c *Client) Set(key, value string) (string, error) {
-	frame := cmd.NewSet(key, value).IntoFrame()
+	frame := cmd.NewSet(key, []byte(value)).IntoFrame()
 	rsp, err := c.Invoke(frame)
 	if err != nil {
 		return "", err
@@ -60,14 +116,23 @@ func (c *Client) Set(key, value string) (string, error) {
 }
 
 func (c *Client) Get(key string) (string, error) {
-	frame := cmd.NewGet(key).IntoFrame()
+	return c.get(cmd.NewGet(key))
+}
+
+// GetWithConsistency 使用指定的读一致性级别读取 key
+func (c *Client) GetWithConsistency(key, consistency string) (string, error) {
+	return c.get(cmd.NewGetWithConsistency(key, consistency))
+}
+
+func (c *Client) get(command cmd.Command) (string, error) {
+	frame := command.IntoFrame()
 	rsp, err := c.Invoke(frame)
 	if err != nil {
 		return "", err
 	}
 	switch rsp.Ftype {
 	case network.Bulk:
-		return rsp.Value.(string), nil
+		return string(rsp.Value.([]byte)), nil
 	case network.Null:
 		return "null", nil
 	case network.Error:
@@ -101,9 +166,36 @@ func (c *Client) readResponse() (*network.Frame, error) {
 	return frame, nil
 }
 
+// SetTimeout 设置每个请求的超时时间，为 0 时不超时
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
 func (c *Client) Invoke(frame *network.Frame) (*network.Frame, error) {
-	if err := c.connnection.WriteFrame(frame); err != nil {
+	return c.InvokeTimeout(frame, c.timeout)
+}
+
+// InvokeTimeout 发送 frame 并读取响应，超过 timeout 仍未读完时返回超时错误，
+// 之后连接上可能还有未读的响应，不能再使用
+func (c *Client) InvokeTimeout(frame *network.Frame, timeout time.Duration) (*network.Frame, error) {
+	if err := c.send(frame, timeout); err != nil {
 		return nil, err
 	}
 	return c.readResponse()
 }
+
+// send 设置本次请求的截止时间并发送 frame
+func (c *Client) send(frame *network.Frame, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.connnection.SetDeadline(deadline); err != nil {
+		return err
+	}
+	return c.connnection.WriteFrame(frame)
+}
+
+func (c *Client) Close() error {
+	return c.connnection.Close()
+}
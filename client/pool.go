@@ -0,0 +1,91 @@
+package client
+
+import (
+	"crypto/tls"
+	"github.com/huiming23344/kv-raft/network"
+	"sync"
+	"time"
+)
+
+// Pool 按地址缓存空闲连接，供节点之间转发请求时复用
+type Pool struct {
+	mutex   sync.Mutex
+	maxIdle int
+	idle    map[string][]*Client
+	// 为 nil 时使用明文连接
+	tlsConfig *tls.Config
+}
+
+// NewPool 创建连接池，maxIdle 为每个地址最多缓存的空闲连接数，
+// tlsConfig 不为 nil 时使用 TLS 连接
+func NewPool(maxIdle int, tlsConfig *tls.Config) *Pool {
+	return &Pool{
+		maxIdle:   maxIdle,
+		idle:      make(map[string][]*Client),
+		tlsConfig: tlsConfig,
+	}
+}
+
+// Invoke 从连接池取出到 addr 的连接发送 frame 并读取响应，超过 timeout 时返回超时错误。
+// 空闲连接可能已被对端关闭，只有发送失败时使用新建的连接重试一次；
+// 发送成功后读取失败时对端可能已经执行了请求，直接返回错误，避免写请求被执行两次
+func (p *Pool) Invoke(addr string, frame *network.Frame, timeout time.Duration) (*network.Frame, error) {
+	client, pooled, err := p.get(addr)
+	if err != nil {
+		return nil, err
+	}
+	err = client.send(frame, timeout)
+	if err != nil && pooled {
+		_ = client.Close()
+		if client, err = NewTLSClient(addr, p.tlsConfig); err != nil {
+			return nil, err
+		}
+		err = client.send(frame, timeout)
+	}
+	var rsp *network.Frame
+	if err == nil {
+		rsp, err = client.readResponse()
+	}
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	p.put(addr, client)
+	return rsp, nil
+}
+
+// Close 关闭所有空闲连接
+func (p *Pool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for addr, clients := range p.idle {
+		for _, client := range clients {
+			_ = client.Close()
+		}
+		delete(p.idle, addr)
+	}
+}
+
+func (p *Pool) get(addr string) (*Client, bool, error) {
+	p.mutex.Lock()
+	clients := p.idle[addr]
+	if n := len(clients); n > 0 {
+		client := clients[n-1]
+		p.idle[addr] = clients[:n-1]
+		p.mutex.Unlock()
+		return client, true, nil
+	}
+	p.mutex.Unlock()
+	client, err := NewTLSClient(addr, p.tlsConfig)
+	return client, false, err
+}
+
+func (p *Pool) put(addr string, client *Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.idle[addr]) >= p.maxIdle {
+		_ = client.Close()
+		return
+	}
+	p.idle[addr] = append(p.idle[addr], client)
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stubServer 读取每个请求后交给 reply 处理，reply 返回 false 时关闭连接
+func stubServer(t *testing.T, reply func(conn *network.Connection) bool) (string, *atomic.Int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	requests := new(atomic.Int32)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				connection := network.NewConnection(conn)
+				for {
+					if _, err := connection.ReadFrame(); err != nil {
+						return
+					}
+					requests.Add(1)
+					if !reply(&connection) {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), requests
+}
+
+func Test_PoolInvoke(t *testing.T) {
+	Convey("a peer that never replies fails the request after the timeout", t, func() {
+		addr, requests := stubServer(t, func(*network.Connection) bool {
+			time.Sleep(time.Second)
+			return false
+		})
+		pool := NewPool(1, nil)
+		defer pool.Close()
+		start := time.Now()
+		_, err := pool.Invoke(addr, cmd.NewGet("name").IntoFrame(), 100*time.Millisecond)
+		So(err, ShouldNotBeNil)
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+		So(requests.Load(), ShouldEqual, 1)
+	})
+
+	Convey("a request that was sent is not retried when reading the reply fails", t, func() {
+		served := new(atomic.Int32)
+		addr, requests := stubServer(t, func(conn *network.Connection) bool {
+			if served.Add(1) == 1 {
+				_ = conn.WriteFrame(&network.Frame{Ftype: network.Simple, Value: "OK"})
+				return true
+			}
+			// 执行了请求但没有回复就关闭连接
+			return false
+		})
+		pool := NewPool(1, nil)
+		defer pool.Close()
+		rsp, err := pool.Invoke(addr, cmd.NewSet("name", []byte("v")).IntoFrame(), time.Second)
+		So(err, ShouldBeNil)
+		So(rsp.Value, ShouldEqual, "OK")
+		_, err = pool.Invoke(addr, cmd.NewSet("name", []byte("v")).IntoFrame(), time.Second)
+		So(err, ShouldNotBeNil)
+		So(requests.Load(), ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"strconv"
+	"time"
+)
+
+const (
+	// 写请求因连接错误失败时的重试次数
+	sessionRetries = 3
+	// 两次重试之间的等待时间，留给集群选出新的 Leader
+	sessionRetryInterval = 500 * time.Millisecond
+)
+
+// Session 为写请求带上会话 ID 和递增的序号。连接断开时使用相同的序号重试，
+// 已经应用过的写请求不会被重复执行，服务端返回第一次执行的结果
+type Session struct {
+	addr   string
+	id     string
+	seq    uint64
+	client *Client
+	// 为 nil 时使用明文连接
+	tlsConfig *tls.Config
+}
+
+// NewSession 连接 addr 并创建一个随机 ID 的会话，同一会话不能被并发使用
+func NewSession(addr string) (*Session, error) {
+	return NewTLSSession(addr, nil)
+}
+
+// NewTLSSession 与 NewSession 相同，tlsConfig 不为 nil 时使用 TLS 连接
+func NewTLSSession(addr string, tlsConfig *tls.Config) (*Session, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return nil, err
+	}
+	client, err := NewTLSClient(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		addr:      addr,
+		id:        hex.EncodeToString(buf[:]),
+		client:    client,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+func (s *Session) Set(key, value string) (string, error) {
+	rsp, err := s.invoke(cmd.NewSet(key, []byte(value)))
+	if err != nil {
+		return "", err
+	}
+	switch rsp.Ftype {
+	case network.Simple:
+		return rsp.Value.(string), nil
+	case network.Error:
+		return rsp.Value.(string), nil
+	default:
+		return "", errors.New("protocol error; expected simple frame or error frame")
+	}
+}
+
+func (s *Session) Del(key string) (string, error) {
+	rsp, err := s.invoke(cmd.NewDelete(key))
+	if err != nil {
+		return "", err
+	}
+	switch rsp.Ftype {
+	case network.Integer:
+		return strconv.FormatInt(int64(rsp.Value.(int)), 10), nil
+	case network.Error:
+		return rsp.Value.(string), nil
+	default:
+		return "", errors.New("protocol error; expected simple frame or error frame")
+	}
+}
+
+// invoke 使用下一个序号发送写请求。连接出错或返回错误（例如 Leader 切换）时用同一个序号重试，
+// 服务端对已经应用的请求返回缓存的结果，因此重试是安全的
+func (s *Session) invoke(command cmd.Command) (*network.Frame, error) {
+	s.seq++
+	frame := cmd.NewSession(s.id, s.seq, command).IntoFrame()
+	var rsp *network.Frame
+	var err error
+	for i := 0; i <= sessionRetries; i++ {
+		if i > 0 {
+			time.Sleep(sessionRetryInterval)
+		}
+		if s.client == nil {
+			if s.client, err = NewTLSClient(s.addr, s.tlsConfig); err != nil {
+				continue
+			}
+		}
+		if rsp, err = s.client.Invoke(frame); err != nil {
+			_ = s.client.Close()
+			s.client = nil
+			continue
+		}
+		if rsp.Ftype != network.Error {
+			return rsp, nil
+		}
+	}
+	if rsp != nil {
+		return rsp, nil
+	}
+	return nil, err
+}
+
+func (s *Session) Close() error {
+	if s.client == nil {
+		return nil
+	}
+	return s.client.Close()
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+)
+
+type Batch struct {
+	// the write commands in proposal order
+	commands []Command
+}
+
+func NewBatch(commands ...Command) Command {
+	return &Batch{
+		commands,
+	}
+}
+
+// 从接收的Frame中解析一个 Batch 命令
+// BATCH command [command ...]，每个 command 是完整的命令数组
+func parseBatchFrame(parse *network.Parse) (Command, error) {
+	commands := make([]Command, 0)
+	for parse.HasNext() {
+		frame, err := parse.NextFrame()
+		if err != nil {
+			return nil, err
+		}
+		command, err := FromFrame(frame)
+		if err != nil {
+			return nil, err
+		}
+		if command.Name() == BATCH {
+			return nil, fmt.Errorf("protocol error; nested %s", BATCH)
+		}
+		commands = append(commands, command)
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("protocol error; empty %s", BATCH)
+	}
+	cmd := &Batch{
+		commands,
+	}
+	return cmd, nil
+}
+
+// Apply 依次执行每个命令，按顺序返回各自的响应
+func (c *Batch) Apply(db engines.KvsEngine) *network.Frame {
+	rsps := make([]*network.Frame, len(c.commands))
+	for i, command := range c.commands {
+		rsps[i] = command.Apply(db)
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: rsps,
+	}
+}
+
+func (c *Batch) IntoFrame() *network.Frame {
+	array := make([]*network.Frame, 0, len(c.commands)+1)
+	array = append(array, &network.Frame{
+		Ftype: network.Bulk,
+		Value: BATCH,
+	})
+	for _, command := range c.commands {
+		array = append(array, command.IntoFrame())
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (c *Batch) Name() string {
+	return BATCH
+}
+
+func (c *Batch) Commands() []Command {
+	return c.commands
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"strings"
+)
+
+// SlotCount Redis Cluster 的槽位数
+const SlotCount = 16384
+
+type Cluster struct {
+	// the cluster subcommand, upper case
+	opt string
+
+	// the arguments of the subcommand
+	args []string
+}
+
+func NewCluster(opt string, args ...string) Command {
+	return &Cluster{
+		strings.ToUpper(opt), args,
+	}
+}
+
+// 将接收到的 Frame 解析为一个 Cluster 命令，子命令不区分大小写
+// CLUSTER <opt> [arg ...]
+func parseClusterFrame(p *network.Parse) (Command, error) {
+	opt, err := p.NextString()
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0)
+	for p.HasNext() {
+		arg, err := p.NextString()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	cmd := &Cluster{
+		strings.ToUpper(opt), args,
+	}
+	return cmd, nil
+}
+
+// Apply 只有 KEYSLOT 不依赖集群状态，其他子命令由 raft 节点处理
+func (c *Cluster) Apply(engines.KvsEngine) *network.Frame {
+	if c.opt != ClusterKeySlot {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "cluster commands are handled by the raft store",
+		}
+	}
+	if len(c.args) != 1 {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "ERR wrong number of arguments for 'cluster|keyslot' command",
+		}
+	}
+	return &network.Frame{
+		Ftype: network.Integer,
+		Value: KeySlot(c.args[0]),
+	}
+}
+
+func (c *Cluster) IntoFrame() *network.Frame {
+	array := []*network.Frame{
+		{
+			Ftype: network.Bulk,
+			Value: CLUSTER,
+		},
+		{
+			Ftype: network.Bulk,
+			Value: c.opt,
+		},
+	}
+	for _, arg := range c.args {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: arg,
+		})
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (c *Cluster) Name() string {
+	return CLUSTER
+}
+
+func (c *Cluster) Opt() string {
+	return c.opt
+}
+
+// Asking 客户端收到 -ASK 后在重发的命令之前发送，只对同一连接上的下一条命令有效
+type Asking struct{}
+
+func NewAsking() Command {
+	return &Asking{}
+}
+
+func (a *Asking) Apply(engines.KvsEngine) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+func (a *Asking) IntoFrame() *network.Frame {
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: []*network.Frame{
+			{
+				Ftype: network.Bulk,
+				Value: ASKING,
+			},
+		},
+	}
+}
+
+func (a *Asking) Name() string {
+	return ASKING
+}
+
+// KeySlot 按 Redis Cluster 的规则计算 key 的槽位：key 中第一个 '{' 与其后第一个 '}'
+// 之间的内容非空时只对它计算 CRC16，使相同 hash tag 的 key 落在同一个槽位
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % SlotCount
+}
+
+// crc16 CRC16-CCITT（XMODEM），多项式 0x1021，初始值 0
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// parseAskingFrame ASKING 没有参数
+func parseAskingFrame(*network.Parse) (Command, error) {
+	return &Asking{}, nil
+}
@@ -12,14 +12,95 @@ const (
 	DELETE = "DEL"
 	MEMBER = "member"
 	CONFIG = "CONFIG"
+	INFO   = "INFO"
+	// BATCH 由 Leader 合并多个并发写请求得到的日志条目，不接受客户端直接发送
+	BATCH = "BATCH"
+	// SESSION 带有客户端会话 ID 和序号的写请求，重试时返回第一次执行的结果
+	SESSION = "SESSION"
+	// RANGE 查看和管理 key 空间的分片区间
+	RANGE = "range"
+	// CLUSTER 兼容 Redis Cluster 的槽位查询命令
+	CLUSTER = "CLUSTER"
+	// ASKING 表示下一条命令是按 -ASK 重定向发送的
+	ASKING = "ASKING"
+	// HELLO 协商连接使用的 RESP 协议版本
+	HELLO = "HELLO"
 )
 
+// InfoRaft INFO 命令的 raft 段，包含节点状态和各成员的复制进度
+const InfoRaft = "raft"
+
 const (
 	MemberAdd    = "add"
 	MemberRemove = "remove"
 	MemberList   = "list"
+	// MemberRegister 在元数据表中登记节点的客户端地址，地址为空时删除登记
+	MemberRegister = "register"
+	// MemberJoin 新节点请求加入集群，先作为 Nonvoter 追赶日志再提升为 Voter
+	MemberJoin = "join"
+	// MemberProgress 查询节点最新的日志位置
+	MemberProgress = "progress"
+	// MemberTransferLeader 将 Leader 转移给指定节点，未指定时由 raft 选择最新的节点
+	MemberTransferLeader = "transfer-leader"
+	// MemberDrain 维护节点前将 Leader 从该节点转移走
+	MemberDrain = "drain"
+	// MemberPromote 将 Nonvoter 提升为 Voter
+	MemberPromote = "promote"
+	// MemberDemote 将 Voter 降级为 Nonvoter
+	MemberDemote = "demote"
+	// MemberHealth 由 Leader 报告集群和每个成员的健康状况
+	MemberHealth = "health"
+	// MemberLearner add、join 的可选参数，以 Nonvoter 身份加入集群，不影响多数派
+	MemberLearner = "learner"
+)
+
+const (
+	// RangeList 列出元数据表中的所有区间
+	RangeList = "list"
+	// RangeSplit 在指定的 key 处分裂区间，未指定时取区间的中位 key
+	RangeSplit = "split"
+	// RangeMerge 将区间与右侧相邻的区间合并
+	RangeMerge = "merge"
+	// RangeAlloc 由元数据组分配新的区间 ID
+	RangeAlloc = "alloc"
+	// RangeUpdate 更新元数据表中的区间描述
+	RangeUpdate = "update"
+	// RangeFreeze 合并前冻结区间，参数为 0 时解冻
+	RangeFreeze = "freeze"
+)
+
+// CLUSTER 命令的子命令，解析时统一转为大写
+const (
+	// ClusterSlots 按槽位区间列出负责的节点，第一个为主节点
+	ClusterSlots = "SLOTS"
+	// ClusterShards 按分片列出槽位区间和节点
+	ClusterShards = "SHARDS"
+	// ClusterNodes 以 nodes.conf 的格式列出节点和它负责的槽位
+	ClusterNodes = "NODES"
+	// ClusterKeySlot 返回 key 的槽位
+	ClusterKeySlot = "KEYSLOT"
 )
 
+// GET 命令的读一致性级别
+const (
+	// ReadLinearizable Leader 通过 ReadIndex 确认自己仍是 Leader 后再读
+	ReadLinearizable = "linearizable"
+	// ReadLease Leader 在租约有效期内直接读本地数据
+	ReadLease = "lease"
+	// ReadStale 任意节点直接读本地数据，可能读到旧值
+	ReadStale = "stale"
+)
+
+// ValidReadConsistency 检查是否是支持的读一致性级别
+func ValidReadConsistency(mode string) bool {
+	switch mode {
+	case ReadLinearizable, ReadLease, ReadStale:
+		return true
+	default:
+		return false
+	}
+}
+
 type Command interface {
 	Apply(db engines.KvsEngine) *network.Frame
 	IntoFrame() *network.Frame
@@ -45,6 +126,20 @@ func FromFrame(frame *network.Frame) (Command, error) {
 		cmd, err = parseMemberFrame(parse)
 	case CONFIG:
 		cmd, err = parseConfigFrame(parse)
+	case INFO:
+		cmd, err = parseInfoFrame(parse)
+	case BATCH:
+		cmd, err = parseBatchFrame(parse)
+	case SESSION:
+		cmd, err = parseSessionFrame(parse)
+	case RANGE:
+		cmd, err = parseRangeFrame(parse)
+	case CLUSTER:
+		cmd, err = parseClusterFrame(parse)
+	case ASKING:
+		cmd, err = parseAskingFrame(parse)
+	case HELLO:
+		cmd, err = parseHelloFrame(parse)
 	default:
 		err = fmt.Errorf("unknown command %s", commandName)
 	}
@@ -82,3 +82,166 @@ func Test_DeleteFrame(t *testing.T) {
 		So(command.Name(), ShouldEqual, DELETE)
 	})
 }
+
+func Test_GetFrameWithConsistency(t *testing.T) {
+	Convey("test GET frame with read consistency", t, func() {
+		command, err := FromFrame(NewGetWithConsistency("name", ReadLease).IntoFrame())
+		So(err, ShouldBeNil)
+		So(command.Name(), ShouldEqual, GET)
+		So(command.(*Get).Consistency(), ShouldEqual, ReadLease)
+
+		frame := NewGet("name").IntoFrame()
+		frame.Value = append(frame.Value.([]*network.Frame), &network.Frame{Ftype: network.Bulk, Value: "CONSISTENCY"},
+			&network.Frame{Ftype: network.Bulk, Value: "eventual"})
+		_, err = FromFrame(frame)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_MemberFrameWithOptions(t *testing.T) {
+	Convey("test MEMBER frame with trailing options", t, func() {
+		command, err := FromFrame(NewMember(MemberJoin, "n2", "127.0.0.1:2328", "127.0.0.1:2327").IntoFrame())
+		So(err, ShouldBeNil)
+		member := command.(*Member)
+		So(member.Opt(), ShouldEqual, MemberJoin)
+		So(member.ServerID(), ShouldEqual, "n2")
+		So(member.Address(), ShouldEqual, "127.0.0.1:2328")
+		So(member.Option(0), ShouldEqual, "127.0.0.1:2327")
+		So(member.Option(1), ShouldEqual, "")
+	})
+}
+
+func Test_InfoFrame(t *testing.T) {
+	Convey("test INFO frame with optional section", t, func() {
+		command, err := FromFrame(NewInfo(InfoRaft).IntoFrame())
+		So(err, ShouldBeNil)
+		So(command.Name(), ShouldEqual, INFO)
+		So(command.(*Info).Section(), ShouldEqual, InfoRaft)
+
+		command, err = FromFrame(NewInfo("").IntoFrame())
+		So(err, ShouldBeNil)
+		So(command.(*Info).Section(), ShouldEqual, "")
+	})
+}
+
+func Test_BatchFrame(t *testing.T) {
+	Convey("test BATCH frame round trip through RESP bytes", t, func() {
+		batch := NewBatch(NewSet("name", []byte("mars")), NewDelete("age"))
+		data, err := batch.IntoFrame().Bytes()
+		So(err, ShouldBeNil)
+		frame, err := network.ParseRESP(data)
+		So(err, ShouldBeNil)
+		command, err := FromFrame(frame)
+		So(err, ShouldBeNil)
+		So(command.Name(), ShouldEqual, BATCH)
+		So(command.(*Batch).Commands(), ShouldResemble, batch.(*Batch).Commands())
+
+		_, err = FromFrame(NewBatch().IntoFrame())
+		So(err, ShouldNotBeNil)
+		_, err = FromFrame(NewBatch(batch).IntoFrame())
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_SessionFrame(t *testing.T) {
+	Convey("test SESSION frame wraps a write command", t, func() {
+		session := NewSession("c1", 7, NewSet("name", []byte("mars")))
+		command, err := FromFrame(session.IntoFrame())
+		So(err, ShouldBeNil)
+		So(command, ShouldResemble, session)
+		So(command.(*Session).Seq(), ShouldEqual, 7)
+
+		_, err = FromFrame(NewSession("c1", 8, NewGet("name")).IntoFrame())
+		So(err, ShouldNotBeNil)
+		frame := NewSession("c1", 9, NewDelete("name")).IntoFrame()
+		frame.Value.([]*network.Frame)[2].Value = "-1"
+		_, err = FromFrame(frame)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_ClusterFrame(t *testing.T) {
+	Convey("test CLUSTER frame and key slots", t, func() {
+		frame := NewCluster("keyslot", "foo").IntoFrame()
+		frame.Value.([]*network.Frame)[1].Value = "keyslot"
+		command, err := FromFrame(frame)
+		So(err, ShouldBeNil)
+		So(command.(*Cluster).Opt(), ShouldEqual, ClusterKeySlot)
+		So(command.Apply(nil).Value, ShouldEqual, 12182)
+
+		So(KeySlot("bar"), ShouldEqual, 5061)
+		So(KeySlot("123456789"), ShouldEqual, 0x31c3%SlotCount)
+		So(KeySlot("{user1000}.following"), ShouldEqual, KeySlot("{user1000}.followers"))
+		So(KeySlot("{user1000}.following"), ShouldEqual, KeySlot("user1000"))
+		// 空的 hash tag 不生效
+		So(KeySlot("foo{}{bar}"), ShouldNotEqual, KeySlot("bar"))
+
+		command, err = FromFrame(NewAsking().IntoFrame())
+		So(err, ShouldBeNil)
+		So(command.Name(), ShouldEqual, ASKING)
+	})
+}
+
+func Test_HelloFrame(t *testing.T) {
+	Convey("test HELLO frame with options", t, func() {
+		frame := NewHello(3).IntoFrame()
+		frame.Value = append(frame.Value.([]*network.Frame),
+			&network.Frame{Ftype: network.Bulk, Value: "setname"},
+			&network.Frame{Ftype: network.Bulk, Value: "worker-1"},
+		)
+		command, err := FromFrame(frame)
+		So(err, ShouldBeNil)
+		hello := command.(*Hello)
+		So(hello.Protocol(), ShouldEqual, 3)
+		So(hello.ClientName(), ShouldEqual, "worker-1")
+		So(hello.Auth(), ShouldBeFalse)
+
+		command, err = FromFrame(NewHello(0).IntoFrame())
+		So(err, ShouldBeNil)
+		So(command.(*Hello).Protocol(), ShouldEqual, 0)
+
+		frame = NewHello(3).IntoFrame()
+		frame.Value.([]*network.Frame)[1].Value = "three"
+		_, err = FromFrame(frame)
+		So(err, ShouldNotBeNil)
+
+		reply := HelloReply(7, network.RESP3, "standalone", "master").Value.([]*network.Frame)
+		So(reply[4].Value, ShouldEqual, "proto")
+		So(reply[5].Value, ShouldEqual, 3)
+	})
+}
+
+func Test_ConfigGet(t *testing.T) {
+	Convey("test CONFIG GET returns matching parameters as a map", t, func() {
+		frame := NewConfig("get", "server.max-frame-size", "SERVER.MAX*").IntoFrame()
+		command, err := FromFrame(frame)
+		So(err, ShouldBeNil)
+		rsp := command.Apply(nil)
+		So(rsp.Ftype, ShouldEqual, network.Map)
+		So(rsp.Value, ShouldResemble, []*network.Frame{
+			{Ftype: network.Bulk, Value: "server.max-frame-size"},
+			{Ftype: network.Bulk, Value: "67108864"},
+		})
+		data, err := rsp.Bytes()
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "*2\r\n$21\r\nserver.max-frame-size\r\n$8\r\n67108864\r\n")
+
+		So(NewConfig("get", "raft.autopilot.*").Apply(nil).Value, ShouldHaveLength, 10)
+		So(NewConfig("set", "server.addr").Apply(nil).Ftype, ShouldEqual, network.Error)
+	})
+}
+
+func Test_InfoMap(t *testing.T) {
+	Convey("test INFO text is converted to a map", t, func() {
+		rsp := InfoMap(&network.Frame{Ftype: network.Bulk, Value: "# Raft\r\nnode_id:0\r\npeer0:id=0,address=a:1\r\n"})
+		So(rsp.Ftype, ShouldEqual, network.Map)
+		So(rsp.Value, ShouldResemble, []*network.Frame{
+			{Ftype: network.Bulk, Value: "node_id"},
+			{Ftype: network.Bulk, Value: "0"},
+			{Ftype: network.Bulk, Value: "peer0"},
+			{Ftype: network.Bulk, Value: "id=0,address=a:1"},
+		})
+		errFrame := &network.Frame{Ftype: network.Error, Value: "not leader"}
+		So(InfoMap(errFrame), ShouldEqual, errFrame)
+	})
+}
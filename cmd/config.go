@@ -1,51 +1,103 @@
 package cmd
 
 import (
-	"fmt"
+	"github.com/huiming23344/kv-raft/config"
 	"github.com/huiming23344/kv-raft/db/engines"
 	"github.com/huiming23344/kv-raft/network"
+	"strings"
 )
 
+// ConfigGet CONFIG 命令目前只支持读取配置
+const ConfigGet = "GET"
+
 type Config struct {
-	opt    string
-	config string
+	// the config subcommand, upper case
+	opt string
+	// the glob patterns of the parameters
+	patterns []string
 }
 
 var _ Command = (*Config)(nil)
 
+func NewConfig(opt string, patterns ...string) Command {
+	return &Config{
+		strings.ToUpper(opt), patterns,
+	}
+}
+
+// 从接收的Frame中解析一个 Config 命令，子命令不区分大小写
+// CONFIG GET pattern [pattern ...]
 func parseConfigFrame(p *network.Parse) (Command, error) {
-	fmt.Println("parseConfigFrame")
-	fmt.Printf("p: %v\n", p)
 	opt, err := p.NextString()
 	if err != nil {
 		return nil, err
 	}
-	config, err := p.NextString()
-	if err != nil {
-		return nil, err
+	patterns := make([]string, 0)
+	for p.HasNext() {
+		pattern, err := p.NextString()
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, pattern)
+	}
+	cmd := &Config{
+		strings.ToUpper(opt), patterns,
 	}
-	cmd := &Config{opt, config}
 	return cmd, nil
 }
 
-func (c *Config) Apply(db engines.KvsEngine) *network.Frame {
-	rsp := new(network.Frame)
-	rsp.Ftype = network.Array
-	rsp.Value = []*network.Frame{}
-	return rsp
+// Apply 返回匹配的配置项和值，RESP3 的连接上是 Map，RESP2 的连接上展开为键值交替的数组
+func (c *Config) Apply(engines.KvsEngine) *network.Frame {
+	if c.opt != ConfigGet {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "ERR unsupported CONFIG subcommand '" + c.opt + "'",
+		}
+	}
+	if len(c.patterns) == 0 {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "ERR wrong number of arguments for 'config|get' command",
+		}
+	}
+	cfg := config.GlobalConfig()
+	seen := make(map[string]bool)
+	pairs := make([]*network.Frame, 0)
+	for _, pattern := range c.patterns {
+		for _, param := range cfg.Params(pattern) {
+			if seen[param.Name] {
+				continue
+			}
+			seen[param.Name] = true
+			pairs = append(pairs,
+				&network.Frame{Ftype: network.Bulk, Value: param.Name},
+				&network.Frame{Ftype: network.Bulk, Value: param.Value},
+			)
+		}
+	}
+	return &network.Frame{
+		Ftype: network.Map,
+		Value: pairs,
+	}
 }
 
 func (c *Config) IntoFrame() *network.Frame {
 	array := []*network.Frame{
 		{
 			Ftype: network.Bulk,
-			Value: DELETE,
+			Value: CONFIG,
 		},
 		{
 			Ftype: network.Bulk,
-			Value: "aaa",
+			Value: c.opt,
 		},
 	}
+	for _, pattern := range c.patterns {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: pattern,
+		})
+	}
 	return &network.Frame{
 		Ftype: network.Array,
 		Value: array,
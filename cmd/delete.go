@@ -69,3 +69,7 @@ func (c *Delete) IntoFrame() *network.Frame {
 func (c *Delete) Name() string {
 	return DELETE
 }
+
+func (c *Delete) Key() string {
+	return c.key
+}
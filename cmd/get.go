@@ -2,31 +2,64 @@ package cmd
 
 import (
 	"errors"
+	"fmt"
 	"github.com/huiming23344/kv-raft/db/engines"
 	kvsError "github.com/huiming23344/kv-raft/errors"
 	"github.com/huiming23344/kv-raft/network"
 	"log"
+	"strings"
 )
 
+// consistencyOption GET 命令可选参数，用于覆盖服务端默认的读一致性级别
+const consistencyOption = "CONSISTENCY"
+
 type Get struct {
 	// the lockup key
 	key string
+	// the read consistency, empty means the server default
+	consistency string
 }
 
 func NewGet(key string) Command {
 	return &Get{
-		key,
+		key: key,
+	}
+}
+
+func NewGetWithConsistency(key, consistency string) Command {
+	return &Get{
+		key:         key,
+		consistency: consistency,
 	}
 }
 
 // 从接收的Frame中解析一个 Get 命令
+// GET key [CONSISTENCY linearizable|lease|stale]
 func parseGetFrame(parse *network.Parse) (Command, error) {
 	key, err := parse.NextString()
 	if err != nil {
 		return nil, err
 	}
 	cmd := &Get{
-		key,
+		key: key,
+	}
+	if parse.HasNext() {
+		option, err := parse.NextString()
+		if err != nil {
+			return nil, err
+		}
+		if strings.ToUpper(option) != consistencyOption {
+			return nil, fmt.Errorf("unknown GET option %s", option)
+		}
+		mode, err := parse.NextString()
+		if err != nil {
+			return nil, err
+		}
+		mode = strings.ToLower(mode)
+		if !ValidReadConsistency(mode) {
+			return nil, fmt.Errorf("unknown read consistency %s", mode)
+		}
+		cmd.consistency = mode
 	}
 	return cmd, nil
 }
@@ -60,6 +93,15 @@ func (c *Get) IntoFrame() *network.Frame {
 			Value: c.key,
 		},
 	}
+	if c.consistency != "" {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: consistencyOption,
+		}, &network.Frame{
+			Ftype: network.Bulk,
+			Value: c.consistency,
+		})
+	}
 	return &network.Frame{
 		Ftype: network.Array,
 		Value: array,
@@ -69,3 +111,12 @@ func (c *Get) IntoFrame() *network.Frame {
 func (c *Get) Name() string {
 	return GET
 }
+
+func (c *Get) Key() string {
+	return c.key
+}
+
+// Consistency 返回请求指定的读一致性级别，为空表示使用服务端默认值
+func (c *Get) Consistency() string {
+	return c.consistency
+}
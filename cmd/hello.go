@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// HELLO 命令的可选参数
+const (
+	helloAuth    = "AUTH"
+	helloSetName = "SETNAME"
+)
+
+// Hello 协商连接使用的协议版本并返回服务端信息
+type Hello struct {
+	// the protocol version, 0 means keep the current version
+	protocol int
+	// whether AUTH was given
+	auth       bool
+	username   string
+	password   string
+	clientName string
+}
+
+func NewHello(protocol int) Command {
+	return &Hello{
+		protocol: protocol,
+	}
+}
+
+// 从接收的Frame中解析一个 Hello 命令，协议版本不支持时由服务端回复 -NOPROTO
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func parseHelloFrame(p *network.Parse) (Command, error) {
+	cmd := &Hello{}
+	if !p.HasNext() {
+		return cmd, nil
+	}
+	version, err := p.NextString()
+	if err != nil {
+		return nil, err
+	}
+	if cmd.protocol, err = strconv.Atoi(version); err != nil {
+		return nil, errors.New("protocol version is not an integer")
+	}
+	for p.HasNext() {
+		opt, err := p.NextString()
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToUpper(opt) {
+		case helloAuth:
+			cmd.auth = true
+			if cmd.username, err = p.NextString(); err != nil {
+				return nil, err
+			}
+			if cmd.password, err = p.NextString(); err != nil {
+				return nil, err
+			}
+		case helloSetName:
+			if cmd.clientName, err = p.NextString(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("syntax error in HELLO option " + opt)
+		}
+	}
+	return cmd, nil
+}
+
+// Apply 协议版本属于连接的状态，HELLO 由服务端处理
+func (c *Hello) Apply(engines.KvsEngine) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Error,
+		Value: "hello is handled by the server",
+	}
+}
+
+func (c *Hello) IntoFrame() *network.Frame {
+	array := []*network.Frame{
+		{
+			Ftype: network.Bulk,
+			Value: HELLO,
+		},
+	}
+	if c.protocol != 0 {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: strconv.Itoa(c.protocol),
+		})
+	}
+	if c.auth {
+		array = append(array,
+			&network.Frame{Ftype: network.Bulk, Value: helloAuth},
+			&network.Frame{Ftype: network.Bulk, Value: c.username},
+			&network.Frame{Ftype: network.Bulk, Value: c.password},
+		)
+	}
+	if c.clientName != "" {
+		array = append(array,
+			&network.Frame{Ftype: network.Bulk, Value: helloSetName},
+			&network.Frame{Ftype: network.Bulk, Value: c.clientName},
+		)
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (c *Hello) Name() string {
+	return HELLO
+}
+
+// Protocol 请求的协议版本，为 0 时不切换
+func (c *Hello) Protocol() int {
+	return c.protocol
+}
+
+// Auth 是否带有 AUTH 参数
+func (c *Hello) Auth() bool {
+	return c.auth
+}
+
+// ClientName SETNAME 设置的连接名称
+func (c *Hello) ClientName() string {
+	return c.clientName
+}
+
+// HelloReply 按 Redis 的格式返回服务端信息，mode 为 standalone 或 cluster，role 为 master 或 replica
+func HelloReply(id int64, protocol int, mode, role string) *network.Frame {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	field := func(key string, value *network.Frame) []*network.Frame {
+		return []*network.Frame{{Ftype: network.Bulk, Value: key}, value}
+	}
+	pairs := make([]*network.Frame, 0, 14)
+	pairs = append(pairs, field("server", &network.Frame{Ftype: network.Bulk, Value: "kv-raft"})...)
+	pairs = append(pairs, field("version", &network.Frame{Ftype: network.Bulk, Value: version})...)
+	pairs = append(pairs, field("proto", &network.Frame{Ftype: network.Integer, Value: protocol})...)
+	pairs = append(pairs, field("id", &network.Frame{Ftype: network.Integer, Value: int(id)})...)
+	pairs = append(pairs, field("mode", &network.Frame{Ftype: network.Bulk, Value: mode})...)
+	pairs = append(pairs, field("role", &network.Frame{Ftype: network.Bulk, Value: role})...)
+	pairs = append(pairs, field("modules", &network.Frame{Ftype: network.Array, Value: []*network.Frame{}})...)
+	return &network.Frame{
+		Ftype: network.Map,
+		Value: pairs,
+	}
+}
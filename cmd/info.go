@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"strings"
+)
+
+type Info struct {
+	// the section to report, empty means all sections
+	section string
+}
+
+func NewInfo(section string) Command {
+	return &Info{
+		section,
+	}
+}
+
+// 从接收的Frame中解析一个 Info 命令
+// INFO [section]
+func parseInfoFrame(parse *network.Parse) (Command, error) {
+	section := ""
+	if parse.HasNext() {
+		var err error
+		if section, err = parse.NextString(); err != nil {
+			return nil, err
+		}
+	}
+	cmd := &Info{
+		section,
+	}
+	return cmd, nil
+}
+
+// Apply 节点状态由 raft 节点提供，存储引擎中没有可报告的内容
+func (c *Info) Apply(engines.KvsEngine) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Bulk,
+		Value: "",
+	}
+}
+
+func (c *Info) IntoFrame() *network.Frame {
+	array := []*network.Frame{
+		{
+			Ftype: network.Bulk,
+			Value: INFO,
+		},
+	}
+	if c.section != "" {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: c.section,
+		})
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (c *Info) Name() string {
+	return INFO
+}
+
+func (c *Info) Section() string {
+	return c.section
+}
+
+// InfoMap 将 INFO 返回的 key:value 文本转换为 RESP3 的 Map，段名所在的行和空行被忽略，
+// 不是文本的响应（例如错误）原样返回
+func InfoMap(frame *network.Frame) *network.Frame {
+	if frame.Ftype != network.Bulk {
+		return frame
+	}
+	text, ok := frame.Value.(string)
+	if !ok {
+		text = string(frame.Value.([]byte))
+	}
+	pairs := make([]*network.Frame, 0)
+	for _, line := range strings.Split(text, "\r\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pairs = append(pairs,
+			&network.Frame{Ftype: network.Bulk, Value: key},
+			&network.Frame{Ftype: network.Bulk, Value: value},
+		)
+	}
+	return &network.Frame{
+		Ftype: network.Map,
+		Value: pairs,
+	}
+}
@@ -14,15 +14,19 @@ type Member struct {
 
 	// the member address
 	address string
+
+	// the optional arguments of the subcommand
+	options []string
 }
 
-func NewMember(opt, serverID, address string) Command {
+func NewMember(opt, serverID, address string, options ...string) Command {
 	return &Member{
-		opt, serverID, address,
+		opt, serverID, address, options,
 	}
 }
 
 // 将接收到的 Frame 解析为一个 Member 命令
+// member <opt> <serverID> <address> [option ...]
 func parseMemberFrame(p *network.Parse) (Command, error) {
 	opt, err := p.NextString()
 	if err != nil {
@@ -36,8 +40,16 @@ func parseMemberFrame(p *network.Parse) (Command, error) {
 	if err != nil {
 		return nil, err
 	}
+	options := make([]string, 0)
+	for p.HasNext() {
+		option, err := p.NextString()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
 	cmd := &Member{
-		opt, serverID, address,
+		opt, serverID, address, options,
 	}
 	return cmd, nil
 }
@@ -68,6 +80,12 @@ func (m *Member) IntoFrame() *network.Frame {
 			Value: m.address,
 		},
 	}
+	for _, option := range m.options {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: option,
+		})
+	}
 	return &network.Frame{
 		Ftype: network.Array,
 		Value: array,
@@ -89,3 +107,16 @@ func (m *Member) ServerID() string {
 func (m *Member) Address() string {
 	return m.address
 }
+
+// Options 返回所有可选参数
+func (m *Member) Options() []string {
+	return m.options
+}
+
+// Option 返回第 i 个可选参数，不存在时返回空字符串
+func (m *Member) Option(i int) string {
+	if i < len(m.options) {
+		return m.options[i]
+	}
+	return ""
+}
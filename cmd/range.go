@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+)
+
+type Range struct {
+	// the range operate command
+	opt string
+
+	// the arguments of the subcommand
+	args []string
+}
+
+func NewRange(opt string, args ...string) Command {
+	return &Range{
+		opt, args,
+	}
+}
+
+// 将接收到的 Frame 解析为一个 Range 命令
+// range <opt> [arg ...]
+func parseRangeFrame(p *network.Parse) (Command, error) {
+	opt, err := p.NextString()
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0)
+	for p.HasNext() {
+		arg, err := p.NextString()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	cmd := &Range{
+		opt, args,
+	}
+	return cmd, nil
+}
+
+// Apply 区间由 raft 节点管理，存储引擎不处理该命令
+func (r *Range) Apply(engines.KvsEngine) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Error,
+		Value: "range commands are handled by the raft store",
+	}
+}
+
+func (r *Range) IntoFrame() *network.Frame {
+	array := []*network.Frame{
+		{
+			Ftype: network.Bulk,
+			Value: RANGE,
+		},
+		{
+			Ftype: network.Bulk,
+			Value: r.opt,
+		},
+	}
+	for _, arg := range r.args {
+		array = append(array, &network.Frame{
+			Ftype: network.Bulk,
+			Value: arg,
+		})
+	}
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (r *Range) Name() string {
+	return RANGE
+}
+
+func (r *Range) Opt() string {
+	return r.opt
+}
+
+// Args 返回所有参数
+func (r *Range) Args() []string {
+	return r.args
+}
+
+// Arg 返回第 i 个参数，不存在时返回空字符串
+func (r *Range) Arg(i int) string {
+	if i < len(r.args) {
+		return r.args[i]
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"strconv"
+)
+
+type Session struct {
+	// the client session id
+	id string
+	// the sequence number of the request in the session
+	seq uint64
+	// the wrapped write command
+	command Command
+}
+
+func NewSession(id string, seq uint64, command Command) Command {
+	return &Session{
+		id, seq, command,
+	}
+}
+
+// 从接收的Frame中解析一个 Session 命令
+// SESSION id seq SET key value | SESSION id seq DEL key
+func parseSessionFrame(parse *network.Parse) (Command, error) {
+	id, err := parse.NextString()
+	if err != nil {
+		return nil, err
+	}
+	seqStr, err := parse.NextString()
+	if err != nil {
+		return nil, err
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("protocol error; invalid session sequence %q", seqStr)
+	}
+	command, err := FromFrame(parse.Rest())
+	if err != nil {
+		return nil, err
+	}
+	if command.Name() != SET && command.Name() != DELETE {
+		return nil, fmt.Errorf("protocol error; %s only wraps %s and %s", SESSION, SET, DELETE)
+	}
+	cmd := &Session{
+		id, seq, command,
+	}
+	return cmd, nil
+}
+
+// Apply 执行被包装的命令，去重由 raft 状态机完成
+func (c *Session) Apply(db engines.KvsEngine) *network.Frame {
+	return c.command.Apply(db)
+}
+
+func (c *Session) IntoFrame() *network.Frame {
+	array := []*network.Frame{
+		{
+			Ftype: network.Bulk,
+			Value: SESSION,
+		},
+		{
+			Ftype: network.Bulk,
+			Value: c.id,
+		},
+		{
+			Ftype: network.Bulk,
+			Value: strconv.FormatUint(c.seq, 10),
+		},
+	}
+	array = append(array, c.command.IntoFrame().Value.([]*network.Frame)...)
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: array,
+	}
+}
+
+func (c *Session) Name() string {
+	return SESSION
+}
+
+func (c *Session) ID() string {
+	return c.id
+}
+
+func (c *Session) Seq() uint64 {
+	return c.seq
+}
+
+func (c *Session) Command() Command {
+	return c.command
+}
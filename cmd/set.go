@@ -9,11 +9,11 @@ import (
 type Set struct {
 	// the lockup key
 	key string
-	// the value to be store
-	value string
+	// the value to be store, may contain any bytes
+	value []byte
 }
 
-func NewSet(key string, value string) Command {
+func NewSet(key string, value []byte) Command {
 	return &Set{
 		key, value,
 	}
@@ -25,7 +25,7 @@ func parseSetFrame(p *network.Parse) (Command, error) {
 	if err != nil {
 		return nil, err
 	}
-	value, err := p.NextString()
+	value, err := p.NextBytes()
 	if err != nil {
 		return nil, err
 	}
@@ -75,3 +75,11 @@ func (c *Set) IntoFrame() *network.Frame {
 func (c *Set) Name() string {
 	return SET
 }
+
+func (c *Set) Key() string {
+	return c.key
+}
+
+func (c *Set) Value() []byte {
+	return c.value
+}
@@ -9,6 +9,9 @@ import (
 
 var ServerConfigPath = defaultConfigPath
 
+// ForceNewCluster 启动前把所有 raft 组的集群配置改写为只有本节点，用于多数节点永久丢失后恢复
+var ForceNewCluster bool
+
 const (
 	defaultConfigPath = "./app.yaml"
 )
@@ -17,6 +20,8 @@ const (
 func serverConfigPath() string {
 	if ServerConfigPath == defaultConfigPath {
 		flag.StringVar(&ServerConfigPath, "conf", defaultConfigPath, "server config path")
+		flag.BoolVar(&ForceNewCluster, "force-new-cluster", false,
+			"rewrite the raft configuration to this node only before starting, after a majority of nodes is lost")
 		flag.Parse()
 	}
 	return ServerConfigPath
@@ -27,16 +32,84 @@ type Config struct {
 		Name     string `yaml:"name"`
 		Addr     string `yaml:"addr"`
 		CacheCap int    `yaml:"cache-cap"`
+		// 节点数据根目录，默认为 ./nodes/node<raft.node-id>
+		DataDir string `yaml:"data-dir"`
+		// 对其他节点公布的客户端 RESP 地址，默认由 addr 推导
+		AdvertiseAddr string `yaml:"advertise-addr"`
+		// GET 默认的读一致性级别：linearizable、lease 或 stale
+		ReadConsistency string `yaml:"read-consistency"`
+		// 跟随者转发请求的方式：proxy 代理给 Leader，redirect 回复 -MOVED
+		ForwardMode string `yaml:"forward-mode"`
+		// 转发请求时每个 Leader 地址缓存的空闲连接数
+		ForwardPoolSize int `yaml:"forward-pool-size"`
+		// 收到 SIGINT/SIGTERM 后完成关闭的最长时间，单位秒
+		ShutdownTimeout int `yaml:"shutdown-timeout"`
+		// 关闭前是否将 Leader 转移给其他节点
+		ShutdownTransferLeader bool `yaml:"shutdown-transfer-leader"`
+		// 客户端发送的单个 RESP Frame 的最大字节数，超过时回复错误并关闭连接
+		MaxFrameSize int `yaml:"max-frame-size"`
+		// 客户端 RESP 监听的 TLS 配置，节点之间转发请求时也使用该证书
+		TLS TLS `yaml:"tls"`
+		// 兼容 Redis Cluster：区间按 16384 个槽位划分，非 Leader 对 key 命令回复 -MOVED/-ASK。
+		// 只能在第一次分裂之前开启，所有节点需要配置相同的值
+		ClusterEnabled bool `yaml:"cluster-enabled"`
 	}
 
 	Raft struct {
+		// 集群内唯一的节点 ID
+		NodeID string `yaml:"node-id"`
+		// 是否作为 Voter 参与选举和多数派，false 时作为只读副本（learner）加入集群
 		Voter       bool   `yaml:"is-voter"`
 		Port        string `yaml:"port"`
 		UseLoopBack bool   `yaml:"use-loopback"`
 		Bootstrap   bool   `yaml:"bootstrap"`
+		// 新节点加入集群时联系的已有成员的客户端地址
+		Join []string `yaml:"join"`
+		// raft 日志、快照的存储目录，默认为 server.data-dir
+		DataDir string `yaml:"data-dir"`
+		// 对其他节点公布的 raft 地址，默认为监听地址
+		AdvertiseAddr string `yaml:"advertise-addr"`
+		// 保留的快照数量
+		SnapshotRetain int `yaml:"snapshot-retain"`
+		// 距上次快照新增多少条日志后触发快照
+		SnapshotThreshold uint64 `yaml:"snapshot-threshold"`
+		// 检查是否需要快照的时间间隔，单位秒
+		SnapshotInterval int `yaml:"snapshot-interval"`
+		// 快照后保留的日志条数，便于落后不多的节点直接追日志
+		TrailingLogs uint64 `yaml:"trailing-logs"`
+		// 客户端会话空闲多久后过期，单位秒，所有节点需要配置相同的值
+		SessionTTL int `yaml:"session-ttl"`
+		// Leader 把并发的写请求合并为一条日志，每条日志最多合并的写请求数，小于等于 1 时不合并
+		MaxBatchSize int `yaml:"max-batch-size"`
+		// 第一个写请求到达后等待更多写请求的时间，单位微秒，为 0 时只合并已在排队的写请求
+		MaxBatchDelay int `yaml:"max-batch-delay"`
+		// 节点之间 raft 通信的 TLS 配置，开启时所有节点都需要开启
+		TLS TLS `yaml:"tls"`
+		// 区间的 key 数超过该值时自动分裂，为 0 时不按大小分裂
+		RangeSplitKeys int64 `yaml:"range-split-keys"`
+		// 区间的 QPS 超过该值时自动分裂，为 0 时不按 QPS 分裂
+		RangeSplitQPS int `yaml:"range-split-qps"`
+		// 相邻两个区间的 key 数之和小于该值时自动合并，为 0 时不合并
+		RangeMergeKeys int64 `yaml:"range-merge-keys"`
+		// 检查区间大小和 QPS 的时间间隔，单位秒
+		RangeCheckInterval int `yaml:"range-check-interval"`
+		// Leader 跟踪成员的健康状况，可选地删除失联的节点
+		Autopilot struct {
+			// 是否删除失联超过 dead-server-threshold 的节点
+			CleanupDeadServers bool `yaml:"cleanup-dead-servers"`
+			// 超过该时间没有联系上的节点视为不健康，单位秒
+			LastContactThreshold int `yaml:"last-contact-threshold"`
+			// 落后 Leader 超过该日志条数的节点视为不健康
+			MaxTrailingLogs uint64 `yaml:"max-trailing-logs"`
+			// 失联超过该时间的节点被删除，单位秒
+			DeadServerThreshold int `yaml:"dead-server-threshold"`
+			// 删除节点后至少保留的 Voter 数
+			MinQuorum int `yaml:"min-quorum"`
+		} `yaml:"autopilot"`
 	}
 
 	Lsm struct {
+		// LSM 数据和 wal.log 的存储目录，默认为 server.data-dir
 		DataDir          string `yaml:"data-dir"`
 		Level0Size       int    `yaml:"level0-size"`
 		PartSize         int    `yaml:"part-size"`
@@ -54,6 +127,23 @@ func init() {
 
 func defaultConfig() *Config {
 	cfg := &Config{}
+	cfg.Server.ReadConsistency = "linearizable"
+	cfg.Server.ForwardMode = "proxy"
+	cfg.Server.ForwardPoolSize = 8
+	cfg.Server.ShutdownTimeout = 10
+	cfg.Server.ShutdownTransferLeader = true
+	cfg.Server.MaxFrameSize = 64 * 1024 * 1024
+	cfg.Raft.Voter = true
+	cfg.Raft.SnapshotRetain = 2
+	cfg.Raft.SnapshotThreshold = 8192
+	cfg.Raft.SnapshotInterval = 120
+	cfg.Raft.TrailingLogs = 10240
+	cfg.Raft.SessionTTL = 3600
+	cfg.Raft.MaxBatchSize = 128
+	cfg.Raft.RangeCheckInterval = 10
+	cfg.Raft.Autopilot.LastContactThreshold = 5
+	cfg.Raft.Autopilot.MaxTrailingLogs = 250
+	cfg.Raft.Autopilot.DeadServerThreshold = 300
 	return cfg
 }
 
@@ -69,11 +159,19 @@ func SetGlobalConfig(cfg *Config) {
 
 // LoadConfig 从配置文件加载配置, 并填充好默认值
 func LoadConfig() (*Config, error) {
-	configPath := serverConfigPath()
+	return LoadConfigFile(serverConfigPath())
+}
+
+// LoadConfigFile 从指定的配置文件加载配置，供 kvsctl 等离线工具使用
+func LoadConfigFile(configPath string) (*Config, error) {
 	cfg, err := parseConfigFromFile(configPath)
 	if err != nil {
 		return nil, err
 	}
+	cfg.fillDataDirs()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
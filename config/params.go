@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Param 一个配置项，Name 是配置文件中的路径，例如 server.max-frame-size
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Params 按配置文件中的顺序列出名称匹配 pattern 的配置项，pattern 是不区分大小写的
+// glob 模式，例如 raft.* 或 *max-frame-size
+func (c *Config) Params(pattern string) []Param {
+	pattern = strings.ToLower(pattern)
+	params := make([]Param, 0)
+	walkParams(reflect.ValueOf(c).Elem(), "", func(name, value string) {
+		if ok, _ := path.Match(pattern, name); ok {
+			params = append(params, Param{Name: name, Value: value})
+		}
+	})
+	return params
+}
+
+// walkParams 依次访问结构体的字段，嵌套的结构体以 . 连接路径，列表以 , 连接
+func walkParams(v reflect.Value, prefix string, fn func(name, value string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" {
+			// 与 yaml 的默认规则相同，使用小写的字段名
+			name = strings.ToLower(field.Name)
+		}
+		name = prefix + name
+		value := v.Field(i)
+		switch value.Kind() {
+		case reflect.Struct:
+			walkParams(value, name+".", fn)
+		case reflect.Slice:
+			items := make([]string, value.Len())
+			for j := range items {
+				items[j] = fmt.Sprint(value.Index(j).Interface())
+			}
+			fn(name, strings.Join(items, ","))
+		default:
+			fn(name, fmt.Sprint(value.Interface()))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLS 证书配置，cert-file 为空时使用明文连接
+type TLS struct {
+	// 本节点的证书和私钥（PEM），监听时作为服务端证书，连接其他节点时作为客户端证书
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+	// 校验对端证书的 CA（PEM），为空时使用系统 CA
+	CAFile string `yaml:"ca-file"`
+	// 是否要求对端出示由 ca-file 签发的客户端证书（mTLS）
+	RequireClientCert bool `yaml:"require-client-cert"`
+	// 连接其他节点时校验的证书名称，为空时使用连接地址中的主机名
+	ServerName string `yaml:"server-name"`
+}
+
+func (t *TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// ServerConfig 返回监听端使用的 TLS 配置
+func (t *TLS) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.RequireClientCert {
+		if conf.ClientCAs, err = loadCertPool(t.CAFile); err != nil {
+			return nil, err
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// ClientConfig 返回连接对端时使用的 TLS 配置，配置了证书时同时出示客户端证书
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		ServerName: t.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if t.CAFile != "" {
+		pool, err := loadCertPool(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// validate 检查证书配置是否完整，name 为配置段的名称
+func (t *TLS) validate(name string) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("%s.cert-file and %s.key-file must be set together", name, name)
+	}
+	if !t.Enabled() && (t.CAFile != "" || t.RequireClientCert || t.ServerName != "") {
+		return fmt.Errorf("%s requires cert-file and key-file", name)
+	}
+	if t.RequireClientCert && t.CAFile == "" {
+		return fmt.Errorf("%s.require-client-cert requires %s.ca-file", name, name)
+	}
+	return nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return pool, nil
+}
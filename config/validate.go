@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+)
+
+// 节点 ID 会作为目录名的一部分，只允许安全的字符
+var nodeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// fillDataDirs 根据节点 ID 填充未配置的数据目录
+func (c *Config) fillDataDirs() {
+	if c.Server.DataDir == "" && c.Raft.NodeID != "" {
+		c.Server.DataDir = fmt.Sprintf("./nodes/node%s", c.Raft.NodeID)
+	}
+	if c.Raft.DataDir == "" {
+		c.Raft.DataDir = c.Server.DataDir
+	}
+	if c.Lsm.DataDir == "" {
+		c.Lsm.DataDir = c.Server.DataDir
+	}
+}
+
+// Validate 检查节点身份、地址和目录配置
+func (c *Config) Validate() error {
+	if c.Raft.NodeID == "" {
+		return fmt.Errorf("raft.node-id is required")
+	}
+	if !nodeIDPattern.MatchString(c.Raft.NodeID) {
+		return fmt.Errorf("raft.node-id %q may only contain letters, digits, '_', '.' and '-'", c.Raft.NodeID)
+	}
+	if c.Raft.Bootstrap && len(c.Raft.Join) > 0 {
+		return fmt.Errorf("raft.bootstrap and raft.join are mutually exclusive")
+	}
+	if c.Raft.Bootstrap && !c.Raft.Voter {
+		return fmt.Errorf("raft.bootstrap requires raft.is-voter, a cluster needs at least one voter")
+	}
+	if c.Raft.DataDir == "" || c.Lsm.DataDir == "" {
+		return fmt.Errorf("server.data-dir is required")
+	}
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		return fmt.Errorf("invalid server.addr %q: %v", c.Server.Addr, err)
+	}
+	if port, err := strconv.Atoi(c.Raft.Port); err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid raft.port %q", c.Raft.Port)
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server.shutdown-timeout must be positive")
+	}
+	if c.Server.MaxFrameSize <= 0 {
+		return fmt.Errorf("server.max-frame-size must be positive")
+	}
+	if c.Raft.SessionTTL <= 0 {
+		return fmt.Errorf("raft.session-ttl must be positive")
+	}
+	if c.Raft.MaxBatchDelay < 0 {
+		return fmt.Errorf("raft.max-batch-delay must not be negative")
+	}
+	if c.Raft.RangeSplitKeys < 0 || c.Raft.RangeSplitQPS < 0 || c.Raft.RangeMergeKeys < 0 {
+		return fmt.Errorf("raft.range-split-keys, raft.range-split-qps and raft.range-merge-keys must not be negative")
+	}
+	// 分裂得到的两个区间不能立即满足合并条件
+	if c.Raft.RangeSplitKeys > 0 && c.Raft.RangeMergeKeys > c.Raft.RangeSplitKeys/2 {
+		return fmt.Errorf("raft.range-merge-keys must not exceed half of raft.range-split-keys")
+	}
+	if c.Raft.RangeCheckInterval <= 0 {
+		return fmt.Errorf("raft.range-check-interval must be positive")
+	}
+	autopilot := c.Raft.Autopilot
+	if autopilot.LastContactThreshold <= 0 || autopilot.DeadServerThreshold <= 0 {
+		return fmt.Errorf("raft.autopilot.last-contact-threshold and raft.autopilot.dead-server-threshold must be positive")
+	}
+	// 节点先变为不健康，之后才会被删除
+	if autopilot.DeadServerThreshold < autopilot.LastContactThreshold {
+		return fmt.Errorf("raft.autopilot.dead-server-threshold must not be less than raft.autopilot.last-contact-threshold")
+	}
+	if autopilot.MinQuorum < 0 {
+		return fmt.Errorf("raft.autopilot.min-quorum must not be negative")
+	}
+	if err := c.Server.TLS.validate("server.tls"); err != nil {
+		return err
+	}
+	if err := c.Raft.TLS.validate("raft.tls"); err != nil {
+		return err
+	}
+	if err := validateAdvertiseAddr("server.advertise-addr", c.Server.AdvertiseAddr); err != nil {
+		return err
+	}
+	return validateAdvertiseAddr("raft.advertise-addr", c.Raft.AdvertiseAddr)
+}
+
+// 公布的地址必须是其他节点可以直接访问的 host:port
+func validateAdvertiseAddr(name, addr string) error {
+	if addr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", name, addr, err)
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return fmt.Errorf("invalid %s %q: host must be routable", name, addr)
+	}
+	return nil
+}
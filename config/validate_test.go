@@ -0,0 +1,118 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// validConfig 返回一份可以通过检查的配置
+func validConfig() *Config {
+	cfg := defaultConfig()
+	cfg.Server.Addr = "127.0.0.1:2317"
+	cfg.Raft.NodeID = "0"
+	cfg.Raft.Port = "2318"
+	cfg.Raft.Bootstrap = true
+	cfg.fillDataDirs()
+	return cfg
+}
+
+func Test_FillDataDirs(t *testing.T) {
+	Convey("data dirs default to the node's directory and keep explicit values", t, func() {
+		cfg := validConfig()
+		So(cfg.Server.DataDir, ShouldEqual, "./nodes/node0")
+		So(cfg.Raft.DataDir, ShouldEqual, "./nodes/node0")
+		So(cfg.Lsm.DataDir, ShouldEqual, "./nodes/node0")
+
+		cfg = defaultConfig()
+		cfg.Raft.NodeID = "1"
+		cfg.Lsm.DataDir = "/data/lsm"
+		cfg.fillDataDirs()
+		So(cfg.Server.DataDir, ShouldEqual, "./nodes/node1")
+		So(cfg.Raft.DataDir, ShouldEqual, "./nodes/node1")
+		So(cfg.Lsm.DataDir, ShouldEqual, "/data/lsm")
+	})
+}
+
+func Test_Validate(t *testing.T) {
+	Convey("a complete config passes", t, func() {
+		So(validConfig().Validate(), ShouldBeNil)
+		cfg := validConfig()
+		cfg.Raft.Bootstrap = false
+		cfg.Raft.Voter = false
+		cfg.Raft.Join = []string{"127.0.0.1:2315"}
+		cfg.Server.AdvertiseAddr = "kv-0.example:2317"
+		cfg.Raft.AdvertiseAddr = "10.0.0.1:2318"
+		So(cfg.Validate(), ShouldBeNil)
+	})
+
+	Convey("each invalid setting is rejected with the name of the setting", t, func() {
+		cases := []struct {
+			name   string
+			modify func(cfg *Config)
+			want   string
+		}{
+			{"missing node id", func(cfg *Config) { cfg.Raft.NodeID = "" }, "raft.node-id is required"},
+			{"node id with a path separator", func(cfg *Config) { cfg.Raft.NodeID = "../0" }, "raft.node-id"},
+			{"node id with a space", func(cfg *Config) { cfg.Raft.NodeID = "node 0" }, "raft.node-id"},
+			{"bootstrap and join", func(cfg *Config) { cfg.Raft.Join = []string{"127.0.0.1:2315"} }, "mutually exclusive"},
+			{"bootstrap a learner", func(cfg *Config) { cfg.Raft.Voter = false }, "raft.bootstrap requires raft.is-voter"},
+			{"missing raft data dir", func(cfg *Config) { cfg.Raft.DataDir = "" }, "server.data-dir is required"},
+			{"missing lsm data dir", func(cfg *Config) { cfg.Lsm.DataDir = "" }, "server.data-dir is required"},
+			{"server addr without port", func(cfg *Config) { cfg.Server.Addr = "127.0.0.1" }, "invalid server.addr"},
+			{"raft port not a number", func(cfg *Config) { cfg.Raft.Port = "raft" }, "invalid raft.port"},
+			{"raft port out of range", func(cfg *Config) { cfg.Raft.Port = "65536" }, "invalid raft.port"},
+			{"zero shutdown timeout", func(cfg *Config) { cfg.Server.ShutdownTimeout = 0 }, "server.shutdown-timeout"},
+			{"zero max frame size", func(cfg *Config) { cfg.Server.MaxFrameSize = 0 }, "server.max-frame-size"},
+			{"zero session ttl", func(cfg *Config) { cfg.Raft.SessionTTL = 0 }, "raft.session-ttl"},
+			{"negative batch delay", func(cfg *Config) { cfg.Raft.MaxBatchDelay = -1 }, "raft.max-batch-delay"},
+			{"negative split keys", func(cfg *Config) { cfg.Raft.RangeSplitKeys = -1 }, "must not be negative"},
+			{"merge keys above half of split keys", func(cfg *Config) {
+				cfg.Raft.RangeSplitKeys = 100
+				cfg.Raft.RangeMergeKeys = 51
+			}, "raft.range-merge-keys"},
+			{"zero range check interval", func(cfg *Config) { cfg.Raft.RangeCheckInterval = 0 }, "raft.range-check-interval"},
+			{"zero last contact threshold", func(cfg *Config) { cfg.Raft.Autopilot.LastContactThreshold = 0 }, "raft.autopilot.last-contact-threshold"},
+			{"dead server threshold below last contact threshold", func(cfg *Config) {
+				cfg.Raft.Autopilot.DeadServerThreshold = 1
+			}, "raft.autopilot.dead-server-threshold"},
+			{"negative min quorum", func(cfg *Config) { cfg.Raft.Autopilot.MinQuorum = -1 }, "raft.autopilot.min-quorum"},
+			{"server cert without key", func(cfg *Config) { cfg.Server.TLS.CertFile = "node.pem" }, "server.tls.cert-file and server.tls.key-file"},
+			{"raft ca without cert", func(cfg *Config) { cfg.Raft.TLS.CAFile = "ca.pem" }, "raft.tls requires cert-file and key-file"},
+			{"client cert without ca", func(cfg *Config) {
+				cfg.Server.TLS.CertFile = "node.pem"
+				cfg.Server.TLS.KeyFile = "node-key.pem"
+				cfg.Server.TLS.RequireClientCert = true
+			}, "server.tls.require-client-cert requires server.tls.ca-file"},
+			{"server advertise addr without port", func(cfg *Config) { cfg.Server.AdvertiseAddr = "10.0.0.1" }, "invalid server.advertise-addr"},
+			{"server advertise addr on all interfaces", func(cfg *Config) { cfg.Server.AdvertiseAddr = "0.0.0.0:2317" }, "host must be routable"},
+			{"raft advertise addr without host", func(cfg *Config) { cfg.Raft.AdvertiseAddr = ":2318" }, "host must be routable"},
+			{"raft advertise addr on all IPv6 interfaces", func(cfg *Config) { cfg.Raft.AdvertiseAddr = "[::]:2318" }, "invalid raft.advertise-addr"},
+		}
+		for _, c := range cases {
+			c := c
+			Convey(c.name, func() {
+				cfg := validConfig()
+				c.modify(cfg)
+				err := cfg.Validate()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, c.want)
+			})
+		}
+	})
+}
+
+func Test_DockerConfigs(t *testing.T) {
+	Convey("the docker configs start one seed node and one node that joins it", t, func() {
+		seed, err := LoadConfigFile("../conf/app-docker-voter.yaml")
+		So(err, ShouldBeNil)
+		So(seed.Raft.Bootstrap, ShouldBeTrue)
+		So(seed.Raft.Voter, ShouldBeTrue)
+
+		node, err := LoadConfigFile("../conf/app-docker.yaml")
+		So(err, ShouldBeNil)
+		So(node.Raft.Bootstrap, ShouldBeFalse)
+		So(node.Raft.Join, ShouldResemble, []string{"127.0.0.1:2315"})
+		So(node.Raft.NodeID, ShouldNotEqual, seed.Raft.NodeID)
+	})
+}
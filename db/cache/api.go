@@ -4,12 +4,15 @@ type Cache interface {
 
 	// Add a kv set to cache.
 	// If the key already exists, the previous value will be overwritten.
-	Set(key, value string)
+	Set(key string, value []byte)
 
-	// Get the string value of a given string key.
+	// Get the binary value of a given string key.
 	// Return `None` if the given key does not exit.
-	Get(key string) (string, bool)
+	Get(key string) ([]byte, bool)
 
 	// Remove a given key.
 	Remove(key string)
+
+	// Clear removes all the keys.
+	Clear()
 }
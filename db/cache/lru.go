@@ -5,7 +5,8 @@ import (
 )
 
 type entry struct {
-	key, value string
+	key   string
+	value []byte
 }
 
 type LRUCache struct {
@@ -20,15 +21,15 @@ func NewLRUCache(capacity int) *LRUCache {
 	return &LRUCache{list: list.New(), capacity: capacity, mp: map[string]*list.Element{}}
 }
 
-func (c *LRUCache) Get(key string) (string, bool) {
+func (c *LRUCache) Get(key string) ([]byte, bool) {
 	if node, ok := c.mp[key]; ok {
 		c.list.MoveToFront(node)
 		return node.Value.(entry).value, true
 	}
-	return "", false
+	return nil, false
 }
 
-func (c *LRUCache) Set(key, value string) {
+func (c *LRUCache) Set(key string, value []byte) {
 	if node, ok := c.mp[key]; ok {
 		node.Value = entry{key, value}
 		c.list.MoveToFront(node)
@@ -48,3 +49,8 @@ func (c *LRUCache) Remove(key string) {
 	}
 	return
 }
+
+func (c *LRUCache) Clear() {
+	c.list.Init()
+	c.mp = map[string]*list.Element{}
+}
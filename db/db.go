@@ -8,17 +8,32 @@ import (
 
 type DB interface {
 
-	// Set the value of a string key to a string.
+	// Set the value of a string key to a binary value.
 	// If the key already exists, the previous value will be overwritten.
-	Set(key, value string) error
+	// The value must not be modified after Set.
+	Set(key string, value []byte) error
 
-	// Get the string value of a given string key.
+	// Get the binary value of a given string key.
 	// Return `None` if the given key does not exit.
-	Get(key string) (string, error)
+	// The returned slice must not be modified.
+	Get(key string) ([]byte, error)
 
 	// Remove a given key.
 	//  It returns `kvserror::KeyNotFound` if the given key not found.
 	Remove(key string) error
+
+	// Scan calls fn for every live key in ascending key order.
+	// The iteration stops as soon as fn returns false.
+	Scan(fn func(key string, value []byte) bool) error
+
+	// View captures a point-in-time view of the key space, the caller must Close it.
+	View() (engines.View, error)
+
+	// Reset atomically replaces the whole key space with the given pairs.
+	Reset(pairs map[string][]byte) error
+
+	// Close flushes the engine to disk and releases its resources.
+	Close() error
 }
 
 type db struct {
@@ -35,7 +50,7 @@ func NewDB(path string, cacheCap int) (DB, error) {
 	}, nil
 }
 
-func (d db) Set(key, value string) error {
+func (d db) Set(key string, value []byte) error {
 	if err := d.engine.Set(key, value); err != nil {
 		return err
 	}
@@ -43,14 +58,14 @@ func (d db) Set(key, value string) error {
 	return nil
 }
 
-func (d db) Get(key string) (string, error) {
+func (d db) Get(key string) ([]byte, error) {
 	if data, ok := d.cache.Get(key); ok {
 		fmt.Println("get from cache")
 		return data, nil
 	}
 	data, err := d.engine.Get(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	d.cache.Set(key, data)
 	return data, nil
@@ -64,3 +79,23 @@ func (d db) Remove(key string) error {
 	d.cache.Remove(key)
 	return nil
 }
+
+func (d db) Scan(fn func(key string, value []byte) bool) error {
+	return d.engine.Scan(fn)
+}
+
+func (d db) View() (engines.View, error) {
+	return d.engine.View()
+}
+
+func (d db) Reset(pairs map[string][]byte) error {
+	if err := d.engine.Reset(pairs); err != nil {
+		return err
+	}
+	d.cache.Clear()
+	return nil
+}
+
+func (d db) Close() error {
+	return d.engine.Close()
+}
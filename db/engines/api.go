@@ -2,15 +2,46 @@ package engines
 
 type KvsEngine interface {
 
-	// Set the value of a string key to a string.
+	// Set the value of a string key to a binary value.
 	// If the key already exists, the previous value will be overwritten.
-	Set(key, value string) error
+	// The engine may keep the slice, callers must not modify it afterwards.
+	Set(key string, value []byte) error
 
-	// Get the string value of a given string key.
+	// Get the binary value of a given string key.
 	// Return `None` if the given key does not exits.
-	Get(key string) (string, error)
+	// The returned slice must not be modified.
+	Get(key string) ([]byte, error)
 
 	// Remove a given key.
 	//  It returns `kvserror::KeyNotFound` if the given key not found.
 	Remove(key string) error
+
+	// Scan calls fn for every live key in ascending key order.
+	// The iteration stops as soon as fn returns false.
+	Scan(fn func(key string, value []byte) bool) error
+
+	// View captures a point-in-time View of the key space without copying the values.
+	// The caller must Close the view.
+	View() (View, error)
+
+	// Reset atomically replaces the whole key space with the given pairs.
+	// Readers never observe a partially replaced engine.
+	Reset(pairs map[string][]byte) error
+
+	// Close flushes buffered writes to disk and releases the file handles.
+	// The engine must not be used after Close.
+	Close() error
+}
+
+// View is a read-only view of the key space at the time it was taken.
+// Later writes, compactions and resets of the engine are not visible through it.
+type View interface {
+
+	// Scan calls fn for every live key in [start, end) in ascending key order,
+	// an empty end means no upper bound.
+	// The iteration stops as soon as fn returns false.
+	Scan(start, end string, fn func(key string, value []byte) bool) error
+
+	// Close releases the file handles held by the view.
+	Close() error
 }
@@ -2,7 +2,9 @@ package engines
 
 import (
 	"bufio"
-	"encoding/json"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	errs "github.com/huiming23344/kv-raft/errors"
 	"io"
@@ -15,17 +17,114 @@ import (
 
 const CompactionThreshold uint64 = 1024 * 1024
 
-type CommandType string
+type CommandType byte
 
 const (
-	SET    CommandType = "SET"
-	DELETE CommandType = "DELETE"
+	SET    CommandType = 1
+	DELETE CommandType = 2
+	// RESET is the first record of a log written by Reset, everything in the older logs is discarded
+	RESET CommandType = 3
 )
 
 type Command struct {
-	Type  CommandType `json:"type"`
-	Key   string      `json:"key"`
-	Value string      `json:"value,omitempty"`
+	Type  CommandType
+	Key   string
+	Value []byte
+}
+
+// A command is stored in the log as a length-prefixed record:
+//
+//	type(1) keyLen(4) valueLen(4) key value
+//
+// with little-endian lengths, so keys and values may contain any bytes.
+const recordHeaderSize = 9
+
+var errCorruptRecord = errors.New("corrupt log record")
+
+// Every log file starts with a header of magic(4) version(1), followed by the records.
+var logMagic = []byte("KVSL")
+
+const logVersion byte = 1
+
+var (
+	// ErrLegacyLog is returned for a log file written in JSON by an older version
+	ErrLegacyLog = errors.New("legacy JSON log file is not supported, export the data with the previous version first")
+	// ErrUnknownLog is returned for a file that is not a log file or has an unsupported version
+	ErrUnknownLog = errors.New("not a kvs log file or unsupported log version")
+)
+
+func logHeader() []byte {
+	return append(append([]byte{}, logMagic...), logVersion)
+}
+
+// readLogHeader checks the header at the start of r, an empty file has no header
+// since it is only written along with the first record
+func readLogHeader(r io.Reader) error {
+	header := make([]byte, len(logMagic)+1)
+	n, err := io.ReadFull(r, header)
+	switch {
+	case n == 0 && err == io.EOF:
+		return nil
+	case header[0] == '{':
+		return ErrLegacyLog
+	case !bytes.HasPrefix(logMagic, header[:min(n, len(logMagic))]):
+		return ErrUnknownLog
+	case err != nil:
+		return err
+	case header[len(logMagic)] != logVersion:
+		return ErrUnknownLog
+	}
+	return nil
+}
+
+// encode serializes the command into a log record
+func (cmd *Command) encode() []byte {
+	buf := make([]byte, recordHeaderSize, recordHeaderSize+len(cmd.Key)+len(cmd.Value))
+	buf[0] = byte(cmd.Type)
+	binary.LittleEndian.PutUint32(buf[1:5], uint32(len(cmd.Key)))
+	binary.LittleEndian.PutUint32(buf[5:9], uint32(len(cmd.Value)))
+	buf = append(buf, cmd.Key...)
+	return append(buf, cmd.Value...)
+}
+
+// decodeCommand parses a whole log record produced by encode
+func decodeCommand(buf []byte) (*Command, error) {
+	if len(buf) < recordHeaderSize {
+		return nil, errCorruptRecord
+	}
+	cmdType := CommandType(buf[0])
+	keyLen := uint64(binary.LittleEndian.Uint32(buf[1:5]))
+	valueLen := uint64(binary.LittleEndian.Uint32(buf[5:9]))
+	if (cmdType != SET && cmdType != DELETE && cmdType != RESET) || recordHeaderSize+keyLen+valueLen != uint64(len(buf)) {
+		return nil, errCorruptRecord
+	}
+	key := buf[recordHeaderSize : recordHeaderSize+keyLen]
+	return &Command{
+		Type:  cmdType,
+		Key:   string(key),
+		Value: buf[recordHeaderSize+keyLen:],
+	}, nil
+}
+
+// readRecord reads the next whole record from r, it returns io.EOF at the end of the log
+// and io.ErrUnexpectedEOF when the last record was only partially written
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	header, err := r.Peek(recordHeaderSize)
+	if err != nil {
+		if err == io.EOF && len(header) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	size := recordHeaderSize + int(binary.LittleEndian.Uint32(header[1:5])) + int(binary.LittleEndian.Uint32(header[5:9]))
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
 }
 
 // CommandPos is a position used to find command in logFiles
@@ -58,16 +157,11 @@ func (r *BufReaderWithPos) readCommand(commandPos *CommandPos) (*Command, error)
 	if err != nil {
 		return nil, err
 	}
-	cmd := &Command{}
-	err = json.Unmarshal(buf, cmd)
-	if err != nil {
-		return nil, err
-	}
-	return cmd, nil
+	return decodeCommand(buf)
 }
 
 func (r *BufReaderWithPos) read(buf []byte) error {
-	n, err := r.file.Read(buf)
+	n, err := io.ReadFull(r.file, buf)
 	if err != nil {
 		return err
 	}
@@ -82,8 +176,9 @@ func (r *BufReaderWithPos) seek(pos uint64) error {
 }
 
 type BufWriterWithPos struct {
-	buf *bufio.Writer
-	pos uint64
+	file *os.File
+	buf  *bufio.Writer
+	pos  uint64
 }
 
 func (w *BufWriterWithPos) write(p []byte) error {
@@ -99,8 +194,19 @@ func (w *BufWriterWithPos) flush() error {
 	return w.buf.Flush()
 }
 
+// close flushes the buffer, syncs the file and closes it
+func (w *BufWriterWithPos) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
 func NewBufWriterWithPos(file *os.File) *BufWriterWithPos {
-	return &BufWriterWithPos{buf: bufio.NewWriter(file), pos: 0}
+	return &BufWriterWithPos{file: file, buf: bufio.NewWriter(file), pos: 0}
 }
 
 type KvsStore struct {
@@ -118,6 +224,10 @@ func NewKvsStore(path string) (KvsEngine, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Reset 未完成改名的临时文件
+	if err := removeTempLogs(path); err != nil {
+		return nil, err
+	}
 	index := &sync.Map{}
 	readers := make(map[uint64]*BufReaderWithPos)
 	var uncompacted uint64
@@ -126,11 +236,26 @@ func NewKvsStore(path string) (KvsEngine, error) {
 		if err != nil {
 			return nil, err
 		}
+		if err := readLogHeader(file); err != nil {
+			_ = file.Close()
+			if err == ErrLegacyLog || err == ErrUnknownLog {
+				return nil, fmt.Errorf("%s: %w", logPath(path, uint64(gen)), err)
+			}
+			return nil, err
+		}
 		reader := NewBufReaderWithPos(file)
-		n, err := load(uint64(gen), reader, index)
+		n, reset, err := load(uint64(gen), reader, index)
 		if err != nil {
 			return nil, err
 		}
+		if reset {
+			// 更早的日志已被 Reset 取代，只是还没来得及删除
+			for old, r := range readers {
+				_ = r.file.Close()
+				delete(readers, old)
+			}
+			uncompacted = 0
+		}
 		uncompacted += n
 		readers[uint64(gen)] = reader
 	}
@@ -153,15 +278,33 @@ func NewKvsStore(path string) (KvsEngine, error) {
 	return kvsStore, nil
 }
 
-func load(gen uint64, reader *BufReaderWithPos, index *sync.Map) (uint64, error) {
-	var uncompacted, pos uint64
+// load reads the records after the header into index, it reports whether
+// the log starts with a RESET record, in which case index only holds this log
+func load(gen uint64, reader *BufReaderWithPos, index *sync.Map) (uint64, bool, error) {
+	var uncompacted uint64
+	var reset bool
+	pos := uint64(len(logHeader()))
 	buf := bufio.NewReader(reader.file)
-	for bytes, err := buf.ReadSlice('}'); err == nil; bytes, err = buf.ReadSlice('}') {
-		newPos := pos + uint64(len(bytes))
-		cmd := &Command{}
-		err := json.Unmarshal(bytes, cmd)
+	for {
+		record, err := readRecord(buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		newPos := pos + uint64(len(record))
+		cmd, err := decodeCommand(record)
 		if err != nil {
-			return 0, err
+			return 0, false, err
+		}
+		if cmd.Type == RESET {
+			index.Range(func(key, _ interface{}) bool {
+				index.Delete(key)
+				return true
+			})
+			uncompacted = 0
+			reset = true
 		}
 		if cmd.Type == SET {
 			if val, ok := index.Load(cmd.Key); ok {
@@ -180,22 +323,60 @@ func load(gen uint64, reader *BufReaderWithPos, index *sync.Map) (uint64, error)
 		}
 		pos = newPos
 	}
-	return uncompacted, nil
+	return uncompacted, reset, nil
 }
 
 func logPath(path string, gen uint64) string {
 	return fmt.Sprintf("%s/%d.log", path, gen)
 }
 
+const tempLogSuffix = ".tmp"
+
 func newLogFile(path string, gen uint64) (*BufWriterWithPos, error) {
-	file, err := os.Create(logPath(path, gen))
+	return createLogFile(logPath(path, gen))
+}
+
+func createLogFile(name string) (*BufWriterWithPos, error) {
+	file, err := os.Create(name)
 	if err != nil {
 		return nil, err
 	}
 	writer := NewBufWriterWithPos(file)
+	if err := writer.write(logHeader()); err != nil {
+		return nil, err
+	}
 	return writer, nil
 }
 
+// syncDir syncs the directory so that the files created in it survive a crash
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// removeTempLogs removes the logs left behind by a Reset that crashed before renaming its log
+func removeTempLogs(path string) error {
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, v := range files {
+		if strings.HasSuffix(v.Name(), ".log"+tempLogSuffix) {
+			if err := os.Remove(path + "/" + v.Name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func sortedGenList(path string) ([]int, error) {
 	if err := os.MkdirAll(path, 0700); err != nil {
 		return nil, err
@@ -277,18 +458,15 @@ func (kvs *KvsStore) compact() (err error) {
 // Set will write new kv-set to current kvs.writer
 // and then update kvs.index with CommandPos
 // if index has older version update kvs.unCompacted
-func (kvs *KvsStore) Set(key, value string) error {
+func (kvs *KvsStore) Set(key string, value []byte) error {
 	kvs.mutex.Lock()
 	defer kvs.mutex.Unlock()
 	cmd := &Command{SET, key, value}
 	pos := kvs.writer.pos
-	bytes, err := json.Marshal(cmd)
+	err := kvs.writer.write(cmd.encode())
 	if err != nil {
 		return err
 	}
-	if err = kvs.writer.write(bytes); err != nil {
-		return err
-	}
 	if err = kvs.writer.flush(); err != nil {
 		return err
 	}
@@ -308,13 +486,9 @@ func (kvs *KvsStore) Remove(key string) error {
 	kvs.mutex.Lock()
 	defer kvs.mutex.Unlock()
 	if _, ok := kvs.index.Load(key); ok {
-		cmd := &Command{DELETE, key, ""}
+		cmd := &Command{DELETE, key, nil}
 		pos := kvs.writer.pos
-		bytes, err := json.Marshal(cmd)
-		if err != nil {
-			return err
-		}
-		err = kvs.writer.write(bytes)
+		err := kvs.writer.write(cmd.encode())
 		if err != nil {
 			return err
 		}
@@ -334,33 +508,225 @@ func (kvs *KvsStore) Remove(key string) error {
 	return nil
 }
 
-func (kvs *KvsStore) Get(key string) (string, error) {
+func (kvs *KvsStore) Get(key string) ([]byte, error) {
 	kvs.mutex.Lock()
 	defer kvs.mutex.Unlock()
 
 	if val, ok := kvs.index.Load(key); ok {
-		pos := val.(*CommandPos)
-		reader := kvs.readers[pos.gen]
+		return kvs.readValue(val.(*CommandPos))
+	} else {
+		return nil, errs.KeyNotFound
+	}
+}
 
-		if reader == nil {
-			file, err := os.Open(logPath(kvs.path, pos.gen))
-			if err != nil {
-				return "", err
-			}
-			reader = NewBufReaderWithPos(file)
-			kvs.readers[pos.gen] = reader
+// readValue reads the command at pos from its log file, the caller must hold kvs.mutex
+func (kvs *KvsStore) readValue(pos *CommandPos) ([]byte, error) {
+	reader := kvs.readers[pos.gen]
+
+	if reader == nil {
+		file, err := os.Open(logPath(kvs.path, pos.gen))
+		if err != nil {
+			return nil, err
 		}
+		reader = NewBufReaderWithPos(file)
+		kvs.readers[pos.gen] = reader
+	}
+
+	err := reader.seek(pos.pos)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := reader.readCommand(pos)
+	if err != nil {
+		return nil, err
+	}
+	return cmd.Value, nil
+}
+
+// Scan walks the index in ascending key order and reads every value from the log files
+func (kvs *KvsStore) Scan(fn func(key string, value []byte) bool) error {
+	kvs.mutex.Lock()
+	defer kvs.mutex.Unlock()
 
-		err := reader.seek(pos.pos)
+	keys := make([]string, 0)
+	kvs.index.Range(func(key, _ interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	sort.Strings(keys)
+	for _, key := range keys {
+		val, ok := kvs.index.Load(key)
+		if !ok {
+			continue
+		}
+		value, err := kvs.readValue(val.(*CommandPos))
 		if err != nil {
-			return "", err
+			return err
+		}
+		if !fn(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// kvsView is a point-in-time view of a KvsStore. It keeps its own handles on the log
+// files it points into, so compaction and Reset may remove them while the view is open.
+type kvsView struct {
+	entries []kvsViewEntry
+	sorted  bool
+	files   map[uint64]*os.File
+}
+
+type kvsViewEntry struct {
+	key string
+	pos *CommandPos
+}
+
+// View copies the index and opens the log files it points into,
+// the values are only read and the keys only sorted by Scan
+func (kvs *KvsStore) View() (View, error) {
+	kvs.mutex.Lock()
+	defer kvs.mutex.Unlock()
+
+	view := &kvsView{files: make(map[uint64]*os.File)}
+	var err error
+	kvs.index.Range(func(key, value interface{}) bool {
+		pos := value.(*CommandPos)
+		if _, ok := view.files[pos.gen]; !ok {
+			var file *os.File
+			if file, err = os.Open(logPath(kvs.path, pos.gen)); err != nil {
+				return false
+			}
+			view.files[pos.gen] = file
+		}
+		view.entries = append(view.entries, kvsViewEntry{key.(string), pos})
+		return true
+	})
+	if err != nil {
+		_ = view.Close()
+		return nil, err
+	}
+	return view, nil
+}
+
+func (v *kvsView) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	if !v.sorted {
+		sort.Slice(v.entries, func(i, j int) bool {
+			return v.entries[i].key < v.entries[j].key
+		})
+		v.sorted = true
+	}
+	i := sort.Search(len(v.entries), func(i int) bool {
+		return v.entries[i].key >= start
+	})
+	for ; i < len(v.entries); i++ {
+		entry := v.entries[i]
+		if end != "" && entry.key >= end {
+			break
 		}
-		cmd, err := reader.readCommand(pos)
+		buf := make([]byte, entry.pos.len)
+		if _, err := v.files[entry.pos.gen].ReadAt(buf, int64(entry.pos.pos)); err != nil {
+			return err
+		}
+		cmd, err := decodeCommand(buf)
 		if err != nil {
-			return "", err
+			return err
 		}
-		return cmd.Value, nil
-	} else {
-		return "", errs.KeyNotFound
+		if !fn(entry.key, cmd.Value) {
+			break
+		}
+	}
+	return nil
+}
+
+func (v *kvsView) Close() error {
+	var err error
+	for gen, file := range v.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		delete(v.files, gen)
 	}
+	return err
+}
+
+// Reset writes a RESET record and all the pairs into a temporary log file,
+// syncs it and renames it to the next generation. load drops everything read
+// before a RESET record, so once the rename is durable the stale logs are
+// ignored even if the process crashes before removing them, and a crash before
+// the rename only leaves the temporary file, which NewKvsStore removes.
+func (kvs *KvsStore) Reset(pairs map[string][]byte) (err error) {
+	kvs.mutex.Lock()
+	defer kvs.mutex.Unlock()
+
+	resetGen := kvs.currentGen + 1
+	tempPath := logPath(kvs.path, resetGen) + tempLogSuffix
+	resetWriter, err := createLogFile(tempPath)
+	if err != nil {
+		return err
+	}
+	if err = resetWriter.write((&Command{RESET, "", nil}).encode()); err != nil {
+		_ = resetWriter.file.Close()
+		return err
+	}
+	index := &sync.Map{}
+	for key, value := range pairs {
+		pos := resetWriter.pos
+		if err = resetWriter.write((&Command{SET, key, value}).encode()); err != nil {
+			_ = resetWriter.file.Close()
+			return err
+		}
+		index.Store(key, NewCommandPos(resetGen, pos, resetWriter.pos))
+	}
+	if err = resetWriter.close(); err != nil {
+		return err
+	}
+	if err = kvs.writer.close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tempPath, logPath(kvs.path, resetGen)); err != nil {
+		return err
+	}
+	kvs.currentGen += 2
+	kvs.writer, err = newLogFile(kvs.path, kvs.currentGen)
+	if err != nil {
+		return err
+	}
+	if err = syncDir(kvs.path); err != nil {
+		return err
+	}
+	kvs.index = index
+	for _, reader := range kvs.readers {
+		_ = reader.file.Close()
+	}
+	kvs.readers = make(map[uint64]*BufReaderWithPos)
+	kvs.unCompacted = 0
+
+	// remove stale log files
+	genList, err := sortedGenList(kvs.path)
+	if err != nil {
+		return err
+	}
+	for _, gen := range genList {
+		if uint64(gen) < resetGen {
+			if err := os.Remove(logPath(kvs.path, uint64(gen))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close flushes and syncs the current log file and closes all the log files
+func (kvs *KvsStore) Close() error {
+	kvs.mutex.Lock()
+	defer kvs.mutex.Unlock()
+
+	err := kvs.writer.close()
+	for gen, reader := range kvs.readers {
+		_ = reader.file.Close()
+		delete(kvs.readers, gen)
+	}
+	return err
 }
@@ -1,8 +1,12 @@
 package engines
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	errs "github.com/huiming23344/kv-raft/errors"
+	"io"
+	"math/rand"
 	"os"
 	"testing"
 
@@ -26,14 +30,14 @@ func Test_KvsStore(t *testing.T) {
 			t.Fatal(err)
 		}
 		// 1.日志首次写入
-		err = engine.Set("name", "mars")
+		err = engine.Set("name", []byte("mars"))
 		if err != nil {
 			t.Fatal(err)
 		}
 		val, err := engine.Get("name")
-		So(val, ShouldEqual, "mars")
+		So(string(val), ShouldEqual, "mars")
 		// 2.日志追加
-		err = engine.Set("age", "25")
+		err = engine.Set("age", []byte("25"))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -41,7 +45,7 @@ func Test_KvsStore(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		So(val, ShouldEqual, "25")
+		So(string(val), ShouldEqual, "25")
 		// 3.删除索引，日志不动
 		err = engine.Remove("name")
 		if err != nil {
@@ -65,3 +69,183 @@ func Test_Compact(t *testing.T) {
 		}
 	})
 }
+
+func Test_KvsStoreClose(t *testing.T) {
+	Convey("test KvsStore reopen after close", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+
+		engine, err = NewKvsStore(path)
+		So(err, ShouldBeNil)
+		val, err := engine.Get("name")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "mars")
+		So(engine.Close(), ShouldBeNil)
+	})
+}
+
+func Test_KvsStoreReset(t *testing.T) {
+	Convey("test KvsStore reset replaces the contents and removes the stale logs", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+		So(engine.Set("age", []byte("25")), ShouldBeNil)
+		So(engine.Reset(map[string][]byte{"city": []byte("paris")}), ShouldBeNil)
+		So(engine.Set("age", []byte("26")), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+
+		genList, err := sortedGenList(path)
+		So(err, ShouldBeNil)
+		So(genList, ShouldResemble, []int{2, 3})
+		engine, err = NewKvsStore(path)
+		So(err, ShouldBeNil)
+		_, err = engine.Get("name")
+		So(err, ShouldResemble, errs.KeyNotFound)
+		val, err := engine.Get("city")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "paris")
+		val, err = engine.Get("age")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "26")
+		So(engine.Close(), ShouldBeNil)
+	})
+}
+
+func Test_KvsStoreResetCrash(t *testing.T) {
+	Convey("test KvsStore ignores the stale logs left by a reset that crashed before removing them", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+		So(engine.Set("age", []byte("25")), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+		stale, err := os.ReadFile(logPath(path, 1))
+		So(err, ShouldBeNil)
+
+		engine, err = NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Reset(map[string][]byte{"age": []byte("26"), "city": []byte("paris")}), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+		// 模拟删除旧日志之前崩溃：旧日志仍在，下一次 Reset 的临时文件写了一半
+		So(os.WriteFile(logPath(path, 1), stale, 0600), ShouldBeNil)
+		So(os.WriteFile(logPath(path, 5)+tempLogSuffix, stale[:len(stale)-1], 0600), ShouldBeNil)
+
+		engine, err = NewKvsStore(path)
+		So(err, ShouldBeNil)
+		_, err = engine.Get("name")
+		So(err, ShouldResemble, errs.KeyNotFound)
+		val, err := engine.Get("age")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "26")
+		val, err = engine.Get("city")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "paris")
+		So(engine.Close(), ShouldBeNil)
+		_, err = os.Stat(logPath(path, 5) + tempLogSuffix)
+		So(os.IsNotExist(err), ShouldBeTrue)
+	})
+}
+
+func Test_KvsStoreView(t *testing.T) {
+	Convey("test KvsStore view keeps the contents at the time it was taken", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+		So(engine.Set("age", []byte("25")), ShouldBeNil)
+		So(engine.Set("city", []byte("paris")), ShouldBeNil)
+		view, err := engine.View()
+		So(err, ShouldBeNil)
+
+		// 视图之后的写入和 Reset 都看不到，Reset 删除的日志仍然可以读取
+		So(engine.Set("age", []byte("26")), ShouldBeNil)
+		So(engine.Remove("name"), ShouldBeNil)
+		So(engine.Reset(map[string][]byte{"other": []byte("value")}), ShouldBeNil)
+		scan := func(start, end string) map[string]string {
+			got := make(map[string]string)
+			So(view.Scan(start, end, func(key string, value []byte) bool {
+				got[key] = string(value)
+				return true
+			}), ShouldBeNil)
+			return got
+		}
+		So(scan("", ""), ShouldResemble, map[string]string{"age": "25", "city": "paris", "name": "mars"})
+		So(scan("b", "n"), ShouldResemble, map[string]string{"city": "paris"})
+		So(view.Close(), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+	})
+}
+
+func Test_KvsStoreBinary(t *testing.T) {
+	Convey("test KvsStore keeps random binary values across reopen and compaction", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		rnd := rand.New(rand.NewSource(1))
+		pairs := make(map[string][]byte)
+		for i := 0; i < 200; i++ {
+			key := make([]byte, 1+rnd.Intn(16))
+			rnd.Read(key)
+			value := make([]byte, rnd.Intn(256))
+			rnd.Read(value)
+			pairs[string(key)] = value
+			So(engine.Set(string(key), value), ShouldBeNil)
+		}
+		// JSON 记录的分隔符和非 UTF-8 字节
+		pairs["a}b"] = []byte("{\"x\":}\xff\xfe\r\n")
+		So(engine.Set("a}b", pairs["a}b"]), ShouldBeNil)
+		pairs["empty"] = []byte{}
+		So(engine.Set("empty", pairs["empty"]), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+
+		engine, err = NewKvsStore(path)
+		So(err, ShouldBeNil)
+		for key, value := range pairs {
+			val, err := engine.Get(key)
+			So(err, ShouldBeNil)
+			So(bytes.Equal(val, value), ShouldBeTrue)
+		}
+		So(engine.(*KvsStore).compact(), ShouldBeNil)
+		count := 0
+		So(engine.Scan(func(key string, value []byte) bool {
+			count++
+			return bytes.Equal(value, pairs[key])
+		}), ShouldBeNil)
+		So(count, ShouldEqual, len(pairs))
+		So(engine.Close(), ShouldBeNil)
+	})
+
+	Convey("test KvsStore rejects a truncated log record", t, func() {
+		path := t.TempDir()
+		engine, err := NewKvsStore(path)
+		So(err, ShouldBeNil)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+		So(engine.Close(), ShouldBeNil)
+		log := logPath(path, 1)
+		data, err := os.ReadFile(log)
+		So(err, ShouldBeNil)
+		So(os.WriteFile(log, data[:len(data)-1], 0600), ShouldBeNil)
+		_, err = NewKvsStore(path)
+		So(err, ShouldEqual, io.ErrUnexpectedEOF)
+	})
+
+	Convey("test KvsStore rejects legacy and unknown log files", t, func() {
+		files := map[string]error{
+			// 旧版本以 JSON 记录命令
+			`{"type":"SET","key":"name","value":"mars"}`: ErrLegacyLog,
+			"not a log file": ErrUnknownLog,
+			"KVSL\x02":       ErrUnknownLog,
+		}
+		for data, want := range files {
+			path := t.TempDir()
+			So(os.WriteFile(logPath(path, 1), []byte(data), 0600), ShouldBeNil)
+			_, err := NewKvsStore(path)
+			So(errors.Is(err, want), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, logPath(path, 1))
+		}
+	})
+}
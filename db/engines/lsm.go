@@ -29,22 +29,47 @@ func InitLsmEngine(path string) {
 	})
 }
 
-func (l *lsmEngine) Set(key, value string) error {
-	if success := lsm.Set[string](key, value); !success {
+func (l *lsmEngine) Set(key string, value []byte) error {
+	if success := lsm.Set[[]byte](key, value); !success {
 		return errors.New("set failed")
 	}
 	return nil
 }
 
 func (l *lsmEngine) Remove(key string) error {
-	lsm.Delete[string](key)
+	lsm.Delete[[]byte](key)
 	return nil
 }
 
-func (l *lsmEngine) Get(key string) (string, error) {
-	value, success := lsm.Get[string](key)
+func (l *lsmEngine) Get(key string) ([]byte, error) {
+	value, success := lsm.Get[[]byte](key)
 	if !success {
-		return "", errors.New("key not found")
+		return nil, errors.New("key not found")
 	}
 	return value, nil
 }
+
+func (l *lsmEngine) Scan(fn func(key string, value []byte) bool) error {
+	lsm.Range[[]byte](fn)
+	return nil
+}
+
+func (l *lsmEngine) View() (View, error) {
+	view, err := lsm.NewView()
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+func (l *lsmEngine) Close() error {
+	lsm.Stop()
+	return nil
+}
+
+func (l *lsmEngine) Reset(pairs map[string][]byte) error {
+	if success := lsm.Reset[[]byte](pairs); !success {
+		return errors.New("reset failed")
+	}
+	return nil
+}
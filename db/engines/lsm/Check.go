@@ -8,13 +8,22 @@ import (
 
 func Check() {
 	con := config.GetConfig()
-	ticker := time.Tick(time.Duration(con.CheckInterval) * time.Second)
-	for range ticker {
+	ticker := time.NewTicker(time.Duration(con.CheckInterval) * time.Second)
+	defer ticker.Stop()
+	stop := database.stop
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
 		log.Println("Performing background checks...")
+		database.lock.RLock()
 		// 检查内存
 		checkMemory()
 		// 检查压缩数据库文件
 		database.TableTree.Check()
+		database.lock.RUnlock()
 	}
 }
 
@@ -32,13 +41,22 @@ func checkMemory() {
 // CompressMemory 会监听iMemTable，当iMemTable有数据的时候就进行压缩
 func CompressMemory() {
 	con := config.GetConfig()
-	ticker := time.Tick(time.Duration(con.CompressInterval) * time.Second)
-	for range ticker {
+	ticker := time.NewTicker(time.Duration(con.CompressInterval) * time.Second)
+	defer ticker.Stop()
+	stop := database.stop
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		database.lock.RLock()
 		for database.iMemTable.Getlen() != 0 {
 			log.Println("Compressing iMemTable")
 			preTable := database.iMemTable.GetTable()
 			database.TableTree.CreateNewTable(preTable.MemoryTree.GetValues())
 			preTable.Wal.DeleteFile()
 		}
+		database.lock.RUnlock()
 	}
 }
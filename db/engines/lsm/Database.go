@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"sync"
 )
 
 type Database struct {
@@ -15,6 +16,12 @@ type Database struct {
 	iMemTable *ReadOnlyMemTables
 	// SSTable 列表
 	TableTree *ssTable.TableTree
+	// 读写和后台压缩持有读锁，Reset、Stop 持有写锁
+	lock *sync.RWMutex
+	// 关闭时通知后台线程退出
+	stop chan struct{}
+	// 等待后台线程退出
+	wg sync.WaitGroup
 }
 
 // 数据库，全局唯一实例
@@ -36,6 +43,7 @@ func (d *Database) loadAllWalFiles(dir string) {
 			table := &MemTable{
 				MemoryTree: preTree,
 				Wal:        preWal,
+				swapLock:   &sync.RWMutex{},
 			}
 			log.Printf("add table to iMemTable, table: %v\n", table)
 			d.iMemTable.AddTable(table)
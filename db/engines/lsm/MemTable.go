@@ -38,6 +38,7 @@ func (m *MemTable) Swap() *MemTable {
 	table := &MemTable{
 		MemoryTree: tmpTree,
 		Wal:        m.Wal,
+		swapLock:   &sync.RWMutex{},
 	}
 	// creat new wal
 	newWal := &wal.Wal{}
@@ -78,3 +79,23 @@ func (m *MemTable) Delete(key string) (kv.Value, bool) {
 	}
 	return oldValue, success
 }
+
+// GetValues 获取内存表中的所有元素，包含删除标记
+func (m *MemTable) GetValues() []kv.Value {
+	m.swapLock.RLock()
+	defer m.swapLock.RUnlock()
+	return m.MemoryTree.GetValues()
+}
+
+// Reset 清空内存表，删除旧的 wal.log 并创建新的 wal.log
+func (m *MemTable) Reset() {
+	con := config.GetConfig()
+	m.swapLock.Lock()
+	defer m.swapLock.Unlock()
+	m.MemoryTree = &sortTree.Tree{}
+	m.MemoryTree.Init()
+	m.Wal.DeleteFile()
+	newWal := &wal.Wal{}
+	newWal.Init(con.DataDir)
+	m.Wal = newWal
+}
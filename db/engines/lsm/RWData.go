@@ -1,14 +1,19 @@
 package lsm
 
 import (
-	"encoding/json"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/config"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/ssTable"
 	"log"
+	"path"
+	"sort"
 )
 
 // Get 获取一个元素
 func Get[T any](key string) (T, bool) {
 	log.Print("Get ", key)
+	database.lock.RLock()
+	defer database.lock.RUnlock()
 	// 先查内存表
 	value, result := database.MemTable.Search(key)
 	if result == kv.Success {
@@ -40,6 +45,8 @@ func Set[T any](key string, value T) bool {
 		return false
 	}
 
+	database.lock.RLock()
+	defer database.lock.RUnlock()
 	_, _ = database.MemTable.Set(key, data)
 	return true
 }
@@ -48,6 +55,8 @@ func Set[T any](key string, value T) bool {
 // 返回的 bool 表示是否有旧值，不表示是否删除成功
 func DeleteAndGet[T any](key string) (T, bool) {
 	log.Print("Delete ", key)
+	database.lock.RLock()
+	defer database.lock.RUnlock()
 	value, success := database.MemTable.Delete(key)
 	if success {
 		return getInstance[T](value.Value)
@@ -59,13 +68,76 @@ func DeleteAndGet[T any](key string) (T, bool) {
 // Delete 删除元素
 func Delete[T any](key string) {
 	log.Print("Delete ", key)
+	database.lock.RLock()
+	defer database.lock.RUnlock()
 	database.MemTable.Delete(key)
 }
 
+// Range 按 key 升序遍历所有未删除的元素，fn 返回 false 时停止遍历
+func Range[T any](fn func(key string, value T) bool) {
+	database.lock.RLock()
+	defer database.lock.RUnlock()
+
+	// 由旧到新合并：SSTable -> iMemTable -> 内存表，新值覆盖旧值
+	merged := make(map[string]kv.Value)
+	for _, value := range database.TableTree.GetValues() {
+		merged[value.Key] = value
+	}
+	for _, value := range database.iMemTable.GetValues() {
+		merged[value.Key] = value
+	}
+	for _, value := range database.MemTable.GetValues() {
+		merged[value.Key] = value
+	}
+	keys := make([]string, 0, len(merged))
+	for key, value := range merged {
+		if !value.Deleted {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, _ := getInstance[T](merged[key].Value)
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// Reset 用 data 替换数据库的全部内容，持有写锁期间所有读写都会阻塞，不会读到一半的数据。
+// 先把 data 写成 resetPendingFile 并落盘，之后才删除内存表、wal.log 和旧的 SSTable，
+// 此后崩溃时由启动时的 recoverReset 完成替换，在此之前崩溃则保留原来的内容
+func Reset[T any](data map[string]T) bool {
+	log.Printf("Reset the database with %d elements", len(data))
+	values := make([]kv.Value, 0, len(data))
+	for key, value := range data {
+		bytes, err := kv.Convert(value)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		values = append(values, kv.Value{Key: key, Value: bytes})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Key < values[j].Key
+	})
+
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	pendingPath := path.Join(config.GetConfig().DataDir, resetPendingFile)
+	ssTable.WriteTable(pendingPath, values)
+
+	database.MemTable.Reset()
+	for _, table := range database.iMemTable.Reset() {
+		table.Wal.DeleteFile()
+	}
+	database.TableTree.Replace(pendingPath)
+	return true
+}
+
 // 将字节数组转为类型对象
 func getInstance[T any](data []byte) (T, bool) {
-	var value T
-	err := json.Unmarshal(data, &value)
+	value, err := kv.Get[T](&kv.Value{Value: data})
 	if err != nil {
 		log.Println(err)
 	}
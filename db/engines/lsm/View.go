@@ -0,0 +1,111 @@
+package lsm
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/ssTable"
+	"sort"
+)
+
+// View 数据库某一时刻的只读视图。内存表和只读内存表复制一份，大小受 Threshold 限制，
+// SSTable 只共享索引并使用独立的文件句柄，之后的写入、压缩和 Reset 都不影响视图
+type View struct {
+	// 由旧到新排列的数据来源，相同的 key 以较新的来源为准
+	sources []viewSource
+	tables  []*ssTable.TableView
+}
+
+// 一个按 key 升序排列的数据来源
+type viewSource struct {
+	keys  []string
+	value func(i int) (kv.Value, error)
+}
+
+// NewView 创建数据库当前内容的视图，使用完毕后需要 Close。
+// 持有写锁，后台把内存表移到只读内存表、再写入 SSTable 的途中不会漏掉数据
+func NewView() (*View, error) {
+	database.lock.Lock()
+	defer database.lock.Unlock()
+
+	tables, err := database.TableTree.View()
+	if err != nil {
+		return nil, err
+	}
+	view := &View{tables: tables}
+	for _, table := range tables {
+		table := table
+		keys := table.Keys()
+		view.sources = append(view.sources, viewSource{
+			keys: keys,
+			value: func(i int) (kv.Value, error) {
+				return table.Value(keys[i])
+			},
+		})
+	}
+	for _, values := range database.iMemTable.GetTableValues() {
+		view.sources = append(view.sources, valuesSource(values))
+	}
+	view.sources = append(view.sources, valuesSource(database.MemTable.GetValues()))
+	return view, nil
+}
+
+func valuesSource(values []kv.Value) viewSource {
+	keys := make([]string, len(values))
+	for i, value := range values {
+		keys[i] = value.Key
+	}
+	return viewSource{
+		keys: keys,
+		value: func(i int) (kv.Value, error) {
+			return values[i], nil
+		},
+	}
+}
+
+// Scan 按 key 升序遍历 [start, end) 中所有未删除的元素，end 为空时没有上界，
+// 每次从所有来源中取出最小的 key，fn 返回 false 时停止遍历
+func (v *View) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	cursors := make([]int, len(v.sources))
+	for i, source := range v.sources {
+		cursors[i] = sort.SearchStrings(source.keys, start)
+	}
+	for {
+		key, newest := "", -1
+		for i, source := range v.sources {
+			if cursors[i] == len(source.keys) {
+				continue
+			}
+			// 来源由旧到新排列，key 相同时取后面的来源
+			if k := source.keys[cursors[i]]; newest == -1 || k <= key {
+				key, newest = k, i
+			}
+		}
+		if newest == -1 || (end != "" && key >= end) {
+			return nil
+		}
+		value, err := v.sources[newest].value(cursors[newest])
+		if err != nil {
+			return err
+		}
+		for i, source := range v.sources {
+			for cursors[i] < len(source.keys) && source.keys[cursors[i]] == key {
+				cursors[i]++
+			}
+		}
+		if !value.Deleted && !fn(key, value.Value) {
+			return nil
+		}
+	}
+}
+
+// Close 关闭视图持有的 SSTable 文件句柄
+func (v *View) Close() error {
+	var err error
+	for _, table := range v.tables {
+		if closeErr := table.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	v.tables = nil
+	v.sources = nil
+	return err
+}
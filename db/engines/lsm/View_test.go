@@ -0,0 +1,35 @@
+package lsm
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"reflect"
+	"testing"
+)
+
+func Test_View_Scan(t *testing.T) {
+	// 由旧到新：较新的来源覆盖或删除较旧的值
+	view := &View{sources: []viewSource{
+		valuesSource([]kv.Value{{Key: "a", Value: []byte("1")}, {Key: "b", Value: []byte("1")}, {Key: "d", Value: []byte("1")}}),
+		valuesSource([]kv.Value{{Key: "b", Value: []byte("2")}, {Key: "c", Value: []byte("2")}}),
+		valuesSource([]kv.Value{{Key: "a", Deleted: true}, {Key: "e", Value: []byte("3")}}),
+	}}
+	scan := func(start, end string, limit int) []string {
+		got := make([]string, 0)
+		if err := view.Scan(start, end, func(key string, value []byte) bool {
+			got = append(got, key+"="+string(value))
+			return len(got) < limit
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got, want := scan("", "", 10), []string{"b=2", "c=2", "d=1", "e=3"}; !reflect.DeepEqual(got, want) {
+		t.Fatal(got)
+	}
+	if got, want := scan("b", "e", 10), []string{"b=2", "c=2", "d=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatal(got)
+	}
+	if got, want := scan("bb", "", 1), []string{"c=2"}; !reflect.DeepEqual(got, want) {
+		t.Fatal(got)
+	}
+}
@@ -48,3 +48,34 @@ func (r *ReadOnlyMemTables) Search(key string) (kv.Value, kv.SearchResult) {
 	var nilV kv.Value
 	return nilV, kv.None
 }
+
+// GetValues 由旧到新获取所有只读内存表中的元素，包含删除标记
+func (r *ReadOnlyMemTables) GetValues() []kv.Value {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	values := make([]kv.Value, 0)
+	for _, table := range r.readonlyTable {
+		values = append(values, table.MemoryTree.GetValues()...)
+	}
+	return values
+}
+
+// GetTableValues 由旧到新获取每个只读内存表中按 key 排序的元素，包含删除标记
+func (r *ReadOnlyMemTables) GetTableValues() [][]kv.Value {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	tables := make([][]kv.Value, 0, len(r.readonlyTable))
+	for _, table := range r.readonlyTable {
+		tables = append(tables, table.MemoryTree.GetValues())
+	}
+	return tables
+}
+
+// Reset 清空只读内存表，返回被移除的表，由调用方删除对应的 wal.log
+func (r *ReadOnlyMemTables) Reset() []*MemTable {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tables := r.readonlyTable
+	r.readonlyTable = make([]*MemTable, 0)
+	return tables
+}
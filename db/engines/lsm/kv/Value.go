@@ -1,6 +1,10 @@
 package kv
 
-import "encoding/json"
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
 
 // SearchResult 查找结果
 type SearchResult int
@@ -29,26 +33,65 @@ func (v *Value) Copy() *Value {
 	}
 }
 
-// Get 反序列化元素中的值
+// Get 反序列化元素中的值，[]byte 类型直接返回原始字节
 func Get[T any](v *Value) (T, error) {
 	var value T
+	if raw, ok := any(&value).(*[]byte); ok {
+		*raw = v.Value
+		return value, nil
+	}
 	err := json.Unmarshal(v.Value, &value)
 	return value, err
 }
 
-// Convert 将值序列化为二进制
+// Convert 将值序列化为二进制，[]byte 类型不经过 JSON，原样保存
 func Convert[T any](value T) ([]byte, error) {
+	if raw, ok := any(value).([]byte); ok {
+		return raw, nil
+	}
 	return json.Marshal(value)
 }
 
+// 记录的编码：flags(1) uvarint(len(key)) key value，value 占用剩余的全部字节，
+// 长度由 wal.log 和 SSTable 中记录的长度确定
+const flagDeleted byte = 1
+
+var errTruncated = errors.New("kv: truncated value")
+
 // Decode 二进制数据反序列化为 Value
 func Decode(data []byte) (Value, error) {
-	var value Value
-	err := json.Unmarshal(data, &value)
-	return value, err
+	if len(data) < 1 {
+		return Value{}, errTruncated
+	}
+	flags := data[0]
+	n, size := binary.Uvarint(data[1:])
+	if size <= 0 || n > uint64(len(data)-1-size) {
+		return Value{}, errTruncated
+	}
+	keyEnd := 1 + size + int(n)
+	value := Value{
+		Key:     string(data[1+size : keyEnd]),
+		Deleted: flags&flagDeleted != 0,
+	}
+	if !value.Deleted {
+		value.Value = make([]byte, len(data)-keyEnd)
+		copy(value.Value, data[keyEnd:])
+	}
+	return value, nil
 }
 
-// Encode 将 Value 序列化为二进制
+// Encode 将 Value 序列化为二进制，key 和 value 可以包含任意字节
 func Encode(value Value) ([]byte, error) {
-	return json.Marshal(value)
+	var flags byte
+	if value.Deleted {
+		flags |= flagDeleted
+	}
+	data := make([]byte, 1, 1+binary.MaxVarintLen64+len(value.Key)+len(value.Value))
+	data[0] = flags
+	data = binary.AppendUvarint(data, uint64(len(value.Key)))
+	data = append(data, value.Key...)
+	if !value.Deleted {
+		data = append(data, value.Value...)
+	}
+	return data, nil
 }
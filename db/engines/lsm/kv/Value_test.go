@@ -1,6 +1,8 @@
 package kv
 
 import (
+	"bytes"
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -26,13 +28,55 @@ func Test_Value_Convert(t *testing.T) {
 }
 
 func Test_Value_Get(t *testing.T) {
-
+	data := []byte{0, '}', 0xff}
+	value, err := Get[[]byte](&Value{Value: data})
+	if err != nil || !bytes.Equal(value, data) {
+		t.Fatal(value, err)
+	}
+	v, err := Get[vTest](&Value{Value: testData})
+	if err != nil || v.A != 123 || v.B != 123 {
+		t.Fatal(v, err)
+	}
 }
 
 func Test_Value_Encode(t *testing.T) {
-
+	data := []byte{0, '}', 0xff}
+	converted, err := Convert(data)
+	if err != nil || !bytes.Equal(converted, data) {
+		t.Fatal(converted, err)
+	}
+	encoded, err := Encode(Value{Key: "a", Value: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, []byte{0, 1, 'a', 0, '}', 0xff}) {
+		t.Fatal(encoded)
+	}
 }
 
 func Test_Value_Decode(t *testing.T) {
-
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		key := make([]byte, rnd.Intn(32))
+		rnd.Read(key)
+		value := Value{Key: string(key), Value: make([]byte, rnd.Intn(512)), Deleted: i%10 == 0}
+		rnd.Read(value.Value)
+		if value.Deleted {
+			value.Value = nil
+		}
+		data, err := Encode(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Key != value.Key || decoded.Deleted != value.Deleted || !bytes.Equal(decoded.Value, value.Value) {
+			t.Fatalf("round trip %d: got %v, want %v", i, decoded, value)
+		}
+	}
+	if _, err := Decode([]byte{0, 5, 'a'}); err == nil {
+		t.Fatal("expected an error for a truncated key")
+	}
 }
@@ -17,7 +17,7 @@ package ssTable
 // MetaInfo 是 SSTable 的元数据，
 // 元数据出现在磁盘文件的末尾
 type MetaInfo struct {
-	// 版本号
+	// 版本号，1 表示数据区使用 kv.Encode 的二进制编码
 	version int64
 	// 数据区起始索引
 	dataStart int64
@@ -1,5 +1,11 @@
 package ssTable
 
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
 // Position 元素定位，存储在稀疏索引区中，表示一个元素的起始位置和长度
 type Position struct {
 	// 起始索引
@@ -9,3 +15,56 @@ type Position struct {
 	// Key 已经被删除
 	Deleted bool
 }
+
+// 稀疏索引区按 key 升序编码，每个元素为：
+// uvarint(len(key)) key uvarint(Start) uvarint(Len) deleted(1)
+func encodeIndex(keys []string, positions map[string]Position) []byte {
+	data := make([]byte, 0)
+	for _, key := range keys {
+		position := positions[key]
+		data = binary.AppendUvarint(data, uint64(len(key)))
+		data = append(data, key...)
+		data = binary.AppendUvarint(data, uint64(position.Start))
+		data = binary.AppendUvarint(data, uint64(position.Len))
+		if position.Deleted {
+			data = append(data, 1)
+		} else {
+			data = append(data, 0)
+		}
+	}
+	return data
+}
+
+// 解码稀疏索引区，key 按写入时的顺序返回
+func decodeIndex(data []byte) ([]string, map[string]Position, error) {
+	keys := make([]string, 0)
+	positions := make(map[string]Position)
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, nil, errIndexTruncated
+		}
+		key := make([]byte, n)
+		_, _ = r.Read(key)
+		var position Position
+		start, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, nil, errIndexTruncated
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, nil, errIndexTruncated
+		}
+		deleted, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, errIndexTruncated
+		}
+		position.Start, position.Len, position.Deleted = int64(start), int64(length), deleted == 1
+		keys = append(keys, string(key))
+		positions[string(key)] = position
+	}
+	return keys, positions, nil
+}
+
+var errIndexTruncated = errors.New("sparse index truncated")
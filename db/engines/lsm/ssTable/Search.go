@@ -59,3 +59,34 @@ func (table *SSTable) Search(key string) (value kv.Value, result kv.SearchResult
 	}
 	return value, kv.Success
 }
+
+// GetValues 从磁盘文件中加载该表的所有元素，包含删除标记
+func (table *SSTable) GetValues() []kv.Value {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	dataArea := make([]byte, table.tableMetaInfo.dataLen)
+	if _, err := table.f.Seek(0, 0); err != nil {
+		log.Println(" error open file ", table.filePath)
+		panic(err)
+	}
+	if _, err := table.f.Read(dataArea); err != nil {
+		log.Println(" error read file ", table.filePath)
+		panic(err)
+	}
+	values := make([]kv.Value, 0, len(table.sortIndex))
+	for _, key := range table.sortIndex {
+		position := table.sparseIndex[key]
+		if position.Deleted {
+			values = append(values, kv.Value{Key: key, Deleted: true})
+			continue
+		}
+		value, err := kv.Decode(dataArea[position.Start:(position.Start + position.Len)])
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,104 @@
+package ssTable
+
+import (
+	"bytes"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/config"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func Test_SSTable_Binary(t *testing.T) {
+	dir := t.TempDir()
+	config.Init(config.Config{DataDir: dir, Level0Size: 1, PartSize: 4})
+	tree := &TableTree{}
+	tree.Init(dir)
+
+	rnd := rand.New(rand.NewSource(1))
+	values := make([]kv.Value, 0)
+	expected := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 1+rnd.Intn(16))
+		rnd.Read(key)
+		value := make([]byte, rnd.Intn(512))
+		rnd.Read(value)
+		if _, ok := expected[string(key)]; ok {
+			continue
+		}
+		expected[string(key)] = value
+		values = append(values, kv.Value{Key: string(key), Value: value})
+	}
+	values = append(values, kv.Value{Key: "deleted}\xff", Deleted: true})
+	tree.CreateNewTable(values)
+	tree.Close()
+
+	// 重新加载磁盘上的 SSTable
+	tree = &TableTree{}
+	tree.Init(dir)
+	defer tree.Close()
+	for key, value := range expected {
+		got, result := tree.Search(key)
+		if result != kv.Success || !bytes.Equal(got.Value, value) {
+			t.Fatalf("key %q: got %v %v", key, result, got.Value)
+		}
+	}
+	if _, result := tree.Search("deleted}\xff"); result != kv.Deleted {
+		t.Fatal(result)
+	}
+	if got := tree.GetValues(); len(got) != len(values) {
+		t.Fatal(len(got))
+	}
+}
+
+func Test_TableTree_Replace(t *testing.T) {
+	dir := t.TempDir()
+	tree := &TableTree{}
+	tree.Init(dir)
+	tree.insertTestTable(dir, 0, kv.Value{Key: "old", Value: []byte("1")})
+	tree.insertTestTable(dir, 1, kv.Value{Key: "older", Value: []byte("2")})
+
+	pending := path.Join(dir, "reset.pending")
+	WriteTable(pending, []kv.Value{{Key: "new", Value: []byte("3")}})
+	tree.Replace(pending)
+	check := func(tree *TableTree) {
+		for _, key := range []string{"old", "older"} {
+			if _, result := tree.Search(key); result != kv.None {
+				t.Fatalf("key %q: got %v", key, result)
+			}
+		}
+		if got, result := tree.Search("new"); result != kv.Success || string(got.Value) != "3" {
+			t.Fatalf("key new: got %v %v", result, got.Value)
+		}
+	}
+	check(tree)
+	tree.Close()
+	tree = &TableTree{}
+	tree.Init(dir)
+	check(tree)
+	tree.Close()
+
+	// 模拟删除旧 SSTable 的途中崩溃：旧文件和新文件都还在，重新执行即可
+	tree = &TableTree{}
+	tree.Init(dir)
+	tree.insertTestTable(dir, 2, kv.Value{Key: "old", Value: []byte("1")})
+	tree.Close()
+	WriteTable(pending, []kv.Value{{Key: "new", Value: []byte("3")}})
+	InstallTable(dir, pending)
+	tree = &TableTree{}
+	tree.Init(dir)
+	defer tree.Close()
+	check(tree)
+	if _, err := os.Stat(pending); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+// 在指定层写入一个 SSTable 文件并加载
+func (tree *TableTree) insertTestTable(dir string, level int, values ...kv.Value) {
+	file := path.Join(dir, strconv.Itoa(level)+".0.db")
+	WriteTable(file, values)
+	tree.loadDbFile(file)
+}
@@ -3,6 +3,10 @@ package ssTable
 import (
 	"fmt"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"sync"
 )
 
@@ -77,3 +81,79 @@ func getLevel(name string) (level int, index int, err error) {
 	}
 	return level, index, nil
 }
+
+// GetValues 由旧到新获取所有 SSTable 中的元素，包含删除标记，
+// 层数越大数据越旧，同一层中序号越大数据越新
+func (tree *TableTree) GetValues() []kv.Value {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	values := make([]kv.Value, 0)
+	for level := len(tree.levels) - 1; level >= 0; level-- {
+		for node := tree.levels[level]; node != nil; node = node.next {
+			values = append(values, node.table.GetValues()...)
+		}
+	}
+	return values
+}
+
+// Close 关闭所有 SSTable 文件
+func (tree *TableTree) Close() {
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	for _, node := range tree.levels {
+		for ; node != nil; node = node.next {
+			if node.table.f != nil {
+				_ = node.table.f.Close()
+				node.table.f = nil
+			}
+		}
+	}
+}
+
+// Clear 关闭并删除所有的 SSTable 文件
+func (tree *TableTree) Clear() {
+	tree.lock.Lock()
+	levels := tree.levels
+	tree.levels = make([]*tableNode, len(levels))
+	tree.lock.Unlock()
+
+	for _, node := range levels {
+		tree.clearLevel(node)
+	}
+}
+
+// Replace 用 file 处已经写好并落盘的 SSTable 替换所有的 SSTable，
+// file 必须位于数据目录中
+func (tree *TableTree) Replace(file string) {
+	tree.Close()
+	tree.lock.Lock()
+	defer tree.lock.Unlock()
+	dir := filepath.Dir(file)
+	InstallTable(dir, file)
+	tree.levels = make([]*tableNode, len(tree.levels))
+	tree.loadDbFile(path.Join(dir, "0.0.db"))
+}
+
+// InstallTable 删除 dir 中所有的 SSTable 文件，再把 file 改名为第 0 层的第一个 SSTable，
+// 中途崩溃时 file 仍然存在，可以重新执行
+func InstallTable(dir string, file string) {
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println("Failed to read the database file")
+		panic(err)
+	}
+	for _, info := range infos {
+		if path.Ext(info.Name()) == ".db" {
+			if err := os.Remove(path.Join(dir, info.Name())); err != nil {
+				log.Println(" error delete file,", info.Name())
+				panic(err)
+			}
+		}
+	}
+	if err := os.Rename(file, path.Join(dir, "0.0.db")); err != nil {
+		log.Println(" error rename file,", file)
+		panic(err)
+	}
+	syncDir(dir)
+}
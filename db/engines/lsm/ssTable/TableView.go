@@ -0,0 +1,63 @@
+package ssTable
+
+import (
+	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"os"
+)
+
+// TableView SSTable 的只读视图，持有独立的文件句柄，
+// SSTable 被压缩或 Reset 删除后仍然可以读取
+type TableView struct {
+	f           *os.File
+	sortIndex   []string
+	sparseIndex map[string]Position
+}
+
+// View 由旧到新打开所有 SSTable 的只读视图，顺序与 GetValues 相同
+func (tree *TableTree) View() ([]*TableView, error) {
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+
+	views := make([]*TableView, 0)
+	for level := len(tree.levels) - 1; level >= 0; level-- {
+		for node := tree.levels[level]; node != nil; node = node.next {
+			f, err := os.Open(node.table.filePath)
+			if err != nil {
+				for _, view := range views {
+					_ = view.Close()
+				}
+				return nil, err
+			}
+			// 索引在 SSTable 创建后不再修改，可以直接共享
+			views = append(views, &TableView{
+				f:           f,
+				sortIndex:   node.table.sortIndex,
+				sparseIndex: node.table.sparseIndex,
+			})
+		}
+	}
+	return views, nil
+}
+
+// Keys 升序排列的所有 key，包含已删除的 key
+func (view *TableView) Keys() []string {
+	return view.sortIndex
+}
+
+// Value 从磁盘文件中读取 key 对应的元素，包含删除标记
+func (view *TableView) Value(key string) (kv.Value, error) {
+	position := view.sparseIndex[key]
+	if position.Deleted {
+		return kv.Value{Key: key, Deleted: true}, nil
+	}
+	bytes := make([]byte, position.Len)
+	if _, err := view.f.ReadAt(bytes, position.Start); err != nil {
+		return kv.Value{}, err
+	}
+	return kv.Decode(bytes)
+}
+
+// Close 关闭视图的文件句柄
+func (view *TableView) Close() error {
+	return view.f.Close()
+}
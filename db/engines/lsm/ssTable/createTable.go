@@ -1,16 +1,19 @@
 package ssTable
 
 import (
-	"encoding/json"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/config"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
 	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"sync"
 )
 
+// SSTable 文件格式的版本号
+const tableVersion int64 = 1
+
 // CreateNewTable 创建新的 SSTable
 func (tree *TableTree) CreateNewTable(values []kv.Value) {
 	tree.createTable(values, 0)
@@ -18,6 +21,38 @@ func (tree *TableTree) CreateNewTable(values []kv.Value) {
 
 // 创建新的 SSTable，插入到合适的层
 func (tree *TableTree) createTable(values []kv.Value, level int) *SSTable {
+	table, dataArea, indexArea := encodeTable(values)
+	index := tree.insert(table, level)
+	log.Printf("Create a new SSTable,level: %d ,index: %d\r\n", level, index)
+	con := config.GetConfig()
+	filePath := con.DataDir + "/" + strconv.Itoa(level) + "." + strconv.Itoa(index) + ".db"
+	table.filePath = filePath
+
+	writeDataToFile(filePath, dataArea, indexArea, table.tableMetaInfo)
+	// 以只读的形式打开文件
+	f, err := os.OpenFile(table.filePath, os.O_RDONLY, 0666)
+	if err != nil {
+		log.Println(" error open file ", table.filePath)
+		panic(err)
+	}
+	table.f = f
+
+	return table
+}
+
+// WriteTable 把 values 写成 filePath 处的 SSTable 文件，不加入 TableTree。
+// 先写入 filePath+".tmp" 并落盘再改名，filePath 存在时文件一定是完整的
+func WriteTable(filePath string, values []kv.Value) {
+	table, dataArea, indexArea := encodeTable(values)
+	writeDataToFile(filePath+".tmp", dataArea, indexArea, table.tableMetaInfo)
+	if err := os.Rename(filePath+".tmp", filePath); err != nil {
+		log.Fatal(" error rename file,", err)
+	}
+	syncDir(filepath.Dir(filePath))
+}
+
+// 生成 SSTable 的数据区、稀疏索引区和元数据
+func encodeTable(values []kv.Value) (*SSTable, []byte, []byte) {
 	// 生成数据区
 	keys := make([]string, 0, len(values))
 	positions := make(map[string]Position)
@@ -40,15 +75,11 @@ func (tree *TableTree) createTable(values []kv.Value, level int) *SSTable {
 	sort.Strings(keys)
 
 	// 生成稀疏索引区
-	// map[string]Position to json
-	indexArea, err := json.Marshal(positions)
-	if err != nil {
-		log.Fatal("An SSTable file cannot be created,", err)
-	}
+	indexArea := encodeIndex(keys, positions)
 
 	// 生成 MetaInfo
 	meta := MetaInfo{
-		version:    0,
+		version:    tableVersion,
 		dataStart:  0,
 		dataLen:    int64(len(dataArea)),
 		indexStart: int64(len(dataArea)),
@@ -61,20 +92,5 @@ func (tree *TableTree) createTable(values []kv.Value, level int) *SSTable {
 		sortIndex:     keys,
 		lock:          &sync.RWMutex{},
 	}
-	index := tree.insert(table, level)
-	log.Printf("Create a new SSTable,level: %d ,index: %d\r\n", level, index)
-	con := config.GetConfig()
-	filePath := con.DataDir + "/" + strconv.Itoa(level) + "." + strconv.Itoa(index) + ".db"
-	table.filePath = filePath
-
-	writeDataToFile(filePath, dataArea, indexArea, meta)
-	// 以只读的形式打开文件
-	f, err := os.OpenFile(table.filePath, os.O_RDONLY, 0666)
-	if err != nil {
-		log.Println(" error open file ", table.filePath)
-		panic(err)
-	}
-	table.f = f
-
-	return table
+	return table, dataArea, indexArea
 }
@@ -32,7 +32,7 @@ func (tree *TableTree) GetLevelSize(level int) int64 {
 
 // 将数据写入文件
 func writeDataToFile(filePath string, dataArea []byte, indexArea []byte, meta MetaInfo) {
-	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE, 0666)
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		log.Fatal(" error create file,", err)
 	}
@@ -60,3 +60,16 @@ func writeDataToFile(filePath string, dataArea []byte, indexArea []byte, meta Me
 		log.Fatal(" error close file,", err)
 	}
 }
+
+// 同步目录，使目录中新建、改名和删除的文件在崩溃后仍然有效
+func syncDir(dir string) {
+	f, err := os.Open(dir)
+	if err != nil {
+		log.Fatal(" error open dir,", err)
+	}
+	err = f.Sync()
+	if err != nil {
+		log.Fatal(" error sync dir,", err)
+	}
+	_ = f.Close()
+}
@@ -2,11 +2,10 @@ package ssTable
 
 import (
 	"encoding/binary"
-	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"time"
 )
 
@@ -84,21 +83,14 @@ func (table *SSTable) loadSparseIndex() {
 		panic(err)
 	}
 
-	// 反序列化到内存
-	table.sparseIndex = make(map[string]Position)
-	err := json.Unmarshal(bytes, &table.sparseIndex)
+	// 反序列化到内存，写入时已经按 key 排序
+	keys, positions, err := decodeIndex(bytes)
 	if err != nil {
 		log.Println(" error open file ", table.filePath)
 		panic(err)
 	}
 	_, _ = table.f.Seek(0, 0)
-
-	// 先排序
-	keys := make([]string, 0, len(table.sparseIndex))
-	for k := range table.sparseIndex {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+	table.sparseIndex = positions
 	table.sortIndex = keys
 }
 
@@ -145,4 +137,10 @@ func (table *SSTable) loadMetaInfo() {
 		panic(err)
 	}
 	_ = binary.Read(f, binary.LittleEndian, &table.tableMetaInfo.indexLen)
+
+	// 旧版本的数据区是 JSON 编码，无法按当前格式读取
+	if table.tableMetaInfo.version != tableVersion {
+		log.Println("Unsupported SSTable version ", table.tableMetaInfo.version, table.filePath)
+		panic(fmt.Sprintf("unsupported SSTable version %d", table.tableMetaInfo.version))
+	}
 }
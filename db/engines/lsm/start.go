@@ -5,6 +5,8 @@ import (
 	"github.com/huiming23344/kv-raft/db/engines/lsm/ssTable"
 	"log"
 	"os"
+	"path"
+	"sync"
 )
 
 // Start 启动数据库
@@ -26,8 +28,44 @@ func Start(con config.Config) {
 	// 检查压缩数据库文件
 	database.TableTree.Check()
 	// 启动后台线程
-	go Check()
-	go CompressMemory()
+	database.wg.Add(2)
+	go func() {
+		defer database.wg.Done()
+		Check()
+	}()
+	go func() {
+		defer database.wg.Done()
+		CompressMemory()
+	}()
+}
+
+// Stop 停止后台线程，将内存表写入 SSTable，并关闭 wal.log 和 SSTable 文件
+func Stop() {
+	if database == nil {
+		return
+	}
+	close(database.stop)
+	database.wg.Wait()
+
+	database.lock.Lock()
+	defer database.lock.Unlock()
+	log.Println("Flushing the memory tables")
+	// 先写入较旧的只读内存表
+	for database.iMemTable.Getlen() != 0 {
+		table := database.iMemTable.GetTable()
+		flushTable(table)
+	}
+	flushTable(database.MemTable)
+	database.TableTree.Close()
+	database = nil
+}
+
+// 将内存表写入新的 SSTable 并删除对应的 wal.log
+func flushTable(table *MemTable) {
+	if values := table.GetValues(); len(values) > 0 {
+		database.TableTree.CreateNewTable(values)
+	}
+	table.Wal.DeleteFile()
 }
 
 // 初始化 Database，从磁盘文件中还原 SSTable、WalF、内存表等
@@ -36,6 +74,8 @@ func initDatabase(dir string) {
 		MemTable:  &MemTable{},
 		iMemTable: &ReadOnlyMemTables{},
 		TableTree: &ssTable.TableTree{},
+		lock:      &sync.RWMutex{},
+		stop:      make(chan struct{}),
 	}
 	// 从磁盘文件中恢复数据
 	// 如果目录不存在，则为空数据库
@@ -47,6 +87,7 @@ func initDatabase(dir string) {
 			panic(err)
 		}
 	}
+	recoverReset(dir)
 	database.iMemTable.Init()
 	database.MemTable.InitMemTree()
 	log.Println("Loading all wal.log...")
@@ -55,3 +96,33 @@ func initDatabase(dir string) {
 	log.Println("Loading database...")
 	database.TableTree.Init(dir)
 }
+
+// Reset 写入的新 SSTable，WriteTable 写入时使用它加上 .tmp 后缀的临时文件
+const resetPendingFile = "reset.pending"
+
+// 完成上一次崩溃时没有完成的 Reset：
+// resetPendingFile 存在说明新的 SSTable 已经落盘，删除所有 wal.log 和旧的 SSTable 后换上它，
+// 只有临时文件说明 Reset 还没有写完，删除即可
+func recoverReset(dir string) {
+	pendingPath := path.Join(dir, resetPendingFile)
+	if err := os.Remove(pendingPath + ".tmp"); err != nil && !os.IsNotExist(err) {
+		panic(err)
+	}
+	if _, err := os.Stat(pendingPath); err != nil {
+		return
+	}
+	log.Println("Completing an interrupted reset")
+	infos, err := os.ReadDir(dir)
+	if err != nil {
+		log.Println("Failed to read the database file")
+		panic(err)
+	}
+	for _, info := range infos {
+		if path.Ext(info.Name()) == ".log" {
+			if err := os.Remove(path.Join(dir, info.Name())); err != nil {
+				panic(err)
+			}
+		}
+	}
+	ssTable.InstallTable(dir, pendingPath)
+}
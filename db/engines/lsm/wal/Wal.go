@@ -3,7 +3,7 @@ package wal
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
 	"github.com/huiming23344/kv-raft/db/engines/lsm/sortTree"
@@ -14,6 +14,35 @@ import (
 	"time"
 )
 
+// wal.log 的文件头：magic(4) version(1)，之后的每条记录为 length(8) kv.Encode 的数据
+var walMagic = []byte("KVWL")
+
+const walVersion byte = 1
+
+var (
+	// ErrLegacyWal 旧版本以 JSON 记录的 wal.log，新版本无法读取，需要先用旧版本把内存表写入 SSTable
+	ErrLegacyWal = errors.New("wal: legacy JSON wal.log is not supported, flush it with the previous version first")
+	// ErrUnknownWal 文件头不是 wal.log 或者版本不支持
+	ErrUnknownWal = errors.New("wal: not a wal.log file or unsupported version")
+)
+
+func walHeader() []byte {
+	return append(append([]byte{}, walMagic...), walVersion)
+}
+
+// checkHeader 检查文件头，返回记录开始的位置
+func checkHeader(data []byte) (int64, error) {
+	header := walHeader()
+	if bytes.HasPrefix(data, header) {
+		return int64(len(header)), nil
+	}
+	// 旧版本的记录为 length(8) JSON
+	if !bytes.HasPrefix(data, walMagic) && len(data) > 8 && data[8] == '{' {
+		return 0, ErrLegacyWal
+	}
+	return 0, ErrUnknownWal
+}
+
 type Wal struct {
 	f    *os.File
 	path string
@@ -35,6 +64,10 @@ func (w *Wal) Init(dir string) {
 		log.Println("The wal.log file cannot be created")
 		panic(err)
 	}
+	if _, err = f.Write(walHeader()); err != nil {
+		log.Println("Failed to write the wal.log")
+		panic(err)
+	}
 	w.f = f
 	w.path = walPath
 	w.lock = &sync.Mutex{}
@@ -63,8 +96,12 @@ func (w *Wal) LoadToMemory(tree *sortTree.Tree) *sortTree.Tree {
 	info, _ := os.Stat(w.path)
 	size := info.Size()
 
-	// 空的 wal.log
+	// 空的 wal.log，写入文件头
 	if size == 0 {
+		if _, err := w.f.Write(walHeader()); err != nil {
+			log.Println("Failed to write the wal.log")
+			panic(err)
+		}
 		return preTree
 	}
 
@@ -90,8 +127,12 @@ func (w *Wal) LoadToMemory(tree *sortTree.Tree) *sortTree.Tree {
 		panic(err)
 	}
 
+	index, err := checkHeader(data) // 当前索引
+	if err != nil {
+		log.Printf("Failed to open the wal.log %s\n", w.path)
+		panic(err)
+	}
 	dataLen := int64(0) // 元素的字节数量
-	index := int64(0)   // 当前索引
 	for index < size {
 		// 前面的 8 个字节表示元素的长度
 		indexData := data[index:(index + 8)]
@@ -105,8 +146,7 @@ func (w *Wal) LoadToMemory(tree *sortTree.Tree) *sortTree.Tree {
 		// 将元素的所有字节读取出来，并还原为 kv.Value
 		index += 8
 		dataArea := data[index:(index + dataLen)]
-		var value kv.Value
-		err = json.Unmarshal(dataArea, &value)
+		value, err := kv.Decode(dataArea)
 		if err != nil {
 			log.Println("Failed to open the wal.log")
 			panic(err)
@@ -136,7 +176,7 @@ func (w *Wal) Write(value kv.Value) {
 		log.Println("wal.log:	insert ", value.Key)
 	}
 
-	data, _ := json.Marshal(value)
+	data, _ := kv.Encode(value)
 	err := binary.Write(w.f, binary.LittleEndian, int64(len(data)))
 	if err != nil {
 		log.Println("Failed to write the wal.log")
@@ -169,6 +209,9 @@ func (w *Wal) Reset() {
 	if err != nil {
 		panic(err)
 	}
+	if _, err = f.Write(walHeader()); err != nil {
+		panic(err)
+	}
 	w.f = f
 }
 
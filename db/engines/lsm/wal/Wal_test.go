@@ -0,0 +1,97 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/kv"
+	"github.com/huiming23344/kv-raft/db/engines/lsm/sortTree"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_Wal_LoadBinary(t *testing.T) {
+	w := &Wal{}
+	w.Init(t.TempDir())
+	rnd := rand.New(rand.NewSource(1))
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i) + "}\xff"
+		value := make([]byte, rnd.Intn(512))
+		rnd.Read(value)
+		values[key] = value
+		w.Write(kv.Value{Key: key, Value: value})
+	}
+	w.Write(kv.Value{Key: "0}\xff", Deleted: true})
+	delete(values, "0}\xff")
+
+	tree := &sortTree.Tree{}
+	tree.Init()
+	loaded := &Wal{}
+	loaded.LoadFromFile(w.path, tree)
+	if tree.GetCount() != len(values) {
+		t.Fatal(tree.GetCount())
+	}
+	for key, value := range values {
+		got, result := tree.Search(key)
+		if result != kv.Success || !bytes.Equal(got.Value, value) {
+			t.Fatalf("key %q: got %v %v", key, result, got.Value)
+		}
+	}
+	if _, result := tree.Search("0}\xff"); result != kv.Deleted {
+		t.Fatal(result)
+	}
+}
+
+func Test_Wal_LoadEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty_wal.log")
+	if err := os.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tree := &sortTree.Tree{}
+	tree.Init()
+	w := &Wal{}
+	w.LoadFromFile(path, tree)
+	w.Write(kv.Value{Key: "name", Value: []byte("mars")})
+
+	tree = &sortTree.Tree{}
+	tree.Init()
+	(&Wal{}).LoadFromFile(path, tree)
+	if got, result := tree.Search("name"); result != kv.Success || string(got.Value) != "mars" {
+		t.Fatal(result, got)
+	}
+}
+
+func Test_Wal_LoadLegacy(t *testing.T) {
+	// 旧版本的记录为 length(8) JSON
+	record := []byte(`{"Key":"name","Value":"bWFycw==","Deleted":false}`)
+	legacy := append(binary.LittleEndian.AppendUint64(nil, uint64(len(record))), record...)
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"legacy", legacy, ErrLegacyWal},
+		{"unknown", []byte("not a wal.log"), ErrUnknownWal},
+		{"version", append([]byte("KVWL"), walVersion+1), ErrUnknownWal},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.name+"_wal.log")
+			if err := os.WriteFile(path, test.data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if r := recover(); r != test.want {
+					t.Fatalf("got %v, want %v", r, test.want)
+				}
+			}()
+			tree := &sortTree.Tree{}
+			tree.Init()
+			(&Wal{}).LoadFromFile(path, tree)
+			t.Fatal("loaded a wal.log without a valid header")
+		})
+	}
+}
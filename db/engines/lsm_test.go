@@ -12,14 +12,14 @@ func Test_LsmStore(t *testing.T) {
 		path, _ := os.Getwd()
 		engine := NewLsmEngine(path)
 		// 1.日志首次写入
-		err := engine.Set("name", "mars")
+		err := engine.Set("name", []byte("mars"))
 		if err != nil {
 			t.Fatal(err)
 		}
 		val, err := engine.Get("name")
-		So(val, ShouldEqual, "mars")
+		So(string(val), ShouldEqual, "mars")
 		// 2.日志追加
-		err = engine.Set("age", "25")
+		err = engine.Set("age", []byte("25"))
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -27,7 +27,7 @@ func Test_LsmStore(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		So(val, ShouldEqual, "25")
+		So(string(val), ShouldEqual, "25")
 		// 3.删除索引，日志不动
 		err = engine.Remove("name")
 		if err != nil {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// 成员复制进度表格的列，与 INFO raft 中 peer 行的字段一致
+var peerColumns = []string{"id", "address", "client", "suffrage", "leader", "match", "lag"}
+
+// clusterStatus INFO raft 的解析结果
+type clusterStatus struct {
+	// 按返回顺序保存的节点状态
+	keys []string
+	node map[string]string
+	// 每个成员的复制进度
+	peers []map[string]string
+}
+
+func NewClusterCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "cluster",
+		Short: "Cluster related commands",
+	}
+	cc.AddCommand(NewClusterStatusCommand())
+	return cc
+}
+
+func NewClusterStatusCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "status",
+		Short: "Shows the raft state of the server and the replication progress of every member",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			output, err := cmd.Flags().GetString("output")
+			if err != nil {
+				log.Fatal(err)
+			}
+			info, err := connectServer(cmd).Info("raft")
+			if err != nil {
+				log.Fatal(err)
+			}
+			status := parseInfo(info)
+			switch output {
+			case "table":
+				status.printTable()
+			case "json":
+				if err := status.printJSON(); err != nil {
+					log.Fatal(err)
+				}
+			default:
+				log.Fatalf("unknown output format %q", output)
+			}
+		},
+	}
+	cc.Flags().StringP("output", "o", "table", "Output format: table or json")
+	return cc
+}
+
+// parseInfo 解析 key:value 格式的 INFO 输出，peer<n> 行的值是逗号分隔的 field=value
+func parseInfo(info string) *clusterStatus {
+	status := &clusterStatus{node: make(map[string]string)}
+	for _, line := range strings.Split(info, "\r\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(key, "peer") {
+			peer := make(map[string]string)
+			for _, field := range strings.Split(value, ",") {
+				if name, v, ok := strings.Cut(field, "="); ok {
+					peer[name] = v
+				}
+			}
+			status.peers = append(status.peers, peer)
+			continue
+		}
+		status.keys = append(status.keys, key)
+		status.node[key] = value
+	}
+	return status
+}
+
+func (s *clusterStatus) printTable() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, key := range s.keys {
+		fmt.Fprintf(w, "%s\t%s\n", key, s.node[key])
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(peerColumns, "\t")))
+	for _, peer := range s.peers {
+		values := make([]string, len(peerColumns))
+		for i, column := range peerColumns {
+			values[i] = peer[column]
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	_ = w.Flush()
+}
+
+func (s *clusterStatus) printJSON() error {
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"node":  s.node,
+		"peers": s.peers,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
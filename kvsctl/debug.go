@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+// 离线排查命令直接读取节点的 raft 数据目录（raft.data-dir），不连接服务端，节点需要先停止
+
+func NewDebugCommand() *cobra.Command {
+	dc := &cobra.Command{
+		Use:   "debug",
+		Short: "Offline inspection of a stopped node's raft data",
+	}
+	dc.PersistentFlags().Uint64("group", 0, "Raft group to inspect, 0 is the meta group")
+	dc.AddCommand(NewDebugRaftLogCommand())
+	dc.AddCommand(NewDebugSnapshotCommand())
+	return dc
+}
+
+func NewDebugRaftLogCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "raft-log <data-dir>",
+		Short: "Lists raft log entries with their index, term, type and decoded command",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			group, _ := cmd.Flags().GetUint64("group")
+			filter := kvsraft.LogFilter{}
+			filter.From, _ = cmd.Flags().GetUint64("from")
+			filter.To, _ = cmd.Flags().GetUint64("to")
+			filter.Key, _ = cmd.Flags().GetString("key")
+			err := kvsraft.ReadLog(kvsraft.GroupDir(args[0], group), filter, func(entry kvsraft.LogEntry) error {
+				fmt.Printf("index=%d term=%d type=%s appended=%s %s\n", entry.Index, entry.Term, entry.Type,
+					formatTime(entry.AppendedAt), entry.Command)
+				return nil
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cc.Flags().Uint64("from", 0, "First index to list")
+	cc.Flags().Uint64("to", 0, "Last index to list, 0 lists to the end of the log")
+	cc.Flags().String("key", "", "Only list entries that write the key")
+	return cc
+}
+
+func NewDebugSnapshotCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "snapshot <data-dir>",
+		Short: "Lists snapshots, with --verify checks their checksums and counts the records",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			group, _ := cmd.Flags().GetUint64("group")
+			verify, _ := cmd.Flags().GetBool("verify")
+			infos, err := kvsraft.ReadSnapshots(kvsraft.GroupDir(args[0], group), verify)
+			if err != nil {
+				log.Fatal(err)
+			}
+			failed := 0
+			for _, info := range infos {
+				fmt.Printf("id=%s index=%d term=%d size=%d config-index=%d servers=%d\n",
+					info.ID, info.Index, info.Term, info.Size, info.ConfigurationIndex, len(info.Configuration.Servers))
+				if !verify {
+					continue
+				}
+				if info.Err != nil {
+					failed++
+					fmt.Printf("  verify=failed err=%v\n", info.Err)
+					continue
+				}
+				fmt.Printf("  verify=ok keys=%d members=%d sessions=%d\n", info.Keys, info.Members, info.Sessions)
+				if info.Range != nil {
+					fmt.Printf("  range id=%d start=%q end=%q generation=%d state=%s\n",
+						info.Range.ID, info.Range.Start, info.Range.End, info.Range.Generation, info.Range.State())
+				}
+				for _, desc := range info.Ranges {
+					fmt.Printf("  table id=%d start=%q end=%q generation=%d state=%s\n",
+						desc.ID, desc.Start, desc.End, desc.Generation, desc.State())
+				}
+			}
+			if failed > 0 {
+				log.Fatalf("%d of %d snapshots failed verification", failed, len(infos))
+			}
+		},
+	}
+	cc.Flags().Bool("verify", false, "Read every snapshot, verify its checksums and count keys, members and sessions")
+	return cc
+}
+
+// formatTime 旧版本写入的日志没有追加时间
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
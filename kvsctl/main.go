@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	kvscli "github.com/huiming23344/kv-raft/client"
+	"github.com/huiming23344/kv-raft/config"
 	"github.com/spf13/cobra"
 	"log"
 )
@@ -10,7 +12,13 @@ import (
 func main() {
 	var rootCmd = &cobra.Command{Use: "kvsctl"}
 	rootCmd.PersistentFlags().StringP("address", "a", "127.0.0.1:2315", "Server address")
-	rootCmd.AddCommand(NewSetCommand(), NewGetCommand(), NewDeleteCommand(), NewMemberCommand())
+	rootCmd.PersistentFlags().Bool("tls", false, "Connect with TLS, implied by the other --tls-* flags")
+	rootCmd.PersistentFlags().String("tls-ca", "", "CA certificate to verify the server, defaults to the system CAs")
+	rootCmd.PersistentFlags().String("tls-cert", "", "Client certificate for servers that require one")
+	rootCmd.PersistentFlags().String("tls-key", "", "Private key of the client certificate")
+	rootCmd.PersistentFlags().String("tls-server-name", "", "Server name to verify, defaults to the host in --address")
+	rootCmd.AddCommand(NewSetCommand(), NewGetCommand(), NewDeleteCommand(), NewMemberCommand(), NewClusterCommand(), NewRangeCommand(), NewDebugCommand(),
+		NewRecoverCommand())
 	if err := rootCmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -21,13 +29,34 @@ func connectServer(cmd *cobra.Command) *kvscli.Client {
 	if err != nil {
 		log.Fatal(err)
 	}
-	client, err := kvscli.NewClient(addr)
+	tlsConfig, err := clientTLS(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client, err := kvscli.NewTLSClient(addr, tlsConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 	return client
 }
 
+// clientTLS 根据 --tls-* 参数生成 TLS 配置，没有指定时返回 nil 使用明文连接
+func clientTLS(cmd *cobra.Command) (*tls.Config, error) {
+	enabled, _ := cmd.Flags().GetBool("tls")
+	cfg := &config.TLS{}
+	cfg.CAFile, _ = cmd.Flags().GetString("tls-ca")
+	cfg.CertFile, _ = cmd.Flags().GetString("tls-cert")
+	cfg.KeyFile, _ = cmd.Flags().GetString("tls-key")
+	cfg.ServerName, _ = cmd.Flags().GetString("tls-server-name")
+	if !enabled && *cfg == (config.TLS{}) {
+		return nil, nil
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("--tls-cert and --tls-key must be set together")
+	}
+	return cfg.ClientConfig()
+}
+
 func NewSetCommand() *cobra.Command {
 	cc := &cobra.Command{
 		Use:   "SET",
@@ -50,13 +79,24 @@ func NewGetCommand() *cobra.Command {
 		Short: "Get the value of key",
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			rsp, err := connectServer(cmd).Get(args[0])
+			consistency, err := cmd.Flags().GetString("consistency")
+			if err != nil {
+				log.Fatal(err)
+			}
+			client := connectServer(cmd)
+			var rsp string
+			if consistency == "" {
+				rsp, err = client.Get(args[0])
+			} else {
+				rsp, err = client.GetWithConsistency(args[0], consistency)
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
 			fmt.Println(rsp)
 		},
 	}
+	cc.Flags().StringP("consistency", "c", "", "Read consistency: linearizable, lease or stale (default: server setting)")
 	return cc
 }
 
@@ -84,22 +124,32 @@ func NewMemberCommand() *cobra.Command {
 	mc.AddCommand(NewMemberAddCommand())
 	mc.AddCommand(NewMemberRemoveCommand())
 	mc.AddCommand(NewMemberListCommand())
+	mc.AddCommand(NewMemberTransferLeaderCommand())
+	mc.AddCommand(NewMemberDrainCommand())
+	mc.AddCommand(NewMemberPromoteCommand())
+	mc.AddCommand(NewMemberDemoteCommand())
+	mc.AddCommand(NewMemberHealthCommand())
 	return mc
 }
 
 func NewMemberAddCommand() *cobra.Command {
 	cc := &cobra.Command{
-		Use:   "add",
+		Use:   "add <id> <raft-addr> [client-addr]",
 		Short: "Adds a member into the cluster",
-		Args:  cobra.ExactArgs(2),
+		Args:  cobra.RangeArgs(2, 3),
 		Run: func(cmd *cobra.Command, args []string) {
-			rsp, err := connectServer(cmd).Member("add", args[0], args[1])
+			options := args[2:]
+			if learner, _ := cmd.Flags().GetBool("learner"); learner {
+				options = append(options, "learner")
+			}
+			rsp, err := connectServer(cmd).Member("add", args[0], args[1], options...)
 			if err != nil {
 				log.Fatal(err)
 			}
 			fmt.Println(rsp)
 		},
 	}
+	cc.Flags().Bool("learner", false, "add the member as a non-voter that does not count towards quorum")
 	return cc
 }
 
@@ -135,3 +185,87 @@ func NewMemberListCommand() *cobra.Command {
 	}
 	return cc
 }
+
+func NewMemberTransferLeaderCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "transfer-leader [id]",
+		Short: "Transfers leadership to the given member or the most up-to-date voter",
+		Args:  cobra.RangeArgs(0, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			id := ""
+			if len(args) > 0 {
+				id = args[0]
+			}
+			rsp, err := connectServer(cmd).Member("transfer-leader", id, "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewMemberDrainCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "drain <id>",
+		Short: "Moves leadership off a member before maintenance",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Member("drain", args[0], "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewMemberPromoteCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "promote <id>",
+		Short: "Promotes a non-voter to a voter once it has caught up",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Member("promote", args[0], "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewMemberDemoteCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "demote <id>",
+		Short: "Demotes a voter to a non-voter",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Member("demote", args[0], "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewMemberHealthCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "health",
+		Short: "Shows the health of the cluster and every member as tracked by the leader",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Member("health", "", "")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
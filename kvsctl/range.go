@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+func NewRangeCommand() *cobra.Command {
+	rc := &cobra.Command{
+		Use:   "range",
+		Short: "Range sharding related commands",
+	}
+	rc.AddCommand(NewRangeListCommand())
+	rc.AddCommand(NewRangeSplitCommand())
+	rc.AddCommand(NewRangeMergeCommand())
+	return rc
+}
+
+func NewRangeListCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "list",
+		Short: "Lists all ranges and the raft group serving each of them",
+		Args:  cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Range("list")
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewRangeSplitCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "split <id> [key]",
+		Short: "Splits a range at the given key, or at its median key when omitted",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Range("split", args...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
+
+func NewRangeMergeCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "merge <id>",
+		Short: "Merges a range with its right neighbour",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			rsp, err := connectServer(cmd).Range("merge", args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println(rsp)
+		},
+	}
+	return cc
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/config"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strings"
+)
+
+func NewRecoverCommand() *cobra.Command {
+	cc := &cobra.Command{
+		Use:   "recover",
+		Short: "Offline disaster recovery: rewrite the raft configuration of a stopped node to the surviving members",
+		Long: `Rewrites the raft configuration of every group on a stopped node after a majority of nodes is lost.
+The node's log is replayed on top of its latest snapshot and written out as a new snapshot whose
+configuration only holds the surviving members. Run it with the same --member list on every survivor,
+then start them again. Writes that only reached the lost nodes are gone.`,
+		Args: cobra.ExactArgs(0),
+		Run: func(cmd *cobra.Command, args []string) {
+			confPath, _ := cmd.Flags().GetString("conf")
+			cfg, err := config.LoadConfigFile(confPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts, err := kvsraft.RecoverOptionsFromConfig(cfg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			members, _ := cmd.Flags().GetStringSlice("member")
+			if len(members) > 0 {
+				if opts.Servers, err = parseMembers(members); err != nil {
+					log.Fatal(err)
+				}
+			}
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			yes, _ := cmd.Flags().GetBool("yes")
+
+			// 先只读检查，确认后再修改
+			opts.DryRun = true
+			reports, err := kvsraft.Recover(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			printRecoverReports(reports)
+			if dryRun {
+				return
+			}
+			if !yes && !confirm(fmt.Sprintf("Rewrite the raft configuration of %d groups in %s? Type 'yes' to continue: ",
+				len(reports), opts.DataDir)) {
+				log.Fatal("aborted")
+			}
+			opts.DryRun = false
+			reports, err = kvsraft.Recover(opts)
+			for _, report := range reports {
+				fmt.Printf("recovered group=%d\n", report.Group)
+				if len(report.Splits) > 0 {
+					fmt.Printf("  warning: the log split off ranges %v, start the node and check `range list`\n", report.Splits)
+				}
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	cc.Flags().StringP("conf", "c", "./app.yaml", "Config file of the stopped node")
+	cc.Flags().StringSlice("member", nil, "Surviving member as id=raft-address, repeat for each; defaults to this node only")
+	cc.Flags().Bool("dry-run", false, "Only print what would be recovered")
+	cc.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	return cc
+}
+
+// parseMembers 解析 id=raft-address 形式的成员，都作为 Voter
+func parseMembers(members []string) ([]raft.Server, error) {
+	servers := make([]raft.Server, 0, len(members))
+	for _, member := range members {
+		id, addr, ok := strings.Cut(member, "=")
+		if !ok {
+			return nil, fmt.Errorf("member %q is not id=raft-address", member)
+		}
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(id),
+			Address:  raft.ServerAddress(addr),
+		})
+	}
+	return servers, nil
+}
+
+func printRecoverReports(reports []kvsraft.RecoverReport) {
+	for _, report := range reports {
+		fmt.Println(report.String())
+		for _, server := range report.Configuration.Servers {
+			fmt.Printf("  current %s=%s(%s)\n", server.ID, server.Address, server.Suffrage)
+		}
+		for _, server := range report.Servers {
+			fmt.Printf("  new     %s=%s(%s)\n", server.ID, server.Address, server.Suffrage)
+		}
+		if removed := report.Removed(); len(removed) > 0 {
+			fmt.Printf("  removed %v\n", removed)
+		}
+	}
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(line) == "yes"
+}
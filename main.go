@@ -1,12 +1,37 @@
 package main
 
 import (
+	"context"
+	"github.com/huiming23344/kv-raft/config"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ltime | log.Ldate)
-	if err := NewKvsServer().Serve(); err != nil {
+	server := NewKvsServer()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve()
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
 		log.Fatal(err)
+	case sig := <-sigCh:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	timeout := time.Duration(config.GlobalConfig().Server.ShutdownTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("shutdown failed: %v", err)
 	}
+	log.Println("server stopped")
 }
@@ -5,19 +5,32 @@ import (
 	"io"
 )
 
+const (
+	// 缓冲区的初始大小，处理完大的请求后缩回该大小
+	initialBufferSize = 4 * 1024
+	// DefaultMaxFrameSize 默认的单个 Frame 最大字节数
+	DefaultMaxFrameSize = 64 * 1024 * 1024
+)
+
+// ErrFrameTooLarge Frame 声明的长度超过上限，或缓冲区已达上限仍不足以容纳一个完整的 Frame
+var ErrFrameTooLarge = errors.New("frame is larger than the max frame size")
+
 type Buffer struct {
 	reader io.Reader
 	buf    []byte
 	start  int
 	end    int
+	// 缓冲区的上限，即单个 Frame 的最大字节数
+	maxSize int
 }
 
 func newBuffer(reader io.Reader) Buffer {
 	return Buffer{
-		reader: reader,
-		buf:    make([]byte, 4*1024),
-		start:  0,
-		end:    0,
+		reader:  reader,
+		buf:     make([]byte, initialBufferSize),
+		start:   0,
+		end:     0,
+		maxSize: DefaultMaxFrameSize,
 	}
 }
 
@@ -26,19 +39,42 @@ func (b *Buffer) remaining() int {
 	return b.end - b.start
 }
 
-// 有效字节前移
-func (b *Buffer) grow() {
-	if b.start == 0 {
+// 有效字节前移，之后仍然没有空间时扩容为两倍，不超过 maxSize
+func (b *Buffer) grow() error {
+	if b.start != 0 {
+		copy(b.buf, b.buf[b.start:b.end])
+		b.end -= b.start
+		b.start = 0
+	}
+	if b.end < len(b.buf) {
+		return nil
+	}
+	if len(b.buf) >= b.maxSize {
+		return ErrFrameTooLarge
+	}
+	buf := make([]byte, min(2*len(b.buf), b.maxSize))
+	copy(buf, b.buf[:b.end])
+	b.buf = buf
+	return nil
+}
+
+// 处理完大的请求后，剩余的字节放得下时缩回初始大小，避免空闲连接长期占用内存
+func (b *Buffer) shrink() {
+	if len(b.buf) <= initialBufferSize || b.remaining() > initialBufferSize/2 {
 		return
 	}
-	copy(b.buf, b.buf[b.start:b.end])
-	b.end -= b.end - b.start
+	buf := make([]byte, initialBufferSize)
+	b.end = copy(buf, b.buf[b.start:b.end])
 	b.start = 0
+	b.buf = buf
 }
 
 // 从reader中读取字节，如果reader阻塞，发生阻塞
 func (b *Buffer) readFromReader() error {
-	b.grow()
+	b.shrink()
+	if err := b.grow(); err != nil {
+		return err
+	}
 	n, err := b.reader.Read(b.buf[b.end:])
 	if err != nil {
 		return err
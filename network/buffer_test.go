@@ -0,0 +1,92 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_BufferGrow(t *testing.T) {
+	Convey("test buffer keeps unread bytes after compaction", t, func() {
+		buffer := newBuffer(bytes.NewReader([]byte("+OK\r\n:1")))
+		So(buffer.readFromReader(), ShouldBeNil)
+		So(buffer.advance(5), ShouldBeNil)
+		buffer.grow()
+		So(string(buffer.chunk()), ShouldEqual, ":1")
+	})
+}
+
+// pipeConnection 返回读取 frames 的连接，frames 由另一个协程依次写入
+func pipeConnection(maxFrameSize int, frames ...string) (*Connection, net.Conn) {
+	server, client := net.Pipe()
+	go func() {
+		for _, frame := range frames {
+			if _, err := client.Write([]byte(frame)); err != nil {
+				return
+			}
+		}
+		_ = client.Close()
+	}()
+	conn := NewLimitedConnection(server, maxFrameSize)
+	return &conn, server
+}
+
+func Test_BufferLargeFrame(t *testing.T) {
+	Convey("test multi-megabyte bulk strings grow the buffer, which shrinks back afterwards", t, func() {
+		value := strings.Repeat("abcdefgh", 1024*1024)
+		conn, server := pipeConnection(DefaultMaxFrameSize,
+			fmt.Sprintf("*3\r\n$3\r\nSET\r\n$3\r\nbig\r\n$%d\r\n%s\r\n", len(value), value),
+			"*1\r\n$4\r\nPING\r\n")
+		defer server.Close()
+
+		frame, err := conn.ReadFrame()
+		So(err, ShouldBeNil)
+		args := frame.Value.([]*Frame)
+		So(args, ShouldHaveLength, 3)
+		So(string(args[2].Value.([]byte)), ShouldEqual, value)
+		So(len(conn.reader.buf), ShouldBeGreaterThan, len(value))
+
+		frame, err = conn.ReadFrame()
+		So(err, ShouldBeNil)
+		So(frame.Value.([]*Frame)[0].Value, ShouldResemble, []byte("PING"))
+		_, err = conn.ReadFrame()
+		So(err, ShouldEqual, io.EOF)
+		So(len(conn.reader.buf), ShouldEqual, initialBufferSize)
+	})
+
+	Convey("test frames larger than the max frame size are rejected", t, func() {
+		limit := 64 * 1024
+		value := strings.Repeat("v", limit)
+		conn, server := pipeConnection(limit, fmt.Sprintf("$%d\r\n%s\r\n", len(value)-16, value[:len(value)-16]),
+			fmt.Sprintf("$%d\r\n%s\r\n", len(value), value))
+		defer server.Close()
+
+		frame, err := conn.ReadFrame()
+		So(err, ShouldBeNil)
+		So(frame.Value, ShouldHaveLength, limit-16)
+		_, err = conn.ReadFrame()
+		So(err, ShouldEqual, ErrFrameTooLarge)
+		So(len(conn.reader.buf), ShouldBeLessThan, limit)
+	})
+
+	Convey("test declared lengths larger than the max frame size are rejected before the buffer grows", t, func() {
+		for _, data := range []string{
+			"$999999999\r\n",
+			"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$999999999\r\n",
+			"*999999999\r\n",
+			"%99999999\r\n",
+			"=999999999\r\n",
+		} {
+			conn, server := pipeConnection(DefaultMaxFrameSize, data)
+			_, err := conn.ReadFrame()
+			So(err, ShouldEqual, ErrFrameTooLarge)
+			So(len(conn.reader.buf), ShouldEqual, initialBufferSize)
+			server.Close()
+		}
+	})
+}
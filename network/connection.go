@@ -4,19 +4,30 @@ import (
 	"bufio"
 	"errors"
 	"net"
+	"time"
 )
 
 type Connection struct {
-	conn   *net.TCPConn
+	conn   net.Conn
 	reader Buffer
 	writer *bufio.Writer
+	// 回包使用的协议版本，RESP2 或 RESP3
+	protocol int
 }
 
-func NewConnection(conn *net.TCPConn) Connection {
+func NewConnection(conn net.Conn) Connection {
+	return NewLimitedConnection(conn, DefaultMaxFrameSize)
+}
+
+// NewLimitedConnection 读取的单个 Frame 不能超过 maxFrameSize 字节，超过时 ReadFrame 返回 ErrFrameTooLarge
+func NewLimitedConnection(conn net.Conn, maxFrameSize int) Connection {
+	reader := newBuffer(conn)
+	reader.maxSize = maxFrameSize
 	return Connection{
-		conn:   conn,
-		reader: newBuffer(conn),
-		writer: bufio.NewWriter(conn),
+		conn:     conn,
+		reader:   reader,
+		writer:   bufio.NewWriter(conn),
+		protocol: RESP2,
 	}
 }
 
@@ -24,6 +35,16 @@ func (c *Connection) RemoteAddr() string {
 	return c.conn.RemoteAddr().String()
 }
 
+// Protocol 返回回包使用的协议版本
+func (c *Connection) Protocol() int {
+	return c.protocol
+}
+
+// SetProtocol 切换回包使用的协议版本，RESP2 的连接上 RESP3 类型按 Frame.Encode 的规则降级
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol = protocol
+}
+
 func (c *Connection) ReadFrame() (*Frame, error) {
 	for {
 		// 1.当缓存区有足够正常的数据，则解析一个Frame返回
@@ -34,7 +55,7 @@ func (c *Connection) ReadFrame() (*Frame, error) {
 		if ok {
 			return frame, nil
 		}
-		// 2.读满缓存区
+		// 2.读满缓存区，缓存区已达上限时返回 ErrFrameTooLarge
 		err = c.reader.readFromReader()
 		if err != nil {
 			return nil, err
@@ -42,14 +63,49 @@ func (c *Connection) ReadFrame() (*Frame, error) {
 	}
 }
 
+// ReadFrames 阻塞直到读取到至少一个 Frame，然后不再等待网络，返回缓冲区中已经完整的
+// 至多 limit 个 Frame，用于一次处理客户端流水线发送的多个请求。
+// 之后的数据无效时先返回已解析的 Frame，错误由下一次读取返回
+func (c *Connection) ReadFrames(limit int) ([]*Frame, error) {
+	frame, err := c.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	frames := []*Frame{frame}
+	for len(frames) < limit {
+		frame, ok, err := c.parseFrame()
+		if err != nil || !ok {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
 func (c *Connection) parseFrame() (*Frame, bool, error) {
+	// 0.inline 命令按行解析，跳过其中的空行
+	for chunk := c.reader.chunk(); len(chunk) > 0 && isInline(chunk[0]); chunk = c.reader.chunk() {
+		frame, length, err := parseInline(chunk)
+		if err == Incomplete {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		_ = c.reader.advance(length)
+		if frame != nil {
+			return frame, true, nil
+		}
+	}
 	// 1.检查缓冲区数据能够读取一个Frame
 	cursor := newCursor(c.reader.chunk())
-	err := check(&cursor)
+	err := check(&cursor, c.reader.maxSize)
 	if err != nil {
 		if err == Incomplete {
 			// 缓冲区数据不够
 			return nil, false, nil
+		} else if err == ErrFrameTooLarge {
+			return nil, false, err
 		} else {
 			// 编码的Frame无效，终止连接
 			return nil, false, errors.New("frame is invalid")
@@ -70,12 +126,29 @@ func (c *Connection) parseFrame() (*Frame, bool, error) {
 
 // WriteFrame 写入一个Frame
 func (c *Connection) WriteFrame(frame *Frame) error {
-	frameBytes, err := frame.Bytes()
-	if err != nil {
-		return err
-	}
-	if _, err := c.writer.Write(frameBytes); err != nil {
-		return err
+	return c.WriteFrames([]*Frame{frame})
+}
+
+// WriteFrames 依次写入多个 Frame，全部写入缓冲区后只 Flush 一次
+func (c *Connection) WriteFrames(frames []*Frame) error {
+	for _, frame := range frames {
+		frameBytes, err := frame.Encode(c.protocol)
+		if err != nil {
+			return err
+		}
+		if _, err := c.writer.Write(frameBytes); err != nil {
+			return err
+		}
 	}
 	return c.writer.Flush()
 }
+
+// SetDeadline 设置之后读写的截止时间，零值表示不超时
+func (c *Connection) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Close 关闭底层的 TCP 连接
+func (c *Connection) Close() error {
+	return c.conn.Close()
+}
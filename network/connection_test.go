@@ -0,0 +1,82 @@
+package network
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ReadFrames(t *testing.T) {
+	Convey("test pipelined frames are read in one batch", t, func() {
+		conn, server := pipeConnection(DefaultMaxFrameSize,
+			"*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$1\r\na\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\n*2\r\n$3\r\nGET",
+			"\r\n$1\r\nc\r\n")
+		defer server.Close()
+
+		frames, err := conn.ReadFrames(2)
+		So(err, ShouldBeNil)
+		So(frames, ShouldHaveLength, 2)
+		So(frames[1].Value.([]*Frame)[1].Value, ShouldResemble, []byte("a"))
+		// 缓冲区中只有一个完整的 Frame，不等待后面不完整的数据
+		frames, err = conn.ReadFrames(16)
+		So(err, ShouldBeNil)
+		So(frames, ShouldHaveLength, 1)
+		frames, err = conn.ReadFrames(16)
+		So(err, ShouldBeNil)
+		So(frames, ShouldHaveLength, 1)
+		So(frames[0].Value.([]*Frame)[1].Value, ShouldResemble, []byte("c"))
+		_, err = conn.ReadFrames(16)
+		So(err, ShouldEqual, io.EOF)
+	})
+
+	Convey("test frames before invalid data are returned first", t, func() {
+		conn, server := pipeConnection(DefaultMaxFrameSize, "+OK\r\n*x\r\n")
+		defer server.Close()
+
+		frames, err := conn.ReadFrames(16)
+		So(err, ShouldBeNil)
+		So(frames, ShouldHaveLength, 1)
+		_, err = conn.ReadFrames(16)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_WriteFrames(t *testing.T) {
+	Convey("test responses of a batch are flushed together in order", t, func() {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConnection(server)
+		go func() {
+			_ = conn.WriteFrames([]*Frame{
+				{Ftype: Simple, Value: "OK"},
+				{Ftype: Bulk, Value: []byte("v")},
+				{Ftype: Null},
+			})
+			_ = server.Close()
+		}()
+		data, err := io.ReadAll(bufio.NewReader(client))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "+OK\r\n$1\r\nv\r\n$-1\r\n")
+	})
+
+	Convey("test responses are encoded with the protocol of the connection", t, func() {
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := NewConnection(server)
+		So(conn.Protocol(), ShouldEqual, RESP2)
+		conn.SetProtocol(RESP3)
+		go func() {
+			_ = conn.WriteFrames([]*Frame{
+				{Ftype: Null},
+				{Ftype: Boolean, Value: true},
+			})
+			_ = server.Close()
+		}()
+		data, err := io.ReadAll(bufio.NewReader(client))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "_\r\n#t\r\n")
+	})
+}
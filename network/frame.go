@@ -3,6 +3,8 @@ package network
 import (
 	"bytes"
 	"errors"
+	"math"
+	"math/big"
 	"strconv"
 )
 
@@ -15,28 +17,47 @@ const (
 	Bulk
 	Null
 	Array
+	// 以下是 RESP3 新增的类型
+	Map
+	Set
+	Double
+	Boolean
+	BigNumber
+	Verbatim
+	Push
+	Attribute
 )
 
+// 协议版本，连接默认使用 RESP2，客户端通过 HELLO 命令切换
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// 编码 Verbatim 时使用的格式前缀
+const verbatimFormat = "txt:"
+
 var Incomplete = errors.New("not enough data is available to parse a message")
 
+// Frame 一个 RESP 值，Bulk 的 Value 是 []byte，可以包含任意字节，
+// 编码时也接受 string；Simple 和 Error 的 Value 是 string。
+// RESP3 类型中 Map 和 Attribute 的 Value 是键值交替的 []*Frame，Set 和 Push 是 []*Frame，
+// Double 是 float64，Boolean 是 bool，BigNumber 是 *big.Int，
+// Verbatim 与 Bulk 相同，不包含格式前缀
 type Frame struct {
 	Ftype FrameType
 	Value interface{}
 }
 
-// 检查有足够正常的数据解析一个Frame
-func check(c *Cursor) error {
+// 检查有足够正常的数据解析一个Frame。声明的长度超过 maxSize 时立即返回 ErrFrameTooLarge，
+// 不必等数据到达、缓冲区扩容到上限
+func check(c *Cursor, maxSize int) error {
 	tb, err := getByte(c)
 	if err != nil {
 		return err
 	}
 	switch tb {
-	case '+':
-		_, err = getLine(c)
-		if err != nil {
-			return err
-		}
-	case '-':
+	case '+', '-', '_', ',', '#', '(':
 		_, err = getLine(c)
 		if err != nil {
 			return err
@@ -63,19 +84,42 @@ func check(c *Cursor) error {
 			if err != nil {
 				return err
 			}
+			if err := checkLength(c, num, 1, maxSize); err != nil {
+				return err
+			}
 			// skip that number of bytes + 2 (\r\n)
 			err = skip(c, num+2)
 			if err != nil {
 				return err
 			}
 		}
-	case '*':
+	case '=':
+		num, err := getDecimal(c)
+		if err != nil {
+			return err
+		}
+		if err := checkLength(c, num, 1, maxSize); err != nil {
+			return err
+		}
+		err = skip(c, num+2)
+		if err != nil {
+			return err
+		}
+	case '*', '~', '>', '%', '|':
 		num, err := getDecimal(c)
 		if err != nil {
 			return err
 		}
+		if tb == '%' || tb == '|' {
+			// Map 和 Attribute 的长度是键值对的个数
+			num *= 2
+		}
+		// 每个元素至少有 3 个字节，如 "_\r\n"
+		if err := checkLength(c, num, 3, maxSize); err != nil {
+			return err
+		}
 		for i := 0; i < num; i++ {
-			err = check(c)
+			err = check(c, maxSize)
 			if err != nil {
 				return err
 			}
@@ -86,6 +130,15 @@ func check(c *Cursor) error {
 	return nil
 }
 
+// checkLength 检查声明了 num 个单位、每个单位至少 unit 字节的数据，加上结尾的 \r\n，
+// 从当前位置算起是否超过 maxSize
+func checkLength(c *Cursor, num, unit, maxSize int) error {
+	if num > (maxSize-c.position()-2)/unit {
+		return ErrFrameTooLarge
+	}
+	return nil
+}
+
 // ParseRESP 解析一个Frame
 func ParseRESP(buf []byte) (*Frame, error) {
 	cur := newCursor(buf)
@@ -134,9 +187,9 @@ func parse(c *Cursor) (*Frame, error) {
 			return nil, err
 		}
 		if string(tb) == "-" {
-			if string(tb) == "-1" {
-				return nil, errors.New("protocol error; invalid frame format")
-
+			// skip '-1\r\n'
+			if err := skip(c, 4); err != nil {
+				return nil, err
 			}
 			frame := &Frame{
 				Ftype: Null,
@@ -163,33 +216,99 @@ func parse(c *Cursor) (*Frame, error) {
 			}
 			frame := &Frame{
 				Ftype: Bulk,
-				Value: string(data),
+				Value: data,
 			}
 			return frame, nil
 		}
 	case '*':
+		return parseAggregate(c, Array, 1)
+	case '~':
+		return parseAggregate(c, Set, 1)
+	case '>':
+		return parseAggregate(c, Push, 1)
+	case '%':
+		return parseAggregate(c, Map, 2)
+	case '|':
+		return parseAggregate(c, Attribute, 2)
+	case '_':
+		if _, err := getLine(c); err != nil {
+			return nil, err
+		}
+		return &Frame{Ftype: Null}, nil
+	case ',':
+		line, err := getLine(c)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.ParseFloat(string(line), 64)
+		if err != nil {
+			return nil, errors.New("protocol error; invalid double")
+		}
+		return &Frame{Ftype: Double, Value: value}, nil
+	case '#':
+		line, err := getLine(c)
+		if err != nil {
+			return nil, err
+		}
+		switch string(line) {
+		case "t":
+			return &Frame{Ftype: Boolean, Value: true}, nil
+		case "f":
+			return &Frame{Ftype: Boolean, Value: false}, nil
+		default:
+			return nil, errors.New("protocol error; invalid boolean")
+		}
+	case '(':
+		line, err := getLine(c)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := new(big.Int).SetString(string(line), 10)
+		if !ok {
+			return nil, errors.New("protocol error; invalid big number")
+		}
+		return &Frame{Ftype: BigNumber, Value: value}, nil
+	case '=':
 		length, err := getDecimal(c)
 		if err != nil {
 			return nil, err
 		}
-		tmpArr := make([]*Frame, 0)
-		for i := 0; i < length; i++ {
-			frame, err := parse(c)
-			if err != nil {
-				return nil, err
-			}
-			tmpArr = append(tmpArr, frame)
+		// 内容以三个字符的格式和 ':' 开头，例如 txt:
+		if length < len(verbatimFormat) || c.remaining() < length+2 {
+			return nil, errors.New("protocol error; invalid verbatim string")
 		}
-		frame := &Frame{
-			Ftype: Array,
-			Value: tmpArr,
+		data := make([]byte, length-len(verbatimFormat))
+		copy(data, c.chunk()[len(verbatimFormat):length])
+		if err := skip(c, length+2); err != nil {
+			return nil, err
 		}
-		return frame, nil
+		return &Frame{Ftype: Verbatim, Value: data}, nil
 	default:
 		return nil, errors.New("not implemented")
 	}
 }
 
+// parseAggregate 解析聚合类型的元素，Map 和 Attribute 每项包含键和值两个元素
+func parseAggregate(c *Cursor, ftype FrameType, width int) (*Frame, error) {
+	length, err := getDecimal(c)
+	if err != nil {
+		return nil, err
+	}
+	tmpArr := make([]*Frame, 0)
+	for i := 0; i < length*width; i++ {
+		frame, err := parse(c)
+		if err != nil {
+			return nil, err
+		}
+		tmpArr = append(tmpArr, frame)
+	}
+	frame := &Frame{
+		Ftype: ftype,
+		Value: tmpArr,
+	}
+	return frame, nil
+}
+
 func getByte(c *Cursor) (byte, error) {
 	if !c.hasRemaining() {
 		return 0, Incomplete
@@ -202,7 +321,7 @@ func getLine(c *Cursor) ([]byte, error) {
 	start := c.pos
 	end := len(c.buf)
 	for i := start; i < end; i++ {
-		if c.buf[i] == '\r' && c.buf[i+1] == '\n' {
+		if c.buf[i] == '\r' && i+1 < end && c.buf[i+1] == '\n' {
 			c.setPosition(i + 2)
 			// return the line
 			return c.buf[start:i], nil
@@ -239,47 +358,40 @@ func skip(c *Cursor, n int) error {
 	return nil
 }
 
+// Bytes 按 RESP2 编码
 func (f *Frame) Bytes() ([]byte, error) {
+	return f.Encode(RESP2)
+}
+
+// Encode 按协议版本编码。RESP2 的连接上 RESP3 类型降级：Map 和 Attribute 展开为键值交替的数组，
+// Set 和 Push 为数组，Double、BigNumber 和 Verbatim 为 Bulk，Boolean 为整数 1 或 0
+func (f *Frame) Encode(protocol int) ([]byte, error) {
 	var buf bytes.Buffer
-	switch f.Ftype {
-	case Array:
-		// Encode the frame type prefix. For an array, it is '*'.
-		buf.WriteByte('*')
-		value, ok := f.Value.([]*Frame)
-		if !ok {
-			return nil, errors.New("unknown value")
-		}
-		// Encode the length of the array
-		length := strconv.FormatInt(int64(len(value)), 10)
-		buf.WriteString(length + "\r\n")
-		// Iterate and encode each entry in the array.
-		for _, v := range value {
-			if err := v.writeString(&buf); err != nil {
-				return nil, err
-			}
-		}
-	default:
-		if err := f.writeString(&buf); err != nil {
-			return nil, err
-		}
+	if err := f.write(&buf, protocol, 0); err != nil {
+		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
-func (f *Frame) writeString(buf *bytes.Buffer) error {
+// write 编码一个 Frame，depth 是所在聚合类型的嵌套层数，最外层为 0
+func (f *Frame) write(buf *bytes.Buffer, protocol int, depth int) error {
+	resp3 := protocol >= RESP3
 	switch f.Ftype {
-	case Simple:
+	case Simple, Error:
 		value, ok := f.Value.(string)
 		if !ok {
 			return errors.New("unknown value")
 		}
-		buf.WriteString("+" + value + "\r\n")
-	case Error:
-		value, ok := f.Value.(string)
-		if !ok {
-			return errors.New("unknown value")
+		if depth > 1 && !resp3 {
+			// RESP2 中嵌套数组的元素除数组、整数和空值外都按 Bulk 编码
+			writeBulk(buf, []byte(value))
+			return nil
+		}
+		if f.Ftype == Simple {
+			buf.WriteString("+" + value + "\r\n")
+		} else {
+			buf.WriteString("-" + value + "\r\n")
 		}
-		buf.WriteString("-" + value + "\r\n")
 	case Integer:
 		value, ok := f.Value.(int)
 		if !ok {
@@ -288,29 +400,142 @@ func (f *Frame) writeString(buf *bytes.Buffer) error {
 		valstr := strconv.FormatInt(int64(value), 10)
 		buf.WriteString(":" + valstr + "\r\n")
 	case Null:
-		buf.WriteString("$-1\r\n")
+		if resp3 {
+			buf.WriteString("_\r\n")
+		} else {
+			buf.WriteString("$-1\r\n")
+		}
 	case Bulk:
-		value, ok := f.Value.(string)
+		value, ok := bulkBytes(f.Value)
 		if !ok {
 			return errors.New("unknown value")
 		}
-		blen := strconv.FormatInt(int64(len(value)), 10)
-		buf.WriteString("$" + blen + "\r\n" + value + "\r\n")
-	case Array:
+		writeBulk(buf, value)
+	case Array, Set, Push:
 		value, ok := f.Value.([]*Frame)
 		if !ok {
 			return errors.New("unknown value")
 		}
-		alen := strconv.FormatInt(int64(len(value)), 10)
-		buf.WriteString("*" + alen + "\r\n")
-		for _, frame := range value {
-			val, ok := frame.Value.(string)
-			if !ok {
-				return errors.New("unknown value")
-			}
-			alen := strconv.FormatInt(int64(len(val)), 10)
-			buf.WriteString("$" + alen + "\r\n" + val + "\r\n")
+		prefix := byte('*')
+		if resp3 && f.Ftype == Set {
+			prefix = '~'
+		} else if resp3 && f.Ftype == Push {
+			prefix = '>'
+		}
+		return writeAggregate(buf, prefix, len(value), value, protocol, depth+1)
+	case Map, Attribute:
+		value, ok := f.Value.([]*Frame)
+		if !ok || len(value)%2 != 0 {
+			return errors.New("unknown value")
+		}
+		if !resp3 {
+			return writeAggregate(buf, '*', len(value), value, protocol, depth+1)
+		}
+		prefix := byte('%')
+		if f.Ftype == Attribute {
+			prefix = '|'
+		}
+		return writeAggregate(buf, prefix, len(value)/2, value, protocol, depth+1)
+	case Double:
+		value, ok := f.Value.(float64)
+		if !ok {
+			return errors.New("unknown value")
 		}
+		if resp3 {
+			buf.WriteString("," + formatDouble(value) + "\r\n")
+		} else {
+			writeBulk(buf, []byte(formatDouble(value)))
+		}
+	case Boolean:
+		value, ok := f.Value.(bool)
+		if !ok {
+			return errors.New("unknown value")
+		}
+		switch {
+		case resp3 && value:
+			buf.WriteString("#t\r\n")
+		case resp3:
+			buf.WriteString("#f\r\n")
+		case value:
+			buf.WriteString(":1\r\n")
+		default:
+			buf.WriteString(":0\r\n")
+		}
+	case BigNumber:
+		value, ok := f.Value.(*big.Int)
+		if !ok || value == nil {
+			return errors.New("unknown value")
+		}
+		if resp3 {
+			buf.WriteString("(" + value.String() + "\r\n")
+		} else {
+			writeBulk(buf, []byte(value.String()))
+		}
+	case Verbatim:
+		value, ok := bulkBytes(f.Value)
+		if !ok {
+			return errors.New("unknown value")
+		}
+		if !resp3 {
+			writeBulk(buf, value)
+			return nil
+		}
+		buf.WriteByte('=')
+		buf.WriteString(strconv.Itoa(len(value) + len(verbatimFormat)))
+		buf.WriteString("\r\n")
+		buf.WriteString(verbatimFormat)
+		buf.Write(value)
+		buf.WriteString("\r\n")
+	default:
+		return errors.New("unknown frame type")
 	}
 	return nil
 }
+
+// writeAggregate 写入聚合类型的长度和元素，Map 的长度是键值对的个数
+func writeAggregate(buf *bytes.Buffer, prefix byte, length int, value []*Frame, protocol int, depth int) error {
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(length))
+	buf.WriteString("\r\n")
+	for _, frame := range value {
+		if err := frame.write(buf, protocol, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatDouble 按 RESP3 的格式输出浮点数，无穷大为 inf 和 -inf
+func formatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// bulkBytes 返回 Bulk 的内容，value 可以是 []byte 或 string
+func bulkBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	default:
+		return nil, false
+	}
+}
+
+// writeBulk 按长度编码，内容可以包含 '\r\n' 等任意字节
+func writeBulk(buf *bytes.Buffer, value []byte) {
+	buf.WriteByte('$')
+	buf.WriteString(strconv.Itoa(len(value)))
+	buf.WriteString("\r\n")
+	buf.Write(value)
+	buf.WriteString("\r\n")
+}
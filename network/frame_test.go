@@ -1,7 +1,11 @@
 package network
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -10,7 +14,7 @@ import (
 func Test_Simple(t *testing.T) {
 	Convey("test parse simple frame", t, func() {
 		cursor := newCursor([]byte("+OK\r\n"))
-		err := check(&cursor)
+		err := check(&cursor, DefaultMaxFrameSize)
 		So(err, ShouldBeNil)
 
 		cursor.setPosition(0)
@@ -24,7 +28,7 @@ func Test_Simple(t *testing.T) {
 func Test_Error(t *testing.T) {
 	Convey("test parse error frame", t, func() {
 		cursor := newCursor([]byte("-Error message\r\n"))
-		err := check(&cursor)
+		err := check(&cursor, DefaultMaxFrameSize)
 		So(err, ShouldBeNil)
 
 		cursor.setPosition(0)
@@ -38,7 +42,7 @@ func Test_Error(t *testing.T) {
 func Test_Integer(t *testing.T) {
 	Convey("test parse integer frame", t, func() {
 		cursor := newCursor([]byte(":1000\r\n"))
-		err := check(&cursor)
+		err := check(&cursor, DefaultMaxFrameSize)
 		So(err, ShouldBeNil)
 
 		cursor.setPosition(0)
@@ -52,21 +56,21 @@ func Test_Integer(t *testing.T) {
 func Test_Bulk(t *testing.T) {
 	Convey("test parse bulk frame", t, func() {
 		cursor := newCursor([]byte("$6\r\nfoobar\r\n"))
-		err := check(&cursor)
+		err := check(&cursor, DefaultMaxFrameSize)
 		So(err, ShouldBeNil)
 
 		cursor.setPosition(0)
 		frame, err := parse(&cursor)
 		So(err, ShouldBeNil)
 		So(frame, ShouldNotBeNil)
-		fmt.Printf("ftype = %d, value = %s\n", frame.Ftype, frame.Value.(string))
+		So(frame.Value, ShouldResemble, []byte("foobar"))
 	})
 }
 
 func Test_Array(t *testing.T) {
 	Convey("test parse array frame", t, func() {
 		cursor := newCursor([]byte("*3\r\n$3\r\nset\r\n$4\r\nname\r\n$4\r\nmars\r\n"))
-		err := check(&cursor)
+		err := check(&cursor, DefaultMaxFrameSize)
 		So(err, ShouldBeNil)
 
 		cursor.setPosition(0)
@@ -76,7 +80,125 @@ func Test_Array(t *testing.T) {
 
 		fmt.Printf("ftype = %d\n", frame.Ftype)
 		for _, frame := range frame.Value.([]*Frame) {
-			fmt.Printf("ftype = %d, value = %s\n", frame.Ftype, frame.Value.(string))
+			fmt.Printf("ftype = %d, value = %s\n", frame.Ftype, frame.Value.([]byte))
+		}
+	})
+}
+
+func Test_NestedArray(t *testing.T) {
+	Convey("test encode nested array frame", t, func() {
+		frame := &Frame{
+			Ftype: Array,
+			Value: []*Frame{
+				{Ftype: Array, Value: []*Frame{
+					{Ftype: Integer, Value: 0},
+					{Ftype: Integer, Value: 16383},
+					{Ftype: Array, Value: []*Frame{
+						{Ftype: Bulk, Value: []byte("127.0.0.1")},
+						{Ftype: Integer, Value: 6379},
+					}},
+				}},
+			},
+		}
+		buf, err := frame.Bytes()
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldEqual, "*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:6379\r\n")
+
+		decoded, err := ParseRESP(buf)
+		So(err, ShouldBeNil)
+		So(decoded, ShouldResemble, frame)
+	})
+}
+
+func Test_BinaryBulk(t *testing.T) {
+	Convey("test bulk frames round trip random binary payloads", t, func() {
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 100; i++ {
+			value := make([]byte, rnd.Intn(1024))
+			rnd.Read(value)
+			frame := &Frame{Ftype: Array, Value: []*Frame{
+				{Ftype: Bulk, Value: []byte("SET")},
+				{Ftype: Bulk, Value: []byte("key")},
+				{Ftype: Bulk, Value: value},
+			}}
+			buf, err := frame.Bytes()
+			So(err, ShouldBeNil)
+			decoded, err := ParseRESP(buf)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, frame)
+
+			parse, err := NewParse(decoded)
+			So(err, ShouldBeNil)
+			name, err := parse.NextString()
+			So(err, ShouldBeNil)
+			So(name, ShouldEqual, "SET")
+			_, _ = parse.NextString()
+			data, err := parse.NextBytes()
+			So(err, ShouldBeNil)
+			So(bytes.Equal(data, value), ShouldBeTrue)
 		}
 	})
 }
+
+func Test_RESP3(t *testing.T) {
+	Convey("test RESP3 frames round trip", t, func() {
+		huge, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+		frame := &Frame{Ftype: Map, Value: []*Frame{
+			{Ftype: Bulk, Value: []byte("set")},
+			{Ftype: Set, Value: []*Frame{{Ftype: Integer, Value: 1}, {Ftype: Simple, Value: "two"}}},
+			{Ftype: Simple, Value: "double"},
+			{Ftype: Double, Value: 1.5},
+			{Ftype: Simple, Value: "bool"},
+			{Ftype: Boolean, Value: false},
+			{Ftype: Simple, Value: "big"},
+			{Ftype: BigNumber, Value: huge},
+			{Ftype: Simple, Value: "text"},
+			{Ftype: Verbatim, Value: []byte("a\r\nb")},
+			{Ftype: Simple, Value: "null"},
+			{Ftype: Null},
+		}}
+		data, err := frame.Encode(RESP3)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldStartWith, "%6\r\n$3\r\nset\r\n~2\r\n:1\r\n+two\r\n+double\r\n,1.5\r\n")
+		So(string(data), ShouldContainSubstring, "#f\r\n")
+		So(string(data), ShouldContainSubstring, "=8\r\ntxt:a\r\nb\r\n")
+		So(string(data), ShouldEndWith, "+null\r\n_\r\n")
+
+		cursor := newCursor(data)
+		So(check(&cursor, DefaultMaxFrameSize), ShouldBeNil)
+		So(cursor.position(), ShouldEqual, len(data))
+		parsed, err := ParseRESP(data)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, frame)
+
+		// 推送消息和属性
+		for _, s := range []string{">2\r\n+message\r\n$2\r\nhi\r\n", "|1\r\n+ttl\r\n:3\r\n", ",-inf\r\n", "(-12\r\n"} {
+			parsed, err := ParseRESP([]byte(s))
+			So(err, ShouldBeNil)
+			data, err := parsed.Encode(RESP3)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, s)
+		}
+		_, err = ParseRESP([]byte("#x\r\n"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("test RESP3 frames are downgraded for RESP2", t, func() {
+		frame := &Frame{Ftype: Map, Value: []*Frame{
+			{Ftype: Bulk, Value: "proto"},
+			{Ftype: Integer, Value: 2},
+			{Ftype: Bulk, Value: "flags"},
+			{Ftype: Set, Value: []*Frame{{Ftype: Boolean, Value: true}, {Ftype: Double, Value: math.Inf(1)}}},
+		}}
+		data, err := frame.Bytes()
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "*4\r\n$5\r\nproto\r\n:2\r\n$5\r\nflags\r\n*2\r\n:1\r\n$3\r\ninf\r\n")
+
+		data, err = (&Frame{Ftype: Array, Value: []*Frame{{Ftype: Null}, {Ftype: Simple, Value: "OK"}}}).Bytes()
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "*2\r\n$-1\r\n+OK\r\n")
+		parsed, err := ParseRESP(data)
+		So(err, ShouldBeNil)
+		So(parsed.Value.([]*Frame)[1].Value, ShouldEqual, "OK")
+	})
+}
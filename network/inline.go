@@ -0,0 +1,154 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrUnbalancedQuotes inline 命令的引号不匹配，之后的数据无法继续解析
+var ErrUnbalancedQuotes = errors.New("protocol error; unbalanced quotes in inline command")
+
+// isInline 不以 RESP 类型字节开头的请求是 telnet、nc 等手工输入的 inline 命令
+func isInline(b byte) bool {
+	switch b {
+	case '+', '-', ':', '$', '*', '_', ',', '#', '(', '=', '%', '|', '~', '>':
+		return false
+	default:
+		return true
+	}
+}
+
+// parseInline 解析一行 inline 命令，返回与 RESP 请求相同的由 Bulk 组成的 Array 和消耗的字节数。
+// 行以 '\n' 结尾，参数以空白分隔，可以用双引号或单引号包含空白，规则与 redis-cli 相同。
+// 空行返回 nil，由调用方跳过
+func parseInline(buf []byte) (*Frame, int, error) {
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		return nil, 0, Incomplete
+	}
+	args, err := splitArgs(buf[:end])
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(args) == 0 {
+		return nil, end + 1, nil
+	}
+	array := make([]*Frame, len(args))
+	for i, arg := range args {
+		array[i] = &Frame{
+			Ftype: Bulk,
+			Value: arg,
+		}
+	}
+	frame := &Frame{
+		Ftype: Array,
+		Value: array,
+	}
+	return frame, end + 1, nil
+}
+
+// splitArgs 按空白切分参数。双引号中支持 \n、\r、\t、\b、\a 和 \xHH 转义，
+// 单引号中只支持 \'；右引号之后必须是空白或行尾
+func splitArgs(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		arg := make([]byte, 0)
+		inDouble, inSingle := false, false
+		for done := false; !done; i++ {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			b := line[i]
+			switch {
+			case inDouble:
+				if b == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					arg = append(arg, hexValue(line[i+2])<<4|hexValue(line[i+3]))
+					i += 3
+				} else if b == '\\' && i+1 < len(line) {
+					i++
+					arg = append(arg, unescape(line[i]))
+				} else if b == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, b)
+				}
+			case inSingle:
+				if b == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if b == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, b)
+				}
+			case isSpace(b):
+				done = true
+			case b == '"':
+				inDouble = true
+			case b == '\'':
+				inSingle = true
+			default:
+				arg = append(arg, b)
+			}
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	default:
+		return false
+	}
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func hexValue(b byte) byte {
+	switch {
+	case b >= '0' && b <= '9':
+		return b - '0'
+	case b >= 'a' && b <= 'f':
+		return b - 'a' + 10
+	default:
+		return b - 'A' + 10
+	}
+}
+
+// unescape 双引号中 '\' 之后的字符，不认识的转义保留字符本身
+func unescape(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return b
+	}
+}
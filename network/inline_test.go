@@ -0,0 +1,60 @@
+package network
+
+import (
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func inlineArgs(frame *Frame) []string {
+	args := make([]string, 0)
+	for _, arg := range frame.Value.([]*Frame) {
+		args = append(args, string(arg.Value.([]byte)))
+	}
+	return args
+}
+
+func Test_Inline(t *testing.T) {
+	Convey("test inline commands are parsed into arrays of bulk strings", t, func() {
+		frame, n, err := parseInline([]byte("SET  name \"hello world\"\r\nGET name\r\n"))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 25)
+		So(frame.Ftype, ShouldEqual, Array)
+		So(inlineArgs(frame), ShouldResemble, []string{"SET", "name", "hello world"})
+
+		frame, _, err = parseInline([]byte(`SET k "a\tb\x00\"c" 'it\'s' ""` + "\n"))
+		So(err, ShouldBeNil)
+		So(inlineArgs(frame), ShouldResemble, []string{"SET", "k", "a\tb\x00\"c", "it's", ""})
+
+		frame, n, err = parseInline([]byte("  \r\n"))
+		So(err, ShouldBeNil)
+		So(frame, ShouldBeNil)
+		So(n, ShouldEqual, 4)
+
+		_, _, err = parseInline([]byte("GET name"))
+		So(err, ShouldEqual, Incomplete)
+		_, _, err = parseInline([]byte("SET k \"v\r\n"))
+		So(err, ShouldEqual, ErrUnbalancedQuotes)
+		_, _, err = parseInline([]byte("SET k \"v\"x\r\n"))
+		So(err, ShouldEqual, ErrUnbalancedQuotes)
+	})
+
+	Convey("test inline and RESP requests on the same connection", t, func() {
+		conn, server := pipeConnection(DefaultMaxFrameSize,
+			"\r\nGET a\r\n\r\n*2\r\n$3\r\nGET\r\n$1\r\nb\r\nDEL ",
+			"c\n")
+		defer server.Close()
+
+		frames, err := conn.ReadFrames(16)
+		So(err, ShouldBeNil)
+		So(frames, ShouldHaveLength, 2)
+		So(inlineArgs(frames[0]), ShouldResemble, []string{"GET", "a"})
+		So(inlineArgs(frames[1]), ShouldResemble, []string{"GET", "b"})
+		frame, err := conn.ReadFrame()
+		So(err, ShouldBeNil)
+		So(inlineArgs(frame), ShouldResemble, []string{"DEL", "c"})
+		_, err = conn.ReadFrame()
+		So(err, ShouldEqual, io.EOF)
+	})
+}
@@ -41,12 +41,60 @@ func (p *Parse) NextString() (string, error) {
 	case Simple:
 		return frame.Value.(string), nil
 	case Bulk:
-		return frame.Value.(string), nil
+		value, ok := bulkBytes(frame.Value)
+		if !ok {
+			return "", errors.New("protocol error; invalid bulk frame value")
+		}
+		return string(value), nil
 	default:
 		return "", errors.New(fmt.Sprintf("protocol error; expected simple frame or bulk frame, got %d", frame.Ftype))
 	}
 }
 
+// NextBytes returns the next bulk frame as raw bytes, used for binary values
+func (p *Parse) NextBytes() ([]byte, error) {
+	frame := p.next()
+	if frame == nil {
+		return nil, errors.New("end of frame")
+	}
+	switch frame.Ftype {
+	case Simple:
+		return []byte(frame.Value.(string)), nil
+	case Bulk:
+		value, ok := bulkBytes(frame.Value)
+		if !ok {
+			return nil, errors.New("protocol error; invalid bulk frame value")
+		}
+		return value, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("protocol error; expected simple frame or bulk frame, got %d", frame.Ftype))
+	}
+}
+
+// NextFrame returns the next frame as is, used for commands nested in another command
+func (p *Parse) NextFrame() (*Frame, error) {
+	frame := p.next()
+	if frame == nil {
+		return nil, errors.New("end of frame")
+	}
+	return frame, nil
+}
+
+// Rest returns the remaining frames as an array frame, used for commands wrapping another command
+func (p *Parse) Rest() *Frame {
+	rest := p.parts[p.index:]
+	p.index = len(p.parts)
+	return &Frame{
+		Ftype: Array,
+		Value: rest,
+	}
+}
+
+// HasNext reports whether there are frames left to parse
+func (p *Parse) HasNext() bool {
+	return p.index < len(p.parts)
+}
+
 // Finish ends a parse from frame
 func (p *Parse) Finish() error {
 	if p.next() == nil {
@@ -0,0 +1,299 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// autopilot 的默认参数
+const (
+	defaultAutopilotInterval    = time.Second
+	defaultLastContactThreshold = 5 * time.Second
+	defaultMaxTrailingLogs      = 250
+	defaultDeadServerThreshold  = 5 * time.Minute
+)
+
+// AutopilotOptions Leader 检查成员健康状况、清理失联节点的参数
+type AutopilotOptions struct {
+	// 是否删除失联超过 DeadServerThreshold 的节点
+	CleanupDeadServers bool
+	// 超过该时间没有联系上的节点视为不健康，为 0 时使用默认值
+	LastContactThreshold time.Duration
+	// 落后 Leader 超过该日志条数的节点视为不健康，为 0 时使用默认值
+	MaxTrailingLogs uint64
+	// 失联超过该时间的节点被删除，为 0 时使用默认值
+	DeadServerThreshold time.Duration
+	// 删除节点后至少保留的 Voter 数，不足时不删除
+	MinQuorum int
+	// 检查的间隔，为 0 时使用默认值
+	Interval time.Duration
+}
+
+func (o *AutopilotOptions) fillDefaults() {
+	if o.LastContactThreshold <= 0 {
+		o.LastContactThreshold = defaultLastContactThreshold
+	}
+	if o.MaxTrailingLogs == 0 {
+		o.MaxTrailingLogs = defaultMaxTrailingLogs
+	}
+	if o.DeadServerThreshold <= 0 {
+		o.DeadServerThreshold = defaultDeadServerThreshold
+	}
+	if o.Interval <= 0 {
+		o.Interval = defaultAutopilotInterval
+	}
+}
+
+// serverHealth Leader 记录的成员健康状况
+type serverHealth struct {
+	suffrage raft.ServerSuffrage
+	// Leader 最近一次与成员心跳成功的时间，心跳正常时为本轮检查的时间
+	lastContact time.Time
+	// 本轮查询到的日志位置，查询失败或超时为 -1
+	match   int64
+	lag     int64
+	healthy bool
+}
+
+// autopilot 在元数据组的 Leader 上跟踪成员的健康状况：最后联系时间来自 Leader 本地的心跳结果，
+// 日志位置每轮查询一次，查询不超过一个检查间隔。开启清理时删除失联太久的节点，
+// 成员的变化由 Store 同步到所有区间组
+type autopilot struct {
+	node *Node
+	opts AutopilotOptions
+
+	mutex   sync.Mutex
+	servers map[raft.ServerID]*serverHealth
+	// 心跳失败的成员及其最后一次心跳成功的时间，心跳恢复后删除
+	failing map[raft.ServerID]time.Time
+}
+
+func newAutopilot(node *Node, opts AutopilotOptions) *autopilot {
+	opts.fillDefaults()
+	return &autopilot{
+		node:    node,
+		opts:    opts,
+		servers: make(map[raft.ServerID]*serverHealth),
+		failing: make(map[raft.ServerID]time.Time),
+	}
+}
+
+func (a *autopilot) loop() {
+	observations := make(chan raft.Observation, 256)
+	observer := raft.NewObserver(observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.FailedHeartbeatObservation, raft.ResumedHeartbeatObservation:
+			return true
+		default:
+			return false
+		}
+	})
+	a.node.raft.RegisterObserver(observer)
+	go a.observe(observations)
+	defer func() {
+		// 注销之后 raft 不再发送，可以关闭
+		a.node.raft.DeregisterObserver(observer)
+		close(observations)
+	}()
+
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if a.node.raft.State() == raft.Shutdown {
+			return
+		}
+		if !a.node.isLeader() {
+			// 跟随者的记录不可信，重新成为 Leader 时从头开始
+			a.reset()
+			continue
+		}
+		a.update()
+		if a.opts.CleanupDeadServers {
+			a.pruneDeadServers()
+		}
+	}
+}
+
+// observe 记录 Leader 与各成员的心跳结果
+func (a *autopilot) observe(observations <-chan raft.Observation) {
+	for o := range observations {
+		a.mutex.Lock()
+		switch data := o.Data.(type) {
+		case raft.FailedHeartbeatObservation:
+			if !data.LastContact.IsZero() {
+				a.failing[data.PeerID] = data.LastContact
+			} else if _, ok := a.failing[data.PeerID]; !ok {
+				// 本任期内还没有联系上，从第一次失败开始计算
+				a.failing[data.PeerID] = time.Now()
+			}
+		case raft.ResumedHeartbeatObservation:
+			delete(a.failing, data.PeerID)
+		}
+		a.mutex.Unlock()
+	}
+}
+
+func (a *autopilot) reset() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.servers = make(map[raft.ServerID]*serverHealth)
+	a.failing = make(map[raft.ServerID]time.Time)
+}
+
+// update 查询所有成员的日志位置并更新健康状况。每次查询不超过一个检查间隔，
+// 不回复的成员本轮的日志位置未知，视为不健康，不会阻塞之后的检查和清理
+func (a *autopilot) update() {
+	configFuture := a.node.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return
+	}
+	servers := configFuture.Configuration().Servers
+	matches := make([]int64, len(servers))
+	timeout := min(progressTimeout, a.opts.Interval)
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, serverID raft.ServerID) {
+			defer wg.Done()
+			matches[i] = -1
+			if index, err := a.node.progress(serverID, timeout); err == nil {
+				matches[i] = int64(index)
+			}
+		}(i, s.ID)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	leaderIndex := int64(a.node.raft.LastIndex())
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	current := make(map[raft.ServerID]*serverHealth, len(servers))
+	for i, s := range servers {
+		health := &serverHealth{suffrage: s.Suffrage, lastContact: now, match: matches[i], lag: -1}
+		if since, ok := a.failing[s.ID]; ok {
+			health.lastContact = since
+		}
+		if matches[i] >= 0 {
+			health.lag = max(leaderIndex-matches[i], 0)
+		}
+		health.healthy = health.match >= 0 && now.Sub(health.lastContact) <= a.opts.LastContactThreshold &&
+			uint64(health.lag) <= a.opts.MaxTrailingLogs
+		current[s.ID] = health
+	}
+	a.servers = current
+	for id := range a.failing {
+		if _, ok := current[id]; !ok {
+			delete(a.failing, id)
+		}
+	}
+}
+
+// pruneDeadServers 每轮最多删除一个失联超过 DeadServerThreshold 的节点，
+// 删除 Voter 后剩余的 Voter 数不能少于 MinQuorum
+func (a *autopilot) pruneDeadServers() {
+	a.mutex.Lock()
+	voters := 0
+	var dead []raft.ServerID
+	for id, health := range a.servers {
+		if health.suffrage == raft.Voter {
+			voters++
+		}
+		if id != a.node.serverID && time.Since(health.lastContact) > a.opts.DeadServerThreshold {
+			dead = append(dead, id)
+		}
+	}
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i] < dead[j]
+	})
+	var target raft.ServerID
+	for _, id := range dead {
+		if a.servers[id].suffrage == raft.Voter && voters-1 < max(a.opts.MinQuorum, 1) {
+			continue
+		}
+		target = id
+		break
+	}
+	a.mutex.Unlock()
+	if target == "" {
+		return
+	}
+	log.Printf("autopilot: removing dead server %s", target)
+	if err := a.node.removeServer(target); err != nil {
+		log.Printf("autopilot: remove dead server %s failed: %v", target, err)
+	}
+}
+
+// health 返回 serverID 的健康状况，本节点不是 Leader 或还没有记录时返回 false
+func (a *autopilot) health(serverID raft.ServerID) (serverHealth, bool) {
+	if a == nil {
+		return serverHealth{}, false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	health, ok := a.servers[serverID]
+	if !ok || !a.node.isLeader() {
+		return serverHealth{}, false
+	}
+	return *health, true
+}
+
+// report 返回集群的整体健康状况和每个成员的记录。failure-tolerance 是在保持多数派的前提下
+// 还能失去的健康 Voter 数
+func (a *autopilot) report() *network.Frame {
+	if a == nil {
+		return errorFrame(fmt.Errorf("server health is only tracked by the meta group"))
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.servers) == 0 {
+		return errorFrame(fmt.Errorf("server health is not known yet"))
+	}
+	ids := make([]raft.ServerID, 0, len(a.servers))
+	voters, healthyVoters := 0, 0
+	for id, health := range a.servers {
+		ids = append(ids, id)
+		if health.suffrage == raft.Voter {
+			voters++
+			if health.healthy {
+				healthyVoters++
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	quorum := voters/2 + 1
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("healthy=%t failure-tolerance=%d voters=%d healthy-voters=%d\n",
+		healthyVoters >= quorum, max(healthyVoters-quorum, 0), voters, healthyVoters))
+	now := time.Now()
+	for _, id := range ids {
+		health := a.servers[id]
+		buf.WriteString(fmt.Sprintf("id=%s suffrage=%s healthy=%t last-contact=%s match=%s lag=%s\n",
+			id, health.suffrage, health.healthy, now.Sub(health.lastContact).Round(time.Millisecond),
+			formatIndex(health.match), formatIndex(health.lag)))
+	}
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: strings.TrimRight(buf.String(), "\n"),
+	}
+}
+
+// removeServer 在 Leader 上把节点移出集群，并删除它在元数据表中的登记
+func (r *Node) removeServer(serverID raft.ServerID) error {
+	if err := r.raft.RemoveServer(serverID, 0, membershipTimeout).Error(); err != nil {
+		return err
+	}
+	rspFrame := r.apply(cmd.NewMember(cmd.MemberRegister, string(serverID), ""))
+	if rspFrame.Ftype == network.Error {
+		return fmt.Errorf("%v", rspFrame.Value)
+	}
+	return nil
+}
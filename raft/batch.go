@@ -0,0 +1,213 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"sync"
+	"time"
+)
+
+// 提交日志时等待进入 raft 队列的最长时间
+const applyTimeout = 5 * time.Second
+
+// proposal 等待提交的写请求
+type proposal struct {
+	command cmd.Command
+	rsp     chan *network.Frame
+}
+
+// batcher 在 Leader 上把并发的写请求合并为一条 BATCH 日志提交，
+// 减少日志条目数、日志存储的写入和状态机的调用次数
+type batcher struct {
+	raft     *raft.Raft
+	maxSize  int
+	maxDelay time.Duration
+
+	mutex   sync.Mutex
+	pending []*proposal
+	stopped bool
+	// 有新的写请求，或待提交的写请求已达到 maxSize
+	wake chan struct{}
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newBatcher 每批最多合并 maxSize 个写请求。maxDelay 为 0 时只合并已经在排队的请求，
+// 不增加延迟；大于 0 时第一个请求到达后最多再等待 maxDelay 凑满一批
+func newBatcher(r *raft.Raft, maxSize int, maxDelay time.Duration) *batcher {
+	b := &batcher{
+		raft:     r,
+		maxSize:  maxSize,
+		maxDelay: maxDelay,
+		wake:     make(chan struct{}, 1),
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+// propose 提交写请求并等待状态机应用的结果
+func (b *batcher) propose(command cmd.Command) *network.Frame {
+	return <-b.enqueue(command)
+}
+
+// enqueue 把写请求加入待提交的队列后立即返回，结果从返回的 channel 中读取。
+// 依次加入的写请求按加入的顺序提交
+func (b *batcher) enqueue(command cmd.Command) <-chan *network.Frame {
+	p := &proposal{
+		command: command,
+		rsp:     make(chan *network.Frame, 1),
+	}
+	b.mutex.Lock()
+	if b.stopped {
+		b.mutex.Unlock()
+		p.rsp <- errorFrame(raft.ErrRaftShutdown)
+		return p.rsp
+	}
+	b.pending = append(b.pending, p)
+	full := len(b.pending) >= b.maxSize
+	b.mutex.Unlock()
+
+	notify(b.wake)
+	if full {
+		notify(b.full)
+	}
+	return p.rsp
+}
+
+// close 停止合并，尚未提交的写请求返回错误
+func (b *batcher) close() {
+	b.mutex.Lock()
+	if b.stopped {
+		b.mutex.Unlock()
+		return
+	}
+	b.stopped = true
+	b.mutex.Unlock()
+	close(b.stop)
+	<-b.done
+}
+
+func (b *batcher) loop() {
+	defer close(b.done)
+	for {
+		select {
+		case <-b.wake:
+		case <-b.stop:
+			b.fail()
+			return
+		}
+		if b.maxDelay > 0 && b.size() < b.maxSize {
+			// 等待更多的写请求加入本批
+			timer := time.NewTimer(b.maxDelay)
+			select {
+			case <-timer.C:
+			case <-b.full:
+				timer.Stop()
+			case <-b.stop:
+				timer.Stop()
+				b.fail()
+				return
+			}
+		}
+		// 提交期间到达的请求组成下一批
+		for batch := b.take(); len(batch) > 0; batch = b.take() {
+			b.submit(batch)
+		}
+	}
+}
+
+func (b *batcher) size() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.pending)
+}
+
+// take 取出最早的至多 maxSize 个写请求
+func (b *batcher) take() []*proposal {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := len(b.pending)
+	if n > b.maxSize {
+		n = b.maxSize
+	}
+	batch := b.pending[:n:n]
+	b.pending = b.pending[n:]
+	return batch
+}
+
+// submit 只有一个写请求时直接提交原始命令，否则提交 BATCH，
+// raft.Apply 在 raft 的队列满时阻塞，期间到达的请求会合并到下一批
+func (b *batcher) submit(batch []*proposal) {
+	command := batch[0].command
+	if len(batch) > 1 {
+		commands := make([]cmd.Command, len(batch))
+		for i, p := range batch {
+			commands[i] = p.command
+		}
+		command = cmd.NewBatch(commands...)
+	}
+	data, err := encodeCommand(command)
+	if err != nil {
+		respond(batch, errorFrame(err))
+		return
+	}
+	future := b.raft.Apply(data, applyTimeout)
+	go b.wait(future, batch)
+}
+
+// wait 等待日志被应用，把 BATCH 的响应数组按顺序分发给各个写请求。
+// raft 关闭时已提交但尚未交给状态机的日志不会再响应，因此同时等待 batcher 停止
+func (b *batcher) wait(future raft.ApplyFuture, batch []*proposal) {
+	applied := make(chan error, 1)
+	go func() { applied <- future.Error() }()
+	select {
+	case err := <-applied:
+		if err != nil {
+			respond(batch, errorFrame(err))
+			return
+		}
+	case <-b.stop:
+		respond(batch, errorFrame(raft.ErrRaftShutdown))
+		return
+	}
+	rsp := future.Response().(*network.Frame)
+	if len(batch) == 1 {
+		batch[0].rsp <- rsp
+		return
+	}
+	rsps, ok := rsp.Value.([]*network.Frame)
+	if rsp.Ftype != network.Array || !ok || len(rsps) != len(batch) {
+		// BATCH 整体执行失败
+		respond(batch, rsp)
+		return
+	}
+	for i, p := range batch {
+		p.rsp <- rsps[i]
+	}
+}
+
+func (b *batcher) fail() {
+	b.mutex.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mutex.Unlock()
+	respond(pending, errorFrame(raft.ErrRaftShutdown))
+}
+
+func respond(batch []*proposal, rsp *network.Frame) {
+	for _, p := range batch {
+		p.rsp <- rsp
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,171 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	kvscli "github.com/huiming23344/kv-raft/client"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"io"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type raftStore interface {
+	raft.LogStore
+	raft.StableStore
+}
+
+// newSingleNode 启动一个单节点集群，durable 为 true 时日志保存在 BoltDB 中，与实际部署的写入开销一致。
+// 旧版 boltdb 无法通过 -race 的指针检查，单元测试使用内存存储
+func newSingleNode(tb testing.TB, durable bool, maxBatchSize int, maxBatchDelay time.Duration) *Node {
+	dir := tb.TempDir()
+	engine, err := engines.NewKvsStore(dir)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var store raftStore = raft.NewInmemStore()
+	var closers []io.Closer
+	if durable {
+		boltStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-log.bolt"))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		store, closers = boltStore, []io.Closer{boltStore}
+	}
+	addr, transport := raft.NewInmemTransport("")
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.LogOutput = io.Discard
+	node, err := NewNode(NodeOptions{
+		ServerID:        "node0",
+		RaftAddr:        addr,
+		ClientAddr:      "127.0.0.1:0",
+		Engine:          engine,
+		Config:          conf,
+		LogStore:        store,
+		StableStore:     store,
+		Snapshots:       raft.NewInmemSnapshotStore(),
+		Transport:       transport,
+		Invoker:         kvscli.NewPool(1, nil),
+		ReadConsistency: cmd.ReadLinearizable,
+		ForwardMode:     ForwardProxy,
+		Bootstrap:       true,
+		Voter:           true,
+		MaxBatchSize:    maxBatchSize,
+		MaxBatchDelay:   maxBatchDelay,
+		Closers:         closers,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = node.Shutdown(false)
+		_ = engine.Close()
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for !node.isLeader() {
+		if time.Now().After(deadline) {
+			tb.Fatal("single node did not become leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return node
+}
+
+// quietLog SET 每次执行都会打印日志，测试期间关闭
+func quietLog(tb testing.TB) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() {
+		log.SetOutput(out)
+	})
+}
+
+func Test_BatchApply(t *testing.T) {
+	Convey("concurrent writes are coalesced and each writer gets its own response", t, func() {
+		quietLog(t)
+		node := newSingleNode(t, false, 16, time.Millisecond)
+		before := node.raft.LastIndex()
+
+		const writers = 64
+		rsps := make([]*network.Frame, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var command cmd.Command = cmd.NewSet(fmt.Sprintf("key%d", i), []byte(fmt.Sprint(i)))
+				if i%4 == 0 {
+					// 删除不存在的 key，响应与 SET 不同
+					command = cmd.NewDelete(fmt.Sprintf("missing%d", i))
+				}
+				rsps[i] = node.Execute(command, command.IntoFrame())
+			}(i)
+		}
+		wg.Wait()
+
+		for i, rsp := range rsps {
+			if i%4 == 0 {
+				So(rsp, ShouldResemble, &network.Frame{Ftype: network.Integer, Value: 0})
+			} else {
+				So(rsp, ShouldResemble, &network.Frame{Ftype: network.Simple, Value: "OK"})
+				value, err := node.engine.Get(fmt.Sprintf("key%d", i))
+				So(err, ShouldBeNil)
+				So(string(value), ShouldEqual, fmt.Sprint(i))
+			}
+		}
+		// 64 个写请求每批最多 16 个，至少节省一半的日志条目
+		So(node.raft.LastIndex()-before, ShouldBeLessThanOrEqualTo, writers/2)
+	})
+
+	Convey("writes after shutdown fail instead of blocking", t, func() {
+		node := newSingleNode(t, false, 16, 0)
+		So(node.Shutdown(false), ShouldBeNil)
+		set := cmd.NewSet("name", []byte("mars"))
+		So(node.apply(set).Ftype, ShouldEqual, network.Error)
+	})
+}
+
+// BenchmarkApply 对比合并与不合并时并发写入的吞吐量：
+// go test ./raft -run '^$' -bench Apply
+func BenchmarkApply(b *testing.B) {
+	for _, bc := range []struct {
+		name     string
+		maxSize  int
+		maxDelay time.Duration
+	}{
+		{"unbatched", 1, 0},
+		{"batched", 128, 0},
+		{"batched-delay-100us", 128, 100 * time.Microsecond},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			quietLog(b)
+			node := newSingleNode(b, true, bc.maxSize, bc.maxDelay)
+			var seq atomic.Int64
+			// 模拟 50 个并发客户端
+			b.SetParallelism(50)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					set := cmd.NewSet(fmt.Sprintf("key%d", seq.Add(1)), []byte("xxx"))
+					if rsp := node.Execute(set, set.IntoFrame()); rsp.Ftype != network.Simple {
+						b.Errorf("SET failed: %v", rsp.Value)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+		})
+	}
+}
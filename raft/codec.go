@@ -0,0 +1,467 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"time"
+)
+
+// raft 日志中命令的二进制编码，与客户端协议无关，协议变化不会影响日志回放：
+//
+//	entry   = magic version command
+//	command = op fields options
+//	SET      key value
+//	DEL      key
+//	REGISTER serverID address    （member register，节点元数据）
+//	BATCH    uvarint(n) command*n
+//	SESSION  id uvarint(seq) command  （客户端会话中的 SET、DEL）
+//	SPLIT    key uvarint(childID) uvarint(n) (suffrage id address)*n
+//	MERGE    desc uvarint(n) (key value)*n uvarint(m) (id uvarint(seq) varint(lastActive) rsp)*m
+//	MDATA    desc clear uvarint(n) (key value)*n  （合并时分批移交的数据）
+//	FREEZE   frozen
+//	UPDATE   desc
+//	ALLOC
+//	desc    = uvarint(id) start end uvarint(generation) flags
+//	options = uvarint(n) (tag bytes)*n
+//	bytes   = uvarint(len) data
+//
+// options 用于以后为命令增加的参数（例如 TTL、条件写），新增选项只需定义新的 tag，
+// 改变已有字段的含义时需要升级 version。旧节点无法识别时返回错误，因此升级 version
+// 前需要先升级所有节点。以 RESP 数组（'*'）开头的日志是旧格式，按 RESP 解析
+const (
+	logMagic   byte = 0xC7
+	logVersion byte = 1
+)
+
+const (
+	opSet byte = iota + 1
+	opDelete
+	opRegister
+	opBatch
+	opSession
+	opSplitRange
+	opMergeRange
+	opFreezeRange
+	opUpdateRange
+	opAllocRange
+	opMergeData
+)
+
+var errTruncated = errors.New("log entry truncated")
+
+// encodeCommand 将需要写入日志的命令编码为二进制
+func encodeCommand(command cmd.Command) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(logMagic)
+	buf.WriteByte(logVersion)
+	if err := writeCommand(&buf, command); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCommand(buf *bytes.Buffer, command cmd.Command) error {
+	switch c := command.(type) {
+	case *cmd.Set:
+		buf.WriteByte(opSet)
+		writeBytes(buf, c.Key())
+		writeBytes(buf, string(c.Value()))
+	case *cmd.Delete:
+		buf.WriteByte(opDelete)
+		writeBytes(buf, c.Key())
+	case *cmd.Member:
+		if c.Opt() != cmd.MemberRegister {
+			return fmt.Errorf("member %s is not a log command", c.Opt())
+		}
+		buf.WriteByte(opRegister)
+		writeBytes(buf, c.ServerID())
+		writeBytes(buf, c.Address())
+	case *cmd.Session:
+		buf.WriteByte(opSession)
+		writeBytes(buf, c.ID())
+		writeUvarint(buf, c.Seq())
+		if err := writeCommand(buf, c.Command()); err != nil {
+			return err
+		}
+	case *splitRange:
+		buf.WriteByte(opSplitRange)
+		writeBytes(buf, c.key)
+		writeUvarint(buf, c.childID)
+		writeUvarint(buf, uint64(len(c.servers)))
+		for _, server := range c.servers {
+			buf.WriteByte(byte(server.Suffrage))
+			writeBytes(buf, string(server.ID))
+			writeBytes(buf, string(server.Address))
+		}
+	case *mergeRange:
+		buf.WriteByte(opMergeRange)
+		writeDesc(buf, &c.right)
+		writePairs(buf, c.pairs)
+		writeUvarint(buf, uint64(len(c.sessions)))
+		for id, s := range c.sessions {
+			rsp, err := s.rsp.Bytes()
+			if err != nil {
+				return err
+			}
+			writeBytes(buf, id)
+			writeUvarint(buf, s.seq)
+			var lastActive int64
+			if !s.lastActive.IsZero() {
+				lastActive = s.lastActive.UnixNano()
+			}
+			var tmp [binary.MaxVarintLen64]byte
+			buf.Write(tmp[:binary.PutVarint(tmp[:], lastActive)])
+			writeBytes(buf, string(rsp))
+		}
+	case *mergeData:
+		buf.WriteByte(opMergeData)
+		writeDesc(buf, &c.right)
+		if c.clear {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		writePairs(buf, c.pairs)
+	case *freezeRange:
+		buf.WriteByte(opFreezeRange)
+		if c.frozen {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case *updateRange:
+		buf.WriteByte(opUpdateRange)
+		writeDesc(buf, &c.desc)
+	case *allocRange:
+		buf.WriteByte(opAllocRange)
+	case *cmd.Batch:
+		buf.WriteByte(opBatch)
+		writeUvarint(buf, uint64(len(c.Commands())))
+		for _, command := range c.Commands() {
+			if err := writeCommand(buf, command); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s is not a log command", command.Name())
+	}
+	// 目前没有命令使用选项
+	writeUvarint(buf, 0)
+	return nil
+}
+
+// decodeCommand 解码日志中的命令，兼容旧版本写入的 RESP 格式
+func decodeCommand(data []byte) (cmd.Command, error) {
+	if len(data) > 0 && data[0] == '*' {
+		frame, err := network.ParseRESP(data)
+		if err != nil {
+			return nil, err
+		}
+		return cmd.FromFrame(frame)
+	}
+	if len(data) < 2 || data[0] != logMagic {
+		return nil, errors.New("unknown log entry format")
+	}
+	if data[1] != logVersion {
+		return nil, fmt.Errorf("unsupported log entry version %d", data[1])
+	}
+	r := bytes.NewReader(data[2:])
+	command, err := readCommand(r, true)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing bytes after log command")
+	}
+	return command, nil
+}
+
+func readCommand(r *bytes.Reader, allowBatch bool) (cmd.Command, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return nil, errTruncated
+	}
+	var command cmd.Command
+	switch op {
+	case opSet:
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		command = cmd.NewSet(key, []byte(value))
+	case opDelete:
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		command = cmd.NewDelete(key)
+	case opRegister:
+		serverID, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		address, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		command = cmd.NewMember(cmd.MemberRegister, serverID, address)
+	case opSession:
+		id, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		seq, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errTruncated
+		}
+		inner, err := readCommand(r, false)
+		if err != nil {
+			return nil, err
+		}
+		if inner.Name() != cmd.SET && inner.Name() != cmd.DELETE {
+			return nil, fmt.Errorf("%s in session log command", inner.Name())
+		}
+		command = cmd.NewSession(id, seq, inner)
+	case opSplitRange:
+		split := &splitRange{}
+		if split.key, err = readBytes(r); err != nil {
+			return nil, err
+		}
+		if split.childID, err = binary.ReadUvarint(r); err != nil {
+			return nil, errTruncated
+		}
+		n, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			suffrage, err := r.ReadByte()
+			if err != nil {
+				return nil, errTruncated
+			}
+			id, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			address, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			split.servers = append(split.servers, raft.Server{
+				Suffrage: raft.ServerSuffrage(suffrage),
+				ID:       raft.ServerID(id),
+				Address:  raft.ServerAddress(address),
+			})
+		}
+		command = split
+	case opMergeRange:
+		merge := &mergeRange{sessions: make(map[string]*clientSession)}
+		right, err := readDesc(r)
+		if err != nil {
+			return nil, err
+		}
+		merge.right = *right
+		if merge.pairs, err = readPairs(r); err != nil {
+			return nil, err
+		}
+		n, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			id, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			s := &clientSession{}
+			if s.seq, err = binary.ReadUvarint(r); err != nil {
+				return nil, errTruncated
+			}
+			lastActive, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, errTruncated
+			}
+			if lastActive != 0 {
+				s.lastActive = time.Unix(0, lastActive)
+			}
+			rsp, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			if s.rsp, err = network.ParseRESP([]byte(rsp)); err != nil {
+				return nil, err
+			}
+			merge.sessions[id] = s
+		}
+		command = merge
+	case opMergeData:
+		data := &mergeData{}
+		right, err := readDesc(r)
+		if err != nil {
+			return nil, err
+		}
+		data.right = *right
+		clear, err := r.ReadByte()
+		if err != nil {
+			return nil, errTruncated
+		}
+		data.clear = clear == 1
+		if data.pairs, err = readPairs(r); err != nil {
+			return nil, err
+		}
+		command = data
+	case opFreezeRange:
+		frozen, err := r.ReadByte()
+		if err != nil {
+			return nil, errTruncated
+		}
+		command = &freezeRange{frozen: frozen == 1}
+	case opUpdateRange:
+		desc, err := readDesc(r)
+		if err != nil {
+			return nil, err
+		}
+		command = &updateRange{desc: *desc}
+	case opAllocRange:
+		command = &allocRange{}
+	case opBatch:
+		if !allowBatch {
+			return nil, errors.New("nested batch in log entry")
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errTruncated
+		}
+		// 每个命令至少占两个字节，避免按损坏的长度分配内存
+		if n == 0 || n > uint64(r.Len()/2) {
+			return nil, fmt.Errorf("invalid batch size %d", n)
+		}
+		commands := make([]cmd.Command, n)
+		for i := range commands {
+			if commands[i], err = readCommand(r, false); err != nil {
+				return nil, err
+			}
+		}
+		return cmd.NewBatch(commands...), nil
+	default:
+		return nil, fmt.Errorf("unknown log command op %d", op)
+	}
+	return command, readOptions(r)
+}
+
+// readOptions 当前版本没有定义选项，出现选项说明日志由更新的版本写入
+func readOptions(r *bytes.Reader) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return errTruncated
+	}
+	if n != 0 {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return errTruncated
+		}
+		return fmt.Errorf("unknown log command option %d", tag)
+	}
+	return nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func writeBytes(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// readCount 读取记录数，每条记录至少占一个字节，避免按损坏的长度分配内存
+func readCount(r *bytes.Reader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, errTruncated
+	}
+	if n > uint64(r.Len()) {
+		return 0, fmt.Errorf("invalid record count %d", n)
+	}
+	return n, nil
+}
+
+func writePairs(buf *bytes.Buffer, pairs []kvPair) {
+	writeUvarint(buf, uint64(len(pairs)))
+	for _, pair := range pairs {
+		writeBytes(buf, pair.key)
+		writeBytes(buf, pair.value)
+	}
+}
+
+func readPairs(r *bytes.Reader) ([]kvPair, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []kvPair
+	for i := uint64(0); i < n; i++ {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, kvPair{key, value})
+	}
+	return pairs, nil
+}
+
+func writeDesc(buf *bytes.Buffer, desc *RangeDescriptor) {
+	writeUvarint(buf, desc.ID)
+	writeBytes(buf, desc.Start)
+	writeBytes(buf, desc.End)
+	writeUvarint(buf, desc.Generation)
+	buf.WriteByte(descriptorFlags(desc))
+}
+
+func readDesc(r *bytes.Reader) (*RangeDescriptor, error) {
+	desc := &RangeDescriptor{}
+	var err error
+	if desc.ID, err = binary.ReadUvarint(r); err != nil {
+		return nil, errTruncated
+	}
+	if desc.Start, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if desc.End, err = readBytes(r); err != nil {
+		return nil, err
+	}
+	if desc.Generation, err = binary.ReadUvarint(r); err != nil {
+		return nil, errTruncated
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, errTruncated
+	}
+	setDescriptorFlags(desc, flags)
+	return desc, nil
+}
+
+func readBytes(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", errTruncated
+	}
+	data := make([]byte, n)
+	_, _ = r.Read(data)
+	return string(data), nil
+}
@@ -0,0 +1,115 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CommandEncoding(t *testing.T) {
+	Convey("test log commands encode and decode", t, func() {
+		commands := []cmd.Command{
+			cmd.NewSet("name", []byte("mars")),
+			cmd.NewSet("empty", []byte("")),
+			cmd.NewSet("binary", []byte{0, '}', '\r', '\n', 0xff}),
+			cmd.NewDelete("age"),
+			cmd.NewMember(cmd.MemberRegister, "node1", "127.0.0.1:2327"),
+			cmd.NewBatch(cmd.NewSet("name", []byte("mars")), cmd.NewDelete("age")),
+			cmd.NewSession("c1", 42, cmd.NewSet("name", []byte("mars"))),
+			cmd.NewBatch(cmd.NewSession("c1", 43, cmd.NewDelete("age")), cmd.NewSet("city", []byte("paris"))),
+		}
+		for _, command := range commands {
+			data, err := encodeCommand(command)
+			So(err, ShouldBeNil)
+			So(data[0], ShouldEqual, logMagic)
+			decoded, err := decodeCommand(data)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, command)
+		}
+
+		_, err := encodeCommand(cmd.NewGet("name"))
+		So(err, ShouldNotBeNil)
+		_, err = encodeCommand(cmd.NewMember(cmd.MemberAdd, "node1", "127.0.0.1:2328"))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("test range commands encode and decode", t, func() {
+		servers := []raft.Server{
+			{Suffrage: raft.Voter, ID: "node0", Address: "127.0.0.1:2328"},
+			{Suffrage: raft.Nonvoter, ID: "node1", Address: "127.0.0.1:2329"},
+		}
+		commands := []cmd.Command{
+			&splitRange{key: "m", childID: 3, servers: servers},
+			&mergeRange{
+				right: RangeDescriptor{ID: 3, Start: "m", Generation: 2, Frozen: true},
+				pairs: []kvPair{{"mango", "1"}, {"pear", ""}},
+				sessions: map[string]*clientSession{
+					"c1": {seq: 7, rsp: &network.Frame{Ftype: network.Simple, Value: "OK"}, lastActive: time.Unix(0, 42)},
+				},
+			},
+			&mergeData{
+				right: RangeDescriptor{ID: 3, Start: "m", Generation: 2, Frozen: true},
+				clear: true,
+				pairs: []kvPair{{"mango", "1"}},
+			},
+			&freezeRange{frozen: true},
+			&updateRange{desc: RangeDescriptor{ID: 3, Start: "m", End: "t", Generation: 5, Removed: true}},
+			&allocRange{},
+		}
+		for _, command := range commands {
+			data, err := encodeCommand(command)
+			So(err, ShouldBeNil)
+			decoded, err := decodeCommand(data)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, command)
+		}
+	})
+
+	Convey("test decode RESP entries written by older versions", t, func() {
+		for _, command := range []cmd.Command{
+			cmd.NewSet("name", []byte("mars")),
+			cmd.NewDelete("age"),
+			cmd.NewMember(cmd.MemberRegister, "node1", "127.0.0.1:2327"),
+			cmd.NewBatch(cmd.NewSet("name", []byte("mars")), cmd.NewDelete("age")),
+		} {
+			data, err := command.IntoFrame().Bytes()
+			So(err, ShouldBeNil)
+			decoded, err := decodeCommand(data)
+			So(err, ShouldBeNil)
+			So(decoded.IntoFrame(), ShouldResemble, command.IntoFrame())
+		}
+	})
+
+	Convey("test reject corrupted or newer entries", t, func() {
+		data, err := encodeCommand(cmd.NewSet("name", []byte("mars")))
+		So(err, ShouldBeNil)
+
+		newer := append([]byte(nil), data...)
+		newer[1] = logVersion + 1
+		_, err = decodeCommand(newer)
+		So(err, ShouldNotBeNil)
+
+		for i := 2; i < len(data); i++ {
+			_, err = decodeCommand(data[:i])
+			So(err, ShouldNotBeNil)
+		}
+
+		withOption := append(append([]byte(nil), data[:len(data)-1]...), 1, 1, 0)
+		_, err = decodeCommand(withOption)
+		So(err, ShouldNotBeNil)
+
+		_, err = decodeCommand(append(append([]byte(nil), data...), 0))
+		So(err, ShouldNotBeNil)
+
+		_, err = decodeCommand([]byte{logMagic, logVersion, opBatch, 1, opBatch, 1, opDelete, 1, 'k', 0})
+		So(err, ShouldNotBeNil)
+		_, err = decodeCommand([]byte{logMagic, logVersion, opSession, 1, 'c', 1, opSession, 1, 'c', 2, opDelete, 1, 'k', 0, 0, 0})
+		So(err, ShouldNotBeNil)
+		_, err = decodeCommand([]byte("garbage"))
+		So(err, ShouldNotBeNil)
+	})
+}
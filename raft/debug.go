@@ -0,0 +1,254 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 以下是离线排查用的接口，直接读取节点数据目录中的日志和快照，节点需要先停止
+
+// GroupDir 返回组的数据目录，元数据组使用 dataDir 本身，区间组在 ranges 子目录下
+func GroupDir(dataDir string, id uint64) string {
+	if id == 0 {
+		return dataDir
+	}
+	return filepath.Join(dataDir, "ranges", strconv.FormatUint(id, 10))
+}
+
+// LogEntry 日志中的一条记录
+type LogEntry struct {
+	Index      uint64
+	Term       uint64
+	Type       raft.LogType
+	AppendedAt time.Time
+	// 解码后的内容，解码失败时为错误信息
+	Command string
+	// 命令涉及的 key
+	Keys []string
+}
+
+// LogFilter 日志的过滤条件，To 为 0 表示到最后一条，Key 为空表示不按 key 过滤
+type LogFilter struct {
+	From uint64
+	To   uint64
+	Key  string
+}
+
+// ReadLog 以只读方式打开 dir 下的日志，按顺序把满足条件的记录交给 fn，fn 返回错误时停止
+func ReadLog(dir string, filter LogFilter, fn func(LogEntry) error) error {
+	store, err := openBoltStore(filepath.Join(dir, "raft-log.bolt"), true)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	first, err := store.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := store.LastIndex()
+	if err != nil {
+		return err
+	}
+	from, to := max(first, filter.From), last
+	if filter.To != 0 {
+		to = min(filter.To, last)
+	}
+	for index := from; index <= to && index != 0; index++ {
+		var log raft.Log
+		if err := store.GetLog(index, &log); err != nil {
+			return fmt.Errorf("read log %d: %w", index, err)
+		}
+		entry := describeLog(&log)
+		if filter.Key != "" && !containsKey(entry.Keys, filter.Key) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describeLog(log *raft.Log) LogEntry {
+	entry := LogEntry{
+		Index:      log.Index,
+		Term:       log.Term,
+		Type:       log.Type,
+		AppendedAt: log.AppendedAt,
+	}
+	switch log.Type {
+	case raft.LogCommand:
+		command, err := decodeCommand(log.Data)
+		if err != nil {
+			entry.Command = "<" + err.Error() + ">"
+			break
+		}
+		entry.Command = describeCommand(command)
+		entry.Keys = logKeys(command)
+	case raft.LogConfiguration:
+		var servers []string
+		for _, server := range raft.DecodeConfiguration(log.Data).Servers {
+			servers = append(servers, fmt.Sprintf("%s=%s(%s)", server.ID, server.Address, server.Suffrage))
+		}
+		entry.Command = strings.Join(servers, " ")
+	}
+	return entry
+}
+
+// describeCommand 返回日志命令的可读形式，客户端命令与 RESP 中的写法一致
+func describeCommand(command cmd.Command) string {
+	switch c := command.(type) {
+	case *cmd.Batch:
+		parts := make([]string, 0, len(c.Commands()))
+		for _, command := range c.Commands() {
+			parts = append(parts, describeCommand(command))
+		}
+		return fmt.Sprintf("BATCH %d [%s]", len(parts), strings.Join(parts, "; "))
+	case *cmd.Session:
+		return fmt.Sprintf("SESSION %s %d %s", c.ID(), c.Seq(), describeCommand(c.Command()))
+	case *splitRange:
+		return fmt.Sprintf("RANGE SPLIT key=%q child=%d servers=%d", c.key, c.childID, len(c.servers))
+	case *mergeRange:
+		return fmt.Sprintf("RANGE MERGE %s keys=%d sessions=%d", describeRange(&c.right), len(c.pairs), len(c.sessions))
+	case *mergeData:
+		return fmt.Sprintf("RANGE MERGE DATA %s clear=%t keys=%d", describeRange(&c.right), c.clear, len(c.pairs))
+	case *freezeRange:
+		return fmt.Sprintf("RANGE FREEZE frozen=%t", c.frozen)
+	case *updateRange:
+		return "RANGE UPDATE " + describeRange(&c.desc)
+	case *allocRange:
+		return "RANGE ALLOC"
+	}
+	args, _ := command.IntoFrame().Value.([]*network.Frame)
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		s := fmt.Sprint(arg.Value)
+		if value, ok := arg.Value.([]byte); ok {
+			s = string(value)
+		}
+		// 含空白、不可打印字符或非 UTF-8 字节的参数加引号
+		if s == "" || strings.IndexFunc(s, func(r rune) bool {
+			return !strconv.IsGraphic(r) || r == ' ' || r == utf8.RuneError
+		}) >= 0 {
+			s = strconv.Quote(s)
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+func describeRange(desc *RangeDescriptor) string {
+	return fmt.Sprintf("id=%d start=%q end=%q generation=%d state=%s",
+		desc.ID, desc.Start, desc.End, desc.Generation, desc.State())
+}
+
+// logKeys 返回日志命令写入的 key
+func logKeys(command cmd.Command) []string {
+	switch c := command.(type) {
+	case *cmd.Batch:
+		var keys []string
+		for _, command := range c.Commands() {
+			keys = append(keys, logKeys(command)...)
+		}
+		return keys
+	case *splitRange:
+		return []string{c.key}
+	case *mergeRange:
+		return pairKeys(c.pairs)
+	case *mergeData:
+		return pairKeys(c.pairs)
+	}
+	if key, ok := commandKey(command); ok {
+		return []string{key}
+	}
+	return nil
+}
+
+func pairKeys(pairs []kvPair) []string {
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		keys = append(keys, pair.key)
+	}
+	return keys
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// SnapshotInfo 快照的元数据，校验后还包含快照中各类记录的数量
+type SnapshotInfo struct {
+	raft.SnapshotMeta
+	// 以下字段只在校验时填充，Err 为校验失败的原因
+	Err      error
+	Keys     int
+	Members  int
+	Sessions int
+	// 本组的区间描述和元数据表，版本 4 之前的快照为空
+	Range  *RangeDescriptor
+	Ranges []RangeDescriptor
+}
+
+// ReadSnapshots 列出 dir 下的快照，新的在前。verify 为 true 时读取每个快照，校验文件和内容的 CRC
+func ReadSnapshots(dir string, verify bool) ([]SnapshotInfo, error) {
+	// 快照目录不存在时 NewFileSnapshotStore 会创建它
+	if _, err := os.Stat(filepath.Join(dir, "snapshots")); err != nil {
+		return nil, err
+	}
+	store, err := raft.NewFileSnapshotStore(dir, 1, io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapshotInfo, 0, len(metas))
+	for _, meta := range metas {
+		info := SnapshotInfo{SnapshotMeta: *meta}
+		if verify {
+			info.Err = verifySnapshot(store, &info)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func verifySnapshot(store *raft.FileSnapshotStore, info *SnapshotInfo) error {
+	// Open 会先校验整个文件的 CRC
+	_, source, err := store.Open(info.ID)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	state, err := readSnapshot(source)
+	if err != nil {
+		return err
+	}
+	info.Keys = len(state.pairs)
+	info.Members = len(state.members)
+	info.Sessions = len(state.sessions)
+	if state.ranges != nil {
+		info.Range = state.ranges.desc
+		info.Ranges = state.ranges.table
+		sort.Slice(info.Ranges, func(i, j int) bool {
+			return info.Ranges[i].ID < info.Ranges[j].ID
+		})
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/huiming23344/kv-raft/cmd"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ReadLog(t *testing.T) {
+	if raceEnabled {
+		t.Skip("boltdb/bolt does not pass checkptr")
+	}
+	Convey("test listing and filtering raft log entries offline", t, func() {
+		dir := t.TempDir()
+		store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-log.bolt"))
+		So(err, ShouldBeNil)
+		encode := func(command cmd.Command) []byte {
+			data, err := encodeCommand(command)
+			So(err, ShouldBeNil)
+			return data
+		}
+		configuration := raft.Configuration{Servers: []raft.Server{{Suffrage: raft.Voter, ID: "0", Address: "127.0.0.1:2318"}}}
+		So(store.StoreLogs([]*raft.Log{
+			{Index: 1, Term: 1, Type: raft.LogConfiguration, Data: raft.EncodeConfiguration(configuration)},
+			{Index: 2, Term: 2, Type: raft.LogNoop},
+			{Index: 3, Term: 2, Type: raft.LogCommand, Data: encode(cmd.NewSet("name", []byte("hello world")))},
+			{Index: 4, Term: 2, Type: raft.LogCommand, Data: encode(cmd.NewBatch(cmd.NewDelete("age"), cmd.NewSession("c1", 3, cmd.NewSet("name", []byte("v")))))},
+			{Index: 5, Term: 3, Type: raft.LogCommand, Data: encode(&splitRange{key: "m", childID: 1})},
+			{Index: 6, Term: 3, Type: raft.LogCommand, Data: []byte{0x01}},
+		}), ShouldBeNil)
+		So(store.Close(), ShouldBeNil)
+
+		var entries []LogEntry
+		collect := func(entry LogEntry) error {
+			entries = append(entries, entry)
+			return nil
+		}
+		So(ReadLog(dir, LogFilter{}, collect), ShouldBeNil)
+		So(entries, ShouldHaveLength, 6)
+		So(entries[0].Command, ShouldEqual, "0=127.0.0.1:2318(Voter)")
+		So(entries[1].Type, ShouldEqual, raft.LogNoop)
+		So(entries[2].Command, ShouldEqual, `SET name "hello world"`)
+		So(entries[3].Command, ShouldEqual, "BATCH 2 [DEL age; SESSION c1 3 SET name v]")
+		So(entries[4].Command, ShouldEqual, `RANGE SPLIT key="m" child=1 servers=0`)
+		So(entries[5].Command, ShouldEqual, "<unknown log entry format>")
+
+		entries = nil
+		So(ReadLog(dir, LogFilter{From: 3, To: 4, Key: "name"}, collect), ShouldBeNil)
+		So(entries, ShouldHaveLength, 2)
+		So(entries[0].Index, ShouldEqual, 3)
+		So(entries[1].Index, ShouldEqual, 4)
+
+		So(ReadLog(filepath.Join(dir, "missing"), LogFilter{}, collect), ShouldNotBeNil)
+	})
+}
+
+func Test_ReadSnapshots(t *testing.T) {
+	Convey("test listing and verifying snapshots offline", t, func() {
+		dir := t.TempDir()
+		_, err := ReadSnapshots(dir, true)
+		So(err, ShouldNotBeNil)
+
+		store, err := raft.NewFileSnapshotStore(dir, 2, io.Discard)
+		So(err, ShouldBeNil)
+		sink, err := store.Create(raft.SnapshotVersionMax, 10, 2, raft.Configuration{}, 1, nil)
+		So(err, ShouldBeNil)
+		So((&fsmSnapshot{
+			pairs:   []kvPair{{"age", "25"}, {"name", "mars"}},
+			members: []kvPair{{"0", "127.0.0.1:2317"}},
+			ranges: &rangeState{
+				desc:  &RangeDescriptor{ID: 0, End: "m", Generation: 1},
+				table: []RangeDescriptor{{ID: 1, Start: "m", Generation: 1}, {ID: 0, End: "m", Generation: 1}},
+			},
+		}).Persist(sink), ShouldBeNil)
+
+		infos, err := ReadSnapshots(dir, false)
+		So(err, ShouldBeNil)
+		So(infos, ShouldHaveLength, 1)
+		So(infos[0].Index, ShouldEqual, 10)
+		So(infos[0].Keys, ShouldEqual, 0)
+
+		infos, err = ReadSnapshots(dir, true)
+		So(err, ShouldBeNil)
+		So(infos[0].Err, ShouldBeNil)
+		So(infos[0].Keys, ShouldEqual, 2)
+		So(infos[0].Members, ShouldEqual, 1)
+		So(infos[0].Range, ShouldResemble, &RangeDescriptor{ID: 0, End: "m", Generation: 1})
+		So(infos[0].Ranges[1].ID, ShouldEqual, 1)
+
+		state := filepath.Join(dir, "snapshots", infos[0].ID, "state.bin")
+		data, err := os.ReadFile(state)
+		So(err, ShouldBeNil)
+		data[len(data)-1] ^= 0xff
+		So(os.WriteFile(state, data, 0600), ShouldBeNil)
+		infos, err = ReadSnapshots(dir, true)
+		So(err, ShouldBeNil)
+		So(infos[0].Err, ShouldNotBeNil)
+	})
+}
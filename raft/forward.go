@@ -0,0 +1,131 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	kvscli "github.com/huiming23344/kv-raft/client"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"log"
+	"net"
+	"time"
+)
+
+// 跟随者处理写请求和需要 Leader 参与的读请求的方式
+const (
+	// ForwardProxy 代理调用 Leader 并返回其响应
+	ForwardProxy = "proxy"
+	// ForwardRedirect 回复 -MOVED 错误，由客户端自行重定向到 Leader
+	ForwardRedirect = "redirect"
+)
+
+const (
+	// 检查元数据表中本节点登记信息的时间间隔
+	registerInterval = time.Second
+	// 代理客户端请求给 Leader 的超时时间，覆盖 Leader 提交日志或确认读的时间
+	forwardTimeout = applyTimeout + readTimeout
+)
+
+var (
+	errNoLeader       = errors.New("no leader elected")
+	errUnknownAddress = errors.New("leader client address is unknown")
+)
+
+// forward 按配置的方式把请求交给 Leader 处理
+func (r *Node) forward(frame *network.Frame) *network.Frame {
+	addr, err := r.leader()
+	if err != nil {
+		return errorFrame(err)
+	}
+	if r.forwardMode == ForwardRedirect {
+		// 未开启集群模式时槽位固定为 0
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: fmt.Sprintf("MOVED 0 %s", addr),
+		}
+	}
+	return r.proxyToLeader(addr, frame, forwardTimeout)
+}
+
+// toLeader 将必须由 Leader 执行的管理命令代理给 Leader，不受重定向模式影响。
+// member join 需要等待新节点追赶日志，使用客户端请求的默认超时时间
+func (r *Node) toLeader(frame *network.Frame) *network.Frame {
+	addr, err := r.leader()
+	if err != nil {
+		return errorFrame(err)
+	}
+	return r.proxyToLeader(addr, frame, kvscli.DefaultRequestTimeout)
+}
+
+func (r *Node) proxyToLeader(addr string, frame *network.Frame, timeout time.Duration) *network.Frame {
+	rspFrame, err := r.proxyInvoke(addr, frame, timeout)
+	if err != nil {
+		return errorFrame(err)
+	}
+	return rspFrame
+}
+
+// leader 从元数据表中查询 Leader 的客户端地址
+func (r *Node) leader() (string, error) {
+	_, serverID := r.raft.LeaderWithID()
+	if serverID == "" {
+		return "", errNoLeader
+	}
+	addr, ok := r.directory.member(serverID)
+	if !ok {
+		return "", errUnknownAddress
+	}
+	return addr, nil
+}
+
+func (r *Node) proxyInvoke(addr string, frame *network.Frame, timeout time.Duration) (*network.Frame, error) {
+	return r.pool.Invoke(addr, frame, timeout)
+}
+
+// registerLoop 保证元数据表中登记的是本节点当前的客户端地址。
+// Leader 直接提交日志，跟随者总是代理给 Leader，不受重定向模式影响
+func (r *Node) registerLoop() {
+	ticker := time.NewTicker(registerInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if r.raft.State() == raft.Shutdown {
+			return
+		}
+		if addr, ok := r.directory.member(r.serverID); ok && addr == r.clientAddr {
+			continue
+		}
+		register := cmd.NewMember(cmd.MemberRegister, string(r.serverID), r.clientAddr)
+		var rspFrame *network.Frame
+		if r.isLeader() {
+			rspFrame = r.apply(register)
+		} else if _, err := r.leader(); err == nil {
+			rspFrame = r.toLeader(register.IntoFrame())
+		} else {
+			continue
+		}
+		if rspFrame.Ftype == network.Error {
+			log.Printf("register client address %s failed: %v", r.clientAddr, rspFrame.Value)
+		}
+	}
+}
+
+// advertiseAddr 监听地址的 host 为空或为 0.0.0.0 时，使用 host 替换，
+// 得到其他节点可以访问的地址
+func advertiseAddr(listenAddr, host string) (string, error) {
+	listenHost, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(listenHost); listenHost != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listenAddr, nil
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func errorFrame(err error) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Error,
+		Value: err.Error(),
+	}
+}
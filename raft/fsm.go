@@ -1,48 +1,245 @@
 package raft
 
 import (
+	"fmt"
 	"github.com/hashicorp/raft"
 	"github.com/huiming23344/kv-raft/cmd"
 	dbs "github.com/huiming23344/kv-raft/db"
 	"github.com/huiming23344/kv-raft/network"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// 客户端会话默认的空闲过期时间
+const defaultSessionTTL = time.Hour
+
 type FSM struct {
 	db dbs.DB
+	// 节点元数据表，raft ServerID -> 客户端 RESP 地址，随日志和快照复制
+	members map[string]string
+	mutex   sync.RWMutex
+	// 最近一次应用的集群配置变更所在的日志位置
+	configIndex atomic.Uint64
+	// 客户端会话去重表，随快照复制。只在应用日志的协程中访问，不需要加锁
+	sessions map[string]*clientSession
+	// 会话空闲超过 sessionTTL 后过期，所有节点需要使用相同的值
+	sessionTTL time.Duration
+	// 下一次清理过期会话的时间，只影响内存占用，不影响去重的结果
+	nextSweep time.Time
+	// 本组负责的区间，为 nil 时表示尚未从快照中得知（刚创建的空组）
+	desc *RangeDescriptor
+	// 元数据组中的区间描述表和下一个可分配的区间 ID
+	ranges      map[uint64]RangeDescriptor
+	nextRangeID uint64
+	// 冻结时的会话副本，合并时移交给左侧区间
+	frozenSessions map[string]*clientSession
+	// 应用分裂日志时创建新的组，为 nil 时不支持分裂
+	host rangeHost
+	// 区间按槽位划分，区间的边界与 key 的路由键比较
+	slots bool
+	// 正在应用的日志位置，只在应用日志的协程中访问
+	index uint64
+}
+
+// clientSession 客户端会话最近一次写请求的序号和响应
+type clientSession struct {
+	seq uint64
+	rsp *network.Frame
+	// 最近一次写请求所在日志的追加时间，由 Leader 写入日志，各节点一致
+	lastActive time.Time
 }
 
-func NewFSM(db dbs.DB) raft.FSM {
+var _ raft.ConfigurationStore = (*FSM)(nil)
+
+// NewFSM 创建负责整个 key 空间的状态机，即未分片时的 0 号组
+func NewFSM(db dbs.DB) *FSM {
 	return &FSM{
-		db: db,
+		db:         db,
+		members:    make(map[string]string),
+		sessions:   make(map[string]*clientSession),
+		sessionTTL: defaultSessionTTL,
+		desc:       &RangeDescriptor{},
+		ranges:     make(map[uint64]RangeDescriptor),
 	}
 }
 
+// StoreConfiguration 在集群配置变更的日志提交后调用，记录配置所在的日志位置
+func (f *FSM) StoreConfiguration(index uint64, _ raft.Configuration) {
+	f.configIndex.Store(index)
+}
+
 func (f *FSM) Apply(logEntry *raft.Log) interface{} {
-	frame := new(network.Frame)
-	frame, err := network.ParseRESP(logEntry.Data)
+	f.index = logEntry.Index
+	command, err := decodeCommand(logEntry.Data)
 	if err != nil {
 		return &network.Frame{
 			Ftype: network.Error,
 			Value: err.Error(),
 		}
 	}
-	command, err := cmd.FromFrame(frame)
-	if err != nil {
+	if batch, ok := command.(*cmd.Batch); ok {
+		// 合并提交的写请求，按顺序返回每个命令的响应
+		rsps := make([]*network.Frame, len(batch.Commands()))
+		for i, command := range batch.Commands() {
+			rsps[i] = f.apply(command, logEntry.AppendedAt)
+		}
 		return &network.Frame{
-			Ftype: network.Error,
-			Value: err.Error(),
+			Ftype: network.Array,
+			Value: rsps,
+		}
+	}
+	return f.apply(command, logEntry.AppendedAt)
+}
+
+// apply 执行一个命令，appendedAt 为命令所在日志的追加时间
+func (f *FSM) apply(command cmd.Command, appendedAt time.Time) *network.Frame {
+	if key, ok := commandKey(command); ok {
+		// 区间分裂或合并后，发往旧区间的写请求不会被执行，由 Store 重新路由
+		if err := f.checkKey(key); err != nil {
+			return errorFrame(err)
+		}
+	}
+	switch c := command.(type) {
+	case *splitRange, *mergeRange, *mergeData, *freezeRange, *updateRange, *allocRange:
+		return f.applyRange(c)
+	case *cmd.Session:
+		return f.applySession(c, appendedAt)
+	case *cmd.Member:
+		if c.Opt() == cmd.MemberRegister {
+			f.register(c.ServerID(), c.Address())
+			return &network.Frame{
+				Ftype: network.Simple,
+				Value: "OK",
+			}
 		}
 	}
 	return command.Apply(f.db)
 }
 
+// applySession 同一会话的写请求按序号去重。客户端每个会话同时只有一个未完成的写请求，
+// 重试时使用相同的序号，因此只需记录最近一次的序号和响应
+func (f *FSM) applySession(c *cmd.Session, now time.Time) *network.Frame {
+	f.sweepSessions(now)
+	s, ok := f.sessions[c.ID()]
+	if ok && f.expired(s, now) {
+		delete(f.sessions, c.ID())
+		ok = false
+	}
+	if ok && c.Seq() == s.seq {
+		s.lastActive = now
+		return s.rsp
+	}
+	if ok && c.Seq() < s.seq {
+		return errorFrame(fmt.Errorf("session %s: sequence %d is older than the last applied %d", c.ID(), c.Seq(), s.seq))
+	}
+	rsp := f.apply(c.Command(), now)
+	f.sessions[c.ID()] = &clientSession{
+		seq:        c.Seq(),
+		rsp:        rsp,
+		lastActive: now,
+	}
+	return rsp
+}
+
+// expired 只依据日志中的时间判断，所有节点的结果一致。没有追加时间的旧日志不会使会话过期
+func (f *FSM) expired(s *clientSession, now time.Time) bool {
+	return !now.IsZero() && !s.lastActive.IsZero() && now.Sub(s.lastActive) > f.sessionTTL
+}
+
+// sweepSessions 定期删除过期的会话，释放内存
+func (f *FSM) sweepSessions(now time.Time) {
+	if now.Before(f.nextSweep) {
+		return
+	}
+	for id, s := range f.sessions {
+		if f.expired(s, now) {
+			delete(f.sessions, id)
+		}
+	}
+	f.nextSweep = now.Add(f.sessionTTL / 2)
+}
+
+// Snapshot 在 Apply 的协程中调用，此时没有并发写入，
+// 只取引擎的时间点视图，数据由 Persist 在后台从视图中逐条读出写入，不阻塞 Apply
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	// todo: create snapshot
-	return nil, nil
+	view, err := f.db.View()
+	if err != nil {
+		return nil, err
+	}
+	f.mutex.RLock()
+	ranges := &rangeState{
+		desc:        f.desc,
+		table:       make([]RangeDescriptor, 0, len(f.ranges)),
+		nextRangeID: f.nextRangeID,
+	}
+	for _, desc := range f.ranges {
+		ranges.table = append(ranges.table, desc)
+	}
+	f.mutex.RUnlock()
+	return &fsmSnapshot{
+		view:     view,
+		members:  f.memberPairs(),
+		sessions: f.copySessions(),
+		ranges:   ranges,
+	}, nil
 }
 
-func (f *FSM) Restore(io.ReadCloser) error {
-	// todo: restore from snapshot
+// Restore 先完整读取并校验快照，校验通过后再整体替换引擎中的数据
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+	state, err := readSnapshot(rc)
+	if err != nil {
+		return err
+	}
+	pairs := make(map[string][]byte, len(state.pairs))
+	for key, value := range state.pairs {
+		pairs[key] = []byte(value)
+	}
+	if err := f.db.Reset(pairs); err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	f.members = state.members
+	// 版本 4 之前的快照只来自未分片的 0 号组，保留默认的区间描述
+	if state.ranges != nil {
+		f.desc = state.ranges.desc
+		f.ranges = make(map[uint64]RangeDescriptor, len(state.ranges.table))
+		for _, desc := range state.ranges.table {
+			f.ranges[desc.ID] = desc
+		}
+		f.nextRangeID = state.ranges.nextRangeID
+	}
+	f.mutex.Unlock()
+	f.sessions = state.sessions
 	return nil
 }
+
+func (f *FSM) register(serverID, addr string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if addr == "" {
+		delete(f.members, serverID)
+	} else {
+		f.members[serverID] = addr
+	}
+}
+
+// member 查询节点的客户端地址
+func (f *FSM) member(serverID raft.ServerID) (string, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	addr, ok := f.members[string(serverID)]
+	return addr, ok
+}
+
+func (f *FSM) memberPairs() []kvPair {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	pairs := make([]kvPair, 0, len(f.members))
+	for id, addr := range f.members {
+		pairs = append(pairs, kvPair{id, addr})
+	}
+	return pairs
+}
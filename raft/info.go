@@ -0,0 +1,79 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info 按 Redis INFO 的格式返回节点状态，每行一个 key:value，以 # 开头的行是段名
+func (r *Node) Info(c *cmd.Info) *network.Frame {
+	var buf strings.Builder
+	switch strings.ToLower(c.Section()) {
+	case "", "all", cmd.InfoRaft:
+		r.writeRaftInfo(&buf)
+	}
+	return &network.Frame{
+		Ftype: network.Bulk,
+		Value: buf.String(),
+	}
+}
+
+// writeRaftInfo 写入 raft.Stats() 的内容和各成员的复制进度
+func (r *Node) writeRaftInfo(buf *strings.Builder) {
+	buf.WriteString("# Raft\r\n")
+	leaderAddr, leaderID := r.raft.LeaderWithID()
+	writeInfo(buf, "node_id", string(r.serverID))
+	writeInfo(buf, "raft_addr", string(r.raftAddr))
+	writeInfo(buf, "client_addr", r.clientAddr)
+	writeInfo(buf, "leader_id", string(leaderID))
+	writeInfo(buf, "leader_addr", string(leaderAddr))
+
+	stats := r.raft.Stats()
+	// 完整的配置由下面的 peer 行给出
+	delete(stats, "latest_configuration")
+	stats["latest_configuration_index"] = strconv.FormatUint(r.configurationIndex(), 10)
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeInfo(buf, key, stats[key])
+	}
+
+	members, err := r.members()
+	if err != nil {
+		return
+	}
+	for i, m := range members {
+		leader := 0
+		if m.isLeader {
+			leader = 1
+		}
+		writeInfo(buf, fmt.Sprintf("peer%d", i), fmt.Sprintf("id=%s,address=%s,client=%s,suffrage=%s,leader=%d,match=%s,lag=%s",
+			m.ID, m.Address, m.clientAddr, m.Suffrage, leader, formatIndex(m.match), formatIndex(m.lag)))
+	}
+}
+
+// configurationIndex 返回最新集群配置所在的日志位置。raft.Stats() 中的值始终为 0，
+// 这里使用 FSM 记录的配置变更，日志中没有配置变更时使用最新快照中的配置位置
+func (r *Node) configurationIndex() uint64 {
+	if index := r.fsm.configIndex.Load(); index > 0 {
+		return index
+	}
+	if snapshots, err := r.snapshots.List(); err == nil && len(snapshots) > 0 {
+		return snapshots[0].ConfigurationIndex
+	}
+	return 0
+}
+
+func writeInfo(buf *strings.Builder, key, value string) {
+	buf.WriteString(key)
+	buf.WriteByte(':')
+	buf.WriteString(value)
+	buf.WriteString("\r\n")
+}
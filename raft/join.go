@@ -0,0 +1,142 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	kvscli "github.com/huiming23344/kv-raft/client"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"log"
+	"time"
+)
+
+const (
+	// 等待新节点追上日志的最长时间
+	catchUpTimeout = 2 * time.Minute
+	// 查询新节点进度的时间间隔
+	catchUpInterval = 200 * time.Millisecond
+	// 加入集群失败后的重试间隔
+	joinRetryInterval = 2 * time.Second
+	// 提交成员变更的超时时间
+	membershipTimeout = 10 * time.Second
+	// 查询一个节点日志位置的超时时间，对端不响应时视为查询失败
+	progressTimeout = 2 * time.Second
+)
+
+// join 在 Leader 上先把新节点作为 Nonvoter 加入集群，voter 为 true 时等它追上日志后再提升为 Voter，
+// 避免新节点同步数据期间就计入多数派而阻塞写入
+func (r *Node) join(serverID raft.ServerID, addr raft.ServerAddress, clientAddr string, voter bool) error {
+	configFuture := r.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	for _, s := range configFuture.Configuration().Servers {
+		if s.ID != serverID {
+			continue
+		}
+		if s.Address != addr {
+			return fmt.Errorf("server %s already joined with address %s", serverID, s.Address)
+		}
+		if s.Suffrage == raft.Voter && !voter {
+			return fmt.Errorf("server %s is already a voter, demote it instead", serverID)
+		}
+		if s.Suffrage == raft.Voter || !voter {
+			// 重复加入
+			return nil
+		}
+	}
+	if clientAddr != "" {
+		rspFrame := r.apply(cmd.NewMember(cmd.MemberRegister, string(serverID), clientAddr))
+		if rspFrame.Ftype == network.Error {
+			return errors.New(rspFrame.Value.(string))
+		}
+	}
+	future := r.raft.AddNonvoter(serverID, addr, 0, membershipTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if !voter {
+		return nil
+	}
+	if err := r.waitCatchUp(serverID, future.Index()); err != nil {
+		return err
+	}
+	return r.raft.AddVoter(serverID, addr, 0, membershipTimeout).Error()
+}
+
+// waitCatchUp 轮询新节点最新的日志位置，直到它复制了 index 处的日志
+func (r *Node) waitCatchUp(serverID raft.ServerID, index uint64) error {
+	deadline := time.Now().Add(catchUpTimeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		// 每次查询不超过剩余的时间，对端不响应时不会阻塞在 Nonvoter 状态
+		if applied, err := r.progress(serverID, min(progressTimeout, remaining)); err == nil && applied >= index {
+			return nil
+		}
+		if !r.isLeader() {
+			return raft.ErrNotLeader
+		}
+		time.Sleep(catchUpInterval)
+	}
+	return fmt.Errorf("server %s did not catch up within %s", serverID, catchUpTimeout)
+}
+
+// progress 通过节点登记的客户端地址查询它最新的日志位置，超过 timeout 时返回错误
+func (r *Node) progress(serverID raft.ServerID, timeout time.Duration) (uint64, error) {
+	if serverID == r.serverID {
+		return r.raft.LastIndex(), nil
+	}
+	addr, ok := r.directory.member(serverID)
+	if !ok {
+		return 0, fmt.Errorf("client address of server %s is unknown", serverID)
+	}
+	rspFrame, err := r.proxyInvoke(addr, cmd.NewMember(cmd.MemberProgress, "", "").IntoFrame(), timeout)
+	if err != nil {
+		return 0, err
+	}
+	if rspFrame.Ftype != network.Integer {
+		return 0, fmt.Errorf("unexpected progress of server %s: %v", serverID, rspFrame.Value)
+	}
+	return uint64(rspFrame.Value.(int)), nil
+}
+
+// joinLoop 新节点通过任意一个已有成员加入集群，该成员会把请求转发给 Leader，
+// voter 为 false 时作为 learner 加入。重启的节点同样由 Leader 按它的集群配置确认：
+// 已经是成员时直接成功，离线期间被移除的节点本地配置已经过期，会重新加入
+func (r *Node) joinLoop(seeds []string, voter bool) {
+	options := []string{r.clientAddr}
+	if !voter {
+		options = append(options, cmd.MemberLearner)
+	}
+	frame := cmd.NewMember(cmd.MemberJoin, string(r.serverID), string(r.raftAddr), options...).IntoFrame()
+	for {
+		if r.isLeader() && r.inConfiguration() {
+			// 本节点就是 Leader，本地配置就是 Leader 的配置
+			return
+		}
+		for _, seed := range seeds {
+			rspFrame, err := r.proxyInvoke(seed, frame, kvscli.DefaultRequestTimeout)
+			if err == nil && rspFrame.Ftype == network.Error {
+				err = errors.New(rspFrame.Value.(string))
+			}
+			if err == nil {
+				log.Printf("joined the cluster through %s", seed)
+				return
+			}
+			log.Printf("join the cluster through %s failed: %v", seed, err)
+		}
+		time.Sleep(joinRetryInterval)
+		if r.raft.State() == raft.Shutdown {
+			return
+		}
+	}
+}
+
+func (r *Node) inConfiguration() bool {
+	_, err := r.server(r.serverID)
+	return err == nil
+}
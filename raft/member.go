@@ -0,0 +1,97 @@
+package raft
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"sync"
+)
+
+// memberInfo 集群成员及其日志复制进度，match、lag 为 -1 表示未知
+type memberInfo struct {
+	raft.Server
+	clientAddr string
+	isLeader   bool
+	match      int64
+	lag        int64
+}
+
+// members 返回集群配置中的所有成员，并发查询每个成员最新的日志位置，每次查询不超过
+// progressTimeout，卡住的成员不会阻塞整个命令。落后量以 Leader 的日志位置为基准
+func (r *Node) members() ([]memberInfo, error) {
+	configFuture := r.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return nil, err
+	}
+	servers := configFuture.Configuration().Servers
+	infos := make([]memberInfo, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		infos[i] = memberInfo{Server: s, isLeader: r.checkIsLeader(s.ID), match: -1, lag: -1}
+		infos[i].clientAddr, _ = r.directory.member(s.ID)
+		wg.Add(1)
+		go func(info *memberInfo) {
+			defer wg.Done()
+			if index, err := r.progress(info.ID, progressTimeout); err == nil {
+				info.match = int64(index)
+			}
+		}(&infos[i])
+	}
+	wg.Wait()
+
+	leaderIndex := int64(-1)
+	for _, info := range infos {
+		if info.isLeader {
+			leaderIndex = info.match
+		}
+	}
+	for i := range infos {
+		if leaderIndex >= 0 && infos[i].match >= 0 {
+			infos[i].lag = max(leaderIndex-infos[i].match, 0)
+		}
+	}
+	return infos, nil
+}
+
+// promote 等 Nonvoter 追上 Leader 当前的日志后将它提升为 Voter
+func (r *Node) promote(serverID raft.ServerID) error {
+	server, err := r.server(serverID)
+	if err != nil {
+		return err
+	}
+	if server.Suffrage == raft.Voter {
+		return nil
+	}
+	if err := r.waitCatchUp(serverID, r.raft.LastIndex()); err != nil {
+		return err
+	}
+	return r.raft.AddVoter(server.ID, server.Address, 0, membershipTimeout).Error()
+}
+
+// demote 将 Voter 降级为 Nonvoter，节点继续复制日志但不再参与选举和多数派
+func (r *Node) demote(serverID raft.ServerID) error {
+	server, err := r.server(serverID)
+	if err != nil {
+		return err
+	}
+	if server.Suffrage != raft.Voter {
+		return nil
+	}
+	voters := 0
+	for _, s := range r.raft.GetConfiguration().Configuration().Servers {
+		if s.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	if voters == 1 {
+		return fmt.Errorf("server %s is the last voter", serverID)
+	}
+	return r.raft.DemoteVoter(serverID, 0, membershipTimeout).Error()
+}
+
+// formatIndex 查询失败或超时的成员的日志位置为 unknown
+func formatIndex(index int64) string {
+	if index < 0 {
+		return "unknown"
+	}
+	return fmt.Sprint(index)
+}
@@ -0,0 +1,203 @@
+package raft
+
+import (
+	"encoding/binary"
+	dbs "github.com/huiming23344/kv-raft/db"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"strings"
+)
+
+// rangeKeyPrefix 区间组的数据在共享引擎中的 key 前缀，后接 8 字节大端序的组 ID。
+// 0 号组使用原始的 key，与未分片时写入的数据兼容，以该前缀开头的 key 保留给区间组使用
+const rangeKeyPrefix = "\x00range/"
+
+// sharedEngine 所有组共享同一个存储引擎，每个组只能看到自己的命名空间
+type sharedEngine struct {
+	db dbs.DB
+}
+
+func newSharedEngine(db dbs.DB) *sharedEngine {
+	return &sharedEngine{db: db}
+}
+
+func (e *sharedEngine) namespace(id uint64) *namespace {
+	prefix := ""
+	if id != 0 {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], id)
+		prefix = rangeKeyPrefix + string(buf[:])
+	}
+	return &namespace{engine: e, id: id, prefix: prefix}
+}
+
+// Scan 按组 ID 和原始的 key 遍历所有命名空间的数据
+func (e *sharedEngine) Scan(fn func(id uint64, key string, value []byte) bool) error {
+	return e.db.Scan(func(key string, value []byte) bool {
+		id, key := splitRangeKey(key)
+		return fn(id, key, value)
+	})
+}
+
+// splitRangeKey 从引擎中的 key 解析出组 ID 和原始的 key
+func splitRangeKey(key string) (uint64, string) {
+	if !strings.HasPrefix(key, rangeKeyPrefix) || len(key) < len(rangeKeyPrefix)+8 {
+		return 0, key
+	}
+	rest := key[len(rangeKeyPrefix):]
+	return binary.BigEndian.Uint64([]byte(rest[:8])), rest[8:]
+}
+
+// namespace 一个组在共享引擎中的数据，实现 dbs.DB
+type namespace struct {
+	engine *sharedEngine
+	id     uint64
+	prefix string
+}
+
+var _ dbs.DB = (*namespace)(nil)
+
+func (n *namespace) owns(key string) bool {
+	if n.id == 0 {
+		return !strings.HasPrefix(key, rangeKeyPrefix)
+	}
+	return strings.HasPrefix(key, n.prefix)
+}
+
+func (n *namespace) Set(key string, value []byte) error {
+	return n.engine.db.Set(n.prefix+key, value)
+}
+
+func (n *namespace) Get(key string) ([]byte, error) {
+	return n.engine.db.Get(n.prefix + key)
+}
+
+func (n *namespace) Remove(key string) error {
+	return n.engine.db.Remove(n.prefix + key)
+}
+
+func (n *namespace) Scan(fn func(key string, value []byte) bool) error {
+	return n.engine.db.Scan(func(key string, value []byte) bool {
+		if !n.owns(key) {
+			return true
+		}
+		return fn(key[len(n.prefix):], value)
+	})
+}
+
+// View 只包含本命名空间数据的视图，key 去掉了前缀
+func (n *namespace) View() (engines.View, error) {
+	view, err := n.engine.db.View()
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceView{View: view, namespace: n}, nil
+}
+
+type namespaceView struct {
+	engines.View
+	namespace *namespace
+}
+
+// Scan 只遍历引擎中属于本命名空间的 key 区间
+func (v *namespaceView) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	prefix := v.namespace.prefix
+	if v.namespace.id != 0 {
+		upper := prefixEnd(prefix)
+		if end != "" {
+			upper = prefix + end
+		}
+		return v.View.Scan(prefix+start, upper, func(key string, value []byte) bool {
+			return fn(key[len(prefix):], value)
+		})
+	}
+	// 0 号组跳过 rangeKeyPrefix 开头的 key，分为前后两段
+	stopped := false
+	scan := func(key string, value []byte) bool {
+		stopped = !fn(key, value)
+		return !stopped
+	}
+	if start < rangeKeyPrefix {
+		upper := rangeKeyPrefix
+		if end != "" && end < upper {
+			upper = end
+		}
+		if err := v.View.Scan(start, upper, scan); err != nil || stopped {
+			return err
+		}
+	}
+	lower := prefixEnd(rangeKeyPrefix)
+	if start > lower {
+		lower = start
+	}
+	if end != "" && end <= lower {
+		return nil
+	}
+	return v.View.Scan(lower, end, scan)
+}
+
+// prefixEnd 返回大于所有以 prefix 开头的 key 的最小 key，prefix 全为 0xff 时没有上界，返回空串
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// Reset 只替换本命名空间的数据，删除 pairs 中没有的 key 再写入 pairs，其他命名空间不受影响。
+// 替换不是原子的，只在 Restore 时调用，中途崩溃时重启后会重新从快照恢复
+func (n *namespace) Reset(pairs map[string][]byte) error {
+	view, err := n.View()
+	if err != nil {
+		return err
+	}
+	// 视图不受之后删除的影响，可以边遍历边删除
+	var removeErr error
+	err = view.Scan("", "", func(key string, _ []byte) bool {
+		if _, ok := pairs[key]; !ok {
+			removeErr = n.Remove(key)
+		}
+		return removeErr == nil
+	})
+	if closeErr := view.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if removeErr != nil {
+		return removeErr
+	}
+	for key, value := range pairs {
+		if err := n.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 共享的引擎由 Store 的使用者关闭
+func (n *namespace) Close() error {
+	return nil
+}
+
+// drop 删除命名空间中的所有数据，用于删除已合并的组
+func (n *namespace) drop() error {
+	var keys []string
+	err := n.Scan(func(key string, _ []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := n.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
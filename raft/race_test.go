@@ -0,0 +1,6 @@
+//go:build race
+
+package raft
+
+// boltdb/bolt 在 -race 开启的 checkptr 检查下会崩溃，使用 bolt 文件的测试需要跳过
+const raceEnabled = true
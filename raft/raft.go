@@ -2,61 +2,127 @@ package raft
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	kvscli "github.com/huiming23344/kv-raft/client"
 	"github.com/huiming23344/kv-raft/cmd"
 	kvscfg "github.com/huiming23344/kv-raft/config"
+	dbs "github.com/huiming23344/kv-raft/db"
 	engines2 "github.com/huiming23344/kv-raft/db/engines"
 	"github.com/huiming23344/kv-raft/network"
+	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// Invoker 向其他节点的客户端地址发送命令，默认为 RESP 连接池
+type Invoker interface {
+	// Invoke 发送 frame 并读取响应，超过 timeout 时返回错误
+	Invoke(addr string, frame *network.Frame, timeout time.Duration) (*network.Frame, error)
+	Close()
+}
+
 type Node struct {
 	raft     *raft.Raft
+	fsm      *FSM
 	serverID raft.ServerID
+	// 快照存储，用于查询最新快照中的集群配置
+	snapshots raft.SnapshotStore
+	// 关闭节点时需要释放的资源，例如 raft 日志和元数据的存储
+	closers []io.Closer
+	// 本节点对外公布的 raft 地址
+	raftAddr raft.ServerAddress
+	// 本节点对外公布的客户端 RESP 地址
+	clientAddr string
+	// 转发请求给 Leader 的方式，proxy 或 redirect
+	forwardMode string
+	// 转发请求、查询其他节点进度使用的客户端
+	pool Invoker
+	// 本地数据，用于服务读请求
+	engine engines2.KvsEngine
+	// 默认的读一致性级别
+	readConsistency string
+	// 每次角色变化加一，readyGen 等于它时表示 Leader 已在本任期提交过日志
+	leaderGen atomic.Uint64
+	readyGen  atomic.Uint64
+	// 合并并发的写请求，为 nil 时每个写请求单独提交
+	batcher *batcher
+	// 本节点负责的组 ID，0 号组同时是元数据组
+	group uint64
+	// 元数据组的状态机，用于查询节点的客户端地址
+	directory *FSM
+	// 本节点路由到该组的请求数，用于计算 QPS
+	requests atomic.Uint64
+	// 元数据组跟踪成员健康状况，区间组为 nil
+	autopilot *autopilot
 }
 
-func NewRaftNode(engine engines2.KvsEngine) (*Node, error) {
+// NewRaftStore 按全局配置创建本节点的所有 raft 组，engine 为所有组共享的存储引擎
+func NewRaftStore(engine dbs.DB) (*Store, error) {
 	cfg := kvscfg.GlobalConfig()
-	dataDir := fmt.Sprintf("./nodes/node0")
-	serverID := "0"
+	if !cmd.ValidReadConsistency(cfg.Server.ReadConsistency) {
+		return nil, fmt.Errorf("unknown read consistency %q", cfg.Server.ReadConsistency)
+	}
+	if cfg.Server.ForwardMode != ForwardProxy && cfg.Server.ForwardMode != ForwardRedirect {
+		return nil, fmt.Errorf("unknown forward mode %q", cfg.Server.ForwardMode)
+	}
+	dataDir := cfg.Raft.DataDir
+	serverID := cfg.Raft.NodeID
 
 	raftConfig := raft.DefaultConfig()
 	raftConfig.ProtocolVersion = raft.ProtocolVersionMax
-	raftConfig.LocalID = raft.ServerID(serverID)
-	leaderNotifyCh := make(chan bool, 1)
-	raftConfig.NotifyCh = leaderNotifyCh
+	if cfg.Raft.SnapshotThreshold > 0 {
+		raftConfig.SnapshotThreshold = cfg.Raft.SnapshotThreshold
+	}
+	if cfg.Raft.SnapshotInterval > 0 {
+		raftConfig.SnapshotInterval = time.Duration(cfg.Raft.SnapshotInterval) * time.Second
+	}
+	if cfg.Raft.TrailingLogs > 0 {
+		raftConfig.TrailingLogs = cfg.Raft.TrailingLogs
+	}
 
-	fsm := NewFSM(engine)
-	var raftAddr string
-	// init raft ip
-	if cfg.Raft.UseLoopBack {
-		raftAddr = fmt.Sprintf("127.0.0.1:%s", cfg.Raft.Port)
-	} else {
-		addrs, err := GetHostIPAddresses()
-		if err != nil {
+	raftHost, bindAddr, raftAddr, err := raftAddress(cfg)
+	if err != nil {
+		return nil, err
+	}
+	clientAddr := cfg.Server.AdvertiseAddr
+	if clientAddr == "" {
+		if clientAddr, err = advertiseAddr(cfg.Server.Addr, raftHost); err != nil {
 			return nil, err
 		}
-		addr := addrs[len(addrs)-1]
-		raftAddr = fmt.Sprintf("%s:%s", addr, cfg.Raft.Port)
 	}
-	transport, err := newRaftTransport(raftAddr)
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := checkNodeID(dataDir, serverID); err != nil {
+		return nil, err
+	}
+	stream, err := newStreamLayer(bindAddr, raftAddr, &cfg.Raft.TLS)
 	if err != nil {
 		return nil, err
 	}
-	if err := os.MkdirAll(dataDir, 0700); err != nil {
+	// 所有组共享同一个 raft 端口
+	mux := newMuxStreamLayer(stream)
+	// 转发请求时连接其他节点的客户端地址，使用 server.tls 的证书
+	var forwardTLS *tls.Config
+	if cfg.Server.TLS.Enabled() {
+		if forwardTLS, err = cfg.Server.TLS.ClientConfig(); err != nil {
+			return nil, err
+		}
+	}
+	snapshotStore, err := raft.NewFileSnapshotStore(dataDir, cfg.Raft.SnapshotRetain, os.Stderr)
+	if err != nil {
 		return nil, err
 	}
-	// 忽略快照
-	snapshotStore := raft.NewDiscardSnapshotStore()
 	logStore, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft-log.bolt"))
 	if err != nil {
 		return nil, err
@@ -65,71 +131,309 @@ func NewRaftNode(engine engines2.KvsEngine) (*Node, error) {
 	if err != nil {
 		return nil, err
 	}
-	raftNode, err := raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshotStore, transport)
+	groups, err := localGroups(filepath.Join(dataDir, "ranges"))
 	if err != nil {
 		return nil, err
 	}
-	if cfg.Raft.Bootstrap {
-		nodeBootstrap(raftNode, cfg.Raft.Voter, serverID, raftAddr)
+	meta := NodeOptions{
+		ServerID:        raft.ServerID(serverID),
+		RaftAddr:        raft.ServerAddress(raftAddr),
+		ClientAddr:      clientAddr,
+		Config:          raftConfig,
+		LogStore:        logStore,
+		StableStore:     stableStore,
+		Snapshots:       snapshotStore,
+		Transport:       raft.NewNetworkTransport(mux.group(0), 3, 10*time.Second, os.Stderr),
+		Invoker:         kvscli.NewPool(cfg.Server.ForwardPoolSize, forwardTLS),
+		ReadConsistency: cfg.Server.ReadConsistency,
+		ForwardMode:     cfg.Server.ForwardMode,
+		Bootstrap:       cfg.Raft.Bootstrap,
+		Voter:           cfg.Raft.Voter,
+		Join:            cfg.Raft.Join,
+		SessionTTL:      time.Duration(cfg.Raft.SessionTTL) * time.Second,
+		MaxBatchSize:    cfg.Raft.MaxBatchSize,
+		MaxBatchDelay:   time.Duration(cfg.Raft.MaxBatchDelay) * time.Microsecond,
+		Autopilot: AutopilotOptions{
+			CleanupDeadServers:   cfg.Raft.Autopilot.CleanupDeadServers,
+			LastContactThreshold: time.Duration(cfg.Raft.Autopilot.LastContactThreshold) * time.Second,
+			MaxTrailingLogs:      cfg.Raft.Autopilot.MaxTrailingLogs,
+			DeadServerThreshold:  time.Duration(cfg.Raft.Autopilot.DeadServerThreshold) * time.Second,
+			MinQuorum:            cfg.Raft.Autopilot.MinQuorum,
+		},
+		Closers: []io.Closer{logStore, stableStore},
 	}
-	return &Node{
-		raft:     raftNode,
-		serverID: raft.ServerID(serverID),
-	}, nil
+	return NewStore(engine, meta, StoreOptions{
+		OpenGroup: func(id uint64) (GroupStorage, error) {
+			dir := GroupDir(dataDir, id)
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				return GroupStorage{}, err
+			}
+			snapshots, err := raft.NewFileSnapshotStore(dir, cfg.Raft.SnapshotRetain, os.Stderr)
+			if err != nil {
+				return GroupStorage{}, err
+			}
+			logStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-log.bolt"))
+			if err != nil {
+				return GroupStorage{}, err
+			}
+			stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-stable.bolt"))
+			if err != nil {
+				_ = logStore.Close()
+				return GroupStorage{}, err
+			}
+			transport := raft.NewNetworkTransport(mux.group(id), 3, 10*time.Second, os.Stderr)
+			return GroupStorage{
+				LogStore:    logStore,
+				StableStore: stableStore,
+				Snapshots:   snapshots,
+				Transport:   transport,
+				Closers:     []io.Closer{transport, logStore, stableStore},
+			}, nil
+		},
+		RemoveGroup: func(id uint64) error {
+			return os.RemoveAll(GroupDir(dataDir, id))
+		},
+		Groups:        groups,
+		SplitKeys:     cfg.Raft.RangeSplitKeys,
+		SplitQPS:      float64(cfg.Raft.RangeSplitQPS),
+		MergeKeys:     cfg.Raft.RangeMergeKeys,
+		CheckInterval: time.Duration(cfg.Raft.RangeCheckInterval) * time.Second,
+		Slots:         cfg.Server.ClusterEnabled,
+		Closers:       []io.Closer{mux},
+	})
 }
 
-func nodeBootstrap(node *raft.Raft, isVoter bool, id string, addr string) {
-	if isVoter {
-		cfg := raft.Configuration{
-			Servers: []raft.Server{
-				{
-					Suffrage: raft.Nonvoter,
-					ID:       raft.ServerID(id),
-					Address:  raft.ServerAddress(addr),
-				},
-			},
-		}
-		node.BootstrapCluster(cfg)
+// raftAddress 返回 raft 使用的主机、监听地址和对外公布的地址
+func raftAddress(cfg *kvscfg.Config) (string, string, string, error) {
+	var raftHost string
+	// init raft ip
+	if cfg.Raft.UseLoopBack {
+		raftHost = "127.0.0.1"
 	} else {
-		cfg := raft.Configuration{
-			Servers: []raft.Server{
-				{
-					ID:      raft.ServerID(id),
-					Address: raft.ServerAddress(addr),
-				},
-			},
+		addrs, err := GetHostIPAddresses()
+		if err != nil {
+			return "", "", "", err
 		}
-		node.BootstrapCluster(cfg)
+		raftHost = addrs[len(addrs)-1]
 	}
+	bindAddr := fmt.Sprintf("%s:%s", raftHost, cfg.Raft.Port)
+	raftAddr := bindAddr
+	if cfg.Raft.AdvertiseAddr != "" {
+		raftAddr = cfg.Raft.AdvertiseAddr
+	}
+	return raftHost, bindAddr, raftAddr, nil
 }
 
-func newRaftTransport(addr string) (*raft.NetworkTransport, error) {
-	address, err := net.ResolveTCPAddr("tcp", addr)
+// localGroups 返回本地已有的区间组，目录名为组 ID
+func localGroups(rangesDir string) ([]uint64, error) {
+	entries, err := os.ReadDir(rangesDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	transport, err := raft.NewTCPTransport(address.String(), address, 3, 10*time.Second, os.Stderr)
+	var groups []uint64
+	for _, entry := range entries {
+		if id, err := strconv.ParseUint(entry.Name(), 10, 64); err == nil && entry.IsDir() && id != 0 {
+			groups = append(groups, id)
+		}
+	}
+	return groups, nil
+}
+
+// NodeOptions 创建 Node 所需的参数和依赖，测试时可以替换为内存中的实现
+type NodeOptions struct {
+	ServerID raft.ServerID
+	// 对外公布的 raft 地址
+	RaftAddr raft.ServerAddress
+	// 对外公布的客户端 RESP 地址
+	ClientAddr string
+	Engine     engines2.KvsEngine
+	// raft 配置，LocalID 和 NotifyCh 由 NewNode 设置
+	Config      *raft.Config
+	LogStore    raft.LogStore
+	StableStore raft.StableStore
+	Snapshots   raft.SnapshotStore
+	Transport   raft.Transport
+	Invoker     Invoker
+	// 默认的读一致性级别和转发方式
+	ReadConsistency string
+	ForwardMode     string
+	// 是否以单节点集群启动，Voter 为本节点的身份
+	Bootstrap bool
+	Voter     bool
+	// 加入集群时联系的已有成员的客户端地址
+	Join []string
+	// 客户端会话的空闲过期时间，为 0 时使用默认值，所有节点需要使用相同的值
+	SessionTTL time.Duration
+	// 每条日志最多合并的写请求数，小于等于 1 时不合并
+	MaxBatchSize int
+	// 第一个写请求到达后等待更多写请求的时间，为 0 时只合并已在排队的写请求
+	MaxBatchDelay time.Duration
+	// 成员健康检查和失联节点清理的参数
+	Autopilot AutopilotOptions
+	// 关闭节点时需要释放的资源
+	Closers []io.Closer
+}
+
+// NewNode 使用给定的依赖创建负责整个 key 空间的 raft 节点，不支持分片
+func NewNode(opts NodeOptions) (*Node, error) {
+	return newNode(opts, 0, nil)
+}
+
+// newNode 创建 group 号组在本节点上的副本，store 为 nil 时不支持分片
+func newNode(opts NodeOptions, group uint64, store *Store) (*Node, error) {
+	raftConfig := *opts.Config
+	raftConfig.LocalID = opts.ServerID
+	leaderNotifyCh := make(chan bool, 1)
+	raftConfig.NotifyCh = leaderNotifyCh
+	fsm := NewFSM(opts.Engine)
+	if opts.SessionTTL > 0 {
+		fsm.sessionTTL = opts.SessionTTL
+	}
+	directory := fsm
+	if store != nil {
+		// 必须在创建 raft 之前设置，回放的日志中可能有分裂
+		fsm.host = store
+		fsm.slots = store.opts.Slots
+		if group != 0 {
+			// 区间组的描述来自快照，节点地址登记在元数据组中
+			fsm.desc = nil
+			directory = store.meta.fsm
+		}
+	}
+	raftNode, err := raft.NewRaft(&raftConfig, fsm, opts.LogStore, opts.StableStore, opts.Snapshots, opts.Transport)
 	if err != nil {
 		return nil, err
 	}
-	return transport, nil
+	if opts.Bootstrap {
+		if err := nodeBootstrap(raftNode, opts.Voter, string(opts.ServerID), string(opts.RaftAddr)); err != nil {
+			_ = raftNode.Shutdown().Error()
+			return nil, err
+		}
+	}
+	node := &Node{
+		raft:            raftNode,
+		fsm:             fsm,
+		snapshots:       opts.Snapshots,
+		closers:         opts.Closers,
+		serverID:        opts.ServerID,
+		raftAddr:        opts.RaftAddr,
+		clientAddr:      opts.ClientAddr,
+		forwardMode:     opts.ForwardMode,
+		pool:            opts.Invoker,
+		engine:          opts.Engine,
+		readConsistency: opts.ReadConsistency,
+		group:           group,
+		directory:       directory,
+	}
+	if opts.MaxBatchSize > 1 {
+		node.batcher = newBatcher(raftNode, opts.MaxBatchSize, opts.MaxBatchDelay)
+	}
+	// 初始状态视为未就绪
+	node.leaderGen.Store(1)
+	go node.observeLeadership(leaderNotifyCh)
+	if group == 0 {
+		node.autopilot = newAutopilot(node, opts.Autopilot)
+		go node.autopilot.loop()
+		go node.registerLoop()
+		if len(opts.Join) > 0 {
+			go node.joinLoop(opts.Join, opts.Voter)
+		}
+	}
+	return node, nil
+}
+
+// checkNodeID 在数据目录中记录节点 ID，防止不同节点误用同一个数据目录
+func checkNodeID(dataDir, serverID string) error {
+	idPath := filepath.Join(dataDir, "node-id")
+	data, err := os.ReadFile(idPath)
+	if os.IsNotExist(err) {
+		return os.WriteFile(idPath, []byte(serverID), 0600)
+	}
+	if err != nil {
+		return err
+	}
+	if string(data) != serverID {
+		return fmt.Errorf("data dir %s belongs to node %q, not %q", dataDir, string(data), serverID)
+	}
+	return nil
 }
 
-// Apply FSM 状态机
-func (r *Node) Apply(frame *network.Frame) *network.Frame {
+// nodeBootstrap 以单节点集群启动，已经有集群配置（重启）时不做任何事。
+// 配置中至少要有一个 Voter，isVoter 为 false 时 raft 拒绝启动
+func nodeBootstrap(node *raft.Raft, isVoter bool, id string, addr string) error {
+	suffrage := raft.Voter
+	if !isVoter {
+		suffrage = raft.Nonvoter
+	}
+	cfg := raft.Configuration{
+		Servers: []raft.Server{
+			{
+				Suffrage: suffrage,
+				ID:       raft.ServerID(id),
+				Address:  raft.ServerAddress(addr),
+			},
+		},
+	}
+	err := node.BootstrapCluster(cfg).Error()
+	if errors.Is(err, raft.ErrCantBootstrap) {
+		return nil
+	}
+	return err
+}
+
+// Execute 执行客户端命令，读请求按一致性级别读取，写请求通过 raft 提交
+func (r *Node) Execute(command cmd.Command, frame *network.Frame) *network.Frame {
+	switch command.Name() {
+	case cmd.GET:
+		return r.Get(command.(*cmd.Get))
+	case cmd.SET, cmd.DELETE, cmd.SESSION:
+		return r.Apply(command, frame)
+	case cmd.MEMBER:
+		return r.Member(command.(*cmd.Member))
+	case cmd.INFO:
+		return r.Info(command.(*cmd.Info))
+	default:
+		return errorFrame(fmt.Errorf("unsupported command %s", command.Name()))
+	}
+}
+
+// Apply FSM 状态机，frame 为客户端发送的原始命令，转发给 Leader 时使用
+func (r *Node) Apply(command cmd.Command, frame *network.Frame) *network.Frame {
 	if !r.isLeader() {
-		// 代理调用 Leader
-		rspFrame, err := r.proxyInvoke(r.leader(), frame)
-		if err != nil {
-			rspFrame = &network.Frame{
-				Ftype: network.Error,
-				Value: err.Error(),
-			}
+		// 转发给 Leader
+		return r.forward(frame)
+	}
+	return r.apply(command)
+}
+
+// Submit 与 Execute 相同，但在 Leader 上开启合并时写请求加入提交队列后立即返回，
+// 由返回的函数等待结果，同一调用方依次提交的写请求可以合并到同一条日志中。
+// 其他命令执行完成后才返回
+func (r *Node) Submit(command cmd.Command, frame *network.Frame) func() *network.Frame {
+	switch command.Name() {
+	case cmd.SET, cmd.DELETE:
+		if r.batcher != nil && r.isLeader() {
+			rsp := r.batcher.enqueue(command)
+			return func() *network.Frame { return <-rsp }
 		}
-		return rspFrame
 	}
-	cmdBytes, _ := frame.Bytes()
-	ret := r.raft.Apply(cmdBytes, 5*time.Second)
+	rspFrame := r.Execute(command, frame)
+	return func() *network.Frame { return rspFrame }
+}
+
+// apply 在 Leader 上提交日志并等待状态机应用的结果
+func (r *Node) apply(command cmd.Command) *network.Frame {
+	if r.batcher != nil {
+		return r.batcher.propose(command)
+	}
+	cmdBytes, err := encodeCommand(command)
+	if err != nil {
+		return errorFrame(err)
+	}
+	ret := r.raft.Apply(cmdBytes, applyTimeout)
 	if ret.Error() != nil {
 		return &network.Frame{
 			Ftype: network.Error,
@@ -146,80 +450,132 @@ func (r *Node) Member(cm *cmd.Member) *network.Frame {
 		Value: "OK",
 	}
 	switch cm.Opt() {
-	case cmd.MemberAdd:
-		timeOut := time.Microsecond * 100
-		// add node with loopback addr will get a new raft node
-		loRe := regexp.MustCompile(`^127\.0\.0\.1`)
-		if loRe.FindString(cm.Address()) != "" {
-			dataDir := fmt.Sprintf("./nodes/node%s", cm.ServerID())
-			engine, err := engines2.NewKvsStore(dataDir)
-			var fsm = NewFSM(engine)
-			raftConfig := raft.DefaultConfig()
-			raftConfig.ProtocolVersion = raft.ProtocolVersionMax
-			raftConfig.LocalID = raft.ServerID(cm.ServerID())
-			LeaderNotifyCh := make(chan bool, 1)
-			raftConfig.NotifyCh = LeaderNotifyCh
-			if err != nil {
-				log.Fatal(err)
+	case cmd.MemberProgress:
+		return &network.Frame{
+			Ftype: network.Integer,
+			Value: int(r.raft.LastIndex()),
+		}
+	case cmd.MemberRegister:
+		return r.Apply(cm, cm.IntoFrame())
+	case cmd.MemberList:
+		members, err := r.members()
+		if err != nil {
+			return errorFrame(err)
+		}
+		var buf bytes.Buffer
+		for _, m := range members {
+			// 健康状况只有 Leader 知道
+			health, lastContact := "-", "-"
+			if h, ok := r.autopilot.health(m.ID); ok {
+				health = "unhealthy"
+				if h.healthy {
+					health = "healthy"
+				}
+				lastContact = time.Since(h.lastContact).Round(time.Millisecond).String()
 			}
-			var transport, _ = newRaftTransport(cm.Address())
-			os.MkdirAll(dataDir, 0700)
-			// 忽略快照
-			snapshotStore := raft.NewDiscardSnapshotStore()
-			logStore, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft-log.bolt"))
-			stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft-stable.bolt"))
-
-			raftNode, err := raft.NewRaft(raftConfig, fsm, logStore, stableStore, snapshotStore, transport)
-			nodeBootstrap(raftNode, true, cm.ServerID(), cm.Address())
-		}
-		if err := r.raft.AddVoter(raft.ServerID(cm.ServerID()), raft.ServerAddress(cm.Address()), 0, timeOut).Error(); err != nil {
-			return &network.Frame{
-				Ftype: network.Error,
-				Value: err.Error(),
+			buf.WriteString(fmt.Sprintf("id=%s address=%s client=%s suffrage=%s isLeader=%t match=%s lag=%s health=%s last-contact=%s\n",
+				m.ID, m.Address, m.clientAddr, m.Suffrage, m.isLeader, formatIndex(m.match), formatIndex(m.lag), health, lastContact))
+		}
+		rspFrame.Value = strings.TrimRight(buf.String(), "\n")
+		return rspFrame
+	}
+	// 成员变更只能由 Leader 执行
+	if !r.isLeader() {
+		return r.toLeader(cm.IntoFrame())
+	}
+	switch cm.Opt() {
+	case cmd.MemberAdd, cmd.MemberJoin:
+		// 可选参数为新节点的客户端地址和 learner 标记
+		clientAddr, voter := "", true
+		for _, option := range cm.Options() {
+			if strings.EqualFold(option, cmd.MemberLearner) {
+				voter = false
+			} else {
+				clientAddr = option
 			}
 		}
+		if err := r.join(raft.ServerID(cm.ServerID()), raft.ServerAddress(cm.Address()), clientAddr, voter); err != nil {
+			return errorFrame(err)
+		}
+	case cmd.MemberPromote:
+		if err := r.promote(raft.ServerID(cm.ServerID())); err != nil {
+			return errorFrame(err)
+		}
+	case cmd.MemberDemote:
+		if err := r.demote(raft.ServerID(cm.ServerID())); err != nil {
+			return errorFrame(err)
+		}
 	case cmd.MemberRemove:
-		if err := r.raft.RemoveServer(raft.ServerID(cm.ServerID()), 0, 0).Error(); err != nil {
-			return &network.Frame{
-				Ftype: network.Error,
-				Value: err.Error(),
-			}
+		if err := r.removeServer(raft.ServerID(cm.ServerID())); err != nil {
+			return errorFrame(err)
 		}
-	case cmd.MemberList:
-		var buf bytes.Buffer
-		for _, s := range r.raft.GetConfiguration().Configuration().Servers {
-			buf.WriteString(fmt.Sprintf("id=%s address=%s suffrage=%d isLeader=%t\n", s.ID, s.Address, s.Suffrage, r.checkIsLeader(s.ID)))
+	case cmd.MemberHealth:
+		return r.autopilot.report()
+	case cmd.MemberTransferLeader:
+		if err := r.transferLeader(raft.ServerID(cm.ServerID())); err != nil {
+			return errorFrame(err)
+		}
+	case cmd.MemberDrain:
+		if err := r.drain(raft.ServerID(cm.ServerID())); err != nil {
+			return errorFrame(err)
 		}
-		rspFrame.Value = strings.TrimRight(buf.String(), "\n")
 	default:
 		rspFrame.Value = "unknown member subcommand " + cm.Opt()
 	}
 	return rspFrame
 }
 
+// Shutdown 关闭 raft 节点。transferLeader 为 true 且本节点是 Leader 时先转移领导权，
+// 避免其他节点等待选举超时
+func (r *Node) Shutdown(transferLeader bool) error {
+	if transferLeader && r.isLeader() {
+		if err := r.raft.LeadershipTransfer().Error(); err != nil {
+			log.Printf("transfer leadership before shutdown failed: %v", err)
+		}
+	}
+	if r.batcher != nil {
+		r.batcher.close()
+	}
+	err := r.raft.Shutdown().Error()
+	r.pool.Close()
+	for _, closer := range r.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Raft 返回底层的 raft 实例，供测试和运维工具查询状态
+func (r *Node) Raft() *raft.Raft {
+	return r.raft
+}
+
+// Group 返回本节点负责的组 ID
+func (r *Node) Group() uint64 {
+	return r.group
+}
+
+// Range 返回本组当前负责的区间，尚未得知时返回 nil
+func (r *Node) Range() *RangeDescriptor {
+	return r.fsm.descriptor()
+}
+
+// IsLeader 本节点是否是本组的 Leader
+func (r *Node) IsLeader() bool {
+	return r.isLeader()
+}
+
 func (r *Node) isLeader() bool {
 	_, leaderId := r.raft.LeaderWithID()
 	return leaderId == r.serverID
 }
 
-func (r *Node) leader() raft.ServerAddress {
-	_, serverID := r.raft.LeaderWithID()
-	return raft.ServerAddress(serverID)
-}
-
 func (r *Node) checkIsLeader(serverID raft.ServerID) bool {
 	_, leaderId := r.raft.LeaderWithID()
 	return leaderId == serverID
 }
 
-func (r *Node) proxyInvoke(addr raft.ServerAddress, frame *network.Frame) (*network.Frame, error) {
-	client, err := kvscli.NewClient(string(addr))
-	if err != nil {
-		return nil, err
-	}
-	return client.Invoke(frame)
-}
-
 func GetHostIPAddresses() ([]string, error) {
 	var addresses []string
 
@@ -0,0 +1,39 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_NodeBootstrap(t *testing.T) {
+	Convey("a bootstrapped node joins its own configuration as a voter, and a cluster without voters is rejected", t, func() {
+		newRaft := func() (*raft.Raft, raft.ServerAddress) {
+			store := raft.NewInmemStore()
+			addr, transport := raft.NewInmemTransport("")
+			conf := raft.DefaultConfig()
+			conf.LocalID = "node0"
+			conf.LogOutput = io.Discard
+			node, err := raft.NewRaft(conf, &raft.MockFSM{}, store, store, raft.NewInmemSnapshotStore(), transport)
+			So(err, ShouldBeNil)
+			return node, addr
+		}
+
+		node, addr := newRaft()
+		So(nodeBootstrap(node, true, "node0", string(addr)), ShouldBeNil)
+		configFuture := node.GetConfiguration()
+		So(configFuture.Error(), ShouldBeNil)
+		So(configFuture.Configuration().Servers, ShouldResemble, []raft.Server{
+			{Suffrage: raft.Voter, ID: "node0", Address: addr},
+		})
+		// 重启时已有集群配置，不再启动
+		So(nodeBootstrap(node, true, "node0", string(addr)), ShouldBeNil)
+		So(node.Shutdown().Error(), ShouldBeNil)
+
+		node, addr = newRaft()
+		So(nodeBootstrap(node, false, "node0", string(addr)), ShouldNotBeNil)
+		So(node.Shutdown().Error(), ShouldBeNil)
+	})
+}
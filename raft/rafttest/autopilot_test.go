@@ -0,0 +1,93 @@
+package rafttest
+
+import (
+	"github.com/huiming23344/kv-raft/cmd"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// health 在第 i 个节点上执行 member health，由 Leader 回复
+func health(c *Cluster, i int) string {
+	rsp, _ := c.Do(i, cmd.NewMember(cmd.MemberHealth, "", "")).Value.(string)
+	return rsp
+}
+
+func Test_AutopilotHealth(t *testing.T) {
+	Convey("the leader tracks member health and removes a dead voter", t, func() {
+		c := NewAutopilot(t, 3, kvsraft.AutopilotOptions{
+			CleanupDeadServers:   true,
+			LastContactThreshold: 300 * time.Millisecond,
+			DeadServerThreshold:  2 * time.Second,
+			Interval:             50 * time.Millisecond,
+		})
+		leader := c.WaitLeader()
+		So(waitFor(func() bool {
+			return strings.HasPrefix(health(c, follower(c, leader)), "healthy=true failure-tolerance=1 voters=3 healthy-voters=3")
+		}), ShouldBeTrue)
+		list := c.Do(leader.index, cmd.NewMember(cmd.MemberList, "", "")).Value.(string)
+		So(strings.Count(list, "health=healthy"), ShouldEqual, 3)
+		// 只有 Leader 知道健康状况
+		list = c.Do(follower(c, leader), cmd.NewMember(cmd.MemberList, "", "")).Value.(string)
+		So(strings.Count(list, "health=- last-contact=-"), ShouldEqual, 3)
+
+		dead := c.Node(follower(c, leader))
+		c.Kill(dead.index)
+		So(waitFor(func() bool {
+			return strings.HasPrefix(health(c, leader.index), "healthy=true failure-tolerance=0 voters=3 healthy-voters=2")
+		}), ShouldBeTrue)
+		So(health(c, leader.index), ShouldContainSubstring, "id="+string(dead.ID)+" suffrage=Voter healthy=false")
+
+		// 失联超过阈值后被删除，剩余的两个节点仍然可以写入
+		So(waitFor(func() bool {
+			return strings.HasPrefix(health(c, leader.index), "healthy=true failure-tolerance=0 voters=2 healthy-voters=2")
+		}), ShouldBeTrue)
+		list = c.Do(leader.index, cmd.NewMember(cmd.MemberList, "", "")).Value.(string)
+		So(list, ShouldNotContainSubstring, "id="+string(dead.ID)+" ")
+		So(c.Do(leader.index, cmd.NewSet("k", []byte("v"))).Value, ShouldEqual, "OK")
+	})
+
+	Convey("dead voters are kept when removing them would go below the minimum quorum", t, func() {
+		c := NewAutopilot(t, 3, kvsraft.AutopilotOptions{
+			CleanupDeadServers:   true,
+			LastContactThreshold: 300 * time.Millisecond,
+			DeadServerThreshold:  500 * time.Millisecond,
+			MinQuorum:            3,
+			Interval:             50 * time.Millisecond,
+		})
+		leader := c.WaitLeader()
+		dead := c.Node(follower(c, leader))
+		c.Kill(dead.index)
+		So(waitFor(func() bool {
+			return strings.Contains(health(c, leader.index), "id="+string(dead.ID)+" suffrage=Voter healthy=false")
+		}), ShouldBeTrue)
+		time.Sleep(time.Second)
+		So(health(c, leader.index), ShouldStartWith, "healthy=true failure-tolerance=0 voters=3 healthy-voters=2")
+	})
+
+	Convey("a member that does not answer the probe is unhealthy but not removed while it answers heartbeats", t, func() {
+		c := NewAutopilot(t, 3, kvsraft.AutopilotOptions{
+			CleanupDeadServers:   true,
+			LastContactThreshold: 300 * time.Millisecond,
+			DeadServerThreshold:  500 * time.Millisecond,
+			Interval:             50 * time.Millisecond,
+		})
+		leader := c.WaitLeader()
+		hung := c.Node(follower(c, leader))
+		c.Hang(hung.index, true)
+		So(waitFor(func() bool {
+			return strings.Contains(health(c, leader.index), "id="+string(hung.ID)+" suffrage=Voter healthy=false")
+		}), ShouldBeTrue)
+		time.Sleep(time.Second)
+		So(health(c, leader.index), ShouldStartWith, "healthy=true failure-tolerance=0 voters=3 healthy-voters=2")
+
+		// 恢复回复后重新变为健康
+		c.Hang(hung.index, false)
+		So(waitFor(func() bool {
+			return strings.HasPrefix(health(c, leader.index), "healthy=true failure-tolerance=1 voters=3 healthy-voters=3")
+		}), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,643 @@
+// Package rafttest 在同一进程中运行多个 raft 节点，用于编写确定性的故障转移测试。
+// 节点之间通过 raft.InmemTransport 通信，每个 raft 组使用各自的 transport，
+// 日志和快照保存在内存中，数据保存在临时目录，
+// 节点之间的客户端请求（转发、查询进度等）直接调用目标节点的 Execute
+package rafttest
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// 等待集群达到预期状态的最长时间
+	waitTimeout = 10 * time.Second
+	// 检查集群状态的时间间隔
+	pollInterval = 10 * time.Millisecond
+)
+
+var (
+	errUnreachable = errors.New("rafttest: node unreachable")
+	errTimeout     = errors.New("rafttest: request timed out")
+)
+
+// Cluster 进程内的多节点集群
+type Cluster struct {
+	t     testing.TB
+	dir   string
+	mutex sync.Mutex
+	nodes []*Node
+	// 被切断的节点对，小序号在前
+	cuts map[[2]int]bool
+	// 区间分片的参数，存储相关的字段由集群填充
+	ranges kvsraft.StoreOptions
+	// 成员健康检查和失联节点清理的参数
+	autopilot kvsraft.AutopilotOptions
+}
+
+// Node 集群中的一个节点，Kill 之后再 Restart 会保留日志、快照和数据目录
+type Node struct {
+	ID         raft.ServerID
+	Addr       raft.ServerAddress
+	ClientAddr string
+
+	index int
+	dir   string
+	alive bool
+	// 接受客户端请求但不回复，调用方等到超时
+	hung bool
+	// 各 raft 组的存储，0 号为元数据组，组被删除之前一直保留
+	groups map[uint64]*groupStorage
+	engine engines.KvsEngine
+	store  *kvsraft.Store
+}
+
+// groupStorage 一个 raft 组在节点上的内存存储和 transport，所有组使用相同的地址
+type groupStorage struct {
+	logs      *raft.InmemStore
+	snapshots *raft.InmemSnapshotStore
+	transport *raft.InmemTransport
+}
+
+// New 创建并启动 n 个 Voter 组成的集群，等待选出 Leader 且所有节点登记了客户端地址。
+// 测试结束时自动关闭集群
+func New(t testing.TB, n int) *Cluster {
+	t.Helper()
+	return NewSharded(t, n, kvsraft.StoreOptions{})
+}
+
+// NewSharded 与 New 相同，opts 为自动分裂、合并区间的参数
+func NewSharded(t testing.TB, n int, opts kvsraft.StoreOptions) *Cluster {
+	t.Helper()
+	return newCluster(t, n, opts, kvsraft.AutopilotOptions{})
+}
+
+// NewAutopilot 与 New 相同，opts 为成员健康检查和失联节点清理的参数
+func NewAutopilot(t testing.TB, n int, opts kvsraft.AutopilotOptions) *Cluster {
+	t.Helper()
+	return newCluster(t, n, kvsraft.StoreOptions{}, opts)
+}
+
+func newCluster(t testing.TB, n int, ranges kvsraft.StoreOptions, autopilot kvsraft.AutopilotOptions) *Cluster {
+	t.Helper()
+	c := &Cluster{
+		t:         t,
+		dir:       t.TempDir(),
+		cuts:      make(map[[2]int]bool),
+		ranges:    ranges,
+		autopilot: autopilot,
+	}
+	t.Cleanup(c.Close)
+	configuration := raft.Configuration{}
+	for i := 0; i < n; i++ {
+		node := c.newNode()
+		configuration.Servers = append(configuration.Servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       node.ID,
+			Address:  node.Addr,
+		})
+	}
+	for _, node := range c.nodes {
+		meta := node.groups[0]
+		err := raft.BootstrapCluster(testConfig(node.ID), meta.logs, meta.logs, meta.snapshots, meta.transport, configuration)
+		if err != nil {
+			t.Fatalf("bootstrap %s: %v", node.ID, err)
+		}
+	}
+	for i := range c.nodes {
+		c.start(i)
+	}
+	c.WaitReady()
+	return c
+}
+
+// AddNode 创建并启动一个不在集群配置中的节点，返回它的序号，
+// 由测试通过 member add 等命令把它加入集群
+func (c *Cluster) AddNode() int {
+	c.t.Helper()
+	node := c.newNode()
+	c.start(node.index)
+	return node.index
+}
+
+// Node 返回第 i 个节点
+func (c *Cluster) Node(i int) *Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.nodes[i]
+}
+
+// Do 模拟连接到第 i 个节点的客户端执行命令，节点已被 Kill 时返回错误
+func (c *Cluster) Do(i int, command cmd.Command) *network.Frame {
+	c.mutex.Lock()
+	node := c.nodes[i]
+	alive, store := node.alive, node.store
+	c.mutex.Unlock()
+	if !alive {
+		return &network.Frame{Ftype: network.Error, Value: errUnreachable.Error()}
+	}
+	return store.Execute(command, command.IntoFrame())
+}
+
+// Kill 关闭第 i 个节点，保留它的存储以便 Restart
+func (c *Cluster) Kill(i int) {
+	c.t.Helper()
+	c.mutex.Lock()
+	node := c.nodes[i]
+	if !node.alive {
+		c.mutex.Unlock()
+		return
+	}
+	node.alive = false
+	for _, other := range c.nodes {
+		if other != node {
+			disconnect(other, node)
+		}
+	}
+	c.mutex.Unlock()
+
+	if err := node.store.Shutdown(false); err != nil {
+		c.t.Fatalf("shutdown %s: %v", node.ID, err)
+	}
+	if err := node.engine.Close(); err != nil {
+		c.t.Fatalf("close engine of %s: %v", node.ID, err)
+	}
+}
+
+// Restart 使用 Kill 之前的存储重新启动第 i 个节点
+func (c *Cluster) Restart(i int) {
+	c.t.Helper()
+	c.start(i)
+}
+
+// Recover 在已被 Kill 的第 i 个节点上强制恢复所有组，恢复后的成员为 survivors 中的节点
+func (c *Cluster) Recover(i int, dryRun bool, survivors ...int) []kvsraft.RecoverReport {
+	c.t.Helper()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	node := c.nodes[i]
+	if node.alive {
+		c.t.Fatalf("recover %s: node is running", node.ID)
+	}
+	opts := kvsraft.RecoverOptions{
+		ServerID: node.ID,
+		Slots:    c.ranges.Slots,
+		DryRun:   dryRun,
+	}
+	for _, j := range survivors {
+		opts.Servers = append(opts.Servers, raft.Server{Suffrage: raft.Voter, ID: c.nodes[j].ID, Address: c.nodes[j].Addr})
+	}
+	ids := make([]uint64, 0, len(node.groups))
+	for id := range node.groups {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return ids[a] < ids[b]
+	})
+	var reports []kvsraft.RecoverReport
+	for _, id := range ids {
+		group := node.groups[id]
+		report, err := kvsraft.RecoverGroup(id, kvsraft.GroupStorage{
+			LogStore:    group.logs,
+			StableStore: group.logs,
+			Snapshots:   group.snapshots,
+		}, opts)
+		if err != nil {
+			c.t.Fatalf("recover group %d of %s: %v", id, node.ID, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Partition 将 group 中的节点与其他节点隔离，group 内部仍然互通
+func (c *Cluster) Partition(group ...int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	inGroup := make(map[int]bool)
+	for _, i := range group {
+		inGroup[i] = true
+	}
+	for _, a := range c.nodes {
+		for _, b := range c.nodes {
+			if a.index < b.index && inGroup[a.index] != inGroup[b.index] {
+				c.cuts[[2]int{a.index, b.index}] = true
+				disconnect(a, b)
+				disconnect(b, a)
+			}
+		}
+	}
+}
+
+// Hang 让节点 i 的客户端地址接受请求但不回复，模拟卡住的节点，raft 通信不受影响
+func (c *Cluster) Hang(i int, hung bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nodes[i].hung = hung
+}
+
+// Heal 恢复所有存活节点之间的连接
+func (c *Cluster) Heal() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cuts = make(map[[2]int]bool)
+	for _, a := range c.nodes {
+		for _, b := range c.nodes {
+			if a.index < b.index && a.alive && b.alive {
+				connect(a, b)
+			}
+		}
+	}
+}
+
+// Leader 返回元数据组当前唯一的 Leader，没有 Leader 或有多个节点自认为 Leader 时返回 nil
+func (c *Cluster) Leader() *Node {
+	return c.GroupLeader(0)
+}
+
+// GroupLeader 返回 id 号组当前唯一的 Leader
+func (c *Cluster) GroupLeader(id uint64) *Node {
+	var leader *Node
+	for _, node := range c.aliveNodes() {
+		if group := node.store.Group(id); group != nil && group.Raft().State() == raft.Leader {
+			if leader != nil {
+				return nil
+			}
+			leader = node
+		}
+	}
+	return leader
+}
+
+// WaitLeader 等待元数据组选出唯一的 Leader
+func (c *Cluster) WaitLeader() *Node {
+	c.t.Helper()
+	return c.WaitGroupLeader(0)
+}
+
+// WaitGroupLeader 等待 id 号组选出唯一的 Leader
+func (c *Cluster) WaitGroupLeader(id uint64) *Node {
+	c.t.Helper()
+	var leader *Node
+	if !waitFor(func() bool {
+		leader = c.GroupLeader(id)
+		return leader != nil
+	}) {
+		c.t.Fatalf("range %d has no leader elected within %s", id, waitTimeout)
+	}
+	return leader
+}
+
+// WaitReady 等待选出 Leader，并且每个存活节点都知道所有成员的客户端地址，
+// 之后在任意节点上执行的请求都可以转发给 Leader
+func (c *Cluster) WaitReady() {
+	c.t.Helper()
+	c.WaitLeader()
+	var reason string
+	if !waitFor(func() bool {
+		for _, node := range c.aliveNodes() {
+			list := c.Do(node.index, cmd.NewMember(cmd.MemberList, "", ""))
+			if list.Ftype == network.Error {
+				reason = fmt.Sprintf("%s: %v", node.ID, list.Value)
+				return false
+			}
+			if strings.Contains(list.Value.(string), "client= ") {
+				reason = fmt.Sprintf("%s does not know all client addresses:\n%s", node.ID, list.Value)
+				return false
+			}
+		}
+		return true
+	}) {
+		c.t.Fatalf("cluster not ready within %s: %s", waitTimeout, reason)
+	}
+}
+
+// WaitConverged 等待所有存活节点上恰好有元数据表中未删除的组，每个组都应用了 Leader 的全部日志，
+// 并且数据完全一致。
+// 被隔离的节点无法追上日志，调用前需要先 Heal
+func (c *Cluster) WaitConverged() {
+	c.t.Helper()
+	var reason string
+	if !waitFor(func() bool {
+		leader := c.Leader()
+		if leader == nil {
+			reason = "no leader"
+			return false
+		}
+		groups := []uint64{0}
+		for _, desc := range leader.store.Ranges() {
+			if desc.ID != 0 && !desc.Removed {
+				groups = append(groups, desc.ID)
+			}
+		}
+		sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+		for _, id := range groups {
+			groupLeader := c.GroupLeader(id)
+			if groupLeader == nil {
+				reason = fmt.Sprintf("range %d has no leader", id)
+				return false
+			}
+			lastIndex := groupLeader.store.Group(id).Raft().LastIndex()
+			for _, node := range c.aliveNodes() {
+				group := node.store.Group(id)
+				if group == nil {
+					reason = fmt.Sprintf("%s does not serve range %d", node.ID, id)
+					return false
+				}
+				if applied := group.Raft().AppliedIndex(); applied < lastIndex {
+					reason = fmt.Sprintf("%s applied %d of %d in range %d", node.ID, applied, lastIndex, id)
+					return false
+				}
+			}
+		}
+		want := leader.Data()
+		for _, node := range c.aliveNodes() {
+			if ids := node.Groups(); !reflect.DeepEqual(ids, groups) {
+				reason = fmt.Sprintf("%s serves ranges %v, want %v", node.ID, ids, groups)
+				return false
+			}
+			if data := node.Data(); !reflect.DeepEqual(data, want) {
+				reason = fmt.Sprintf("%s has %v, leader %s has %v", node.ID, data, leader.ID, want)
+				return false
+			}
+		}
+		return true
+	}) {
+		c.t.Fatalf("cluster did not converge within %s: %s", waitTimeout, reason)
+	}
+}
+
+// Close 关闭所有存活的节点
+func (c *Cluster) Close() {
+	c.mutex.Lock()
+	nodes := c.nodes
+	c.mutex.Unlock()
+	for _, node := range nodes {
+		c.Kill(node.index)
+	}
+}
+
+// Index 返回节点在集群中的序号，用于 Kill、Restart、Partition 和 Do
+func (n *Node) Index() int {
+	return n.index
+}
+
+// Raft 返回节点上元数据组的 raft.Node
+func (n *Node) Raft() *kvsraft.Node {
+	return n.store.Meta()
+}
+
+// Store 返回节点上管理所有 raft 组的 Store
+func (n *Node) Store() *kvsraft.Store {
+	return n.store
+}
+
+// Groups 按顺序返回节点上所有组的 ID
+func (n *Node) Groups() []uint64 {
+	var ids []uint64
+	for _, group := range n.store.Groups() {
+		ids = append(ids, group.Group())
+	}
+	return ids
+}
+
+// Data 返回节点上所有区间当前负责的数据
+func (n *Node) Data() map[string]string {
+	data := make(map[string]string)
+	_ = n.store.Scan(func(key string, value []byte) bool {
+		data[key] = string(value)
+		return true
+	})
+	return data
+}
+
+func (c *Cluster) newNode() *Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	index := len(c.nodes)
+	node := &Node{
+		ID:         raft.ServerID(fmt.Sprintf("node%d", index)),
+		Addr:       raft.ServerAddress(fmt.Sprintf("raft-%d", index)),
+		ClientAddr: fmt.Sprintf("client-%d", index),
+		index:      index,
+		dir:        filepath.Join(c.dir, fmt.Sprintf("node%d", index)),
+		groups:     make(map[uint64]*groupStorage),
+	}
+	node.groups[0] = newGroupStorage(node.Addr)
+	c.nodes = append(c.nodes, node)
+	return node
+}
+
+func newGroupStorage(addr raft.ServerAddress) *groupStorage {
+	_, transport := raft.NewInmemTransport(addr)
+	return &groupStorage{
+		logs:      raft.NewInmemStore(),
+		snapshots: raft.NewInmemSnapshotStore(),
+		transport: transport,
+	}
+}
+
+// start 启动第 i 个节点并连接到所有未被隔离的存活节点
+func (c *Cluster) start(i int) {
+	c.t.Helper()
+	c.mutex.Lock()
+	node := c.nodes[i]
+	if node.alive {
+		c.mutex.Unlock()
+		return
+	}
+	meta := node.groups[0]
+	var groups []uint64
+	if node.store != nil {
+		// 重启时 raft 已经关闭了原来的 transport，区间组的 transport 在打开组时重新创建
+		_, meta.transport = raft.NewInmemTransport(node.Addr)
+		for id := range node.groups {
+			if id != 0 {
+				groups = append(groups, id)
+			}
+		}
+	}
+	c.connectGroup(node, 0)
+	c.mutex.Unlock()
+
+	if err := os.MkdirAll(node.dir, 0700); err != nil {
+		c.t.Fatal(err)
+	}
+	engine, err := engines.NewKvsStore(node.dir)
+	if err != nil {
+		c.t.Fatalf("open engine of %s: %v", node.ID, err)
+	}
+	opts := c.ranges
+	opts.OpenGroup = func(id uint64) (kvsraft.GroupStorage, error) {
+		return c.openGroup(node, id), nil
+	}
+	opts.RemoveGroup = func(id uint64) error {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		delete(node.groups, id)
+		return nil
+	}
+	opts.Groups = groups
+	store, err := kvsraft.NewStore(engine, kvsraft.NodeOptions{
+		ServerID:        node.ID,
+		RaftAddr:        node.Addr,
+		ClientAddr:      node.ClientAddr,
+		Config:          testConfig(node.ID),
+		LogStore:        meta.logs,
+		StableStore:     meta.logs,
+		Snapshots:       meta.snapshots,
+		Transport:       meta.transport,
+		Invoker:         &invoker{cluster: c, from: node.index},
+		ReadConsistency: cmd.ReadLinearizable,
+		ForwardMode:     kvsraft.ForwardProxy,
+		Voter:           true,
+		MaxBatchSize:    64,
+		Autopilot:       c.autopilot,
+	}, opts)
+	if err != nil {
+		c.t.Fatalf("start %s: %v", node.ID, err)
+	}
+	c.mutex.Lock()
+	node.engine = engine
+	node.store = store
+	node.alive = true
+	c.mutex.Unlock()
+}
+
+// openGroup 返回 id 号组在节点上的存储，每次打开都使用新的 transport 并连接到其他节点上的同一个组
+func (c *Cluster) openGroup(node *Node, id uint64) kvsraft.GroupStorage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	group := node.groups[id]
+	if group == nil {
+		group = newGroupStorage(node.Addr)
+		node.groups[id] = group
+	} else {
+		_, group.transport = raft.NewInmemTransport(node.Addr)
+	}
+	c.connectGroup(node, id)
+	return kvsraft.GroupStorage{
+		LogStore:    group.logs,
+		StableStore: group.logs,
+		Snapshots:   group.snapshots,
+		Transport:   group.transport,
+	}
+}
+
+// connectGroup 把节点上的 id 号组连接到其他未被隔离的存活节点上的同一个组
+func (c *Cluster) connectGroup(node *Node, id uint64) {
+	for _, other := range c.nodes {
+		if other == node || !other.alive || c.cut(node.index, other.index) {
+			continue
+		}
+		if a, b := node.groups[id], other.groups[id]; a != nil && b != nil {
+			a.transport.Connect(other.Addr, b.transport)
+			b.transport.Connect(node.Addr, a.transport)
+		}
+	}
+}
+
+func (c *Cluster) aliveNodes() []*Node {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	nodes := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node.alive {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (c *Cluster) cut(a, b int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	return c.cuts[[2]int{a, b}]
+}
+
+func connect(a, b *Node) {
+	for id, group := range a.groups {
+		if other := b.groups[id]; other != nil {
+			group.transport.Connect(b.Addr, other.transport)
+			other.transport.Connect(a.Addr, group.transport)
+		}
+	}
+}
+
+// disconnect 断开 a 上所有组到 b 的连接
+func disconnect(a, b *Node) {
+	for _, group := range a.groups {
+		group.transport.Disconnect(b.Addr)
+	}
+}
+
+// invoker 把节点之间的客户端请求直接交给目标节点执行，遵守 Kill 和 Partition
+type invoker struct {
+	cluster *Cluster
+	from    int
+}
+
+func (i *invoker) Invoke(addr string, frame *network.Frame, timeout time.Duration) (*network.Frame, error) {
+	c := i.cluster
+	c.mutex.Lock()
+	var target *Node
+	for _, node := range c.nodes {
+		if node.ClientAddr == addr && node.alive && !c.cut(i.from, node.index) {
+			target = node
+		}
+	}
+	hung := target != nil && target.hung
+	c.mutex.Unlock()
+	if target == nil {
+		return nil, errUnreachable
+	}
+	if hung {
+		time.Sleep(timeout)
+		return nil, errTimeout
+	}
+	command, err := cmd.FromFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	return target.store.Execute(command, frame), nil
+}
+
+func (i *invoker) Close() {}
+
+func testConfig(id raft.ServerID) *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.LocalID = id
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	conf.LogOutput = io.Discard
+	conf.LogLevel = "ERROR"
+	return conf
+}
+
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(pollInterval)
+	}
+	return false
+}
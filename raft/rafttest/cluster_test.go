@@ -0,0 +1,199 @@
+package rafttest
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// 返回一个不是 Leader 的存活节点
+func follower(c *Cluster, leader *Node) int {
+	for _, node := range c.aliveNodes() {
+		if node != leader {
+			return node.index
+		}
+	}
+	return -1
+}
+
+func Test_LeaderFailover(t *testing.T) {
+	Convey("writes survive the loss of the leader", t, func() {
+		c := New(t, 3)
+		leader := c.WaitLeader()
+		So(c.Do(follower(c, leader), cmd.NewSet("name", []byte("mars"))).Value, ShouldEqual, "OK")
+		So(c.Do(leader.index, cmd.NewSet("age", []byte("25"))).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+
+		c.Kill(leader.index)
+		newLeader := c.WaitLeader()
+		So(newLeader.ID, ShouldNotEqual, leader.ID)
+		So(c.Do(newLeader.index, cmd.NewDelete("age")).Value, ShouldEqual, 1)
+		So(c.Do(follower(c, newLeader), cmd.NewSet("city", []byte("paris"))).Value, ShouldEqual, "OK")
+
+		c.Restart(leader.index)
+		c.WaitConverged()
+		So(leader.Data(), ShouldResemble, map[string]string{"name": "mars", "city": "paris"})
+		So(c.Do(leader.index, cmd.NewGet("city")).Value, ShouldResemble, []byte("paris"))
+	})
+}
+
+func Test_PartitionedLeader(t *testing.T) {
+	Convey("a leader cut off from the majority cannot commit writes", t, func() {
+		c := New(t, 3)
+		old := c.WaitLeader()
+		c.Partition(old.index)
+
+		So(c.Do(old.index, cmd.NewSet("name", []byte("lost"))).Ftype, ShouldEqual, network.Error)
+		leader := c.WaitLeader()
+		So(leader.ID, ShouldNotEqual, old.ID)
+		So(c.Do(leader.index, cmd.NewSet("name", []byte("mars"))).Value, ShouldEqual, "OK")
+
+		c.Heal()
+		c.WaitConverged()
+		So(old.Data(), ShouldResemble, map[string]string{"name": "mars"})
+	})
+}
+
+func Test_MemberAddRemove(t *testing.T) {
+	Convey("a node added through a follower catches up and can be removed again", t, func() {
+		c := New(t, 3)
+		So(c.Do(0, cmd.NewSet("name", []byte("mars"))).Value, ShouldEqual, "OK")
+
+		i := c.AddNode()
+		joiner := c.Node(i)
+		leader := c.WaitLeader()
+		add := cmd.NewMember(cmd.MemberAdd, string(joiner.ID), string(joiner.Addr), joiner.ClientAddr)
+		So(c.Do(follower(c, leader), add).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+		So(joiner.Data(), ShouldResemble, map[string]string{"name": "mars"})
+
+		remove := cmd.NewMember(cmd.MemberRemove, string(joiner.ID), "")
+		So(c.Do(follower(c, leader), remove).Value, ShouldEqual, "OK")
+		c.Kill(i)
+		So(c.Do(0, cmd.NewSet("age", []byte("25"))).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+	})
+}
+
+func Test_MemberTransferLeaderDrain(t *testing.T) {
+	Convey("transfer-leader and drain move the leadership of every group, including the range groups", t, func() {
+		c := New(t, 3)
+		leader := c.WaitLeader()
+		So(c.Do(leader.index, cmd.NewRange(cmd.RangeSplit, "0", "m")).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+		c.WaitGroupLeader(1)
+		groups := []uint64{0, 1}
+
+		// 在第三个节点上执行，两个组的 Leader 都需要转发
+		target := c.Node(follower(c, leader))
+		other := 3 - leader.index - target.index
+		transfer := cmd.NewMember(cmd.MemberTransferLeader, string(target.ID), "")
+		So(c.Do(other, transfer).Value, ShouldEqual, "OK")
+		So(waitFor(func() bool {
+			for _, id := range groups {
+				if c.GroupLeader(id) != target {
+					return false
+				}
+			}
+			return true
+		}), ShouldBeTrue)
+
+		drain := cmd.NewMember(cmd.MemberDrain, string(target.ID), "")
+		So(c.Do(other, drain).Value, ShouldEqual, "OK")
+		So(waitFor(func() bool {
+			for _, id := range groups {
+				if leader := c.GroupLeader(id); leader == nil || leader == target {
+					return false
+				}
+				// 写请求由 target 转发给新的 Leader
+				if addr, _ := target.Store().Group(id).Raft().LeaderWithID(); addr == "" {
+					return false
+				}
+			}
+			return true
+		}), ShouldBeTrue)
+		So(c.Do(target.index, cmd.NewSet("pear", []byte("pear-v1"))).Value, ShouldEqual, "OK")
+		So(c.Do(target.index, cmd.NewSet("apple", []byte("apple-v1"))).Value, ShouldEqual, "OK")
+
+		unknown := cmd.NewMember(cmd.MemberDrain, "node9", "")
+		So(c.Do(other, unknown).Ftype, ShouldEqual, network.Error)
+	})
+}
+
+// suffrage 返回 Leader 上集群配置中每个成员的身份
+func suffrage(c *Cluster) map[raft.ServerID]raft.ServerSuffrage {
+	configFuture := c.WaitLeader().Raft().Raft().GetConfiguration()
+	So(configFuture.Error(), ShouldBeNil)
+	servers := make(map[raft.ServerID]raft.ServerSuffrage)
+	for _, s := range configFuture.Configuration().Servers {
+		servers[s.ID] = s.Suffrage
+	}
+	return servers
+}
+
+func Test_MemberLearner(t *testing.T) {
+	Convey("a learner replicates the data without voting until it is promoted, and a demoted voter stops voting", t, func() {
+		c := New(t, 3)
+		So(suffrage(c), ShouldResemble, map[raft.ServerID]raft.ServerSuffrage{
+			"node0": raft.Voter, "node1": raft.Voter, "node2": raft.Voter,
+		})
+		So(c.Do(0, cmd.NewSet("name", []byte("mars"))).Value, ShouldEqual, "OK")
+
+		i := c.AddNode()
+		learner := c.Node(i)
+		leader := c.WaitLeader()
+		add := cmd.NewMember(cmd.MemberAdd, string(learner.ID), string(learner.Addr), learner.ClientAddr, cmd.MemberLearner)
+		So(c.Do(follower(c, leader), add).Value, ShouldEqual, "OK")
+		So(suffrage(c)[learner.ID], ShouldEqual, raft.Nonvoter)
+		c.WaitConverged()
+		So(learner.Data(), ShouldResemble, map[string]string{"name": "mars"})
+
+		promote := cmd.NewMember(cmd.MemberPromote, string(learner.ID), "")
+		So(c.Do(follower(c, leader), promote).Value, ShouldEqual, "OK")
+		So(suffrage(c), ShouldResemble, map[raft.ServerID]raft.ServerSuffrage{
+			"node0": raft.Voter, "node1": raft.Voter, "node2": raft.Voter, learner.ID: raft.Voter,
+		})
+
+		demoted := c.Node(follower(c, c.WaitLeader()))
+		demote := cmd.NewMember(cmd.MemberDemote, string(demoted.ID), "")
+		So(c.Do(i, demote).Value, ShouldEqual, "OK")
+		servers := suffrage(c)
+		So(servers[demoted.ID], ShouldEqual, raft.Nonvoter)
+		voters := 0
+		for _, s := range servers {
+			if s == raft.Voter {
+				voters++
+			}
+		}
+		So(voters, ShouldEqual, 3)
+		// 降级后的节点继续复制日志
+		So(c.Do(i, cmd.NewSet("age", []byte("25"))).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+		So(demoted.Data(), ShouldResemble, map[string]string{"name": "mars", "age": "25"})
+	})
+}
+
+func Test_MemberListHungNode(t *testing.T) {
+	Convey("a member that accepts requests but never replies does not block member list", t, func() {
+		c := New(t, 3)
+		leader := c.WaitLeader()
+		hung := follower(c, leader)
+		c.Hang(hung, true)
+		start := time.Now()
+		list := c.Do(leader.index, cmd.NewMember(cmd.MemberList, "", "")).Value.(string)
+		So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		for _, line := range strings.Split(list, "\n") {
+			if strings.HasPrefix(line, "id="+string(c.Node(hung).ID)+" ") {
+				So(line, ShouldContainSubstring, "match=unknown lag=unknown")
+			} else {
+				So(line, ShouldNotContainSubstring, "unknown")
+			}
+		}
+		c.Hang(hung, false)
+	})
+}
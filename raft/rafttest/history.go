@@ -0,0 +1,168 @@
+package rafttest
+
+import (
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rejected 这些错误表示命令在提交给 raft 之前就被拒绝了，一定没有生效
+var rejected = []string{
+	errUnreachable.Error(),
+	raft.ErrNotLeader.Error(),
+	"no leader elected",
+	"leader client address is unknown",
+}
+
+// OpKind 客户端操作的类型
+type OpKind int
+
+const (
+	OpGet OpKind = iota
+	OpSet
+	OpDel
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpGet:
+		return cmd.GET
+	case OpSet:
+		return cmd.SET
+	default:
+		return cmd.DELETE
+	}
+}
+
+// Operation 一次客户端操作，Call、Return 为相对于记录开始的纳秒数。
+// 结果未知的写操作（请求失败或超时，可能已经提交）Return 为 math.MaxInt64，
+// 即认为它可能在调用之后的任意时刻生效
+type Operation struct {
+	Client int
+	Kind   OpKind
+	Key    string
+	// SET 写入的值，GET 读到的值
+	Value string
+	// GET 是否读到了值
+	Found bool
+	// DEL 删除的 key 数量
+	Removed int
+	// 写操作的结果是否未知
+	Unknown bool
+	Call    int64
+	Return  int64
+}
+
+func (op Operation) String() string {
+	ret := fmt.Sprint(op.Return)
+	if op.Unknown {
+		ret = "?"
+	}
+	var desc string
+	switch op.Kind {
+	case OpGet:
+		desc = fmt.Sprintf("GET %s -> %q", op.Key, op.Value)
+		if !op.Found {
+			desc = fmt.Sprintf("GET %s -> nil", op.Key)
+		}
+	case OpSet:
+		desc = fmt.Sprintf("SET %s %q", op.Key, op.Value)
+	case OpDel:
+		desc = fmt.Sprintf("DEL %s -> %d", op.Key, op.Removed)
+		if op.Unknown {
+			desc = fmt.Sprintf("DEL %s -> ?", op.Key)
+		}
+	}
+	return fmt.Sprintf("client %d [%d, %s] %s", op.Client, op.Call, ret, desc)
+}
+
+// History 记录并发客户端在集群上执行的操作
+type History struct {
+	mutex sync.Mutex
+	start time.Time
+	ops   []Operation
+}
+
+func NewHistory() *History {
+	return &History{start: time.Now()}
+}
+
+// Get 在第 node 个节点上执行 GET 并记录，失败的读没有副作用，不记录
+func (h *History) Get(c *Cluster, client, node int, key string) {
+	call := h.now()
+	rsp := c.Do(node, cmd.NewGet(key))
+	op := Operation{Client: client, Kind: OpGet, Key: key, Call: call, Return: h.now()}
+	switch rsp.Ftype {
+	case network.Bulk:
+		op.Value, op.Found = string(rsp.Value.([]byte)), true
+	case network.Null:
+	default:
+		return
+	}
+	h.record(op)
+}
+
+// Set 在第 node 个节点上执行 SET 并记录，确定被拒绝的写没有副作用，不记录
+func (h *History) Set(c *Cluster, client, node int, key, value string) {
+	call := h.now()
+	rsp := c.Do(node, cmd.NewSet(key, []byte(value)))
+	op := Operation{Client: client, Kind: OpSet, Key: key, Value: value, Call: call, Return: h.now()}
+	if rsp.Ftype != network.Simple {
+		if isRejected(rsp) {
+			return
+		}
+		op.Unknown, op.Return = true, math.MaxInt64
+	}
+	h.record(op)
+}
+
+// Del 在第 node 个节点上执行 DEL 并记录
+func (h *History) Del(c *Cluster, client, node int, key string) {
+	call := h.now()
+	rsp := c.Do(node, cmd.NewDelete(key))
+	op := Operation{Client: client, Kind: OpDel, Key: key, Call: call, Return: h.now()}
+	if rsp.Ftype == network.Integer {
+		op.Removed = rsp.Value.(int)
+	} else {
+		if isRejected(rsp) {
+			return
+		}
+		op.Unknown, op.Return = true, math.MaxInt64
+	}
+	h.record(op)
+}
+
+// Operations 返回记录的所有操作
+func (h *History) Operations() []Operation {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Operation(nil), h.ops...)
+}
+
+func (h *History) now() int64 {
+	return time.Since(h.start).Nanoseconds()
+}
+
+func (h *History) record(op Operation) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.ops = append(h.ops, op)
+}
+
+func isRejected(rsp *network.Frame) bool {
+	if rsp.Ftype != network.Error {
+		return false
+	}
+	msg, _ := rsp.Value.(string)
+	for _, reason := range rejected {
+		if strings.Contains(msg, reason) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package rafttest
+
+import (
+	"sort"
+)
+
+// CheckLinearizable 检查操作历史是否可线性化。不同 key 的操作互不影响，按 key 分别检查，
+// 每个 key 是一个可以被删除的寄存器。不可线性化时返回一个最小的违例子历史：
+// 除了为其中的读提供值的写，去掉任意一个操作后剩下的历史都可以线性化
+func CheckLinearizable(ops []Operation) (bool, []Operation) {
+	byKey := make(map[string][]Operation)
+	keys := make([]string, 0)
+	for _, op := range ops {
+		if _, ok := byKey[op.Key]; !ok {
+			keys = append(keys, op.Key)
+		}
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !linearizable(byKey[key]) {
+			return false, minimize(byKey[key])
+		}
+	}
+	return true, nil
+}
+
+// minimize 逐个尝试去掉操作，去掉后仍不可线性化就不再保留它。
+// 被保留的 GET 读到的值所对应的 SET 不会被去掉，以免得到读取了从未写入的值这种难以理解的结果
+func minimize(ops []Operation) []Operation {
+	violation := append([]Operation(nil), ops...)
+	for shrunk := true; shrunk; {
+		shrunk = false
+		for i := 0; i < len(violation); {
+			if explainsRead(violation, i) {
+				i++
+				continue
+			}
+			candidate := append(append([]Operation(nil), violation[:i]...), violation[i+1:]...)
+			if !linearizable(candidate) {
+				violation, shrunk = candidate, true
+			} else {
+				i++
+			}
+		}
+	}
+	sort.Slice(violation, func(i, j int) bool {
+		return violation[i].Call < violation[j].Call
+	})
+	return violation
+}
+
+// explainsRead ops[i] 是否是其他操作读到的值的写入者
+func explainsRead(ops []Operation, i int) bool {
+	if ops[i].Kind != OpSet {
+		return false
+	}
+	for _, op := range ops {
+		if op.Kind == OpGet && op.Found && op.Value == ops[i].Value {
+			return true
+		}
+	}
+	return false
+}
+
+// register 单个 key 的状态
+type register struct {
+	value  string
+	exists bool
+}
+
+// step 在状态 s 上执行 op，返回 op 的结果是否与模型一致以及执行后的状态
+func (s register) step(op *Operation) (bool, register) {
+	switch op.Kind {
+	case OpGet:
+		return op.Found == s.exists && op.Value == s.value, s
+	case OpSet:
+		return true, register{value: op.Value, exists: true}
+	default:
+		return op.Unknown || (op.Removed == 1) == s.exists, register{}
+	}
+}
+
+// event 操作的调用或返回，按时间顺序串成双向链表
+type event struct {
+	id     int
+	call   bool
+	time   int64
+	match  *event
+	prev   *event
+	next   *event
+	parent *Operation
+}
+
+// linearizable 使用 Wing & Gong 的回溯搜索，并按 Lowe 的方法缓存
+// （已线性化的操作集合, 状态）剪枝，与 Porcupine 的实现相同
+func linearizable(ops []Operation) bool {
+	head := buildEvents(ops)
+	linearized := newBitset(len(ops))
+	cache := make(map[uint64][]cacheEntry)
+	type frame struct {
+		call  *event
+		state register
+	}
+	var stack []frame
+	state := register{}
+	e := head.next
+	for head.next != nil {
+		if e.call {
+			ok, next := state.step(e.parent)
+			if ok {
+				candidate := linearized.clone().set(e.id)
+				if !cacheContains(cache, candidate, next) {
+					hash := candidate.hash()
+					cache[hash] = append(cache[hash], cacheEntry{candidate, next})
+					stack = append(stack, frame{e, state})
+					state = next
+					linearized.set(e.id)
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+		// 遇到了某个操作的返回，而它之前的调用都无法线性化，需要回溯
+		if len(stack) == 0 {
+			return false
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		state = top.state
+		linearized.clear(top.call.id)
+		unlift(top.call)
+		e = top.call.next
+	}
+	return true
+}
+
+// buildEvents 按时间排序调用和返回，时间相同时调用在前，视为并发
+func buildEvents(ops []Operation) *event {
+	events := make([]*event, 0, 2*len(ops))
+	for i := range ops {
+		call := &event{id: i, call: true, time: ops[i].Call, parent: &ops[i]}
+		ret := &event{id: i, time: ops[i].Return, parent: &ops[i]}
+		call.match, ret.match = ret, call
+		events = append(events, call, ret)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].time != events[j].time {
+			return events[i].time < events[j].time
+		}
+		return events[i].call && !events[j].call
+	})
+	head := &event{}
+	prev := head
+	for _, e := range events {
+		prev.next, e.prev = e, prev
+		prev = e
+	}
+	return head
+}
+
+// lift 从链表中摘下调用和对应的返回
+func lift(call *event) {
+	call.prev.next = call.next
+	if call.next != nil {
+		call.next.prev = call.prev
+	}
+	ret := call.match
+	ret.prev.next = ret.next
+	if ret.next != nil {
+		ret.next.prev = ret.prev
+	}
+}
+
+// unlift 按相反的顺序把 lift 摘下的事件放回原位
+func unlift(call *event) {
+	ret := call.match
+	ret.prev.next = ret
+	if ret.next != nil {
+		ret.next.prev = ret
+	}
+	call.prev.next = call
+	if call.next != nil {
+		call.next.prev = call
+	}
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      register
+}
+
+func cacheContains(cache map[uint64][]cacheEntry, linearized bitset, state register) bool {
+	for _, entry := range cache[linearized.hash()] {
+		if entry.state == state && entry.linearized.equals(linearized) {
+			return true
+		}
+	}
+	return false
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset(nil), b...)
+}
+
+func (b bitset) set(i int) bitset {
+	b[i/64] |= 1 << (uint(i) % 64)
+	return b
+}
+
+func (b bitset) clear(i int) bitset {
+	b[i/64] &^= 1 << (uint(i) % 64)
+	return b
+}
+
+func (b bitset) equals(other bitset) bool {
+	for i := range b {
+		if b[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hash FNV-1a
+func (b bitset) hash() uint64 {
+	hash := uint64(14695981039346656037)
+	for _, word := range b {
+		hash ^= word
+		hash *= 1099511628211
+	}
+	return hash
+}
@@ -0,0 +1,160 @@
+package rafttest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func set(client int, key, value string, call, ret int64) Operation {
+	return Operation{Client: client, Kind: OpSet, Key: key, Value: value, Call: call, Return: ret}
+}
+
+func get(client int, key, value string, call, ret int64) Operation {
+	return Operation{Client: client, Kind: OpGet, Key: key, Value: value, Found: value != "", Call: call, Return: ret}
+}
+
+func del(client int, key string, removed int, call, ret int64) Operation {
+	return Operation{Client: client, Kind: OpDel, Key: key, Removed: removed, Call: call, Return: ret}
+}
+
+func Test_CheckLinearizable(t *testing.T) {
+	Convey("concurrent operations may take effect in any order within their intervals", t, func() {
+		ok, _ := CheckLinearizable([]Operation{
+			set(0, "k", "a", 0, 10),
+			set(1, "k", "b", 5, 20),
+			get(2, "k", "a", 12, 15),
+			get(2, "k", "b", 16, 18),
+			del(0, "k", 1, 30, 40),
+			get(1, "k", "", 41, 42),
+			set(2, "other", "x", 0, 50),
+		})
+		So(ok, ShouldBeTrue)
+	})
+
+	Convey("an unknown write may take effect at any time after its call, or never", t, func() {
+		unknown := set(0, "k", "a", 0, math.MaxInt64)
+		unknown.Unknown = true
+		ok, _ := CheckLinearizable([]Operation{unknown, get(1, "k", "", 10, 20), get(1, "k", "a", 30, 40)})
+		So(ok, ShouldBeTrue)
+		ok, _ = CheckLinearizable([]Operation{unknown, get(1, "k", "a", 10, 20), get(1, "k", "", 30, 40)})
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("a stale read is reported with a minimal violating sub-history", t, func() {
+		ok, violation := CheckLinearizable([]Operation{
+			set(0, "k", "a", 0, 10),
+			set(2, "other", "x", 0, 50),
+			get(1, "k", "a", 11, 12),
+			set(0, "k", "b", 20, 30),
+			get(2, "other", "x", 55, 60),
+			get(1, "k", "a", 35, 40),
+			get(1, "k", "b", 45, 50),
+		})
+		So(ok, ShouldBeFalse)
+		So(violation, ShouldResemble, []Operation{
+			set(0, "k", "a", 0, 10),
+			set(0, "k", "b", 20, 30),
+			get(1, "k", "a", 35, 40),
+		})
+	})
+
+	Convey("a delete must report whether the key existed", t, func() {
+		ok, violation := CheckLinearizable([]Operation{
+			del(0, "k", 1, 0, 10),
+			set(1, "k", "a", 20, 30),
+		})
+		So(ok, ShouldBeFalse)
+		So(violation, ShouldResemble, []Operation{del(0, "k", 1, 0, 10)})
+	})
+}
+
+// Test_Linearizability 并发客户端在不断发生网络分区、Leader 宕机和重启的集群上读写，
+// 检查记录的历史是否可线性化
+func Test_Linearizability(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping linearizability workload in short mode")
+	}
+	const (
+		nodes    = 3
+		clients  = 5
+		duration = 3 * time.Second
+	)
+	keys := []string{"k0", "k1", "k2", "k3"}
+	c := New(t, nodes)
+	h := NewHistory()
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for client := 0; client < clients; client++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(client)))
+			for seq := 0; ; seq++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				node, key := rnd.Intn(nodes), keys[rnd.Intn(len(keys))]
+				switch n := rnd.Intn(10); {
+				case n < 5:
+					h.Get(c, client, node, key)
+				case n < 9:
+					h.Set(c, client, node, key, fmt.Sprintf("%d-%d", client, seq))
+				default:
+					h.Del(c, client, node, key)
+				}
+				time.Sleep(time.Duration(rnd.Intn(10)) * time.Millisecond)
+			}
+		}(client)
+	}
+
+	// nemesis 每次只制造一种故障，保证多数派大部分时间可用
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	deadline := time.Now().Add(duration)
+	killed := -1
+	for time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+		if killed >= 0 {
+			c.Restart(killed)
+			killed = -1
+			continue
+		}
+		switch rnd.Intn(3) {
+		case 0:
+			c.Partition(rnd.Intn(nodes))
+		case 1:
+			c.Heal()
+		default:
+			if leader := c.Leader(); leader != nil {
+				killed = leader.Index()
+				c.Kill(killed)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if killed >= 0 {
+		c.Restart(killed)
+	}
+	c.Heal()
+	c.WaitConverged()
+
+	ops := h.Operations()
+	t.Logf("checking %d operations", len(ops))
+	if ok, violation := CheckLinearizable(ops); !ok {
+		lines := make([]string, len(violation))
+		for i, op := range violation {
+			lines[i] = op.String()
+		}
+		t.Fatalf("history is not linearizable, minimal violating sub-history:\n%s", strings.Join(lines, "\n"))
+	}
+}
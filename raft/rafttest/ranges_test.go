@@ -0,0 +1,138 @@
+package rafttest
+
+import (
+	"fmt"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// waitRanges 等待第 i 个节点上 range list 的输出包含 want 中的每一行
+func waitRanges(c *Cluster, i int, want ...string) string {
+	var list string
+	waitFor(func() bool {
+		list, _ = c.Do(i, cmd.NewRange(cmd.RangeList)).Value.(string)
+		for _, line := range want {
+			if !strings.Contains(list, line) {
+				return false
+			}
+		}
+		return true
+	})
+	return list
+}
+
+func Test_RangeSplitMerge(t *testing.T) {
+	Convey("a range split through a follower moves its keys to a new raft group and merges back", t, func() {
+		c := New(t, 3)
+		leader := c.WaitLeader()
+		for _, key := range []string{"apple", "kiwi", "mango", "pear"} {
+			So(c.Do(follower(c, leader), cmd.NewSet(key, []byte(key+"-v1"))).Value, ShouldEqual, "OK")
+		}
+
+		split := c.Do(follower(c, leader), cmd.NewRange(cmd.RangeSplit, "0", "m"))
+		So(split.Value, ShouldEqual, "OK")
+		list := waitRanges(c, 0, `id=0 start="" end="m"`, `id=1 start="m" end=""`)
+		So(list, ShouldContainSubstring, `id=0 start="" end="m" generation=1 state=active`)
+		So(list, ShouldContainSubstring, `id=1 start="m" end="" generation=1 state=active`)
+		c.WaitConverged()
+		for _, node := range c.aliveNodes() {
+			So(node.Groups(), ShouldResemble, []uint64{0, 1})
+			So(node.Store().Group(1).Range().Start, ShouldEqual, "m")
+		}
+
+		// 写请求由各自区间的 Leader 提交，任意节点都能读到
+		So(c.Do(0, cmd.NewSet("pear", []byte("pear-v2"))).Value, ShouldEqual, "OK")
+		So(c.Do(1, cmd.NewSet("banana", []byte("banana-v1"))).Value, ShouldEqual, "OK")
+		So(c.Do(2, cmd.NewGet("pear")).Value, ShouldResemble, []byte("pear-v2"))
+		So(c.Do(2, cmd.NewGet("kiwi")).Value, ShouldResemble, []byte("kiwi-v1"))
+		c.WaitConverged()
+		So(leader.Data(), ShouldResemble, map[string]string{
+			"apple": "apple-v1", "banana": "banana-v1", "kiwi": "kiwi-v1", "mango": "mango-v1", "pear": "pear-v2",
+		})
+
+		So(c.Do(2, cmd.NewRange(cmd.RangeMerge, "0")).Value, ShouldEqual, "OK")
+		list = waitRanges(c, 1, `id=0 start="" end=""`, `state=removed`)
+		So(list, ShouldContainSubstring, `id=0 start="" end="" generation=2 state=active`)
+		So(list, ShouldContainSubstring, `id=1 start="m" end="" generation=2 state=removed`)
+		c.WaitConverged()
+		for _, node := range c.aliveNodes() {
+			So(node.Groups(), ShouldResemble, []uint64{0})
+		}
+		So(c.Do(1, cmd.NewGet("mango")).Value, ShouldResemble, []byte("mango-v1"))
+		So(leader.Data()["pear"], ShouldEqual, "pear-v2")
+	})
+
+	Convey("a merge moves a large range in several log entries", t, func() {
+		c := New(t, 1)
+		leader := c.WaitLeader()
+		value := strings.Repeat("v", 64<<10)
+		for i := 0; i < 12; i++ {
+			So(c.Do(0, cmd.NewSet(fmt.Sprintf("key%02d", i), []byte(value))).Value, ShouldEqual, "OK")
+		}
+		So(c.Do(0, cmd.NewRange(cmd.RangeSplit, "0", "key")).Value, ShouldEqual, "OK")
+		waitRanges(c, 0, `id=1 start="key" end=""`)
+		c.WaitConverged()
+
+		before := leader.Raft().Raft().LastIndex()
+		So(c.Do(0, cmd.NewRange(cmd.RangeMerge, "0")).Value, ShouldEqual, "OK")
+		// 每条日志最多移交 256KB，再加上合并和两次描述表更新
+		So(leader.Raft().Raft().LastIndex()-before, ShouldBeGreaterThanOrEqualTo, 6)
+		waitRanges(c, 0, `id=0 start="" end=""`)
+		data := leader.Data()
+		So(data, ShouldHaveLength, 12)
+		So(data["key11"], ShouldEqual, value)
+	})
+
+	Convey("keys reserved for range namespaces are rejected", t, func() {
+		c := New(t, 1)
+		rsp := c.Do(0, cmd.NewSet("\x00range/x", []byte("v")))
+		So(rsp.Ftype, ShouldEqual, network.Error)
+	})
+}
+
+func Test_RangeRestart(t *testing.T) {
+	Convey("a restarted node reopens its range groups and catches up", t, func() {
+		c := New(t, 3)
+		for i := 0; i < 6; i++ {
+			So(c.Do(0, cmd.NewSet(fmt.Sprintf("key%d", i), []byte("v1"))).Value, ShouldEqual, "OK")
+		}
+		So(c.Do(0, cmd.NewRange(cmd.RangeSplit, "0", "key3")).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+
+		rangeLeader := c.GroupLeader(1)
+		c.Kill(rangeLeader.index)
+		c.WaitGroupLeader(1)
+		So(c.Do(follower(c, rangeLeader), cmd.NewSet("key4", []byte("v2"))).Value, ShouldEqual, "OK")
+		c.Restart(rangeLeader.index)
+		c.WaitConverged()
+		So(rangeLeader.Groups(), ShouldResemble, []uint64{0, 1})
+		So(rangeLeader.Data()["key4"], ShouldEqual, "v2")
+	})
+}
+
+func Test_RangeAutoSplit(t *testing.T) {
+	Convey("a range with too many keys is split automatically", t, func() {
+		c := NewSharded(t, 3, kvsraft.StoreOptions{
+			SplitKeys:     8,
+			CheckInterval: 100 * time.Millisecond,
+		})
+		for i := 0; i < 20; i++ {
+			So(c.Do(i%3, cmd.NewSet(fmt.Sprintf("key%02d", i), []byte("v"))).Value, ShouldEqual, "OK")
+		}
+		So(waitFor(func() bool {
+			list, _ := c.Do(0, cmd.NewRange(cmd.RangeList)).Value.(string)
+			return strings.Count(list, "state=active") >= 3
+		}), ShouldBeTrue)
+		c.WaitConverged()
+		So(c.Leader().Data(), ShouldHaveLength, 20)
+		for i := 0; i < 20; i++ {
+			So(c.Do(i%3, cmd.NewGet(fmt.Sprintf("key%02d", i))).Value, ShouldResemble, []byte("v"))
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package rafttest
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ForceNewCluster(t *testing.T) {
+	Convey("a single survivor recovers every group after the majority is lost", t, func() {
+		c := New(t, 3)
+		leader := c.WaitLeader()
+		So(c.Do(leader.index, cmd.NewSet("apple", []byte("v1"))).Value, ShouldEqual, "OK")
+		So(c.Do(leader.index, cmd.NewSet("pear", []byte("v1"))).Value, ShouldEqual, "OK")
+		So(c.Do(leader.index, cmd.NewRange(cmd.RangeSplit, "0", "m")).Value, ShouldEqual, "OK")
+		waitRanges(c, 0, `id=0 start="" end="m"`, `id=1 start="m" end=""`)
+		c.WaitConverged()
+		So(c.Do(c.WaitGroupLeader(1).index, cmd.NewSet("pear", []byte("v2"))).Value, ShouldEqual, "OK")
+		c.WaitConverged()
+
+		survivor := c.Node(follower(c, leader))
+		for i := 0; i < 3; i++ {
+			c.Kill(i)
+		}
+		// 只生成报告，不修改数据
+		reports := c.Recover(survivor.index, true, survivor.index)
+		So(reports, ShouldHaveLength, 2)
+		So(reports[1].Group, ShouldEqual, 1)
+		So(reports[1].Configuration.Servers, ShouldHaveLength, 3)
+		So(reports[1].Removed(), ShouldHaveLength, 2)
+		So(c.Recover(survivor.index, true, survivor.index)[0].Configuration.Servers, ShouldHaveLength, 3)
+
+		reports = c.Recover(survivor.index, false, survivor.index)
+		So(reports[0].String(), ShouldEndWith, " -> "+string(survivor.ID))
+		c.Restart(survivor.index)
+		So(c.WaitLeader(), ShouldEqual, survivor)
+		So(c.WaitGroupLeader(1), ShouldEqual, survivor)
+		So(c.Do(survivor.index, cmd.NewGet("apple")).Value, ShouldResemble, []byte("v1"))
+		So(c.Do(survivor.index, cmd.NewGet("pear")).Value, ShouldResemble, []byte("v2"))
+		So(c.Do(survivor.index, cmd.NewSet("pear", []byte("v3"))).Value, ShouldEqual, "OK")
+		list := c.Do(survivor.index, cmd.NewMember(cmd.MemberList, "", "")).Value.(string)
+		So(strings.Count(list, "\n"), ShouldEqual, 0)
+		So(list, ShouldContainSubstring, "id="+string(survivor.ID)+" ")
+	})
+
+	Convey("recovery needs existing state and the local node among the members", t, func() {
+		store := raft.NewInmemStore()
+		storage := kvsraft.GroupStorage{LogStore: store, StableStore: store, Snapshots: raft.NewInmemSnapshotStore()}
+		servers := []raft.Server{{Suffrage: raft.Voter, ID: "a", Address: "raft-a"}}
+		_, err := kvsraft.RecoverGroup(0, storage, kvsraft.RecoverOptions{ServerID: "a", Servers: servers})
+		So(err, ShouldNotBeNil)
+		_, err = kvsraft.RecoverGroup(0, storage, kvsraft.RecoverOptions{ServerID: "b", Servers: servers})
+		So(err.Error(), ShouldContainSubstring, "not one of the recovered members")
+		_, err = kvsraft.RecoverGroup(0, storage, kvsraft.RecoverOptions{ServerID: "a", Servers: append(servers, servers[0])})
+		So(err.Error(), ShouldContainSubstring, "listed twice")
+	})
+}
@@ -0,0 +1,77 @@
+package rafttest
+
+import (
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	kvsraft "github.com/huiming23344/kv-raft/raft"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// slotSpans 返回 CLUSTER SLOTS 中每段槽位的起止和主节点 ID
+func slotSpans(c *Cluster, i int) [][3]interface{} {
+	var spans [][3]interface{}
+	for _, entry := range c.Do(i, cmd.NewCluster(cmd.ClusterSlots)).Value.([]*network.Frame) {
+		fields := entry.Value.([]*network.Frame)
+		master := fields[2].Value.([]*network.Frame)
+		spans = append(spans, [3]interface{}{fields[0].Value, fields[1].Value, master[2].Value})
+	}
+	return spans
+}
+
+func Test_ClusterSlots(t *testing.T) {
+	Convey("in cluster mode slots follow range leaders and other nodes redirect", t, func() {
+		c := NewSharded(t, 3, kvsraft.StoreOptions{Slots: true})
+		leader := c.WaitLeader()
+		So(slotSpans(c, 0), ShouldResemble, [][3]interface{}{{0, 16383, string(leader.ID)}})
+
+		get := cmd.NewGet("foo")
+		So(leader.Store().Redirect(get, false), ShouldBeNil)
+		other := c.Node(follower(c, leader))
+		So(other.Store().Redirect(get, false).Value, ShouldEqual, "MOVED 12182 "+leader.ClientAddr)
+		// 非 key 命令不重定向
+		So(other.Store().Redirect(cmd.NewCluster(cmd.ClusterNodes), false), ShouldBeNil)
+
+		// 分裂点对齐到 key 所在槽位的起点
+		So(c.Do(other.index, cmd.NewSet("foo", []byte("v1"))).Value, ShouldEqual, "OK")
+		So(c.Do(other.index, cmd.NewRange(cmd.RangeSplit, "0", "foo")).Value, ShouldEqual, "OK")
+		waitRanges(c, 0, "slots=0-12181", "slots=12182-16383")
+		c.WaitConverged()
+		rangeLeader := c.WaitGroupLeader(1)
+		So(waitFor(func() bool {
+			spans := slotSpans(c, 2)
+			return len(spans) == 2 && spans[1] == [3]interface{}{12182, 16383, string(rangeLeader.ID)}
+		}), ShouldBeTrue)
+		So(c.Do(0, cmd.NewGet("foo")).Value, ShouldResemble, []byte("v1"))
+
+		// 合并期间冻结的区间的 Leader 回复 -ASK，左侧区间的 Leader 只接受带 ASKING 的命令
+		leftLeader := c.WaitGroupLeader(0)
+		So(c.Do(0, cmd.NewRange(cmd.RangeFreeze, "1", "1")).Ftype, ShouldEqual, network.Integer)
+		So(rangeLeader.Store().Redirect(get, false).Value, ShouldEqual, "ASK 12182 "+leftLeader.ClientAddr)
+		So(waitFor(func() bool {
+			return leftLeader.Store().Redirect(get, true) == nil
+		}), ShouldBeTrue)
+		if leftLeader != rangeLeader {
+			So(leftLeader.Store().Redirect(get, false).Value, ShouldEqual, "MOVED 12182 "+rangeLeader.ClientAddr)
+		}
+		So(c.Do(0, cmd.NewRange(cmd.RangeFreeze, "1", "0")).Ftype, ShouldEqual, network.Integer)
+
+		nodes := c.Do(leader.index, cmd.NewCluster(cmd.ClusterNodes)).Value.(string)
+		So(strings.Count(nodes, "\n"), ShouldEqual, 3)
+		So(nodes, ShouldContainSubstring, string(leader.ID)+" "+leader.ClientAddr+":0@0 myself,master")
+		So(nodes, ShouldContainSubstring, " 12182-16383")
+
+		shards := c.Do(1, cmd.NewCluster(cmd.ClusterShards)).Value.([]*network.Frame)
+		So(shards, ShouldHaveLength, 2)
+		So(shards[0].Value.([]*network.Frame)[3].Value, ShouldHaveLength, 3)
+	})
+
+	Convey("cluster commands other than KEYSLOT need cluster mode", t, func() {
+		c := New(t, 1)
+		So(c.Do(0, cmd.NewCluster(cmd.ClusterSlots)).Ftype, ShouldEqual, network.Error)
+		So(c.Do(0, cmd.NewCluster(cmd.ClusterKeySlot, "foo")).Value, ShouldEqual, 12182)
+		So(c.Node(0).Store().Redirect(cmd.NewGet("foo"), false), ShouldBeNil)
+	})
+}
@@ -0,0 +1,423 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"log"
+	"strings"
+)
+
+// 区间不负责请求的 key 时返回的错误前缀，Store 据此重新路由
+const tryAgain = "TRYAGAIN"
+
+var (
+	errRangesDisabled = errors.New("ranges are not enabled on this node")
+	errRangeCommand   = errors.New("range log commands cannot be sent by clients")
+	errRangeUnknown   = fmt.Errorf("%s range is not initialized yet", tryAgain)
+)
+
+// RangeDescriptor 描述区间 [Start, End) 及负责它的 raft 组，End 为空表示没有上界。
+// 每个组的状态机保存自己的描述，并且只通过本组的日志修改，数据的移交与描述的修改是原子的。
+// 元数据组（0 号组）另外保存所有区间的描述表，用于路由以及在各节点上创建和删除组
+type RangeDescriptor struct {
+	ID    uint64
+	Start string
+	End   string
+	// 每次分裂、合并时加一，元数据表只接受不旧于当前的描述
+	Generation uint64
+	// 正在合并进左侧相邻的区间，不再接受读写
+	Frozen bool
+	// 已经合并进左侧相邻的区间，各节点关闭该组并删除数据
+	Removed bool
+}
+
+func (d *RangeDescriptor) Contains(key string) bool {
+	return key >= d.Start && (d.End == "" || key < d.End)
+}
+
+func (d *RangeDescriptor) State() string {
+	switch {
+	case d.Removed:
+		return "removed"
+	case d.Frozen:
+		return "frozen"
+	default:
+		return "active"
+	}
+}
+
+// commandKey 返回需要按 key 路由的命令的 key
+func commandKey(command cmd.Command) (string, bool) {
+	switch c := command.(type) {
+	case *cmd.Get:
+		return c.Key(), true
+	case *cmd.Set:
+		return c.Key(), true
+	case *cmd.Delete:
+		return c.Key(), true
+	case *cmd.Session:
+		return commandKey(c.Command())
+	default:
+		return "", false
+	}
+}
+
+func isTryAgain(frame *network.Frame) bool {
+	if frame.Ftype != network.Error {
+		return false
+	}
+	msg, ok := frame.Value.(string)
+	return ok && strings.HasPrefix(msg, tryAgain)
+}
+
+// rangeHost 由 Store 实现，应用分裂日志时在本节点创建新的组，
+// 返回 nil 时新的组已经在本节点上存在并持有移交的数据
+type rangeHost interface {
+	createRange(desc RangeDescriptor, servers []raft.Server, pairs []kvPair, sessions map[string]*clientSession) error
+}
+
+// rangeCommand 只出现在 raft 日志中的区间管理命令，由 Store 提交，客户端不能发送
+type rangeCommand struct{}
+
+func (rangeCommand) Apply(engines.KvsEngine) *network.Frame {
+	return errorFrame(errRangeCommand)
+}
+
+func (rangeCommand) IntoFrame() *network.Frame {
+	return errorFrame(errRangeCommand)
+}
+
+func (rangeCommand) Name() string {
+	return cmd.RANGE
+}
+
+// splitRange 在父区间的组中提交，key 及之后的数据移交给新的组
+type splitRange struct {
+	rangeCommand
+	key     string
+	childID uint64
+	// 新组的初始成员，与提交时父区间的组相同
+	servers []raft.Server
+}
+
+// mergeRange 在左侧区间的组中提交，带有已冻结的右侧区间的描述和会话，之后左侧区间负责两个区间的并集。
+// 数据由之前的 mergeData 分批移交，旧版本的日志在 pairs 中带有全部数据
+type mergeRange struct {
+	rangeCommand
+	right    RangeDescriptor
+	pairs    []kvPair
+	sessions map[string]*clientSession
+}
+
+// mergeData 在左侧区间的组中提交，分批移交已冻结的右侧区间的数据，每条日志的数据量不超过 mergeChunkSize。
+// 第一批的 clear 为 true，先删除之前失败的合并留在本组中的右侧区间的数据，合并失败时也用它清理
+type mergeData struct {
+	rangeCommand
+	right RangeDescriptor
+	clear bool
+	pairs []kvPair
+}
+
+// freezeRange 在右侧区间的组中提交，合并期间冻结区间，合并失败时解冻
+type freezeRange struct {
+	rangeCommand
+	frozen bool
+}
+
+// updateRange 在元数据组中提交，更新描述表
+type updateRange struct {
+	rangeCommand
+	desc RangeDescriptor
+}
+
+// allocRange 在元数据组中提交，分配新的区间 ID
+type allocRange struct {
+	rangeCommand
+}
+
+// descriptor 返回本组区间描述的副本，尚未得知时返回 nil
+func (f *FSM) descriptor() *RangeDescriptor {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	if f.desc == nil {
+		return nil
+	}
+	desc := *f.desc
+	return &desc
+}
+
+func (f *FSM) setDescriptor(desc *RangeDescriptor) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.desc = desc
+}
+
+// checkKey 检查 key 是否由本组负责
+func (f *FSM) checkKey(key string) error {
+	desc := f.descriptor()
+	switch {
+	case desc == nil:
+		return errRangeUnknown
+	case desc.Frozen:
+		return fmt.Errorf("%s range %d is merging", tryAgain, desc.ID)
+	case !desc.Contains(routingKey(key, f.slots)):
+		return fmt.Errorf("%s range %d does not contain key %q", tryAgain, desc.ID, key)
+	}
+	return nil
+}
+
+// rangeTable 返回元数据表的副本
+func (f *FSM) rangeTable() []RangeDescriptor {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	table := make([]RangeDescriptor, 0, len(f.ranges))
+	for _, desc := range f.ranges {
+		table = append(table, desc)
+	}
+	return table
+}
+
+func (f *FSM) rangeEntry(id uint64) (RangeDescriptor, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	desc, ok := f.ranges[id]
+	return desc, ok
+}
+
+// frozenState 返回冻结时保存的会话副本，合并时随数据一起移交
+func (f *FSM) frozenState() map[string]*clientSession {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.frozenSessions
+}
+
+// applyRange 执行区间管理命令
+func (f *FSM) applyRange(command cmd.Command) *network.Frame {
+	switch c := command.(type) {
+	case *splitRange:
+		return f.applySplit(c)
+	case *mergeRange:
+		return f.applyMerge(c)
+	case *mergeData:
+		return f.applyMergeData(c)
+	case *freezeRange:
+		return f.applyFreeze(c)
+	case *updateRange:
+		return f.applyUpdate(c)
+	case *allocRange:
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		id := max(f.nextRangeID, 1)
+		f.nextRangeID = id + 1
+		return &network.Frame{
+			Ftype: network.Integer,
+			Value: int(id),
+		}
+	}
+	return errorFrame(fmt.Errorf("unknown range command %T", command))
+}
+
+// applySplit 把 key 及之后的数据交给新的组，之后本组只负责 key 之前的部分。
+// 只有新的组在本节点上创建成功后才删除移交的数据
+func (f *FSM) applySplit(c *splitRange) *network.Frame {
+	desc := f.descriptor()
+	if f.host == nil {
+		return errorFrame(errRangesDisabled)
+	}
+	if desc == nil || desc.Frozen {
+		return errorFrame(fmt.Errorf("range cannot be split now"))
+	}
+	if c.key <= desc.Start || !desc.Contains(c.key) {
+		return errorFrame(fmt.Errorf("split key %q is not inside range %d", c.key, desc.ID))
+	}
+	child := RangeDescriptor{
+		ID:         c.childID,
+		Start:      c.key,
+		End:        desc.End,
+		Generation: desc.Generation + 1,
+	}
+	var pairs []kvPair
+	err := f.db.Scan(func(key string, value []byte) bool {
+		if child.Contains(routingKey(key, f.slots)) {
+			pairs = append(pairs, kvPair{key, string(value)})
+		}
+		return true
+	})
+	if err != nil {
+		return errorFrame(err)
+	}
+	// 新的组没有创建出来时不删除数据、不缩小区间，分裂失败，本组仍然负责整个区间
+	if err := f.host.createRange(child, c.servers, pairs, f.copySessions()); err != nil {
+		return errorFrame(fmt.Errorf("split range %d: %w", desc.ID, err))
+	}
+	for _, pair := range pairs {
+		if err := f.db.Remove(pair.key); err != nil {
+			log.Printf("range %d: remove moved key %q failed: %v", desc.ID, pair.key, err)
+		}
+	}
+	desc.End = c.key
+	desc.Generation++
+	f.setDescriptor(desc)
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+// applyMerge 接收右侧区间的数据和会话，之后本组负责两个区间的并集
+func (f *FSM) applyMerge(c *mergeRange) *network.Frame {
+	desc := f.descriptor()
+	if desc == nil || desc.Frozen {
+		return errorFrame(fmt.Errorf("range cannot be merged now"))
+	}
+	if desc.End == c.right.End && desc.End != c.right.Start {
+		// 重复提交的合并
+		return &network.Frame{
+			Ftype: network.Simple,
+			Value: "OK",
+		}
+	}
+	if desc.End != c.right.Start {
+		return errorFrame(fmt.Errorf("range %d is not adjacent to range %d", c.right.ID, desc.ID))
+	}
+	for _, pair := range c.pairs {
+		if err := f.db.Set(pair.key, []byte(pair.value)); err != nil {
+			return errorFrame(err)
+		}
+	}
+	for id, s := range c.sessions {
+		if old, ok := f.sessions[id]; !ok || old.seq < s.seq {
+			f.sessions[id] = s
+		}
+	}
+	desc.End = c.right.End
+	desc.Generation = max(desc.Generation, c.right.Generation) + 1
+	f.setDescriptor(desc)
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+// applyMergeData 写入右侧区间的一批数据。左侧区间此时还不负责这些 key，checkKey 拒绝客户端的读写，
+// 直到 mergeRange 扩大区间后才可见
+func (f *FSM) applyMergeData(c *mergeData) *network.Frame {
+	desc := f.descriptor()
+	if desc == nil || desc.Frozen {
+		return errorFrame(fmt.Errorf("range cannot be merged now"))
+	}
+	if desc.End != c.right.Start {
+		return errorFrame(fmt.Errorf("range %d is not adjacent to range %d", c.right.ID, desc.ID))
+	}
+	if c.clear {
+		if err := f.clearRange(c.right); err != nil {
+			return errorFrame(err)
+		}
+	}
+	for _, pair := range c.pairs {
+		if !c.right.Contains(routingKey(pair.key, f.slots)) {
+			return errorFrame(fmt.Errorf("key %q is not inside range %d", pair.key, c.right.ID))
+		}
+		if err := f.db.Set(pair.key, []byte(pair.value)); err != nil {
+			return errorFrame(err)
+		}
+	}
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+// clearRange 删除本组中属于 desc 区间的 key
+func (f *FSM) clearRange(desc RangeDescriptor) error {
+	view, err := f.db.View()
+	if err != nil {
+		return err
+	}
+	defer view.Close()
+	// 按槽位划分时 key 的顺序与路由键不同，需要遍历全部数据
+	start, end := desc.Start, desc.End
+	if f.slots {
+		start, end = "", ""
+	}
+	var removeErr error
+	err = view.Scan(start, end, func(key string, _ []byte) bool {
+		if desc.Contains(routingKey(key, f.slots)) {
+			removeErr = f.db.Remove(key)
+		}
+		return removeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return removeErr
+}
+
+// applyFreeze 冻结或解冻区间，返回本条日志的位置。冻结后区间的数据和会话不再变化，
+// 合并方等本地副本应用到该位置后即可读出完整的数据
+func (f *FSM) applyFreeze(c *freezeRange) *network.Frame {
+	desc := f.descriptor()
+	if desc == nil {
+		return errorFrame(errRangeUnknown)
+	}
+	desc.Frozen = c.frozen
+	sessions := f.copySessions()
+	f.mutex.Lock()
+	f.desc = desc
+	f.frozenSessions = sessions
+	f.mutex.Unlock()
+	return &network.Frame{
+		Ftype: network.Integer,
+		Value: int(f.index),
+	}
+}
+
+// applyUpdate 更新元数据表，旧版本的描述不会覆盖新版本，已删除的区间不会复活
+func (f *FSM) applyUpdate(c *updateRange) *network.Frame {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	old, ok := f.ranges[c.desc.ID]
+	if !ok || c.desc.Generation > old.Generation || (c.desc.Generation == old.Generation && !old.Removed) {
+		f.ranges[c.desc.ID] = c.desc
+	}
+	f.nextRangeID = max(f.nextRangeID, c.desc.ID+1)
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+func (f *FSM) copySessions() map[string]*clientSession {
+	sessions := make(map[string]*clientSession, len(f.sessions))
+	for id, s := range f.sessions {
+		session := *s
+		sessions[id] = &session
+	}
+	return sessions
+}
+
+// 区间描述的标志位，用于日志和快照的编码
+const (
+	rangeFrozen byte = 1 << iota
+	rangeRemoved
+)
+
+func descriptorFlags(desc *RangeDescriptor) byte {
+	var flags byte
+	if desc.Frozen {
+		flags |= rangeFrozen
+	}
+	if desc.Removed {
+		flags |= rangeRemoved
+	}
+	return flags
+}
+
+func setDescriptorFlags(desc *RangeDescriptor, flags byte) {
+	desc.Frozen = flags&rangeFrozen != 0
+	desc.Removed = flags&rangeRemoved != 0
+}
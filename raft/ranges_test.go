@@ -0,0 +1,71 @@
+package raft
+
+import (
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingHost 无法创建新的组
+type failingHost struct{}
+
+func (failingHost) createRange(RangeDescriptor, []raft.Server, []kvPair, map[string]*clientSession) error {
+	return errors.New("disk full")
+}
+
+func Test_SplitWithoutChildGroup(t *testing.T) {
+	Convey("a split whose child group cannot be created keeps the keys and the range", t, func() {
+		quietLog(t)
+		engine, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		fsm := NewFSM(engine)
+		now := time.Unix(1700000000, 0)
+		So(engine.Set("a", []byte("1")), ShouldBeNil)
+		So(engine.Set("x", []byte("2")), ShouldBeNil)
+
+		split := &splitRange{key: "m", childID: 1}
+		So(applyAt(fsm, split, now).Value, ShouldEqual, errRangesDisabled.Error())
+		fsm.host = failingHost{}
+		rsp := applyAt(fsm, split, now)
+		So(rsp.Ftype, ShouldEqual, network.Error)
+		So(rsp.Value, ShouldContainSubstring, "disk full")
+
+		value, err := engine.Get("x")
+		So(err, ShouldBeNil)
+		So(string(value), ShouldEqual, "2")
+		So(fsm.descriptor(), ShouldResemble, &RangeDescriptor{})
+	})
+}
+
+func Test_MergeData(t *testing.T) {
+	Convey("merged data is moved in chunks and only becomes visible with the final merge", t, func() {
+		quietLog(t)
+		engine, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		fsm := NewFSM(engine)
+		fsm.desc = &RangeDescriptor{ID: 1, End: "m", Generation: 1}
+		now := time.Unix(1700000000, 0)
+		right := RangeDescriptor{ID: 2, Start: "m", Generation: 1, Frozen: true}
+		// 上一次失败的合并留下的数据
+		So(engine.Set("stale", []byte("old")), ShouldBeNil)
+
+		So(applyAt(fsm, &mergeData{right: right, clear: true, pairs: []kvPair{{"mango", "1"}}}, now).Value, ShouldEqual, "OK")
+		So(applyAt(fsm, &mergeData{right: right, pairs: []kvPair{{"pear", "2"}}}, now).Value, ShouldEqual, "OK")
+		_, err = engine.Get("stale")
+		So(err, ShouldNotBeNil)
+		So(applyAt(fsm, &mergeData{right: right, pairs: []kvPair{{"apple", "3"}}}, now).Ftype, ShouldEqual, network.Error)
+		So(applyAt(fsm, &mergeData{right: RangeDescriptor{ID: 3, Start: "t"}}, now).Ftype, ShouldEqual, network.Error)
+		So(fsm.checkKey("mango"), ShouldNotBeNil)
+
+		So(applyAt(fsm, &mergeRange{right: right}, now).Value, ShouldEqual, "OK")
+		So(fsm.checkKey("mango"), ShouldBeNil)
+		value, err := engine.Get("pear")
+		So(err, ShouldBeNil)
+		So(string(value), ShouldEqual, "2")
+	})
+}
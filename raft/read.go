@@ -0,0 +1,126 @@
+package raft
+
+import (
+	"errors"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"time"
+)
+
+const readTimeout = 5 * time.Second
+
+var (
+	errReadTimeout   = errors.New("timed out waiting for the read index to be applied")
+	errVerifyTimeout = errors.New("timed out confirming leadership for the read index")
+)
+
+// Get 按读一致性级别执行 GET 命令
+func (r *Node) Get(command *cmd.Get) *network.Frame {
+	mode := command.Consistency()
+	if mode == "" {
+		mode = r.readConsistency
+	}
+	if mode == cmd.ReadStale {
+		if err := r.fsm.checkKey(command.Key()); err != nil {
+			return errorFrame(err)
+		}
+		return command.Apply(r.engine)
+	}
+	if !r.isLeader() {
+		// 代理调用 Leader，显式带上一致性级别，避免 Leader 使用它自己的默认值
+		return r.forward(cmd.NewGetWithConsistency(command.Key(), mode).IntoFrame())
+	}
+	var err error
+	if mode == cmd.ReadLease {
+		err = r.leaseRead()
+	} else {
+		err = r.readIndex()
+	}
+	if err != nil {
+		return errorFrame(err)
+	}
+	// 读到的区间描述不旧于 read index，分裂或合并后不会读到已移交的数据
+	if err := r.fsm.checkKey(command.Key()); err != nil {
+		return errorFrame(err)
+	}
+	return command.Apply(r.engine)
+}
+
+// readIndex 记录当前的 commit index，通过一轮心跳确认自己仍是 Leader，
+// 再等待状态机应用到该位置，之后读取本地数据即满足线性一致
+func (r *Node) readIndex() error {
+	if err := r.waitLeaderReady(); err != nil {
+		return err
+	}
+	index := r.raft.CommitIndex()
+	if err := r.verifyLeader(); err != nil {
+		return err
+	}
+	return r.waitApplied(index)
+}
+
+// verifyLeader 通过一轮心跳确认自己仍是 Leader。raft 关闭时还在队列中的确认请求不会再响应，
+// 因此最多等待 readTimeout
+func (r *Node) verifyLeader() error {
+	future := r.raft.VerifyLeader()
+	verified := make(chan error, 1)
+	go func() { verified <- future.Error() }()
+	timer := time.NewTimer(readTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-verified:
+		return err
+	case <-timer.C:
+		return errVerifyTimeout
+	}
+}
+
+// leaseRead Leader 在 LeaderLeaseTimeout 内联系不上多数派就会退位，
+// 因此仍处于 Leader 状态时可直接读本地数据，代价是依赖时钟
+func (r *Node) leaseRead() error {
+	if r.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+	return r.waitLeaderReady()
+}
+
+// waitLeaderReady 新 Leader 的 commit index 可能落后，
+// 需要等到本任期提交过一条日志后，才能用它作为 read index
+func (r *Node) waitLeaderReady() error {
+	gen := r.leaderGen.Load()
+	if r.readyGen.Load() == gen {
+		return nil
+	}
+	if err := r.raft.Barrier(readTimeout).Error(); err != nil {
+		return err
+	}
+	r.readyGen.Store(gen)
+	return nil
+}
+
+func (r *Node) waitApplied(index uint64) error {
+	if r.raft.AppliedIndex() >= index {
+		return nil
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(readTimeout)
+	for {
+		select {
+		case <-ticker.C:
+			if r.raft.AppliedIndex() >= index {
+				return nil
+			}
+		case <-timeout:
+			return errReadTimeout
+		}
+	}
+}
+
+// observeLeadership 消费 raft 的 NotifyCh，每次角色变化都使 Leader 就绪状态失效
+func (r *Node) observeLeadership(notifyCh <-chan bool) {
+	for range notifyCh {
+		r.leaderGen.Add(1)
+	}
+}
@@ -0,0 +1,405 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	kvscfg "github.com/huiming23344/kv-raft/config"
+	"github.com/huiming23344/kv-raft/db/engines"
+	kvsError "github.com/huiming23344/kv-raft/errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 多数节点永久丢失后集群无法再选出 Leader。强制恢复在存活的节点停止时，用本地的日志和快照
+// 回放出最新的状态，写出一个集群配置只包含存活成员的新快照并清空日志，之后这些节点重新启动
+// 即可组成新的集群。只有存活节点上已经写入的数据会保留，其余节点上独有的写入会丢失
+
+// RecoverOptions 强制恢复集群的参数
+type RecoverOptions struct {
+	// 节点的 raft 数据目录
+	DataDir  string
+	ServerID raft.ServerID
+	// 恢复后的集群成员，必须包含本节点
+	Servers []raft.Server
+	// 回放日志时使用，需要与节点的配置一致
+	Slots      bool
+	SessionTTL time.Duration
+	// 只检查并返回报告，不修改数据
+	DryRun bool
+}
+
+// RecoverOptionsFromConfig 按节点的配置生成只保留本节点的恢复参数
+func RecoverOptionsFromConfig(cfg *kvscfg.Config) (RecoverOptions, error) {
+	_, _, raftAddr, err := raftAddress(cfg)
+	if err != nil {
+		return RecoverOptions{}, err
+	}
+	suffrage := raft.Voter
+	if !cfg.Raft.Voter {
+		suffrage = raft.Nonvoter
+	}
+	return RecoverOptions{
+		DataDir:  cfg.Raft.DataDir,
+		ServerID: raft.ServerID(cfg.Raft.NodeID),
+		Servers: []raft.Server{{
+			Suffrage: suffrage,
+			ID:       raft.ServerID(cfg.Raft.NodeID),
+			Address:  raft.ServerAddress(raftAddr),
+		}},
+		Slots:      cfg.Server.ClusterEnabled,
+		SessionTTL: time.Duration(cfg.Raft.SessionTTL) * time.Second,
+	}, nil
+}
+
+// RecoverReport 一个组恢复前的状态和恢复后的成员
+type RecoverReport struct {
+	Group uint64
+	// 最新快照和最后一条日志的位置，恢复时回放两者之间的日志
+	SnapshotIndex uint64
+	LastIndex     uint64
+	LastTerm      uint64
+	// 恢复前最新的集群配置
+	Configuration raft.Configuration
+	// 恢复后的集群配置
+	Servers []raft.Server
+	// 回放的日志中分裂出的区间，本地没有它们的数据时无法恢复
+	Splits []uint64
+}
+
+// Removed 返回恢复后不再属于集群的成员
+func (r *RecoverReport) Removed() []raft.ServerID {
+	kept := make(map[raft.ServerID]bool, len(r.Servers))
+	for _, server := range r.Servers {
+		kept[server.ID] = true
+	}
+	var removed []raft.ServerID
+	for _, server := range r.Configuration.Servers {
+		if !kept[server.ID] {
+			removed = append(removed, server.ID)
+		}
+	}
+	return removed
+}
+
+func (r *RecoverReport) String() string {
+	return fmt.Sprintf("group=%d snapshot-index=%d last-index=%d last-term=%d servers=%s -> %s",
+		r.Group, r.SnapshotIndex, r.LastIndex, r.LastTerm, serverIDs(r.Configuration.Servers), serverIDs(r.Servers))
+}
+
+func serverIDs(servers []raft.Server) string {
+	if len(servers) == 0 {
+		return "-"
+	}
+	ids := make([]string, 0, len(servers))
+	for _, server := range servers {
+		ids = append(ids, string(server.ID))
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// Recover 在节点停止时把元数据组和本地所有区间组的集群配置改写为 opts.Servers。
+// 先检查所有组，任何一个组无法恢复时不修改数据
+func Recover(opts RecoverOptions) ([]RecoverReport, error) {
+	if err := checkRecoverServers(opts); err != nil {
+		return nil, err
+	}
+	groups, err := localGroups(filepath.Join(opts.DataDir, "ranges"))
+	if err != nil {
+		return nil, err
+	}
+	groups = append([]uint64{0}, groups...)
+	storages := make([]GroupStorage, 0, len(groups))
+	defer func() {
+		for _, storage := range storages {
+			for _, closer := range storage.Closers {
+				_ = closer.Close()
+			}
+		}
+	}()
+	reports := make([]RecoverReport, 0, len(groups))
+	for _, id := range groups {
+		storage, err := openRecoverStorage(GroupDir(opts.DataDir, id), opts.DryRun)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", id, err)
+		}
+		storages = append(storages, storage)
+		report, err := inspectGroup(id, storage)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", id, err)
+		}
+		report.Servers = opts.Servers
+		reports = append(reports, report)
+	}
+	if opts.DryRun {
+		return reports, nil
+	}
+	for i, id := range groups {
+		if reports[i].Splits, err = recoverGroup(id, storages[i], opts); err != nil {
+			return reports[:i], fmt.Errorf("group %d: %w", id, err)
+		}
+	}
+	return reports, nil
+}
+
+// RecoverGroup 强制恢复一个组，storage 为组的 raft 存储，调用方负责关闭
+func RecoverGroup(group uint64, storage GroupStorage, opts RecoverOptions) (RecoverReport, error) {
+	if err := checkRecoverServers(opts); err != nil {
+		return RecoverReport{}, err
+	}
+	report, err := inspectGroup(group, storage)
+	if err != nil {
+		return report, err
+	}
+	report.Servers = opts.Servers
+	if !opts.DryRun {
+		report.Splits, err = recoverGroup(group, storage, opts)
+	}
+	return report, err
+}
+
+// checkRecoverServers 与 raft.RecoverCluster 对新配置的检查相同，提前报告以免只恢复了部分组
+func checkRecoverServers(opts RecoverOptions) error {
+	ids := make(map[raft.ServerID]bool, len(opts.Servers))
+	addrs := make(map[raft.ServerAddress]bool, len(opts.Servers))
+	for _, server := range opts.Servers {
+		if server.ID == "" || server.Address == "" {
+			return fmt.Errorf("recovered member %q needs both an id and a raft address", server.ID)
+		}
+		if ids[server.ID] || addrs[server.Address] {
+			return fmt.Errorf("recovered member %s=%s is listed twice", server.ID, server.Address)
+		}
+		ids[server.ID], addrs[server.Address] = true, true
+		if server.ID == opts.ServerID && server.Suffrage != raft.Voter {
+			return fmt.Errorf("server %s must be a voter of the recovered cluster", opts.ServerID)
+		}
+	}
+	if !ids[opts.ServerID] {
+		return fmt.Errorf("server %s is not one of the recovered members", opts.ServerID)
+	}
+	return nil
+}
+
+// openRecoverStorage 打开组的 bolt 存储和快照，节点运行时持有文件锁，打开会超时
+func openRecoverStorage(dir string, readOnly bool) (GroupStorage, error) {
+	if _, err := os.Stat(filepath.Join(dir, "raft-log.bolt")); err != nil {
+		return GroupStorage{}, err
+	}
+	logStore, err := openBoltStore(filepath.Join(dir, "raft-log.bolt"), readOnly)
+	if err != nil {
+		return GroupStorage{}, err
+	}
+	stableStore, err := openBoltStore(filepath.Join(dir, "raft-stable.bolt"), readOnly)
+	if err != nil {
+		_ = logStore.Close()
+		return GroupStorage{}, err
+	}
+	// 恢复写出新的快照时不删除旧的快照
+	var snapshots raft.SnapshotStore = raft.NewInmemSnapshotStore()
+	// 快照目录不存在时 NewFileSnapshotStore 会创建它，只读时视为没有快照
+	if _, statErr := os.Stat(filepath.Join(dir, "snapshots")); statErr == nil || !readOnly {
+		snapshots, err = raft.NewFileSnapshotStore(dir, math.MaxInt32, io.Discard)
+	}
+	if err != nil {
+		_ = logStore.Close()
+		_ = stableStore.Close()
+		return GroupStorage{}, err
+	}
+	return GroupStorage{
+		LogStore:    logStore,
+		StableStore: stableStore,
+		Snapshots:   snapshots,
+		Closers:     []io.Closer{logStore, stableStore},
+	}, nil
+}
+
+func openBoltStore(path string, readOnly bool) (*raftboltdb.BoltStore, error) {
+	// bolt 以只读方式打开不存在的文件时也会创建它
+	if _, err := os.Stat(path); readOnly && err != nil {
+		return nil, err
+	}
+	store, err := raftboltdb.New(raftboltdb.Options{
+		Path:        path,
+		BoltOptions: &bolt.Options{ReadOnly: readOnly, Timeout: time.Second},
+	})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("open %s: file is locked, stop the node first", path)
+	}
+	return store, err
+}
+
+// inspectGroup 读取组的最新快照、最后一条日志和最新的集群配置。与 raft 一样，
+// 日志中最后一条配置变更即使还没有提交也视为当前配置
+func inspectGroup(group uint64, storage GroupStorage) (RecoverReport, error) {
+	report := RecoverReport{Group: group}
+	snapshots, err := storage.Snapshots.List()
+	if err != nil {
+		return report, err
+	}
+	if len(snapshots) > 0 {
+		report.SnapshotIndex = snapshots[0].Index
+		report.LastIndex, report.LastTerm = snapshots[0].Index, snapshots[0].Term
+		report.Configuration = snapshots[0].Configuration
+	}
+	first, err := storage.LogStore.FirstIndex()
+	if err != nil {
+		return report, err
+	}
+	last, err := storage.LogStore.LastIndex()
+	if err != nil {
+		return report, err
+	}
+	if last > report.LastIndex {
+		var entry raft.Log
+		if err := storage.LogStore.GetLog(last, &entry); err != nil {
+			return report, err
+		}
+		report.LastIndex, report.LastTerm = entry.Index, entry.Term
+	}
+	for index := last; index >= first && index > report.SnapshotIndex && index != 0; index-- {
+		var entry raft.Log
+		if err := storage.LogStore.GetLog(index, &entry); err != nil {
+			return report, err
+		}
+		if entry.Type == raft.LogConfiguration {
+			report.Configuration = raft.DecodeConfiguration(entry.Data)
+			break
+		}
+	}
+	if report.LastIndex == 0 {
+		return report, errors.New("no raft state to recover")
+	}
+	return report, nil
+}
+
+// recoverGroup 用内存中的状态机回放日志，由 raft.RecoverCluster 写出新的快照，
+// 节点重新启动时从该快照恢复存储引擎中的数据。返回回放时分裂出的区间
+func recoverGroup(group uint64, storage GroupStorage, opts RecoverOptions) ([]uint64, error) {
+	fsm := NewFSM(newMemDB())
+	if opts.SessionTTL > 0 {
+		fsm.sessionTTL = opts.SessionTTL
+	}
+	host := &recoverHost{}
+	fsm.host = host
+	fsm.slots = opts.Slots
+	if group != 0 {
+		fsm.desc = nil
+	}
+	config := raft.DefaultConfig()
+	config.ProtocolVersion = raft.ProtocolVersionMax
+	config.LocalID = opts.ServerID
+	config.Logger = hclog.NewNullLogger()
+	_, transport := raft.NewInmemTransport(opts.Servers[0].Address)
+	defer transport.Close()
+	err := raft.RecoverCluster(config, fsm, storage.LogStore, storage.StableStore, storage.Snapshots, transport,
+		raft.Configuration{Servers: opts.Servers})
+	return host.splits, err
+}
+
+// recoverHost 回放分裂日志时不创建新的组，只记录分裂出的区间
+type recoverHost struct {
+	splits []uint64
+}
+
+func (h *recoverHost) createRange(desc RangeDescriptor, _ []raft.Server, _ []kvPair, _ map[string]*clientSession) error {
+	h.splits = append(h.splits, desc.ID)
+	return nil
+}
+
+// memDB 恢复时回放日志使用的内存引擎，不修改节点的存储引擎
+type memDB struct {
+	data map[string][]byte
+}
+
+func newMemDB() *memDB {
+	return &memDB{data: make(map[string][]byte)}
+}
+
+func (m *memDB) Set(key string, value []byte) error {
+	m.data[key] = value
+	return nil
+}
+
+func (m *memDB) Get(key string) ([]byte, error) {
+	value, ok := m.data[key]
+	if !ok {
+		return nil, kvsError.KeyNotFound
+	}
+	return value, nil
+}
+
+func (m *memDB) Remove(key string) error {
+	if _, ok := m.data[key]; !ok {
+		return kvsError.KeyNotFound
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memDB) Scan(fn func(key string, value []byte) bool) error {
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key, m.data[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+// View 复制一份 key 和 value 的引用，Set 和 Reset 只替换引用，不修改已有的 value
+func (m *memDB) View() (engines.View, error) {
+	view := &memView{pairs: make(map[string][]byte, len(m.data))}
+	for key, value := range m.data {
+		view.pairs[key] = value
+	}
+	return view, nil
+}
+
+type memView struct {
+	pairs map[string][]byte
+}
+
+func (v *memView) Scan(start, end string, fn func(key string, value []byte) bool) error {
+	keys := make([]string, 0, len(v.pairs))
+	for key := range v.pairs {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key, v.pairs[key]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (v *memView) Close() error {
+	return nil
+}
+
+func (m *memDB) Reset(pairs map[string][]byte) error {
+	m.data = make(map[string][]byte, len(pairs))
+	for key, value := range pairs {
+		m.data[key] = value
+	}
+	return nil
+}
+
+func (m *memDB) Close() error {
+	return nil
+}
@@ -0,0 +1,67 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"github.com/huiming23344/kv-raft/cmd"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Recover(t *testing.T) {
+	if raceEnabled {
+		t.Skip("boltdb/bolt does not pass checkptr")
+	}
+	Convey("test recovering the bolt stores of a stopped node", t, func() {
+		dir := t.TempDir()
+		store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-log.bolt"))
+		So(err, ShouldBeNil)
+		servers := []raft.Server{
+			{Suffrage: raft.Voter, ID: "0", Address: "127.0.0.1:2318"},
+			{Suffrage: raft.Voter, ID: "1", Address: "127.0.0.1:2328"},
+			{Suffrage: raft.Voter, ID: "2", Address: "127.0.0.1:2338"},
+		}
+		set, err := encodeCommand(cmd.NewSet("name", []byte("mars")))
+		So(err, ShouldBeNil)
+		So(store.StoreLogs([]*raft.Log{
+			{Index: 1, Term: 1, Type: raft.LogConfiguration, Data: raft.EncodeConfiguration(raft.Configuration{Servers: servers})},
+			{Index: 2, Term: 2, Type: raft.LogCommand, Data: set},
+		}), ShouldBeNil)
+		So(store.Close(), ShouldBeNil)
+		stable, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft-stable.bolt"))
+		So(err, ShouldBeNil)
+		So(stable.SetUint64([]byte("CurrentTerm"), 2), ShouldBeNil)
+		So(stable.Close(), ShouldBeNil)
+
+		opts := RecoverOptions{DataDir: dir, ServerID: "0", Servers: servers[:1], DryRun: true}
+		reports, err := Recover(opts)
+		So(err, ShouldBeNil)
+		So(reports, ShouldHaveLength, 1)
+		So(reports[0].String(), ShouldEqual, "group=0 snapshot-index=0 last-index=2 last-term=2 servers=0,1,2 -> 0")
+		So(reports[0].Removed(), ShouldResemble, []raft.ServerID{"1", "2"})
+		_, err = ReadSnapshots(dir, false)
+		So(err, ShouldNotBeNil)
+
+		opts.DryRun = false
+		_, err = Recover(opts)
+		So(err, ShouldBeNil)
+		infos, err := ReadSnapshots(dir, true)
+		So(err, ShouldBeNil)
+		So(infos, ShouldHaveLength, 1)
+		So(infos[0].Index, ShouldEqual, 2)
+		So(infos[0].Configuration.Servers, ShouldResemble, servers[:1])
+		So(infos[0].Keys, ShouldEqual, 1)
+		var entries []LogEntry
+		So(ReadLog(dir, LogFilter{}, func(entry LogEntry) error {
+			entries = append(entries, entry)
+			return nil
+		}), ShouldBeNil)
+		So(entries, ShouldBeEmpty)
+
+		opts.ServerID = "1"
+		_, err = Recover(opts)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,90 @@
+package raft
+
+import (
+	"bytes"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// applyAt 把命令编码为日志条目交给 FSM 应用，at 为日志的追加时间
+func applyAt(fsm *FSM, command cmd.Command, at time.Time) *network.Frame {
+	data, err := encodeCommand(command)
+	So(err, ShouldBeNil)
+	return fsm.Apply(&raft.Log{Data: data, AppendedAt: at}).(*network.Frame)
+}
+
+func Test_SessionDedup(t *testing.T) {
+	Convey("a retried session write returns the first response without applying again", t, func() {
+		quietLog(t)
+		engine, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		fsm := NewFSM(engine)
+		now := time.Unix(1700000000, 0)
+		So(engine.Set("name", []byte("mars")), ShouldBeNil)
+
+		del := cmd.NewSession("c1", 1, cmd.NewDelete("name"))
+		So(applyAt(fsm, del, now).Value, ShouldEqual, 1)
+		So(applyAt(fsm, del, now.Add(time.Second)).Value, ShouldEqual, 1)
+		So(applyAt(fsm, cmd.NewDelete("name"), now).Value, ShouldEqual, 0)
+
+		// 被合并提交的重试同样去重
+		set := cmd.NewSession("c1", 2, cmd.NewSet("name", []byte("venus")))
+		So(applyAt(fsm, set, now).Value, ShouldEqual, "OK")
+		So(engine.Set("name", []byte("earth")), ShouldBeNil)
+		rsp := applyAt(fsm, cmd.NewBatch(set, cmd.NewSession("c2", 1, cmd.NewDelete("age"))), now)
+		So(rsp.Value, ShouldResemble, []*network.Frame{
+			{Ftype: network.Simple, Value: "OK"},
+			{Ftype: network.Integer, Value: 0},
+		})
+		value, err := engine.Get("name")
+		So(err, ShouldBeNil)
+		So(string(value), ShouldEqual, "earth")
+
+		So(applyAt(fsm, cmd.NewSession("c1", 1, cmd.NewDelete("name")), now).Ftype, ShouldEqual, network.Error)
+	})
+
+	Convey("idle sessions expire by the log append time", t, func() {
+		quietLog(t)
+		engine, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		fsm := NewFSM(engine)
+		fsm.sessionTTL = time.Minute
+		now := time.Unix(1700000000, 0)
+
+		set := cmd.NewSession("c1", 1, cmd.NewSet("name", []byte("mars")))
+		So(applyAt(fsm, set, now).Value, ShouldEqual, "OK")
+		So(applyAt(fsm, cmd.NewSession("c2", 1, cmd.NewSet("age", []byte("25"))), now.Add(2*time.Minute)).Value, ShouldEqual, "OK")
+		So(fsm.sessions, ShouldNotContainKey, "c1")
+		So(fsm.sessions, ShouldContainKey, "c2")
+	})
+
+	Convey("the dedup table survives snapshot and restore", t, func() {
+		quietLog(t)
+		source, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		sourceFSM := NewFSM(source)
+		now := time.Unix(1700000000, 0)
+		So(source.Set("name", []byte("mars")), ShouldBeNil)
+		del := cmd.NewSession("c1", 1, cmd.NewDelete("name"))
+		So(applyAt(sourceFSM, del, now).Value, ShouldEqual, 1)
+
+		snapshot, err := sourceFSM.Snapshot()
+		So(err, ShouldBeNil)
+		var buf bytes.Buffer
+		So(writeSnapshot(&buf, snapshot.(*fsmSnapshot)), ShouldBeNil)
+		target, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		targetFSM := NewFSM(target)
+		So(targetFSM.Restore(io.NopCloser(&buf)), ShouldBeNil)
+
+		So(targetFSM.sessions["c1"].lastActive.Equal(now), ShouldBeTrue)
+		So(applyAt(targetFSM, del, now).Value, ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,338 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	errClusterDisabled = errors.New("ERR This instance has cluster support disabled")
+	errRangeSingleSlot = errors.New("range covers a single slot")
+)
+
+// 按槽位划分时，区间的边界是 2 字节大端序的槽位号，key 的路由键是它的槽位号加上 key 本身，
+// 因此一个槽位的所有 key 总是落在同一个区间中，区间可以用一段连续的槽位描述
+
+// slotBoundary 返回 slot 的起点
+func slotBoundary(slot int) string {
+	return string([]byte{byte(slot >> 8), byte(slot)})
+}
+
+// routingKey 返回与区间边界比较的路由键，未按槽位划分时就是 key 本身
+func routingKey(key string, slots bool) string {
+	if !slots {
+		return key
+	}
+	return slotBoundary(cmd.KeySlot(key)) + key
+}
+
+// boundarySlot 返回区间边界或路由键所在的槽位，空的起点为 0
+func boundarySlot(boundary string) int {
+	if len(boundary) < 2 {
+		return 0
+	}
+	return int(boundary[0])<<8 | int(boundary[1])
+}
+
+// slotSpan 返回区间负责的槽位 [start, end)
+func slotSpan(desc *RangeDescriptor) (int, int) {
+	end := cmd.SlotCount
+	if desc.End != "" {
+		end = boundarySlot(desc.End)
+	}
+	return boundarySlot(desc.Start), end
+}
+
+// slotAligned 检查区间的边界是否对齐到槽位
+func slotAligned(desc *RangeDescriptor) bool {
+	return (desc.Start == "" || len(desc.Start) == 2) && (desc.End == "" || len(desc.End) == 2)
+}
+
+// medianSlot 把中位 key 的路由键对齐到它所在槽位的起点，落在区间的第一个槽位时取下一个槽位
+func medianSlot(desc *RangeDescriptor, median string) (string, error) {
+	start, end := slotSpan(desc)
+	slot := max(boundarySlot(median), start+1)
+	if slot >= end {
+		return "", errRangeSingleSlot
+	}
+	return slotBoundary(slot), nil
+}
+
+// Redirect 按槽位路由时，key 所在区间的 Leader 不是本节点则回复 -MOVED；区间正在合并进
+// 左侧相邻的区间时，它的 Leader 回复 -ASK 让客户端把这一条命令发给左侧区间的 Leader。
+// asking 表示客户端在命令前发送了 ASKING。返回 nil 时由本节点执行命令
+func (s *Store) Redirect(command cmd.Command, asking bool) *network.Frame {
+	if !s.opts.Slots {
+		return nil
+	}
+	key, ok := commandKey(command)
+	if !ok || strings.HasPrefix(key, rangeKeyPrefix) {
+		return nil
+	}
+	slot := cmd.KeySlot(key)
+	node := s.route(routingKey(key, true))
+	if node == nil {
+		return nil
+	}
+	if desc := node.Range(); desc != nil && desc.Frozen {
+		if left := s.rangeEndingAt(desc.Start); left != nil {
+			if asking && left.isLeader() {
+				return nil
+			}
+			if node.isLeader() {
+				return redirectFrame("ASK", slot, left)
+			}
+		}
+	}
+	if node.isLeader() {
+		return nil
+	}
+	return redirectFrame("MOVED", slot, node)
+}
+
+func redirectFrame(kind string, slot int, node *Node) *network.Frame {
+	addr, err := node.leader()
+	if err != nil {
+		return errorFrame(fmt.Errorf("CLUSTERDOWN range %d: %v", node.group, err))
+	}
+	return &network.Frame{
+		Ftype: network.Error,
+		Value: fmt.Sprintf("%s %d %s", kind, slot, addr),
+	}
+}
+
+// rangeEndingAt 返回本节点上未冻结、终点为 end 的区间所在的组
+func (s *Store) rangeEndingAt(end string) *Node {
+	for _, node := range s.Groups() {
+		if desc := node.Range(); desc != nil && !desc.Frozen && !desc.Removed && desc.End == end {
+			return node
+		}
+	}
+	return nil
+}
+
+// Cluster 执行 CLUSTER 命令
+func (s *Store) Cluster(c *cmd.Cluster) *network.Frame {
+	if c.Opt() == cmd.ClusterKeySlot {
+		return c.Apply(nil)
+	}
+	if !s.opts.Slots {
+		return errorFrame(errClusterDisabled)
+	}
+	switch c.Opt() {
+	case cmd.ClusterSlots:
+		return s.clusterSlots()
+	case cmd.ClusterShards:
+		return s.clusterShards()
+	case cmd.ClusterNodes:
+		return s.clusterNodes()
+	}
+	return errorFrame(fmt.Errorf("ERR unknown subcommand '%s'", c.Opt()))
+}
+
+// slotRange 一段连续的槽位 [start, end] 及负责它们的组
+type slotRange struct {
+	start, end int
+	node       *Node
+}
+
+// slotTable 按本节点上各组的区间描述返回槽位的分配。分裂、合并的过程中多个区间可能包含
+// 同一个槽位，与 route 一样优先选择未冻结、generation 最大的区间
+func (s *Store) slotTable() []slotRange {
+	type candidate struct {
+		node *Node
+		desc *RangeDescriptor
+	}
+	var candidates []candidate
+	for _, node := range s.Groups() {
+		if desc := node.Range(); desc != nil && !desc.Removed {
+			candidates = append(candidates, candidate{node, desc})
+		}
+	}
+	// 优先级低的先填，之后被优先级高的覆盖
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].desc, candidates[j].desc
+		if a.Frozen != b.Frozen {
+			return a.Frozen
+		}
+		return a.Generation < b.Generation
+	})
+	owners := make([]*Node, cmd.SlotCount)
+	for _, c := range candidates {
+		start, end := slotSpan(c.desc)
+		for slot := start; slot < end && slot < cmd.SlotCount; slot++ {
+			owners[slot] = c.node
+		}
+	}
+	var table []slotRange
+	for slot, owner := range owners {
+		if owner == nil {
+			continue
+		}
+		if n := len(table); n > 0 && table[n-1].node == owner && table[n-1].end == slot-1 {
+			table[n-1].end = slot
+			continue
+		}
+		table = append(table, slotRange{start: slot, end: slot, node: owner})
+	}
+	return table
+}
+
+// slotNode 负责一段槽位的节点
+type slotNode struct {
+	id     raft.ServerID
+	host   string
+	port   int
+	master bool
+}
+
+// slotNodes 返回组的成员，Leader 在前，其余按 ID 排序。尚未登记客户端地址的成员不返回
+func (s *Store) slotNodes(node *Node) []slotNode {
+	configFuture := node.raft.GetConfiguration()
+	if configFuture.Error() != nil {
+		return nil
+	}
+	_, leaderID := node.raft.LeaderWithID()
+	var nodes []slotNode
+	for _, server := range configFuture.Configuration().Servers {
+		addr, ok := s.meta.fsm.member(server.ID)
+		if !ok {
+			continue
+		}
+		host, port := splitHostPort(addr)
+		nodes = append(nodes, slotNode{
+			id:     server.ID,
+			host:   host,
+			port:   port,
+			master: server.ID == leaderID,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].master != nodes[j].master {
+			return nodes[i].master
+		}
+		return nodes[i].id < nodes[j].id
+	})
+	return nodes
+}
+
+// splitHostPort 拆分地址，没有端口时端口为 0
+func splitHostPort(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// clusterSlots 每段槽位回复 [start, end, master, replica...]，节点为 [ip, port, id]。
+// 没有 Leader 的区间不回复，客户端会在下次刷新时重新获取
+func (s *Store) clusterSlots() *network.Frame {
+	rsps := make([]*network.Frame, 0)
+	for _, r := range s.slotTable() {
+		nodes := s.slotNodes(r.node)
+		if len(nodes) == 0 || !nodes[0].master {
+			continue
+		}
+		entry := []*network.Frame{integerFrame(r.start), integerFrame(r.end)}
+		for _, n := range nodes {
+			entry = append(entry, arrayFrame(bulkFrame(n.host), integerFrame(n.port), bulkFrame(string(n.id))))
+		}
+		rsps = append(rsps, arrayFrame(entry...))
+	}
+	return arrayFrame(rsps...)
+}
+
+// clusterShards 每个区间是一个分片，回复 slots 和 nodes 两个字段
+func (s *Store) clusterShards() *network.Frame {
+	rsps := make([]*network.Frame, 0)
+	for _, r := range s.slotTable() {
+		nodes := make([]*network.Frame, 0)
+		for _, n := range s.slotNodes(r.node) {
+			role := "replica"
+			if n.master {
+				role = "master"
+			}
+			nodes = append(nodes, arrayFrame(
+				bulkFrame("id"), bulkFrame(string(n.id)),
+				bulkFrame("port"), integerFrame(n.port),
+				bulkFrame("ip"), bulkFrame(n.host),
+				bulkFrame("endpoint"), bulkFrame(n.host),
+				bulkFrame("role"), bulkFrame(role),
+				bulkFrame("health"), bulkFrame("online"),
+			))
+		}
+		rsps = append(rsps, arrayFrame(
+			bulkFrame("slots"), arrayFrame(integerFrame(r.start), integerFrame(r.end)),
+			bulkFrame("nodes"), arrayFrame(nodes...),
+		))
+	}
+	return arrayFrame(rsps...)
+}
+
+// clusterNodes 按 nodes.conf 的格式列出元数据组的成员。一个节点可能是某些区间的 Leader、
+// 另一些区间的 Follower，这种格式无法表达，因此所有节点都标记为 master，只列出它作为 Leader 的槽位
+func (s *Store) clusterNodes() *network.Frame {
+	configFuture := s.meta.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return errorFrame(err)
+	}
+	leading := make(map[raft.ServerID][]string)
+	for _, r := range s.slotTable() {
+		if _, id := r.node.raft.LeaderWithID(); id != "" {
+			span := strconv.Itoa(r.start)
+			if r.end != r.start {
+				span += "-" + strconv.Itoa(r.end)
+			}
+			leading[id] = append(leading[id], span)
+		}
+	}
+	var buf strings.Builder
+	for _, server := range configFuture.Configuration().Servers {
+		addr, ok := s.meta.fsm.member(server.ID)
+		if !ok {
+			continue
+		}
+		host, port := splitHostPort(addr)
+		_, raftPort := splitHostPort(string(server.Address))
+		flags := "master"
+		if server.ID == s.meta.serverID {
+			flags = "myself,master"
+		}
+		buf.WriteString(fmt.Sprintf("%s %s:%d@%d %s - 0 0 0 connected", server.ID, host, port, raftPort, flags))
+		for _, span := range leading[server.ID] {
+			buf.WriteString(" " + span)
+		}
+		buf.WriteString("\n")
+	}
+	return bulkFrame(buf.String())
+}
+
+func integerFrame(value int) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Integer,
+		Value: value,
+	}
+}
+
+func bulkFrame(value string) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Bulk,
+		Value: value,
+	}
+}
+
+func arrayFrame(frames ...*network.Frame) *network.Frame {
+	return &network.Frame{
+		Ftype: network.Array,
+		Value: frames,
+	}
+}
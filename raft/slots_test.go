@@ -0,0 +1,32 @@
+package raft
+
+import (
+	"github.com/huiming23344/kv-raft/cmd"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SlotBoundaries(t *testing.T) {
+	Convey("range boundaries in cluster mode are slot starts", t, func() {
+		So(routingKey("foo", false), ShouldEqual, "foo")
+		So(routingKey("foo", true), ShouldEqual, slotBoundary(12182)+"foo")
+		So(boundarySlot(routingKey("foo", true)), ShouldEqual, 12182)
+
+		desc := &RangeDescriptor{Start: slotBoundary(100)}
+		start, end := slotSpan(desc)
+		So([]int{start, end}, ShouldResemble, []int{100, cmd.SlotCount})
+		So(slotAligned(desc), ShouldBeTrue)
+		So(slotAligned(&RangeDescriptor{End: "m"}), ShouldBeFalse)
+
+		// 中位 key 落在区间的第一个槽位时取下一个槽位
+		key, err := medianSlot(desc, slotBoundary(100)+"a")
+		So(err, ShouldBeNil)
+		So(key, ShouldEqual, slotBoundary(101))
+		key, err = medianSlot(desc, slotBoundary(5000)+"a")
+		So(err, ShouldBeNil)
+		So(key, ShouldEqual, slotBoundary(5000))
+		_, err = medianSlot(&RangeDescriptor{Start: slotBoundary(7), End: slotBoundary(8)}, slotBoundary(7)+"a")
+		So(err, ShouldEqual, errRangeSingleSlot)
+	})
+}
@@ -0,0 +1,476 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"github.com/huiming23344/kv-raft/network"
+	"hash"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+/*
+快照文件格式，整数均为大端序，长度为 uvarint：
+
+	┌────────┬─────────┬────────────────────────┬────────────────────────┬──────────────────────────────────────┬─────────────────────────────┬────────┐
+	│ magic  │ version │ kv 数据区（v5 起流式）    │ 节点元数据区（v2）        │ 客户端会话区（v3）                      │ 区间区（v4）                  │ crc32  │
+	│ "KVSS" │ uint32  │ (1 key value)... 0     │ count (id, addr)...    │ count (id, seq, lastActive, rsp)...  │ desc? count desc... nextID  │ uint32 │
+	└────────┴─────────┴────────────────────────┴────────────────────────┴──────────────────────────────────────┴─────────────────────────────┴────────┘
+
+每个区由 uint64 的记录数开头，kv 和节点元数据的每条记录是 (长度, 字节) 组成的两个字段。
+版本 5 起 kv 数据区改为流式写出，不需要事先知道记录数：每条记录前有一个字节 1，数据区以一个字节 0 结束。
+会话记录中 seq 为 uvarint，lastActive 为 int64 的 UnixNano（0 表示没有时间），rsp 为 RESP 编码的响应。
+区间区以一个字节开头，为 1 时后接本组的区间描述，之后是元数据表的记录数、各条描述和 uint64 的下一个区间 ID。
+区间描述依次为 uint64 的 ID、start、end 两个字段、uint64 的 generation 和一个字节的标志位（1 冻结，2 已删除）。
+crc32 使用 Castagnoli 多项式，覆盖它之前的全部字节
+*/
+
+const (
+	snapshotMagic = "KVSS"
+	// 版本 1 只有 kv 数据区，版本 2 增加节点元数据区，版本 3 增加客户端会话区，版本 4 增加区间区，
+	// 版本 5 的 kv 数据区改为流式
+	snapshotVersion = 5
+	// 单个 key 或 value 的最大长度，防止损坏的长度字段导致超大内存分配
+	maxSnapshotField = 1 << 30
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errSnapshotMagic    = errors.New("snapshot: invalid magic")
+	errSnapshotChecksum = errors.New("snapshot: checksum mismatch")
+	errSnapshotField    = errors.New("snapshot: field too large")
+	errSnapshotRecord   = errors.New("snapshot: invalid record marker")
+)
+
+type kvPair struct {
+	key   string
+	value string
+}
+
+// fsmSnapshot 是 FSM 在某个时间点的全量数据，kv 数据来自引擎的时间点视图，
+// 由 Persist 在后台逐条读出写入，或者是已经读到内存中的 pairs
+type fsmSnapshot struct {
+	view     engines.View
+	pairs    []kvPair
+	members  []kvPair
+	sessions map[string]*clientSession
+	ranges   *rangeState
+}
+
+// rangeState 本组的区间描述和元数据组的区间描述表
+type rangeState struct {
+	desc        *RangeDescriptor
+	table       []RangeDescriptor
+	nextRangeID uint64
+}
+
+var _ raft.FSMSnapshot = (*fsmSnapshot)(nil)
+
+// Persist 将快照写入 sink，失败时取消本次快照
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := writeSnapshot(sink, s); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+// Release 关闭引擎的视图
+func (s *fsmSnapshot) Release() {
+	if s.view != nil {
+		_ = s.view.Close()
+	}
+}
+
+// snapshotState 从快照中读取出的 FSM 状态
+type snapshotState struct {
+	pairs    map[string]string
+	members  map[string]string
+	sessions map[string]*clientSession
+	// 版本 4 之前的快照没有区间区，为 nil
+	ranges *rangeState
+}
+
+func writeSnapshot(w io.Writer, s *fsmSnapshot) error {
+	sum := crc32.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	if _, err := bw.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.BigEndian, uint32(snapshotVersion)); err != nil {
+		return err
+	}
+	if err := writeData(bw, s); err != nil {
+		return err
+	}
+	if err := writeSection(bw, s.members); err != nil {
+		return err
+	}
+	if err := writeSessions(bw, s.sessions); err != nil {
+		return err
+	}
+	ranges := s.ranges
+	if ranges == nil {
+		ranges = &rangeState{desc: &RangeDescriptor{}}
+	}
+	if err := writeRanges(bw, ranges); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, sum.Sum32())
+}
+
+// writeData 流式写出 kv 数据区，先写 pairs，再遍历视图
+func writeData(w *bufio.Writer, s *fsmSnapshot) error {
+	var err error
+	write := func(key string, value []byte) bool {
+		if err = w.WriteByte(1); err != nil {
+			return false
+		}
+		if err = writeField(w, key); err != nil {
+			return false
+		}
+		err = writeField(w, string(value))
+		return err == nil
+	}
+	for _, pair := range s.pairs {
+		if !write(pair.key, []byte(pair.value)) {
+			return err
+		}
+	}
+	if s.view != nil {
+		if scanErr := s.view.Scan("", "", write); scanErr != nil {
+			return scanErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.WriteByte(0)
+}
+
+func writeSection(w *bufio.Writer, pairs []kvPair) error {
+	if err := binary.Write(w, binary.BigEndian, uint64(len(pairs))); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if err := writeField(w, pair.key); err != nil {
+			return err
+		}
+		if err := writeField(w, pair.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSessions(w *bufio.Writer, sessions map[string]*clientSession) error {
+	if err := binary.Write(w, binary.BigEndian, uint64(len(sessions))); err != nil {
+		return err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	for id, s := range sessions {
+		rsp, err := s.rsp.Bytes()
+		if err != nil {
+			return err
+		}
+		if err := writeField(w, id); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:binary.PutUvarint(buf[:], s.seq)]); err != nil {
+			return err
+		}
+		var lastActive int64
+		if !s.lastActive.IsZero() {
+			lastActive = s.lastActive.UnixNano()
+		}
+		if err := binary.Write(w, binary.BigEndian, lastActive); err != nil {
+			return err
+		}
+		if err := writeField(w, string(rsp)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRanges(w *bufio.Writer, ranges *rangeState) error {
+	hasDesc := byte(0)
+	if ranges.desc != nil {
+		hasDesc = 1
+	}
+	if err := w.WriteByte(hasDesc); err != nil {
+		return err
+	}
+	if ranges.desc != nil {
+		if err := writeDescriptor(w, ranges.desc); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint64(len(ranges.table))); err != nil {
+		return err
+	}
+	for i := range ranges.table {
+		if err := writeDescriptor(w, &ranges.table[i]); err != nil {
+			return err
+		}
+	}
+	return binary.Write(w, binary.BigEndian, ranges.nextRangeID)
+}
+
+func writeDescriptor(w *bufio.Writer, desc *RangeDescriptor) error {
+	if err := binary.Write(w, binary.BigEndian, desc.ID); err != nil {
+		return err
+	}
+	if err := writeField(w, desc.Start); err != nil {
+		return err
+	}
+	if err := writeField(w, desc.End); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, desc.Generation); err != nil {
+		return err
+	}
+	return w.WriteByte(descriptorFlags(desc))
+}
+
+func writeField(w *bufio.Writer, field string) error {
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(field)))
+	if _, err := w.Write(lenBuf[:n]); err != nil {
+		return err
+	}
+	_, err := w.WriteString(field)
+	return err
+}
+
+// readSnapshot 读取并校验整个快照，只有校验通过才返回数据
+func readSnapshot(r io.Reader) (*snapshotState, error) {
+	br := bufio.NewReader(r)
+	hr := &hashReader{r: br, sum: crc32.New(crcTable)}
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(hr, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != snapshotMagic {
+		return nil, errSnapshotMagic
+	}
+	var version uint32
+	if err := binary.Read(hr, binary.BigEndian, &version); err != nil {
+		return nil, err
+	}
+	if version < 1 || version > snapshotVersion {
+		return nil, fmt.Errorf("snapshot: unsupported version %d", version)
+	}
+	state := &snapshotState{
+		members:  make(map[string]string),
+		sessions: make(map[string]*clientSession),
+	}
+	var err error
+	if version >= 5 {
+		state.pairs, err = readData(hr)
+	} else {
+		state.pairs, err = readSection(hr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if version >= 2 {
+		if state.members, err = readSection(hr); err != nil {
+			return nil, err
+		}
+	}
+	if version >= 3 {
+		if state.sessions, err = readSessions(hr); err != nil {
+			return nil, err
+		}
+	}
+	if version >= 4 {
+		if state.ranges, err = readRanges(hr); err != nil {
+			return nil, err
+		}
+	}
+	var checksum uint32
+	if err := binary.Read(br, binary.BigEndian, &checksum); err != nil {
+		return nil, err
+	}
+	if checksum != hr.sum.Sum32() {
+		return nil, errSnapshotChecksum
+	}
+	return state, nil
+}
+
+// readData 读取版本 5 起的流式 kv 数据区
+func readData(r *hashReader) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if marker == 0 {
+			return pairs, nil
+		}
+		if marker != 1 {
+			return nil, errSnapshotRecord
+		}
+		key, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		pairs[key] = value
+	}
+}
+
+func readSection(r *hashReader) (map[string]string, error) {
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	pairs := make(map[string]string)
+	for i := uint64(0); i < count; i++ {
+		key, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+func readSessions(r *hashReader) (map[string]*clientSession, error) {
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	sessions := make(map[string]*clientSession)
+	for i := uint64(0); i < count; i++ {
+		id, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		s := &clientSession{}
+		if s.seq, err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+		var lastActive int64
+		if err := binary.Read(r, binary.BigEndian, &lastActive); err != nil {
+			return nil, err
+		}
+		if lastActive != 0 {
+			s.lastActive = time.Unix(0, lastActive)
+		}
+		rsp, err := readField(r)
+		if err != nil {
+			return nil, err
+		}
+		if s.rsp, err = network.ParseRESP([]byte(rsp)); err != nil {
+			return nil, err
+		}
+		sessions[id] = s
+	}
+	return sessions, nil
+}
+
+func readRanges(r *hashReader) (*rangeState, error) {
+	ranges := &rangeState{}
+	hasDesc, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if hasDesc == 1 {
+		if ranges.desc, err = readDescriptor(r); err != nil {
+			return nil, err
+		}
+	}
+	var count uint64
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < count; i++ {
+		desc, err := readDescriptor(r)
+		if err != nil {
+			return nil, err
+		}
+		ranges.table = append(ranges.table, *desc)
+	}
+	if err := binary.Read(r, binary.BigEndian, &ranges.nextRangeID); err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+func readDescriptor(r *hashReader) (*RangeDescriptor, error) {
+	desc := &RangeDescriptor{}
+	if err := binary.Read(r, binary.BigEndian, &desc.ID); err != nil {
+		return nil, err
+	}
+	var err error
+	if desc.Start, err = readField(r); err != nil {
+		return nil, err
+	}
+	if desc.End, err = readField(r); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &desc.Generation); err != nil {
+		return nil, err
+	}
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	setDescriptorFlags(desc, flags)
+	return desc, nil
+}
+
+func readField(r *hashReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxSnapshotField {
+		return "", errSnapshotField
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// hashReader 在读取的同时计算已读字节的校验和
+type hashReader struct {
+	r   *bufio.Reader
+	sum hash.Hash32
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.sum.Write(p[:n])
+	return n, err
+}
+
+func (h *hashReader) ReadByte() (byte, error) {
+	b, err := h.r.ReadByte()
+	if err == nil {
+		h.sum.Write([]byte{b})
+	}
+	return b, err
+}
@@ -0,0 +1,193 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/huiming23344/kv-raft/db/engines"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_SnapshotEncoding(t *testing.T) {
+	Convey("test snapshot encode and decode", t, func() {
+		snapshot := &fsmSnapshot{
+			pairs: []kvPair{
+				{"age", "25"},
+				{"empty", ""},
+				{"name", "mars"},
+			},
+			members: []kvPair{
+				{"0", "127.0.0.1:2317"},
+			},
+		}
+		var buf bytes.Buffer
+		So(writeSnapshot(&buf, snapshot), ShouldBeNil)
+
+		state, err := readSnapshot(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+		So(state.pairs, ShouldResemble, map[string]string{"age": "25", "empty": "", "name": "mars"})
+		So(state.members, ShouldResemble, map[string]string{"0": "127.0.0.1:2317"})
+		So(state.ranges, ShouldResemble, &rangeState{desc: &RangeDescriptor{}})
+
+		corrupted := append([]byte(nil), buf.Bytes()...)
+		corrupted[len(snapshotMagic)+14] ^= 0xff
+		_, err = readSnapshot(bytes.NewReader(corrupted))
+		So(err, ShouldNotBeNil)
+
+		_, err = readSnapshot(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func Test_SnapshotRanges(t *testing.T) {
+	Convey("test snapshot keeps the range descriptor and table", t, func() {
+		ranges := &rangeState{
+			desc: &RangeDescriptor{ID: 2, Start: "m", End: "t", Generation: 3, Frozen: true},
+			table: []RangeDescriptor{
+				{ID: 0, End: "m", Generation: 1},
+				{ID: 2, Start: "m", End: "t", Generation: 3},
+				{ID: 4, Start: "t", Generation: 2, Removed: true},
+			},
+			nextRangeID: 5,
+		}
+		var buf bytes.Buffer
+		So(writeSnapshot(&buf, &fsmSnapshot{ranges: ranges}), ShouldBeNil)
+		state, err := readSnapshot(&buf)
+		So(err, ShouldBeNil)
+		So(state.ranges, ShouldResemble, ranges)
+
+		// 刚创建、尚未得知区间的组没有本组的描述
+		buf.Reset()
+		So(writeSnapshot(&buf, &fsmSnapshot{ranges: &rangeState{}}), ShouldBeNil)
+		state, err = readSnapshot(&buf)
+		So(err, ShouldBeNil)
+		So(state.ranges.desc, ShouldBeNil)
+	})
+}
+
+func Test_SnapshotVersion1(t *testing.T) {
+	Convey("test decode version 1 snapshot without members", t, func() {
+		var buf bytes.Buffer
+		sum := crc32.New(crcTable)
+		bw := bufio.NewWriter(io.MultiWriter(&buf, sum))
+		bw.WriteString(snapshotMagic)
+		So(binary.Write(bw, binary.BigEndian, uint32(1)), ShouldBeNil)
+		So(writeSection(bw, []kvPair{{"name", "mars"}}), ShouldBeNil)
+		So(bw.Flush(), ShouldBeNil)
+		So(binary.Write(&buf, binary.BigEndian, sum.Sum32()), ShouldBeNil)
+
+		state, err := readSnapshot(&buf)
+		So(err, ShouldBeNil)
+		So(state.pairs, ShouldResemble, map[string]string{"name": "mars"})
+		So(state.members, ShouldBeEmpty)
+		So(state.ranges, ShouldBeNil)
+	})
+}
+
+func Test_FSMSnapshotRestore(t *testing.T) {
+	Convey("test FSM snapshot and restore", t, func() {
+		source, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		So(source.Set("name", []byte("mars")), ShouldBeNil)
+		So(source.Set("age", []byte("25")), ShouldBeNil)
+		sourceFSM := NewFSM(source)
+		sourceFSM.register("1", "127.0.0.1:2317")
+
+		snapshot, err := sourceFSM.Snapshot()
+		So(err, ShouldBeNil)
+		// 快照之后的写入不会出现在快照中
+		So(source.Set("later", []byte("value")), ShouldBeNil)
+		So(source.Remove("age"), ShouldBeNil)
+		var buf bytes.Buffer
+		So(writeSnapshot(&buf, snapshot.(*fsmSnapshot)), ShouldBeNil)
+		snapshot.Release()
+
+		target, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		So(target.Set("stale", []byte("value")), ShouldBeNil)
+		targetFSM := NewFSM(target)
+		So(targetFSM.Restore(io.NopCloser(&buf)), ShouldBeNil)
+
+		val, err := target.Get("name")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "mars")
+		val, err = target.Get("age")
+		So(err, ShouldBeNil)
+		So(string(val), ShouldEqual, "25")
+		_, err = target.Get("later")
+		So(err, ShouldNotBeNil)
+		_, err = target.Get("stale")
+		So(err, ShouldNotBeNil)
+		addr, ok := targetFSM.member("1")
+		So(ok, ShouldBeTrue)
+		So(addr, ShouldEqual, "127.0.0.1:2317")
+	})
+}
+
+func Test_NamespaceView(t *testing.T) {
+	Convey("test a namespace view only sees the keys of its own group", t, func() {
+		kvs, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		engine := newSharedEngine(kvs)
+		root, left, right := engine.namespace(0), engine.namespace(1), engine.namespace(2)
+		So(root.Set("a", []byte("0")), ShouldBeNil)
+		So(root.Set("\xff", []byte("0")), ShouldBeNil)
+		So(left.Set("a", []byte("1")), ShouldBeNil)
+		So(left.Set("b", []byte("1")), ShouldBeNil)
+		So(right.Set("a", []byte("2")), ShouldBeNil)
+
+		scan := func(n *namespace, start, end string) map[string]string {
+			view, err := n.View()
+			So(err, ShouldBeNil)
+			defer view.Close()
+			got := make(map[string]string)
+			So(view.Scan(start, end, func(key string, value []byte) bool {
+				got[key] = string(value)
+				return true
+			}), ShouldBeNil)
+			return got
+		}
+		So(scan(root, "", ""), ShouldResemble, map[string]string{"a": "0", "\xff": "0"})
+		So(scan(root, "b", ""), ShouldResemble, map[string]string{"\xff": "0"})
+		So(scan(left, "", ""), ShouldResemble, map[string]string{"a": "1", "b": "1"})
+		So(scan(left, "b", ""), ShouldResemble, map[string]string{"b": "1"})
+		So(scan(right, "", "b"), ShouldResemble, map[string]string{"a": "2"})
+	})
+}
+
+func Test_NamespaceReset(t *testing.T) {
+	Convey("test resetting a namespace leaves the other namespaces alone", t, func() {
+		kvs, err := engines.NewKvsStore(t.TempDir())
+		So(err, ShouldBeNil)
+		engine := newSharedEngine(kvs)
+		root, left, right := engine.namespace(0), engine.namespace(1), engine.namespace(2)
+		So(root.Set("a", []byte("0")), ShouldBeNil)
+		So(left.Set("a", []byte("1")), ShouldBeNil)
+		So(left.Set("b", []byte("1")), ShouldBeNil)
+		So(right.Set("a", []byte("2")), ShouldBeNil)
+
+		So(left.Reset(map[string][]byte{"b": []byte("new"), "c": []byte("new")}), ShouldBeNil)
+		got := make(map[string]string)
+		So(kvs.Scan(func(key string, value []byte) bool {
+			got[key] = string(value)
+			return true
+		}), ShouldBeNil)
+		So(got, ShouldResemble, map[string]string{
+			"a":                "0",
+			left.prefix + "b":  "new",
+			left.prefix + "c":  "new",
+			right.prefix + "a": "2",
+		})
+
+		So(root.Reset(nil), ShouldBeNil)
+		_, err = root.Get("a")
+		So(err, ShouldNotBeNil)
+		value, err := right.Get("a")
+		So(err, ShouldBeNil)
+		So(string(value), ShouldEqual, "2")
+	})
+}
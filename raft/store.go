@@ -0,0 +1,962 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	dbs "github.com/huiming23344/kv-raft/db"
+	"github.com/huiming23344/kv-raft/network"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 检查区间大小和 QPS 的默认间隔
+	defaultRangeCheckInterval = 10 * time.Second
+	// 按元数据表创建、删除本节点上的组以及同步组成员的间隔
+	reconcileInterval = time.Second
+	// 请求遇到 TRYAGAIN 时重新路由的次数和间隔，覆盖分裂、合并的过程
+	routeRetries    = 20
+	routeRetryDelay = 50 * time.Millisecond
+)
+
+var (
+	errReservedKey = fmt.Errorf("keys starting with %q are reserved", rangeKeyPrefix)
+	errRangeSmall  = errors.New("range has too few keys to split")
+	errRangeLast   = errors.New("range has no right neighbour to merge")
+)
+
+// GroupStorage 一个区间组在本节点上的 raft 存储
+type GroupStorage struct {
+	LogStore    raft.LogStore
+	StableStore raft.StableStore
+	Snapshots   raft.SnapshotStore
+	Transport   raft.Transport
+	// 关闭组时需要释放的资源
+	Closers []io.Closer
+}
+
+// StoreOptions 区间分片的参数
+type StoreOptions struct {
+	// 打开本节点上 id 号组的存储，为 nil 时不支持分裂
+	OpenGroup func(id uint64) (GroupStorage, error)
+	// 删除已合并的组的存储
+	RemoveGroup func(id uint64) error
+	// 本节点上已有的区间组，启动时打开
+	Groups []uint64
+	// 区间的 key 数超过 SplitKeys 或 QPS 超过 SplitQPS 时自动分裂，为 0 时不检查
+	SplitKeys int64
+	SplitQPS  float64
+	// 相邻两个区间的 key 数之和小于 MergeKeys 且 QPS 之和小于 SplitQPS 的一半时自动合并，为 0 时不合并
+	MergeKeys int64
+	// 检查区间大小和 QPS 的间隔，为 0 时使用默认值
+	CheckInterval time.Duration
+	// 按 Redis Cluster 的槽位划分区间，区间的边界对齐到槽位，key 按槽位路由
+	Slots bool
+	// 所有组关闭后需要释放的资源，例如共享的 raft 监听
+	Closers []io.Closer
+}
+
+// Store 管理本节点上的所有 raft 组。key 空间被划分为多个区间，每个区间由一个 raft 组负责，
+// 0 号组同时是元数据组，保存节点的客户端地址和区间描述表。所有组共享一个存储引擎，
+// 每个组只能看到自己的命名空间
+type Store struct {
+	meta   *Node
+	engine *sharedEngine
+	opts   StoreOptions
+	// 元数据组的参数，区间组在此基础上替换存储
+	base NodeOptions
+
+	mutex  sync.RWMutex
+	groups map[uint64]*Node
+	closed bool
+	stop   chan struct{}
+	// 后台的 reconcileLoop 和 checkLoop，关闭时等待它们退出
+	loops sync.WaitGroup
+	// 启动时的组全部打开后关闭，之前应用的分裂日志需要等待
+	ready chan struct{}
+}
+
+var _ rangeHost = (*Store)(nil)
+
+// NewStore 创建元数据组并打开本节点上已有的区间组，engine 由所有组共享
+func NewStore(engine dbs.DB, meta NodeOptions, opts StoreOptions) (*Store, error) {
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaultRangeCheckInterval
+	}
+	s := &Store{
+		engine: newSharedEngine(engine),
+		opts:   opts,
+		base:   meta,
+		groups: make(map[uint64]*Node),
+		stop:   make(chan struct{}),
+		ready:  make(chan struct{}),
+	}
+	meta.Engine = s.engine.namespace(0)
+	node, err := newNode(meta, 0, s)
+	if err != nil {
+		for _, closer := range opts.Closers {
+			_ = closer.Close()
+		}
+		return nil, err
+	}
+	s.meta = node
+	s.groups[0] = node
+	if opts.Slots {
+		for _, desc := range node.fsm.rangeTable() {
+			if !desc.Removed && !slotAligned(&desc) {
+				_ = s.Shutdown(false)
+				return nil, fmt.Errorf("range %d is not aligned to slots, cluster mode must be enabled before the first split", desc.ID)
+			}
+		}
+	}
+	if opts.OpenGroup != nil {
+		ids := append([]uint64(nil), opts.Groups...)
+		for _, desc := range node.fsm.rangeTable() {
+			if !desc.Removed {
+				ids = append(ids, desc.ID)
+			}
+		}
+		for _, id := range ids {
+			if id == 0 || s.groups[id] != nil {
+				continue
+			}
+			group, err := s.openGroup(id, nil)
+			if err != nil {
+				_ = s.Shutdown(false)
+				return nil, fmt.Errorf("open range %d: %w", id, err)
+			}
+			s.groups[id] = group
+		}
+		s.loops.Add(2)
+		go s.reconcileLoop()
+		go s.checkLoop()
+	}
+	close(s.ready)
+	return s, nil
+}
+
+// groupInit 分裂时新组的初始状态，写入组的第一个快照
+type groupInit struct {
+	desc     RangeDescriptor
+	servers  []raft.Server
+	pairs    []kvPair
+	sessions map[string]*clientSession
+}
+
+// openGroup 在本节点上启动 id 号组。init 不为 nil 且本地没有该组的状态时，先写入初始快照，
+// 各副本从同一个快照启动后自行选举；否则等待 Leader 把本节点加入组后通过快照追上
+func (s *Store) openGroup(id uint64, init *groupInit) (*Node, error) {
+	storage, err := s.opts.OpenGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if init != nil {
+		var exists bool
+		exists, err = raft.HasExistingState(storage.LogStore, storage.StableStore, storage.Snapshots)
+		if err == nil && !exists {
+			err = writeInitialSnapshot(storage, init)
+		}
+	}
+	var node *Node
+	if err == nil {
+		opts := s.base
+		opts.Engine = s.engine.namespace(id)
+		opts.LogStore = storage.LogStore
+		opts.StableStore = storage.StableStore
+		opts.Snapshots = storage.Snapshots
+		opts.Transport = storage.Transport
+		opts.Invoker = sharedInvoker{s.base.Invoker}
+		opts.Bootstrap = false
+		opts.Join = nil
+		opts.Closers = storage.Closers
+		node, err = newNode(opts, id, s)
+	}
+	if err != nil {
+		for _, closer := range storage.Closers {
+			_ = closer.Close()
+		}
+		return nil, err
+	}
+	return node, nil
+}
+
+// writeInitialSnapshot 写入位于日志位置 1、任期 1 的快照，包含新组的成员、区间描述和数据
+func writeInitialSnapshot(storage GroupStorage, init *groupInit) error {
+	configuration := raft.Configuration{Servers: init.servers}
+	sink, err := storage.Snapshots.Create(raft.SnapshotVersionMax, 1, 1, configuration, 1, storage.Transport)
+	if err != nil {
+		return err
+	}
+	desc := init.desc
+	snapshot := &fsmSnapshot{
+		pairs:    init.pairs,
+		sessions: init.sessions,
+		ranges:   &rangeState{desc: &desc},
+	}
+	return snapshot.Persist(sink)
+}
+
+// createRange 在应用分裂日志时调用，本节点上还没有该组时创建它。
+// 返回错误时分裂日志不会删除任何数据，节点重启后回放日志会再次创建
+func (s *Store) createRange(desc RangeDescriptor, servers []raft.Server, pairs []kvPair, sessions map[string]*clientSession) error {
+	select {
+	case <-s.ready:
+	case <-s.stop:
+		return raft.ErrRaftShutdown
+	}
+	if s.opts.OpenGroup == nil {
+		return errRangesDisabled
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return raft.ErrRaftShutdown
+	}
+	if s.groups[desc.ID] != nil {
+		return nil
+	}
+	node, err := s.openGroup(desc.ID, &groupInit{
+		desc:     desc,
+		servers:  servers,
+		pairs:    pairs,
+		sessions: sessions,
+	})
+	if err != nil {
+		log.Printf("create range %d failed: %v", desc.ID, err)
+		return fmt.Errorf("create range %d: %w", desc.ID, err)
+	}
+	s.groups[desc.ID] = node
+	return nil
+}
+
+// sharedInvoker 区间组共用元数据组的连接池，由元数据组关闭
+type sharedInvoker struct {
+	Invoker
+}
+
+func (sharedInvoker) Close() {}
+
+// Execute 执行客户端命令，按 key 路由到负责它的组，其他命令由元数据组执行
+func (s *Store) Execute(command cmd.Command, frame *network.Frame) *network.Frame {
+	switch command.Name() {
+	case cmd.RANGE:
+		return s.Range(command.(*cmd.Range), frame)
+	case cmd.CLUSTER:
+		return s.Cluster(command.(*cmd.Cluster))
+	case cmd.MEMBER:
+		// 领导权的转移涉及本节点上的所有组
+		if cm := command.(*cmd.Member); cm.Opt() == cmd.MemberTransferLeader || cm.Opt() == cmd.MemberDrain {
+			return s.transfer(cm)
+		}
+	}
+	key, ok := commandKey(command)
+	if !ok {
+		return s.meta.Execute(command, frame)
+	}
+	if strings.HasPrefix(key, rangeKeyPrefix) {
+		return errorFrame(errReservedKey)
+	}
+	var rspFrame *network.Frame
+	for i := 0; i < routeRetries; i++ {
+		if i > 0 {
+			time.Sleep(routeRetryDelay)
+		}
+		node := s.route(routingKey(key, s.opts.Slots))
+		if node == nil {
+			rspFrame = errorFrame(fmt.Errorf("%s no local range contains key %q", tryAgain, key))
+			continue
+		}
+		node.requests.Add(1)
+		rspFrame = node.Execute(command, frame)
+		if !isTryAgain(rspFrame) {
+			break
+		}
+	}
+	return rspFrame
+}
+
+// Submit 与 Execute 相同，写请求加入所在组的提交队列后立即返回，由返回的函数等待结果，
+// 见 Node.Submit。结果是 TRYAGAIN 时在等待的函数中重新执行
+func (s *Store) Submit(command cmd.Command, frame *network.Frame) func() *network.Frame {
+	key, ok := commandKey(command)
+	if !ok || strings.HasPrefix(key, rangeKeyPrefix) {
+		rspFrame := s.Execute(command, frame)
+		return func() *network.Frame { return rspFrame }
+	}
+	node := s.route(routingKey(key, s.opts.Slots))
+	if node == nil {
+		rspFrame := s.Execute(command, frame)
+		return func() *network.Frame { return rspFrame }
+	}
+	node.requests.Add(1)
+	wait := node.Submit(command, frame)
+	return func() *network.Frame {
+		if rspFrame := wait(); !isTryAgain(rspFrame) {
+			return rspFrame
+		}
+		return s.Execute(command, frame)
+	}
+}
+
+// route 返回本节点上负责路由键 key 的组。分裂、合并的过程中可能有多个组的描述包含 key，
+// 优先选择未冻结、generation 最大的组，选错时由状态机返回 TRYAGAIN
+func (s *Store) route(key string) *Node {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	var best *Node
+	var bestDesc *RangeDescriptor
+	for _, node := range s.groups {
+		desc := node.Range()
+		if desc == nil || desc.Removed || !desc.Contains(key) {
+			continue
+		}
+		if best == nil || (bestDesc.Frozen && !desc.Frozen) ||
+			(bestDesc.Frozen == desc.Frozen && desc.Generation > bestDesc.Generation) {
+			best, bestDesc = node, desc
+		}
+	}
+	return best
+}
+
+// Range 执行区间管理命令，修改区间的命令由对应组的 Leader 执行
+func (s *Store) Range(c *cmd.Range, frame *network.Frame) *network.Frame {
+	if c.Opt() == cmd.RangeList {
+		return s.list()
+	}
+	if s.opts.OpenGroup == nil {
+		return errorFrame(errRangesDisabled)
+	}
+	switch c.Opt() {
+	case cmd.RangeAlloc:
+		return s.atLeader(s.meta, frame, func() *network.Frame {
+			return s.meta.apply(&allocRange{})
+		})
+	case cmd.RangeUpdate:
+		desc, err := parseDescriptor(c.Args())
+		if err != nil {
+			return errorFrame(err)
+		}
+		return s.atLeader(s.meta, frame, func() *network.Frame {
+			return s.meta.apply(&updateRange{desc: desc})
+		})
+	}
+	id, err := strconv.ParseUint(c.Arg(0), 10, 64)
+	if err != nil {
+		return errorFrame(fmt.Errorf("invalid range id %q", c.Arg(0)))
+	}
+	node := s.Group(id)
+	if node == nil {
+		return errorFrame(fmt.Errorf("range %d is not served by this node", id))
+	}
+	switch c.Opt() {
+	case cmd.RangeSplit:
+		return s.atLeader(node, frame, func() *network.Frame {
+			return s.split(node, c.Arg(1))
+		})
+	case cmd.RangeMerge:
+		return s.atLeader(node, frame, func() *network.Frame {
+			return s.merge(node)
+		})
+	case cmd.RangeFreeze:
+		return s.atLeader(node, frame, func() *network.Frame {
+			return node.apply(&freezeRange{frozen: c.Arg(1) != "0"})
+		})
+	}
+	return errorFrame(fmt.Errorf("unknown range subcommand %s", c.Opt()))
+}
+
+// atLeader 本节点是 node 所在组的 Leader 时执行 fn，否则把命令代理给 Leader
+func (s *Store) atLeader(node *Node, frame *network.Frame, fn func() *network.Frame) *network.Frame {
+	if node.isLeader() {
+		return fn()
+	}
+	return node.toLeader(frame)
+}
+
+// list 按 start 的顺序列出元数据表中的区间
+func (s *Store) list() *network.Frame {
+	table := s.meta.fsm.rangeTable()
+	if len(table) == 0 {
+		// 从未分裂过时，元数据表为空，整个 key 空间由 0 号组负责
+		if desc := s.meta.Range(); desc != nil {
+			table = append(table, *desc)
+		}
+	}
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].Start != table[j].Start {
+			return table[i].Start < table[j].Start
+		}
+		return table[i].ID < table[j].ID
+	})
+	var buf strings.Builder
+	for _, desc := range table {
+		leader := ""
+		if node := s.Group(desc.ID); node != nil {
+			_, id := node.raft.LeaderWithID()
+			leader = string(id)
+		}
+		buf.WriteString(fmt.Sprintf("id=%d start=%q end=%q generation=%d state=%s leader=%s",
+			desc.ID, desc.Start, desc.End, desc.Generation, desc.State(), leader))
+		if s.opts.Slots {
+			start, end := slotSpan(&desc)
+			buf.WriteString(fmt.Sprintf(" slots=%d-%d", start, end-1))
+		}
+		buf.WriteString("\n")
+	}
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: strings.TrimRight(buf.String(), "\n"),
+	}
+}
+
+// split 在 key 处分裂 node 所在组的区间，key 为空时取区间的中位 key。
+// 按槽位划分时在 key 所在槽位的起点分裂
+func (s *Store) split(node *Node, key string) *network.Frame {
+	desc := node.Range()
+	if desc == nil {
+		return errorFrame(errRangeUnknown)
+	}
+	switch {
+	case key == "":
+		var err error
+		if key, err = medianKey(node.fsm.db, s.opts.Slots); err == nil && s.opts.Slots {
+			key, err = medianSlot(desc, key)
+		}
+		if err != nil {
+			return errorFrame(err)
+		}
+	case s.opts.Slots:
+		key = slotBoundary(cmd.KeySlot(key))
+	}
+	rspFrame := s.atLeader(s.meta, cmd.NewRange(cmd.RangeAlloc).IntoFrame(), func() *network.Frame {
+		return s.meta.apply(&allocRange{})
+	})
+	if rspFrame.Ftype == network.Error {
+		return rspFrame
+	}
+	childID, ok := rspFrame.Value.(int)
+	if !ok {
+		return errorFrame(fmt.Errorf("unexpected range id %v", rspFrame.Value))
+	}
+	configFuture := node.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return errorFrame(err)
+	}
+	rspFrame = node.apply(&splitRange{
+		key:     key,
+		childID: uint64(childID),
+		servers: configFuture.Configuration().Servers,
+	})
+	if rspFrame.Ftype == network.Error {
+		return rspFrame
+	}
+	// 分裂已经生效，元数据表更新失败时由 reconcileLoop 补上
+	child := RangeDescriptor{ID: uint64(childID), Start: key, End: desc.End, Generation: desc.Generation + 1}
+	s.publish(child)
+	if parent := node.Range(); parent != nil {
+		s.publish(*parent)
+	}
+	return rspFrame
+}
+
+// medianKey 返回区间中位于中间的路由键，作为分裂点
+func medianKey(db dbs.DB, slots bool) (string, error) {
+	var keys []string
+	err := db.Scan(func(key string, _ []byte) bool {
+		keys = append(keys, routingKey(key, slots))
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(keys) < 2 {
+		return "", errRangeSmall
+	}
+	if slots {
+		sort.Strings(keys)
+	}
+	return keys[len(keys)/2], nil
+}
+
+// merge 把 left 右侧相邻的区间合并进 left。先冻结右侧区间，等本地副本应用到冻结的位置后
+// 读出它的全部数据和会话，随合并日志一起提交到 left 所在的组，最后在元数据表中删除右侧区间
+func (s *Store) merge(left *Node) *network.Frame {
+	desc := left.Range()
+	if desc == nil {
+		return errorFrame(errRangeUnknown)
+	}
+	if desc.End == "" {
+		return errorFrame(errRangeLast)
+	}
+	right := s.rangeAt(desc.End)
+	if right == nil {
+		return errorFrame(fmt.Errorf("%s range starting at %q is not served by this node", tryAgain, desc.End))
+	}
+	freeze := func(frozen bool) *network.Frame {
+		arg := "0"
+		if frozen {
+			arg = "1"
+		}
+		frame := cmd.NewRange(cmd.RangeFreeze, strconv.FormatUint(right.group, 10), arg).IntoFrame()
+		return s.atLeader(right, frame, func() *network.Frame {
+			return right.apply(&freezeRange{frozen: frozen})
+		})
+	}
+	rspFrame := freeze(true)
+	if rspFrame.Ftype == network.Error {
+		return rspFrame
+	}
+	index, ok := rspFrame.Value.(int)
+	if !ok {
+		return errorFrame(fmt.Errorf("unexpected freeze index %v", rspFrame.Value))
+	}
+	var rightDesc *RangeDescriptor
+	err := right.waitApplied(uint64(index))
+	if err == nil {
+		rightDesc = right.Range()
+		err = s.moveData(left, right, *rightDesc)
+	}
+	if err == nil {
+		rspFrame = left.apply(&mergeRange{
+			right:    *rightDesc,
+			sessions: right.fsm.frozenState(),
+		})
+	} else {
+		rspFrame = errorFrame(err)
+	}
+	if rspFrame.Ftype == network.Error {
+		if rightDesc != nil {
+			// 清除已经移交到左侧区间的数据
+			if clear := left.apply(&mergeData{right: *rightDesc, clear: true}); clear.Ftype == network.Error {
+				log.Printf("clear merged data of range %d failed: %v", right.group, clear.Value)
+			}
+		}
+		if unfreeze := freeze(false); unfreeze.Ftype == network.Error {
+			log.Printf("unfreeze range %d failed: %v", right.group, unfreeze.Value)
+		}
+		return rspFrame
+	}
+	removed := *rightDesc
+	removed.Frozen = false
+	removed.Removed = true
+	removed.Generation++
+	s.publish(removed)
+	if desc := left.Range(); desc != nil {
+		s.publish(*desc)
+	}
+	return rspFrame
+}
+
+// mergeChunkSize 合并时每条 mergeData 日志移交的数据量上限
+const mergeChunkSize = 256 << 10
+
+// moveData 从本地右侧区间副本的视图中读出数据，分批提交到左侧区间的组，
+// 每条日志都不超过 mergeChunkSize，避免单条日志过大阻塞日志复制和应用
+func (s *Store) moveData(left, right *Node, rightDesc RangeDescriptor) error {
+	view, err := right.fsm.db.View()
+	if err != nil {
+		return err
+	}
+	defer view.Close()
+	chunk := &mergeData{right: rightDesc, clear: true}
+	size := 0
+	flush := func() error {
+		if rspFrame := left.apply(chunk); rspFrame.Ftype == network.Error {
+			return errors.New(rspFrame.Value.(string))
+		}
+		chunk = &mergeData{right: rightDesc}
+		size = 0
+		return nil
+	}
+	var applyErr error
+	err = view.Scan("", "", func(key string, value []byte) bool {
+		// 只移交右侧区间负责的 key
+		if !rightDesc.Contains(routingKey(key, right.fsm.slots)) {
+			return true
+		}
+		chunk.pairs = append(chunk.pairs, kvPair{key, string(value)})
+		if size += len(key) + len(value); size >= mergeChunkSize {
+			applyErr = flush()
+		}
+		return applyErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if applyErr != nil {
+		return applyErr
+	}
+	return flush()
+}
+
+// rangeAt 返回本节点上 start 开始的区间所在的组
+func (s *Store) rangeAt(start string) *Node {
+	for _, node := range s.Groups() {
+		if desc := node.Range(); desc != nil && !desc.Removed && desc.Start == start {
+			return node
+		}
+	}
+	return nil
+}
+
+// publish 把区间描述写入元数据表
+func (s *Store) publish(desc RangeDescriptor) {
+	frame := cmd.NewRange(cmd.RangeUpdate, formatDescriptor(desc)...).IntoFrame()
+	rspFrame := s.atLeader(s.meta, frame, func() *network.Frame {
+		return s.meta.apply(&updateRange{desc: desc})
+	})
+	if rspFrame.Ftype == network.Error {
+		log.Printf("publish range %d failed: %v", desc.ID, rspFrame.Value)
+	}
+}
+
+// formatDescriptor 和 parseDescriptor 在 RANGE UPDATE 命令中传递区间描述，
+// 参数依次为 id、start、end、generation 和标志位
+func formatDescriptor(desc RangeDescriptor) []string {
+	return []string{
+		strconv.FormatUint(desc.ID, 10),
+		desc.Start,
+		desc.End,
+		strconv.FormatUint(desc.Generation, 10),
+		strconv.Itoa(int(descriptorFlags(&desc))),
+	}
+}
+
+func parseDescriptor(args []string) (RangeDescriptor, error) {
+	var desc RangeDescriptor
+	if len(args) != 5 {
+		return desc, errors.New("range update needs id, start, end, generation and flags")
+	}
+	var err error
+	if desc.ID, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+		return desc, err
+	}
+	desc.Start, desc.End = args[1], args[2]
+	if desc.Generation, err = strconv.ParseUint(args[3], 10, 64); err != nil {
+		return desc, err
+	}
+	flags, err := strconv.ParseUint(args[4], 10, 8)
+	if err != nil {
+		return desc, err
+	}
+	setDescriptorFlags(&desc, byte(flags))
+	return desc, nil
+}
+
+// reconcileLoop 定期让本节点上的组与元数据表一致
+func (s *Store) reconcileLoop() {
+	defer s.loops.Done()
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.reconcile()
+		}
+	}
+}
+
+// reconcile 打开元数据表中有而本节点上没有的组，关闭已删除的组。
+// 本节点是 Leader 的组把自己的区间描述写入元数据表，并让组成员与元数据组的成员一致
+func (s *Store) reconcile() {
+	table := s.meta.fsm.rangeTable()
+	for _, desc := range table {
+		if desc.ID == 0 {
+			continue
+		}
+		node := s.Group(desc.ID)
+		if desc.Removed && node != nil {
+			s.removeGroup(desc.ID)
+		} else if !desc.Removed && node == nil {
+			s.addGroup(desc.ID)
+		}
+	}
+	for _, node := range s.Groups() {
+		if !node.isLeader() {
+			continue
+		}
+		desc := node.Range()
+		if desc == nil {
+			continue
+		}
+		// 元数据表中更新的描述说明本组已被合并，不再发布
+		if entry, ok := s.meta.fsm.rangeEntry(desc.ID); (!ok && desc.ID != 0) || (ok && entry != *desc && entry.Generation <= desc.Generation) {
+			s.publish(*desc)
+		}
+		if node.group != 0 {
+			s.syncMembers(node)
+		}
+	}
+	if s.meta.isLeader() {
+		s.removeMerged(table)
+	}
+}
+
+// removeMerged 合并日志已提交但元数据表没有更新时，右侧区间停留在冻结状态。
+// 它的起点已被另一个更新的区间包含，说明数据已经移交，可以删除
+func (s *Store) removeMerged(table []RangeDescriptor) {
+	for _, desc := range table {
+		if !desc.Frozen || desc.Removed {
+			continue
+		}
+		for _, other := range table {
+			if other.ID != desc.ID && !other.Frozen && !other.Removed &&
+				other.Generation > desc.Generation && other.Contains(desc.Start) {
+				removed := desc
+				removed.Frozen = false
+				removed.Removed = true
+				removed.Generation++
+				s.publish(removed)
+				break
+			}
+		}
+	}
+}
+
+// addGroup 打开元数据表中有而本节点上没有的组，等待该组的 Leader 把本节点加入
+func (s *Store) addGroup(id uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed || s.groups[id] != nil {
+		return
+	}
+	node, err := s.openGroup(id, nil)
+	if err != nil {
+		log.Printf("open range %d failed: %v", id, err)
+		return
+	}
+	s.groups[id] = node
+}
+
+// removeGroup 关闭已合并的组，删除它的存储和数据
+func (s *Store) removeGroup(id uint64) {
+	s.mutex.Lock()
+	node := s.groups[id]
+	if s.closed || node == nil {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.groups, id)
+	s.mutex.Unlock()
+	// 在锁外关闭，组的状态机可能正在等待锁创建新的组
+	if err := node.Shutdown(false); err != nil {
+		log.Printf("shutdown range %d failed: %v", id, err)
+	}
+	if s.opts.RemoveGroup != nil {
+		if err := s.opts.RemoveGroup(id); err != nil {
+			log.Printf("remove range %d storage failed: %v", id, err)
+		}
+	}
+	if err := s.engine.namespace(id).drop(); err != nil {
+		log.Printf("remove range %d data failed: %v", id, err)
+	}
+}
+
+// syncMembers 让区间组的成员与元数据组一致，节点加入、提升、降级、删除都只需要在元数据组中操作
+func (s *Store) syncMembers(node *Node) {
+	metaFuture := s.meta.raft.GetConfiguration()
+	groupFuture := node.raft.GetConfiguration()
+	if metaFuture.Error() != nil || groupFuture.Error() != nil {
+		return
+	}
+	current := make(map[raft.ServerID]raft.Server)
+	for _, server := range groupFuture.Configuration().Servers {
+		current[server.ID] = server
+	}
+	wanted := make(map[raft.ServerID]bool)
+	for _, server := range metaFuture.Configuration().Servers {
+		wanted[server.ID] = true
+		old, ok := current[server.ID]
+		if ok && old.Suffrage == server.Suffrage && old.Address == server.Address {
+			continue
+		}
+		var err error
+		switch {
+		case server.Suffrage == raft.Voter:
+			err = node.raft.AddVoter(server.ID, server.Address, 0, membershipTimeout).Error()
+		case ok && old.Suffrage == raft.Voter:
+			err = node.raft.DemoteVoter(server.ID, 0, membershipTimeout).Error()
+		default:
+			err = node.raft.AddNonvoter(server.ID, server.Address, 0, membershipTimeout).Error()
+		}
+		if err != nil {
+			log.Printf("range %d: update member %s failed: %v", node.group, server.ID, err)
+			return
+		}
+	}
+	for id := range current {
+		if !wanted[id] && id != node.serverID {
+			if err := node.raft.RemoveServer(id, 0, membershipTimeout).Error(); err != nil {
+				log.Printf("range %d: remove member %s failed: %v", node.group, id, err)
+				return
+			}
+		}
+	}
+}
+
+// checkLoop 定期检查本节点是 Leader 的区间，超过阈值时分裂，相邻的小区间合并
+func (s *Store) checkLoop() {
+	defer s.loops.Done()
+	if s.opts.SplitKeys <= 0 && s.opts.SplitQPS <= 0 && s.opts.MergeKeys <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.opts.CheckInterval)
+	defer ticker.Stop()
+	last := make(map[uint64]uint64)
+	lastTime := time.Now()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			elapsed := now.Sub(lastTime).Seconds()
+			lastTime = now
+			qps := make(map[uint64]float64)
+			for _, node := range s.Groups() {
+				requests := node.requests.Load()
+				qps[node.group] = float64(requests-last[node.group]) / elapsed
+				last[node.group] = requests
+			}
+			s.check(qps)
+		}
+	}
+}
+
+// check 每个区间每轮最多执行一次分裂或合并
+func (s *Store) check(qps map[uint64]float64) {
+	keys := make(map[uint64]int64)
+	err := s.engine.Scan(func(id uint64, _ string, _ []byte) bool {
+		keys[id]++
+		return true
+	})
+	if err != nil {
+		log.Printf("count range keys failed: %v", err)
+		return
+	}
+	for _, node := range s.Groups() {
+		desc := node.Range()
+		if desc == nil || desc.Frozen || desc.Removed || !node.isLeader() {
+			continue
+		}
+		if (s.opts.SplitKeys > 0 && keys[node.group] > s.opts.SplitKeys) ||
+			(s.opts.SplitQPS > 0 && qps[node.group] > s.opts.SplitQPS) {
+			if rspFrame := s.split(node, ""); rspFrame.Ftype == network.Error {
+				log.Printf("split range %d failed: %v", node.group, rspFrame.Value)
+			}
+			continue
+		}
+		if s.opts.MergeKeys <= 0 || desc.End == "" {
+			continue
+		}
+		right := s.rangeAt(desc.End)
+		if right == nil {
+			continue
+		}
+		if keys[node.group]+keys[right.group] >= s.opts.MergeKeys {
+			continue
+		}
+		if s.opts.SplitQPS > 0 && qps[node.group]+qps[right.group] >= s.opts.SplitQPS/2 {
+			continue
+		}
+		if rspFrame := s.merge(node); rspFrame.Ftype == network.Error {
+			log.Printf("merge range %d into %d failed: %v", right.group, node.group, rspFrame.Value)
+		}
+	}
+}
+
+// Meta 返回元数据组在本节点上的副本
+func (s *Store) Meta() *Node {
+	return s.meta
+}
+
+// Group 返回 id 号组在本节点上的副本，不存在时返回 nil
+func (s *Store) Group(id uint64) *Node {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.groups[id]
+}
+
+// Ranges 返回本节点上元数据表的副本
+func (s *Store) Ranges() []RangeDescriptor {
+	return s.meta.fsm.rangeTable()
+}
+
+// Groups 按 ID 的顺序返回本节点上的所有组，包括元数据组
+func (s *Store) Groups() []*Node {
+	s.mutex.RLock()
+	nodes := make([]*Node, 0, len(s.groups))
+	for _, node := range s.groups {
+		nodes = append(nodes, node)
+	}
+	s.mutex.RUnlock()
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].group < nodes[j].group
+	})
+	return nodes
+}
+
+// Scan 遍历本节点上所有区间的数据，只包含各组当前负责的 key
+func (s *Store) Scan(fn func(key string, value []byte) bool) error {
+	descs := make(map[uint64]*RangeDescriptor)
+	for _, node := range s.Groups() {
+		if desc := node.Range(); desc != nil && !desc.Frozen && !desc.Removed {
+			descs[node.group] = desc
+		}
+	}
+	return s.engine.Scan(func(id uint64, key string, value []byte) bool {
+		if desc := descs[id]; desc == nil || !desc.Contains(routingKey(key, s.opts.Slots)) {
+			return true
+		}
+		return fn(key, value)
+	})
+}
+
+// Shutdown 关闭本节点上的所有组，最后关闭元数据组
+func (s *Store) Shutdown(transferLeader bool) error {
+	s.mutex.Lock()
+	select {
+	case <-s.stop:
+		s.mutex.Unlock()
+		return nil
+	default:
+		close(s.stop)
+	}
+	s.mutex.Unlock()
+	// 后台任务可能正在删除组或提交日志，等它们结束后再关闭所有组
+	s.loops.Wait()
+	s.mutex.Lock()
+	s.closed = true
+	var groups []*Node
+	for id, node := range s.groups {
+		if id != 0 {
+			groups = append(groups, node)
+		}
+	}
+	s.mutex.Unlock()
+	var err error
+	for _, node := range groups {
+		if closeErr := node.Shutdown(transferLeader); err == nil {
+			err = closeErr
+		}
+	}
+	if s.meta != nil {
+		if closeErr := s.meta.Shutdown(transferLeader); err == nil {
+			err = closeErr
+		}
+	}
+	for _, closer := range s.opts.Closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
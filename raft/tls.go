@@ -0,0 +1,38 @@
+package raft
+
+import (
+	"crypto/tls"
+	"github.com/hashicorp/raft"
+	"net"
+	"time"
+)
+
+// tlsStreamLayer 为 raft.NetworkTransport 提供 TLS 连接，
+// 监听端和拨号端使用同一份证书，开启 require-client-cert 时节点之间双向认证
+type tlsStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+	client    *tls.Config
+}
+
+// newTLSStreamLayer 在 bindAddr 上监听 TLS 连接，advertise 为对其他节点公布的地址
+func newTLSStreamLayer(bindAddr string, advertise net.Addr, server, client *tls.Config) (*tlsStreamLayer, error) {
+	l, err := tls.Listen("tcp", bindAddr, server)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsStreamLayer{
+		Listener:  l,
+		advertise: advertise,
+		client:    client,
+	}, nil
+}
+
+func (t *tlsStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	return tls.DialWithDialer(dialer, "tcp", string(address), t.client)
+}
+
+func (t *tlsStreamLayer) Addr() net.Addr {
+	return t.advertise
+}
@@ -0,0 +1,167 @@
+package raft
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/hashicorp/raft"
+	kvscfg "github.com/huiming23344/kv-raft/config"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeCert 生成由 parent 签发的证书写入 dir/name.pem 和 dir/name-key.pem，parent 为 nil 时自签名
+func writeCert(tb testing.TB, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600); err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cert, key
+}
+
+// testTLS 生成一个 CA 和由它签发的节点证书，以及另一个 CA 签发的不受信任的证书
+func testTLS(tb testing.TB) (trusted, rogue *kvscfg.TLS) {
+	dir := tb.TempDir()
+	ca, caKey := writeCert(tb, dir, "ca", nil, nil)
+	writeCert(tb, dir, "node", ca, caKey)
+	rogueCA, rogueKey := writeCert(tb, dir, "rogue-ca", nil, nil)
+	writeCert(tb, dir, "rogue", rogueCA, rogueKey)
+	trusted = &kvscfg.TLS{
+		CertFile:          filepath.Join(dir, "node.pem"),
+		KeyFile:           filepath.Join(dir, "node-key.pem"),
+		CAFile:            filepath.Join(dir, "ca.pem"),
+		RequireClientCert: true,
+	}
+	rogue = &kvscfg.TLS{
+		CertFile: filepath.Join(dir, "rogue.pem"),
+		KeyFile:  filepath.Join(dir, "rogue-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	return trusted, rogue
+}
+
+func newTestStreamLayer(tlsCfg *kvscfg.TLS) *tlsStreamLayer {
+	server, err := tlsCfg.ServerConfig()
+	So(err, ShouldBeNil)
+	client, err := tlsCfg.ClientConfig()
+	So(err, ShouldBeNil)
+	advertise := &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
+	layer, err := newTLSStreamLayer("127.0.0.1:0", advertise, server, client)
+	So(err, ShouldBeNil)
+	return layer
+}
+
+// echoOnce 接受一个连接并回显收到的数据
+func echoOnce(layer *tlsStreamLayer) {
+	go func() {
+		conn, err := layer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.CopyN(conn, conn, 4)
+	}()
+}
+
+func Test_TLSStreamLayer(t *testing.T) {
+	Convey("nodes with certificates from the cluster CA talk over mutual TLS", t, func() {
+		trusted, _ := testTLS(t)
+		server := newTestStreamLayer(trusted)
+		defer server.Close()
+		client := newTestStreamLayer(trusted)
+		defer client.Close()
+		echoOnce(server)
+
+		conn, err := client.Dial(raft.ServerAddress(server.Listener.Addr().String()), time.Second)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		So(err, ShouldBeNil)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		So(err, ShouldBeNil)
+		So(string(buf), ShouldEqual, "ping")
+		So(conn.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName, ShouldEqual, "node")
+	})
+
+	Convey("peers without a trusted client certificate are rejected", t, func() {
+		trusted, rogue := testTLS(t)
+		server := newTestStreamLayer(trusted)
+		defer server.Close()
+		addr := server.Listener.Addr().String()
+
+		for _, peer := range []*kvscfg.TLS{rogue, {CAFile: trusted.CAFile}} {
+			echoOnce(server)
+			config, err := peer.ClientConfig()
+			So(err, ShouldBeNil)
+			conn, err := tls.Dial("tcp", addr, config)
+			if err == nil {
+				// TLS 1.3 中服务端在握手完成后才校验客户端证书，错误在读取时返回
+				_ = conn.SetDeadline(time.Now().Add(time.Second))
+				_, _ = conn.Write([]byte("ping"))
+				_, err = io.ReadFull(conn, make([]byte, 4))
+				_ = conn.Close()
+			}
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("servers outside the cluster CA are rejected by the dialer", t, func() {
+		trusted, rogue := testTLS(t)
+		rogueServer := newTestStreamLayer(&kvscfg.TLS{CertFile: rogue.CertFile, KeyFile: rogue.KeyFile})
+		defer rogueServer.Close()
+		client := newTestStreamLayer(trusted)
+		defer client.Close()
+		echoOnce(rogueServer)
+
+		_, err := client.Dial(raft.ServerAddress(rogueServer.Listener.Addr().String()), time.Second)
+		So(err, ShouldNotBeNil)
+	})
+}
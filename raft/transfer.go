@@ -0,0 +1,106 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"log"
+	"strconv"
+)
+
+// transfer 在所有组上执行 member transfer-leader 和 member drain。领导权只能由各组的 Leader 转移：
+// 本节点领导的组直接转移，其他组把命令连同组 ID 交给该组的 Leader，由它只处理这一个组
+func (s *Store) transfer(cm *cmd.Member) *network.Frame {
+	if group := cm.Option(0); group != "" {
+		id, err := strconv.ParseUint(group, 10, 64)
+		if err != nil {
+			return errorFrame(fmt.Errorf("invalid group id %q", group))
+		}
+		node := s.Group(id)
+		if node == nil {
+			return errorFrame(fmt.Errorf("group %d is not served by this node", id))
+		}
+		return node.Member(cm)
+	}
+	serverID := raft.ServerID(cm.ServerID())
+	if cm.Opt() == cmd.MemberDrain && serverID == "" {
+		return errorFrame(errors.New("server id is required"))
+	}
+	if serverID != "" {
+		if _, err := s.meta.server(serverID); err != nil {
+			return errorFrame(err)
+		}
+	}
+	for _, node := range s.Groups() {
+		_, leaderID := node.raft.LeaderWithID()
+		if cm.Opt() == cmd.MemberDrain && leaderID != serverID {
+			// 节点不是这个组的 Leader，无需转移
+			continue
+		}
+		if cm.Opt() == cmd.MemberTransferLeader && serverID != "" && leaderID == serverID {
+			continue
+		}
+		groupCm := cmd.NewMember(cm.Opt(), cm.ServerID(), cm.Address(), strconv.FormatUint(node.group, 10)).(*cmd.Member)
+		if rspFrame := node.Member(groupCm); rspFrame.Ftype == network.Error {
+			return errorFrame(fmt.Errorf("group %d: %v", node.group, rspFrame.Value))
+		}
+	}
+	return &network.Frame{
+		Ftype: network.Simple,
+		Value: "OK",
+	}
+}
+
+// transferLeader 在 Leader 上将领导权转移给 serverID，serverID 为空时由 raft 选择日志最新的 Voter
+func (r *Node) transferLeader(serverID raft.ServerID) error {
+	if serverID == "" {
+		return r.raft.LeadershipTransfer().Error()
+	}
+	if serverID == r.serverID {
+		return fmt.Errorf("server %s is already the leader", serverID)
+	}
+	server, err := r.server(serverID)
+	if err != nil {
+		return err
+	}
+	if server.Suffrage != raft.Voter {
+		return fmt.Errorf("server %s is not a voter", serverID)
+	}
+	return r.raft.LeadershipTransferToServer(server.ID, server.Address).Error()
+}
+
+// drain 在维护节点前调用，节点是 Leader 时把领导权转移给其他 Voter，
+// 这样重启节点时写入不需要等待选举超时
+func (r *Node) drain(serverID raft.ServerID) error {
+	if serverID == "" {
+		return errors.New("server id is required")
+	}
+	if _, err := r.server(serverID); err != nil {
+		return err
+	}
+	if serverID != r.serverID {
+		// 节点不是 Leader，无需转移
+		return nil
+	}
+	if err := r.raft.LeadershipTransfer().Error(); err != nil {
+		return err
+	}
+	log.Printf("server %s drained, leadership transferred", serverID)
+	return nil
+}
+
+// server 在当前集群配置中查找节点
+func (r *Node) server(serverID raft.ServerID) (raft.Server, error) {
+	configFuture := r.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return raft.Server{}, err
+	}
+	for _, s := range configFuture.Configuration().Servers {
+		if s.ID == serverID {
+			return s, nil
+		}
+	}
+	return raft.Server{}, fmt.Errorf("server %s is not a member of the cluster", serverID)
+}
@@ -0,0 +1,229 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/hashicorp/raft"
+	kvscfg "github.com/huiming23344/kv-raft/config"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// 区间组的连接以该字节开头，hashicorp/raft 的 RPC 类型都小于它
+	muxMagic byte = 0xC8
+	// 读取连接头部的超时时间
+	muxHeaderTimeout = 10 * time.Second
+	// 监听出错后重试的间隔
+	muxAcceptBackoff = 100 * time.Millisecond
+)
+
+var errStreamClosed = errors.New("raft stream closed")
+
+// newStreamLayer 在 bindAddr 上监听节点之间的 raft 连接，配置了证书时使用 TLS
+func newStreamLayer(bindAddr, advertise string, tlsCfg *kvscfg.TLS) (raft.StreamLayer, error) {
+	address, err := net.ResolveTCPAddr("tcp", advertise)
+	if err != nil {
+		return nil, err
+	}
+	if address.IP == nil || address.IP.IsUnspecified() {
+		return nil, errors.New("local bind address is not advertisable")
+	}
+	if tlsCfg.Enabled() {
+		server, err := tlsCfg.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		client, err := tlsCfg.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		stream, err := newTLSStreamLayer(bindAddr, address, server, client)
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+	l, err := net.Listen("tcp", bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpStreamLayer{Listener: l, advertise: address}, nil
+}
+
+// tcpStreamLayer 明文的 raft 连接
+type tcpStreamLayer struct {
+	net.Listener
+	advertise net.Addr
+}
+
+func (t *tcpStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", string(address), timeout)
+}
+
+func (t *tcpStreamLayer) Addr() net.Addr {
+	return t.advertise
+}
+
+// muxStreamLayer 让所有 raft 组共享一个监听地址。区间组的连接以 muxMagic 和 uvarint 的组 ID 开头，
+// 其他连接属于 0 号组，因此未分片的旧版本节点仍然可以与 0 号组通信
+type muxStreamLayer struct {
+	base   raft.StreamLayer
+	mutex  sync.Mutex
+	groups map[uint64]*groupStream
+	closed bool
+}
+
+func newMuxStreamLayer(base raft.StreamLayer) *muxStreamLayer {
+	m := &muxStreamLayer{
+		base:   base,
+		groups: make(map[uint64]*groupStream),
+	}
+	go m.acceptLoop()
+	return m
+}
+
+// group 返回 id 号组使用的 StreamLayer，组的 transport 关闭时一并注销
+func (m *muxStreamLayer) group(id uint64) raft.StreamLayer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stream := &groupStream{
+		mux:   m,
+		id:    id,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	if old := m.groups[id]; old != nil {
+		old.closeOnce.Do(func() { close(old.done) })
+	}
+	m.groups[id] = stream
+	return stream
+}
+
+// Close 关闭共享的监听，在所有组关闭后调用，仍在等待连接的组一并关闭
+func (m *muxStreamLayer) Close() error {
+	m.mutex.Lock()
+	m.closed = true
+	for _, stream := range m.groups {
+		stream.closeOnce.Do(func() { close(stream.done) })
+	}
+	m.mutex.Unlock()
+	return m.base.Close()
+}
+
+func (m *muxStreamLayer) acceptLoop() {
+	for {
+		conn, err := m.base.Accept()
+		if err != nil {
+			m.mutex.Lock()
+			closed := m.closed
+			m.mutex.Unlock()
+			if closed {
+				return
+			}
+			log.Printf("accept raft connection failed: %v", err)
+			time.Sleep(muxAcceptBackoff)
+			continue
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// dispatch 读取连接头部，把连接交给对应的组，组不存在时关闭连接
+func (m *muxStreamLayer) dispatch(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(muxHeaderTimeout))
+	reader := bufio.NewReader(conn)
+	id := uint64(0)
+	first, err := reader.Peek(1)
+	if err == nil && first[0] == muxMagic {
+		_, _ = reader.ReadByte()
+		id, err = binary.ReadUvarint(reader)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	m.mutex.Lock()
+	stream := m.groups[id]
+	m.mutex.Unlock()
+	if stream == nil {
+		_ = conn.Close()
+		return
+	}
+	select {
+	case stream.conns <- &bufferedConn{Conn: conn, reader: reader}:
+	case <-stream.done:
+		_ = conn.Close()
+	}
+}
+
+func (m *muxStreamLayer) dial(id uint64, address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := m.base.Dial(address, timeout)
+	if err != nil || id == 0 {
+		return conn, err
+	}
+	header := make([]byte, 1, 1+binary.MaxVarintLen64)
+	header[0] = muxMagic
+	header = binary.AppendUvarint(header, id)
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(header); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetWriteDeadline(time.Time{})
+	return conn, nil
+}
+
+func (m *muxStreamLayer) remove(stream *groupStream) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.groups[stream.id] == stream {
+		delete(m.groups, stream.id)
+	}
+}
+
+// groupStream 一个组在共享监听上的 StreamLayer
+type groupStream struct {
+	mux       *muxStreamLayer
+	id        uint64
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (g *groupStream) Accept() (net.Conn, error) {
+	select {
+	case conn := <-g.conns:
+		return conn, nil
+	case <-g.done:
+		return nil, errStreamClosed
+	}
+}
+
+func (g *groupStream) Close() error {
+	g.closeOnce.Do(func() { close(g.done) })
+	g.mux.remove(g)
+	return nil
+}
+
+func (g *groupStream) Addr() net.Addr {
+	return g.mux.base.Addr()
+}
+
+func (g *groupStream) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return g.mux.dial(g.id, address, timeout)
+}
+
+// bufferedConn 读取头部时预读的数据留在 reader 中
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
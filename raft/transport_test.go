@@ -0,0 +1,74 @@
+package raft
+
+import (
+	"github.com/hashicorp/raft"
+	kvscfg "github.com/huiming23344/kv-raft/config"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func echoGroup(stream raft.StreamLayer) {
+	go func() {
+		conn, err := stream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.CopyN(conn, conn, 4)
+	}()
+}
+
+func ping(conn net.Conn) (string, error) {
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return "", err
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(conn, buf)
+	return string(buf), err
+}
+
+func Test_MuxStreamLayer(t *testing.T) {
+	Convey("raft groups share one listener and each group only accepts its own connections", t, func() {
+		base, err := newStreamLayer("127.0.0.1:0", "127.0.0.1:0", &kvscfg.TLS{})
+		So(err, ShouldBeNil)
+		addr := raft.ServerAddress(base.(*tcpStreamLayer).Listener.Addr().String())
+		mux := newMuxStreamLayer(base)
+		defer mux.Close()
+		meta, range5 := mux.group(0), mux.group(5)
+
+		for _, stream := range []raft.StreamLayer{meta, range5} {
+			echoGroup(stream)
+			conn, err := stream.Dial(addr, time.Second)
+			So(err, ShouldBeNil)
+			rsp, err := ping(conn)
+			So(err, ShouldBeNil)
+			So(rsp, ShouldEqual, "ping")
+			_ = conn.Close()
+		}
+
+		// 未分片的节点直接拨号，连接属于 0 号组
+		echoGroup(meta)
+		conn, err := net.Dial("tcp", string(addr))
+		So(err, ShouldBeNil)
+		rsp, err := ping(conn)
+		So(err, ShouldBeNil)
+		So(rsp, ShouldEqual, "ping")
+		_ = conn.Close()
+
+		// 本节点上没有的组，连接被关闭
+		conn, err = mux.dial(7, addr, time.Second)
+		So(err, ShouldBeNil)
+		_, err = ping(conn)
+		So(err, ShouldNotBeNil)
+		_ = conn.Close()
+
+		So(range5.Close(), ShouldBeNil)
+		_, err = range5.Accept()
+		So(err, ShouldEqual, errStreamClosed)
+	})
+}
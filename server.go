@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/huiming23344/kv-raft/cmd"
 	"github.com/huiming23344/kv-raft/config"
@@ -10,12 +13,31 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type KvsServer struct {
 	addr string
 	db   dbs.DB
-	raft *raft.Node
+	raft *raft.Store
+	// 关闭前是否转移 Leader
+	transferLeader bool
+	// 客户端单个请求的最大字节数
+	maxFrameSize int
+
+	// 为 nil 时使用明文连接
+	tlsConfig *tls.Config
+
+	listener net.Listener
+	closed   atomic.Bool
+	// 正在处理的连接
+	mutex    sync.Mutex
+	conns    map[net.Conn]struct{}
+	handlers sync.WaitGroup
+	// 分配给连接的 ID，在 HELLO 的响应中返回
+	nextID atomic.Int64
 }
 
 func NewKvsServer() *KvsServer {
@@ -24,84 +46,344 @@ func NewKvsServer() *KvsServer {
 		panic("load config fail: " + err.Error())
 	}
 	config.SetGlobalConfig(cfg)
-	db, err := dbs.NewDB("./nodes/node0", cfg.Server.CacheCap)
+	db, err := dbs.NewDB(cfg.Lsm.DataDir, cfg.Server.CacheCap)
 	if err != nil {
 		log.Fatal(err)
 	}
-	raftNode, err := raft.NewRaftNode(db)
+	var tlsConfig *tls.Config
+	if cfg.Server.TLS.Enabled() {
+		if tlsConfig, err = cfg.Server.TLS.ServerConfig(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.ForceNewCluster {
+		forceNewCluster(cfg)
+	}
+	raftNode, err := raft.NewRaftStore(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 	return &KvsServer{
-		addr: cfg.Server.Addr,
-		db:   db,
-		raft: raftNode,
+		addr:           cfg.Server.Addr,
+		db:             db,
+		raft:           raftNode,
+		transferLeader: cfg.Server.ShutdownTransferLeader,
+		maxFrameSize:   cfg.Server.MaxFrameSize,
+		tlsConfig:      tlsConfig,
+		conns:          make(map[net.Conn]struct{}),
 	}
 }
 
-func (s *KvsServer) Serve() error {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", s.addr)
+// forceNewCluster 把本节点上所有 raft 组的集群配置改写为只有本节点，之后正常启动时由本节点
+// 单独组成新的集群。其他节点不能再以原来的数据加入，需要清空数据后重新加入
+func forceNewCluster(cfg *config.Config) {
+	opts, err := raft.RecoverOptionsFromConfig(cfg)
 	if err != nil {
-		return err
+		log.Fatal(err)
+	}
+	log.Printf("force new cluster: only %s=%s is kept in every raft group", opts.ServerID, opts.Servers[0].Address)
+	reports, err := raft.Recover(opts)
+	for _, report := range reports {
+		log.Printf("force new cluster: %s", report.String())
 	}
-	l, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		log.Fatalf("force new cluster failed: %v", err)
+	}
+}
+
+// Serve 监听并处理连接，直到 Shutdown 被调用
+func (s *KvsServer) Serve() error {
+	l, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		// 握手在第一次读写时进行，不会阻塞 Accept
+		l = tls.NewListener(l, s.tlsConfig)
+	}
+	s.mutex.Lock()
+	s.listener = l
+	s.mutex.Unlock()
+	if s.closed.Load() {
+		_ = l.Close()
+	}
 	for {
-		conn, err := l.AcceptTCP()
+		conn, err := l.Accept()
 		if err != nil {
+			if s.closed.Load() {
+				return nil
+			}
 			return err
 		}
 		handler := Handler{
-			db:         s.db,
-			connection: network.NewConnection(conn),
-			raft:       s.raft,
+			id:           s.nextID.Add(1),
+			db:           s.db,
+			connection:   network.NewLimitedConnection(conn, s.maxFrameSize),
+			raft:         s.raft,
+			maxFrameSize: s.maxFrameSize,
 		}
-		go handler.run()
+		s.track(conn)
+		go func() {
+			defer s.untrack(conn)
+			handler.run()
+		}()
+	}
+}
+
+// Shutdown 依次停止接收新连接、等待正在处理的请求完成、转移 Leader、
+// 关闭 raft 节点和存储引擎。ctx 到期时返回错误，剩余的步骤不再等待
+func (s *KvsServer) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.shutdown()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *KvsServer) shutdown() error {
+	s.closed.Store(true)
+	s.mutex.Lock()
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	// 唤醒阻塞在读取请求上的连接，正在处理的请求回包后连接退出
+	for conn := range s.conns {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+	s.mutex.Unlock()
+	s.handlers.Wait()
+	log.Println("all connections drained")
+
+	err := s.raft.Shutdown(s.transferLeader)
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+func (s *KvsServer) track(conn net.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	if s.closed.Load() {
+		_ = conn.SetReadDeadline(time.Now())
 	}
 }
 
+func (s *KvsServer) untrack(conn net.Conn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.conns, conn)
+	_ = conn.Close()
+	s.handlers.Done()
+}
+
+// 每批最多处理的流水线请求数，之后的请求留到下一批
+const maxPipeline = 1024
+
 type Handler struct {
+	id         int64
 	db         dbs.DB
 	connection network.Connection
-	raft       *raft.Node
+	raft       *raft.Store
+	// 单个请求的最大字节数，用于错误信息
+	maxFrameSize int
+	// 上一条命令是 ASKING，只对下一条命令有效
+	asking bool
+	// HELLO SETNAME 设置的连接名称，用于日志
+	name string
 }
 
 func (h *Handler) run() {
 	for {
-		// 1.读取一个 Frame
-		frame, err := h.connection.ReadFrame()
-		if err == io.EOF {
+		// 1.读取缓冲区中所有完整的 Frame，客户端流水线发送的请求作为一批处理
+		frames, err := h.connection.ReadFrames(maxPipeline)
+		var netErr net.Error
+		if err == io.EOF || (errors.As(err, &netErr) && netErr.Timeout()) {
+			// 连接关闭，或服务关闭时读超时
+			return
+		}
+		if errors.Is(err, network.ErrFrameTooLarge) {
+			// 无法再找到下一个 Frame 的开头，回复错误后终止连接
+			fmt.Printf("connection terminate %s, frame larger than %d bytes\n", h.peer(), h.maxFrameSize)
+			_ = h.connection.WriteFrame(&network.Frame{
+				Ftype: network.Error,
+				Value: fmt.Sprintf("ERR Protocol error: request larger than max-frame-size (%d bytes)", h.maxFrameSize),
+			})
+			return
+		}
+		if errors.Is(err, network.ErrUnbalancedQuotes) {
+			// 手工输入的 inline 命令有误，回复错误后终止连接
+			fmt.Printf("connection terminate %s, %v\n", h.peer(), err)
+			_ = h.connection.WriteFrame(&network.Frame{
+				Ftype: network.Error,
+				Value: "ERR Protocol error: unbalanced quotes in request",
+			})
 			return
 		}
 		if err != nil {
 			// 网络读取 Frame 失败或无效协议无法解析，终止连接
-			fmt.Printf("connection terminate %s, read frame error: %v\n", h.connection.RemoteAddr(), err)
+			fmt.Printf("connection terminate %s, read frame error: %v\n", h.peer(), err)
 			return
 		}
-		// 2.转换一个 Frame 为 command 结构
-		command, err := cmd.FromFrame(frame)
-		if err != nil {
-			// 解析 Frame 是不支持的命令，终止连接
-			fmt.Printf("connection terminate %s, parse command error: %v\n", h.connection.RemoteAddr(), err)
+		// 2.执行本批命令
+		rspFrames, err := h.execute(frames)
+		// 3.按请求的顺序回包，每批只 Flush 一次
+		if err := h.connection.WriteFrames(rspFrames); err != nil {
+			fmt.Printf("connection terminate %s, write frame error: %v\n", h.peer(), err)
 			return
 		}
-		var rspFrame *network.Frame
-		switch command.Name() {
-		case cmd.GET:
-			rspFrame = command.Apply(h.db)
-		case cmd.SET, cmd.DELETE:
-			rspFrame = h.raft.Apply(frame)
-		case cmd.MEMBER:
-			rspFrame = h.raft.Member(command.(*cmd.Member))
-		case cmd.CONFIG:
-			rspFrame = command.Apply(h.db)
-		}
-		// 3.回包
-		if err := h.connection.WriteFrame(rspFrame); err != nil {
-			fmt.Printf("connection terminate %s, write frame error: %v\n", h.connection.RemoteAddr(), err)
+		if err != nil {
+			// 解析 Frame 是不支持的命令，回复之前的命令后终止连接
+			fmt.Printf("connection terminate %s, parse command error: %v\n", h.peer(), err)
 			return
 		}
 	}
 }
+
+// execute 按顺序分派一批命令，返回与命令一一对应的响应。相邻的读命令并发执行；
+// 连续的写命令依次提交而不等待结果，在 Leader 上合并为同一批日志；其他命令以及写之后的读
+// 等待之前的命令全部完成后再执行，同一连接上的读仍然能看到之前的写。
+// 遇到无法解析的命令时停止，返回之前命令的响应和错误
+func (h *Handler) execute(frames []*network.Frame) ([]*network.Frame, error) {
+	rspFrames := make([]*network.Frame, len(frames))
+	var reads sync.WaitGroup
+	// 已提交、尚未收到结果的写命令
+	writes := make([]int, 0)
+	waits := make([]func() *network.Frame, len(frames))
+	wait := func() {
+		reads.Wait()
+		for _, i := range writes {
+			rspFrames[i] = waits[i]()
+		}
+		writes = writes[:0]
+	}
+	for i, frame := range frames {
+		command, err := cmd.FromFrame(frame)
+		if err != nil {
+			wait()
+			return rspFrames[:i], err
+		}
+		asking := h.asking
+		h.asking = false
+		switch {
+		case isRead(command):
+			if len(writes) > 0 {
+				wait()
+			}
+			reads.Add(1)
+			go func(i int, command cmd.Command, frame *network.Frame, asking bool) {
+				defer reads.Done()
+				rspFrames[i] = h.dispatch(command, frame, asking)
+			}(i, command, frame, asking)
+		case isWrite(command):
+			reads.Wait()
+			waits[i] = h.submit(command, frame, asking)
+			writes = append(writes, i)
+		default:
+			wait()
+			if command.Name() == cmd.ASKING {
+				h.asking = true
+			}
+			rspFrames[i] = h.dispatch(command, frame, asking)
+		}
+	}
+	wait()
+	return rspFrames, nil
+}
+
+// dispatch 执行一条命令
+func (h *Handler) dispatch(command cmd.Command, frame *network.Frame, asking bool) *network.Frame {
+	switch command.Name() {
+	case cmd.CONFIG, cmd.ASKING:
+		return command.Apply(h.db)
+	case cmd.HELLO:
+		return h.hello(command.(*cmd.Hello))
+	default:
+		// 开启集群模式时，key 所在区间的 Leader 不是本节点则回复 -MOVED/-ASK，
+		// 否则按 key 路由到负责它所在区间的 raft 组
+		if rspFrame := h.raft.Redirect(command, asking); rspFrame != nil {
+			return rspFrame
+		}
+		rspFrame := h.raft.Execute(command, frame)
+		if command.Name() == cmd.INFO && h.connection.Protocol() == network.RESP3 {
+			rspFrame = cmd.InfoMap(rspFrame)
+		}
+		return rspFrame
+	}
+}
+
+// submit 提交一条写命令后立即返回，由返回的函数等待结果
+func (h *Handler) submit(command cmd.Command, frame *network.Frame, asking bool) func() *network.Frame {
+	if rspFrame := h.raft.Redirect(command, asking); rspFrame != nil {
+		return func() *network.Frame { return rspFrame }
+	}
+	return h.raft.Submit(command, frame)
+}
+
+// peer 日志中标识连接，设置了名称时附带名称
+func (h *Handler) peer() string {
+	if h.name == "" {
+		return h.connection.RemoteAddr()
+	}
+	return h.connection.RemoteAddr() + "(" + h.name + ")"
+}
+
+// hello 切换连接的协议版本并返回服务端信息，出错时协议版本不变
+func (h *Handler) hello(c *cmd.Hello) *network.Frame {
+	protocol := c.Protocol()
+	if protocol == 0 {
+		protocol = h.connection.Protocol()
+	}
+	if protocol != network.RESP2 && protocol != network.RESP3 {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "NOPROTO unsupported protocol version",
+		}
+	}
+	if c.Auth() {
+		return &network.Frame{
+			Ftype: network.Error,
+			Value: "ERR AUTH called without any password configured",
+		}
+	}
+	if c.ClientName() != "" {
+		h.name = c.ClientName()
+	}
+	h.connection.SetProtocol(protocol)
+	mode := "standalone"
+	if config.GlobalConfig().Server.ClusterEnabled {
+		mode = "cluster"
+	}
+	role := "replica"
+	if h.raft.Meta().IsLeader() {
+		role = "master"
+	}
+	return cmd.HelloReply(h.id, protocol, mode, role)
+}
+
+// isRead 只读命令之间没有依赖，同一批中相邻的只读命令可以并发执行
+func isRead(command cmd.Command) bool {
+	switch command.Name() {
+	case cmd.GET, cmd.INFO, cmd.CLUSTER:
+		return true
+	default:
+		return false
+	}
+}
+
+// isWrite 可以连续提交、不等待结果的写命令
+func isWrite(command cmd.Command) bool {
+	switch command.Name() {
+	case cmd.SET, cmd.DELETE:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/huiming23344/kv-raft/cmd"
+	"github.com/huiming23344/kv-raft/network"
+	"github.com/huiming23344/kv-raft/raft/rafttest"
+	"strconv"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ExecutePipeline(t *testing.T) {
+	Convey("pipelined writes are submitted together and reads see the earlier writes", t, func() {
+		c := rafttest.New(t, 1)
+		leader := c.WaitLeader()
+		h := &Handler{raft: leader.Store()}
+
+		const writes = 32
+		frames := make([]*network.Frame, 0, writes+2)
+		for i := 0; i < writes; i++ {
+			frames = append(frames, cmd.NewSet("k", []byte(strconv.Itoa(i))).IntoFrame())
+		}
+		frames = append(frames, cmd.NewGet("k").IntoFrame(), cmd.NewDelete("k").IntoFrame())
+		before := leader.Raft().Raft().LastIndex()
+		rspFrames, err := h.execute(frames)
+		So(err, ShouldBeNil)
+		So(rspFrames, ShouldHaveLength, writes+2)
+		for _, rspFrame := range rspFrames[:writes] {
+			So(rspFrame.Value, ShouldEqual, "OK")
+		}
+		// 按顺序执行，读到最后一次写入的值
+		So(rspFrames[writes].Value, ShouldResemble, []byte(strconv.Itoa(writes-1)))
+		So(rspFrames[writes+1].Ftype, ShouldNotEqual, network.Error)
+		// 连续的写请求合并为少量的日志，而不是每个写请求一条
+		So(leader.Raft().Raft().LastIndex()-before, ShouldBeLessThanOrEqualTo, writes/4)
+	})
+}